@@ -0,0 +1,269 @@
+// Command oidc-testclient is a minimal relying party for exercising the
+// built-in OIDC provider end to end. It runs the authorization code flow
+// with PKCE, verifies the ID token against the provider's JWKS and shows
+// the resulting claims together with the userinfo response.
+//
+//	go run ./cmd/oidc-testclient -issuer http://localhost:8080 -client-id testclient
+//
+// then open http://localhost:9999/ and sign in with a directory account.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type pending struct {
+	verifier string
+	nonce    string
+}
+
+type client struct {
+	issuer      string
+	id          string
+	secret      string
+	redirectURI string
+	scope       string
+	meta        discovery
+
+	mu      sync.Mutex
+	pending map[string]pending
+}
+
+func main() {
+	issuer := flag.String("issuer", "http://localhost:8080", "OIDC issuer URL")
+	clientID := flag.String("client-id", "testclient", "registered client ID")
+	clientSecret := flag.String("client-secret", "", "client secret; empty for a public client")
+	listen := flag.String("listen", "localhost:9999", "address to listen on")
+	scope := flag.String("scope", "openid profile email groups", "requested scopes")
+	flag.Parse()
+
+	c := &client{
+		issuer:      strings.TrimSuffix(*issuer, "/"),
+		id:          *clientID,
+		secret:      *clientSecret,
+		redirectURI: fmt.Sprintf("http://%s/callback", *listen),
+		scope:       *scope,
+		pending:     make(map[string]pending),
+	}
+	if err := getJSON(c.issuer+"/.well-known/openid-configuration", "", &c.meta); err != nil {
+		log.Fatalf("discovery failed: %v", err)
+	}
+	if c.meta.Issuer != c.issuer {
+		log.Fatalf("issuer mismatch: discovery reports %q", c.meta.Issuer)
+	}
+
+	http.HandleFunc("/", c.handleLogin)
+	http.HandleFunc("/callback", c.handleCallback)
+
+	log.Printf("redirect URI %s must be registered for client %s", c.redirectURI, c.id)
+	log.Printf("open http://%s/ to sign in", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+func (c *client) handleLogin(w http.ResponseWriter, r *http.Request) {
+	state, verifier, nonce := randomString(), randomString()+randomString(), randomString()
+	sum := sha256.Sum256([]byte(verifier))
+
+	c.mu.Lock()
+	c.pending[state] = pending{verifier: verifier, nonce: nonce}
+	c.mu.Unlock()
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.id},
+		"redirect_uri":          {c.redirectURI},
+		"scope":                 {c.scope},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	http.Redirect(w, r, c.meta.AuthorizationEndpoint+"?"+query.Encode(), http.StatusFound)
+}
+
+func (c *client) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		http.Error(w, fmt.Sprintf("authorization failed: %s: %s", e, query.Get("error_description")), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	p, ok := c.pending[query.Get("state")]
+	delete(c.pending, query.Get("state"))
+	c.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown state", http.StatusBadRequest)
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {query.Get("code")},
+		"redirect_uri":  {c.redirectURI},
+		"code_verifier": {p.verifier},
+		"client_id":     {c.id},
+	}
+	if c.secret != "" {
+		form.Set("client_secret", c.secret)
+	}
+	resp, err := http.PostForm(c.meta.TokenEndpoint, form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil || tokens.Error != "" {
+		http.Error(w, fmt.Sprintf("token exchange failed: %s %s %v", tokens.Error, tokens.Description, err), http.StatusBadGateway)
+		return
+	}
+
+	claims, err := c.verifyIDToken(tokens.IDToken, p.nonce)
+	if err != nil {
+		http.Error(w, "ID token rejected: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	var userinfo map[string]interface{}
+	if err := getJSON(c.meta.UserinfoEndpoint, tokens.AccessToken, &userinfo); err != nil {
+		http.Error(w, "userinfo failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]interface{}{
+		"id_token_claims": claims,
+		"userinfo":        userinfo,
+	})
+}
+
+// verifyIDToken checks the signature against the JWKS and the standard ID token claims
+func (c *client) verifyIDToken(raw, nonce string) (jwt.MapClaims, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(c.meta.JWKSURI, "", &set); err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		for _, key := range set.Keys {
+			if key.Kid == kid {
+				return key.publicKey()
+			}
+		}
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}, jwt.WithIssuer(c.issuer), jwt.WithAudience(c.id), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims["nonce"] != nonce {
+		return nil, fmt.Errorf("nonce mismatch")
+	}
+	return claims, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func getJSON(endpoint, bearer string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
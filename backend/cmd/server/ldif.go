@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/graphql"
+	"github.com/devplatform/ldap-manager/internal/ldap"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// maxLDIFImportSize bounds the body of POST /ldif
+const maxLDIFImportSize = 64 << 20
+
+// ldifTimeout replaces the server's read and write timeouts for LDIF
+// transfers, which take longer than API calls on large directories
+const ldifTimeout = 10 * time.Minute
+
+// ldifHandler serves GET /ldif?scope=users|groups|departments|all, streaming
+// an export, and POST /ldif?dryRun=true|false, importing the LDIF body and
+// answering with a JSON report. Both are for admins only.
+func ldifHandler(dir directory.Directory, logger *logrus.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := authz.FromContext(r.Context())
+		if !principal.HasRole(authz.RoleAdmin) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ldifDir, ok := dir.(graphql.LDIFDirectory)
+		if !ok {
+			http.Error(w, "LDIF needs DIRECTORY_BACKEND=ldap", http.StatusNotImplemented)
+			return
+		}
+		deadline := time.Now().Add(ldifTimeout)
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(deadline); err != nil {
+			logger.WithError(err).Debug("Failed to extend write deadline for LDIF")
+		}
+		// Imports upload the whole LDIF body first
+		if r.Method == http.MethodPost {
+			if err := rc.SetReadDeadline(deadline); err != nil {
+				logger.WithError(err).Debug("Failed to extend read deadline for LDIF")
+			}
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			scope := strings.ToLower(r.URL.Query().Get("scope"))
+			if scope == "" {
+				scope = models.LDIFScopeAll
+			}
+			switch scope {
+			case models.LDIFScopeUsers, models.LDIFScopeGroups, models.LDIFScopeDepartments, models.LDIFScopeAll:
+			default:
+				http.Error(w, "scope must be users, groups, departments or all", http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/x-ldif; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "ldap-manager-"+scope+".ldif"))
+			// Entries are already on their way, so a failure can only cut the export short
+			if err := ldifDir.ExportLDIF(r.Context(), scope, w); err != nil {
+				logger.WithError(err).WithField("scope", scope).Error("LDIF export failed")
+			}
+
+		case http.MethodPost:
+			dryRun := false
+			if value := r.URL.Query().Get("dryRun"); value != "" {
+				parsed, err := strconv.ParseBool(value)
+				if err != nil {
+					http.Error(w, "dryRun must be true or false", http.StatusBadRequest)
+					return
+				}
+				dryRun = parsed
+			}
+
+			report, err := ldifDir.ImportLDIF(r.Context(), http.MaxBytesReader(w, r.Body, maxLDIFImportSize), dryRun)
+			var tooLarge *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooLarge):
+				http.Error(w, fmt.Sprintf("LDIF larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+				return
+			case err != nil && report == nil:
+				logger.WithError(err).Error("LDIF import failed")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			case err != nil:
+				// Records read so far were applied, so the report still matters
+				logger.WithError(err).Error("LDIF import stopped")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "report": report})
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(report)
+
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// runLDIF implements "ldap-manager ldif export [--scope S] [--output FILE]"
+// and "ldap-manager ldif import [--dry-run] [FILE]"
+func runLDIF(args []string) int {
+	usage := "usage: ldap-manager ldif export [--scope users|groups|departments|all] [--output FILE]\n" +
+		"       ldap-manager ldif import [--dry-run] [FILE]"
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("ldif "+args[0], flag.ExitOnError)
+	scope := flags.String("scope", models.LDIFScopeAll, "entries to export: users, groups, departments or all")
+	output := flags.String("output", "-", "file to export to, - for stdout")
+	dryRun := flags.Bool("dry-run", false, "check the records without applying them")
+	flags.Parse(args[1:])
+
+	cfg := config.Load()
+	if cfg.DirectoryBackend != directory.BackendLDAP {
+		fmt.Fprintln(os.Stderr, "ldif needs DIRECTORY_BACKEND=ldap")
+		return 2
+	}
+
+	// Logs go to stderr so an export can be written to stdout
+	logger := setupLogger(cfg)
+	logger.SetOutput(os.Stderr)
+
+	ldapMgr, err := ldap.NewManager(cfg, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize LDAP manager: %v\n", err)
+		return 1
+	}
+	defer ldapMgr.Close()
+
+	ctx := context.Background()
+	if args[0] == "export" {
+		return exportLDIF(ctx, ldapMgr, strings.ToLower(*scope), *output)
+	}
+
+	input := io.Reader(os.Stdin)
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", path, err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+	return importLDIF(ctx, ldapMgr, input, *dryRun)
+}
+
+func exportLDIF(ctx context.Context, ldapMgr *ldap.Manager, scope, output string) int {
+	out := io.Writer(os.Stdout)
+	if output != "-" {
+		file, err := os.Create(output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", output, err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := ldapMgr.ExportLDIF(ctx, scope, out); err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func importLDIF(ctx context.Context, ldapMgr *ldap.Manager, input io.Reader, dryRun bool) int {
+	report, err := ldapMgr.ImportLDIF(ctx, input, dryRun)
+	if report != nil {
+		if dryRun {
+			fmt.Println("Dry run, no changes are made")
+		}
+		for _, record := range report.Records {
+			// Records that failed to parse may lack a changetype and DN
+			fmt.Printf("line %d: ", record.Line)
+			if subject := strings.TrimSpace(record.ChangeType + " " + record.DN); subject != "" {
+				fmt.Printf("%s: ", subject)
+			}
+			fmt.Print(record.Status)
+			if record.Error != "" {
+				fmt.Printf(": %s", record.Error)
+			}
+			fmt.Println()
+		}
+		fmt.Printf("%d applied, %d failed\n", report.Applied, report.Failed)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
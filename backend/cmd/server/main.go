@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,9 +12,19 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/devplatform/ldap-manager/internal/authz"
 	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/directory"
 	"github.com/devplatform/ldap-manager/internal/graphql"
 	"github.com/devplatform/ldap-manager/internal/ldap"
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	"github.com/devplatform/ldap-manager/internal/notify"
+	"github.com/devplatform/ldap-manager/internal/oidc"
+	"github.com/devplatform/ldap-manager/internal/registration"
+	"github.com/devplatform/ldap-manager/internal/reset"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/devplatform/ldap-manager/internal/tlsconfig"
+	"github.com/devplatform/ldap-manager/internal/token"
 	gql "github.com/graphql-go/graphql"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -48,7 +59,19 @@ var (
 	)
 )
 
+// statePurgeInterval is how often expired entries are removed from STATE_STORE=ldap
+const statePurgeInterval = 10 * time.Minute
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(runMigrate(os.Args[2:]))
+		case "ldif":
+			os.Exit(runLDIF(os.Args[2:]))
+		}
+	}
+
 	// Load configuration
 	cfg := config.Load()
 
@@ -56,42 +79,152 @@ func main() {
 	logger := setupLogger(cfg)
 	logger.Info("Starting LDAP Manager Service")
 
-	// Initialize LDAP manager
-	logger.Info("Initializing LDAP connection pool")
-	ldapMgr, err := ldap.NewManager(cfg, logger)
-	if err != nil {
-		logger.WithError(err).Fatal("Failed to initialize LDAP manager")
+	// Initialize the directory backend
+	var dir directory.Directory
+	switch cfg.DirectoryBackend {
+	case directory.BackendLDAP:
+		logger.Info("Initializing LDAP connection pool")
+		ldapMgr, err := ldap.NewManager(cfg, logger)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to initialize LDAP manager")
+		}
+		dir = ldapMgr
+	case directory.BackendMemory:
+		memDir, err := directory.NewMemoryDirectory(cfg, logger)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to initialize in-memory directory")
+		}
+		dir = memDir
+	default:
+		logger.WithField("backend", cfg.DirectoryBackend).Fatal("Unknown DIRECTORY_BACKEND, expected ldap or memory")
 	}
-	defer ldapMgr.Close()
+	defer dir.Close()
 
-	// Test LDAP connection
+	// Test the directory connection
 	ctx := context.Background()
-	if err := ldapMgr.HealthCheck(ctx); err != nil {
-		logger.WithError(err).Warn("Initial LDAP health check failed")
+	if err := dir.HealthCheck(ctx); err != nil {
+		// The pool keeps reconnecting in the background; /ready reports unavailable until it succeeds
+		logger.WithError(err).Warn("Initial LDAP health check failed, will keep retrying")
 	} else {
 		logger.Info("LDAP connection successful")
 	}
 
+	// Purge soft-deleted users once their retention period has passed
+	dir.StartPurge(cfg.DeletedUserPurgeInterval)
+
 	// Initialize GraphQL schema
 	logger.Info("Initializing GraphQL schema")
-	gqlSchema := graphql.NewSchema(ldapMgr, cfg, logger)
+	keys, err := token.NewKeySet(cfg, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load JWT signing keys")
+	}
+	keys.StartRotation(cfg.JWTKeyReloadInterval)
+	defer keys.Close()
+
+	// Login sessions, revoked access tokens, OIDC authorization codes,
+	// password reset tokens and registrations; replicas must share them,
+	// since any of them may see the next request of a login, the click on a
+	// reset link or the review of a registration. Login failures are counted
+	// there too, or each replica would allow the full number of attempts.
+	var sessions session.Store
+	var codes oidc.CodeStore
+	var resets reset.Store
+	var registrations registration.Store
+	var counters lockout.CounterStore
+	switch cfg.StateStore {
+	case "ldap":
+		ldapMgr, ok := dir.(*ldap.Manager)
+		if !ok {
+			logger.Fatal("STATE_STORE=ldap requires DIRECTORY_BACKEND=ldap")
+		}
+		sessions = ldapMgr.Sessions()
+		codes = ldapMgr.AuthCodes()
+		resets = ldapMgr.ResetTokens()
+		registrations = ldapMgr.Registrations()
+		counters = ldapMgr.LoginCounters()
+		ldapMgr.StartStatePurge(statePurgeInterval)
+	case "memory":
+		memSessions := session.NewMemoryStore(time.Minute)
+		defer memSessions.Close()
+		sessions = memSessions
+		codes = oidc.NewMemoryCodeStore()
+		resets = reset.NewMemoryStore()
+		registrations = registration.NewMemoryStore()
+		counters = lockout.NewMemoryCounterStore()
+	default:
+		logger.WithField("store", cfg.StateStore).Fatal("Unknown STATE_STORE, expected memory or ldap")
+	}
+
+	// Login throttling and account lockout, shared by GraphQL login and OIDC
+	var locks lockout.Store
+	switch cfg.LockoutStore {
+	case "ldap":
+		ldapMgr, ok := dir.(*ldap.Manager)
+		if !ok {
+			logger.Fatal("LOCKOUT_STORE=ldap requires DIRECTORY_BACKEND=ldap")
+		}
+		locks = ldapMgr.AccountLocks()
+	case "memory":
+		locks = lockout.NewMemoryStore()
+	default:
+		logger.WithField("store", cfg.LockoutStore).Fatal("Unknown LOCKOUT_STORE, expected memory or ldap")
+	}
+	guard := lockout.NewGuard(cfg, locks, counters, logger)
+
+	// Delivery of password reset links
+	var notifier notify.Notifier
+	switch cfg.Notifier {
+	case "file":
+		notifier, err = notify.NewFileNotifier(cfg.NotifierFile)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to initialize notifier")
+		}
+	default:
+		logger.WithField("notifier", cfg.Notifier).Fatal("Unknown NOTIFIER, expected file")
+	}
+
+	gqlSchema := graphql.NewSchema(dir, sessions, keys, guard, resets, registrations, notifier, cfg, logger)
+
+	// OpenID Connect provider for single sign-on into platform tools
+	var oidcProvider *oidc.Provider
+	if cfg.OIDCEnabled {
+		oidcProvider, err = oidc.NewProvider(cfg, dir, keys, guard, codes, logger)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to initialize OIDC provider")
+		}
+	}
 
 	// Setup HTTP server
-	srv := setupHTTPServer(cfg, gqlSchema, ldapMgr, logger)
+	srv := setupHTTPServer(cfg, gqlSchema, dir, keys, oidcProvider, logger)
+	tlsCfg, err := tlsconfig.Server(cfg)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to configure TLS")
+	}
+	srv.TLSConfig = tlsCfg
 
 	// Start metrics server in background
 	go startMetricsServer(cfg, logger)
 
 	// Start main server in background
 	go func() {
-		logger.WithField("port", cfg.Port).Info("Starting HTTP server")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			logger.WithFields(logrus.Fields{
+				"port":        cfg.Port,
+				"client_auth": srv.TLSConfig.ClientAuth.String(),
+			}).Info("Starting HTTPS server")
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			logger.WithField("port", cfg.Port).Info("Starting HTTP server")
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.WithError(err).Fatal("Server failed to start")
 		}
 	}()
 
 	// Wait for shutdown signal
-	waitForShutdown(srv, ldapMgr, cfg, logger)
+	waitForShutdown(srv, dir, cfg, logger)
 }
 
 func setupLogger(cfg *config.Config) *logrus.Logger {
@@ -116,7 +249,12 @@ func setupLogger(cfg *config.Config) *logrus.Logger {
 	return logger
 }
 
-func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, ldapMgr *ldap.Manager, logger *logrus.Logger) *http.Server {
+func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, dir directory.Directory, keys *token.KeySet, oidcProvider *oidc.Provider, logger *logrus.Logger) *http.Server {
+	proxies, err := cfg.TrustedProxyNets()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to parse trusted proxies")
+	}
+
 	mux := http.NewServeMux()
 
 	// GraphQL endpoint
@@ -156,6 +294,17 @@ func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, ldapMgr *lda
 		json.NewEncoder(w).Encode(result)
 	})
 
+	// LDIF export and import of users, groups and departments
+	mux.HandleFunc("/ldif", ldifHandler(dir, logger))
+
+	// Public keys for verifying our tokens without the signing secret
+	mux.Handle("/.well-known/jwks.json", keys.JWKSHandler())
+
+	// OIDC discovery, authorize, token and userinfo endpoints
+	if oidcProvider != nil {
+		oidcProvider.Register(mux)
+	}
+
 	// Health endpoint (liveness probe)
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -170,21 +319,34 @@ func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, ldapMgr *lda
 		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		defer cancel()
 
-		// Test LDAP connection
-		if err := ldapMgr.HealthCheck(ctx); err != nil {
-			logger.WithError(err).Warn("Readiness check failed")
-			w.WriteHeader(http.StatusServiceUnavailable)
-			json.NewEncoder(w).Encode(map[string]string{
-				"status": "unavailable",
-				"error":  err.Error(),
-			})
-			return
+		// Ready while a provider answers; logins, sessions and every write
+		// need one, while reads fail over to consumers, so losing those only
+		// degrades the service
+		endpoints := dir.CheckEndpoints(ctx)
+		healthy, providers := 0, 0
+		for _, ep := range endpoints {
+			if ep.Healthy {
+				healthy++
+				if ep.Role == config.RoleProvider {
+					providers++
+				}
+			}
+		}
+
+		status, code := "ready", http.StatusOK
+		switch {
+		case providers == 0:
+			status, code = "unavailable", http.StatusServiceUnavailable
+			logger.Warn("Readiness check failed, no LDAP provider available")
+		case healthy < len(endpoints):
+			status = "degraded"
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": "ready",
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    status,
+			"endpoints": endpoints,
 		})
 	})
 
@@ -192,7 +354,8 @@ func setupHTTPServer(cfg *config.Config, gqlSchema *graphql.Schema, ldapMgr *lda
 	handler := corsMiddleware(cfg)(mux)
 	handler = loggingMiddleware(logger)(handler)
 	handler = metricsMiddleware()(handler)
-	handler = authMiddleware(gqlSchema, logger)(handler)
+	handler = authMiddleware(gqlSchema, authz.NewServiceMapper(cfg.TLSClientIdentities), logger)(handler)
+	handler = clientInfoMiddleware(proxies)(handler)
 	handler = injectDependencies(handler, gqlSchema, logger)
 
 	return &http.Server{
@@ -283,22 +446,43 @@ func metricsMiddleware() func(http.Handler) http.Handler {
 	}
 }
 
-func authMiddleware(gqlSchema *graphql.Schema, logger *logrus.Logger) func(http.Handler) http.Handler {
+func authMiddleware(gqlSchema *graphql.Schema, services *authz.ServiceMapper, logger *logrus.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A verified client certificate identifies a service; a bearer token below takes precedence
+			if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				cert := r.TLS.VerifiedChains[0][0]
+				if principal, ok := services.Principal(cert); ok {
+					r = r.WithContext(authz.WithPrincipal(r.Context(), principal))
+					logger.WithField("service", cert.Subject.CommonName).Debug("Authenticated service by client certificate")
+				} else {
+					logger.WithField("subject", cert.Subject.String()).Debug("Client certificate not mapped to a service identity")
+				}
+			}
+
 			// Extract JWT token from Authorization header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
 				tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-				user, err := gqlSchema.ExtractUserFromToken(tokenString)
+				user, claims, err := gqlSchema.ExtractUserFromToken(r.Context(), tokenString)
 				if err != nil {
 					logger.WithError(err).Debug("Invalid or expired token")
 				} else {
-					// Add user to context
+					// Add user, token claims and authorization principal to context
 					ctx := context.WithValue(r.Context(), "user", user)
+					ctx = graphql.WithClaims(ctx, claims)
+					principal, err := gqlSchema.ResolvePrincipal(ctx, user)
+					if err != nil {
+						logger.WithError(err).Warn("Failed to resolve roles, continuing with base role")
+						principal = &authz.Principal{UID: user.UID, Roles: []authz.Role{authz.RoleUser}}
+					}
+					ctx = authz.WithPrincipal(ctx, principal)
 					r = r.WithContext(ctx)
-					logger.WithField("uid", user.UID).Debug("Authenticated request")
+					logger.WithFields(logrus.Fields{
+						"uid":   user.UID,
+						"roles": principal.Roles,
+					}).Debug("Authenticated request")
 				}
 			}
 
@@ -307,6 +491,63 @@ func authMiddleware(gqlSchema *graphql.Schema, logger *logrus.Logger) func(http.
 	}
 }
 
+// clientInfoMiddleware records the caller's address and user agent for session
+// tracking and login throttling. X-Forwarded-For is only believed from trusted
+// proxies, since any client can send it.
+func clientInfoMiddleware(proxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := session.WithClient(r.Context(), session.ClientInfo{
+				IP:        clientIP(r, proxies),
+				UserAgent: r.UserAgent(),
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// clientIP returns the peer address, or behind trusted proxies the last
+// forwarded address not belonging to one of them. Proxies append to
+// X-Forwarded-For, so entries left of that may be forged.
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	if !trustedProxy(ip, proxies) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !trustedProxy(hop, proxies) {
+			break
+		}
+	}
+	return ip
+}
+
+func trustedProxy(ip string, proxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 func injectDependencies(next http.Handler, gqlSchema *graphql.Schema, logger *logrus.Logger) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Inject dependencies into context
@@ -330,7 +571,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-func waitForShutdown(srv *http.Server, ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger) {
+// Unwrap lets http.ResponseController reach the underlying connection
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func waitForShutdown(srv *http.Server, dir directory.Directory, cfg *config.Config, logger *logrus.Logger) {
 	// Create channel to listen for interrupt signals
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -354,9 +600,9 @@ func waitForShutdown(srv *http.Server, ldapMgr *ldap.Manager, cfg *config.Config
 		logger.WithError(err).Error("Server shutdown failed")
 	}
 
-	// Close LDAP connection pool
-	logger.Info("Closing LDAP connections...")
-	ldapMgr.Close()
+	// Close the directory, including the LDAP connection pool
+	logger.Info("Closing directory connections...")
+	dir.Close()
 
 	logger.Info("Shutdown complete")
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/ldap"
+)
+
+// runMigrate implements "ldap-manager migrate [--dry-run]": it installs the
+// schema, creates the base OUs and prints what was changed
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print the changes without making them")
+	flags.Parse(args)
+
+	cfg := config.Load()
+	if cfg.DirectoryBackend != directory.BackendLDAP {
+		fmt.Fprintln(os.Stderr, "migrate needs DIRECTORY_BACKEND=ldap")
+		return 2
+	}
+
+	// Logs go to stderr so the report can be piped
+	logger := setupLogger(cfg)
+	logger.SetOutput(os.Stderr)
+
+	ldapMgr, err := ldap.NewManager(cfg, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize LDAP manager: %v\n", err)
+		return 1
+	}
+	defer ldapMgr.Close()
+
+	results, err := ldapMgr.Migrate(context.Background(), *dryRun)
+	if *dryRun {
+		fmt.Println("Dry run, no changes are made")
+	}
+	for _, result := range results {
+		fmt.Printf("%03d %s: %s\n", result.Version, result.Name, result.Status)
+		for _, change := range result.Changes {
+			fmt.Printf("    %s\n", change)
+		}
+		if result.Reason != "" {
+			fmt.Printf("    %s\n", result.Reason)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package authz
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Role is a coarse permission level granted to a principal
+type Role string
+
+const (
+	// RoleAdmin may read and change everything managed by the service
+	RoleAdmin Role = "admin"
+	// RoleAuditor may read everything but change nothing
+	RoleAuditor Role = "auditor"
+	// RoleUser is granted to every authenticated principal
+	RoleUser Role = "user"
+)
+
+// Principal is the authenticated caller of a request. Service principals
+// authenticate with a client certificate and do not correspond to an LDAP user.
+type Principal struct {
+	UID     string
+	Groups  []string
+	Roles   []Role
+	Service bool
+}
+
+// HasRole returns true if the principal holds any of the given roles
+func (p *Principal) HasRole(roles ...Role) bool {
+	if p == nil {
+		return false
+	}
+	for _, have := range p.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, if any
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// RoleMapper maps LDAP group membership to roles
+type RoleMapper struct {
+	groupRoles map[string]Role
+}
+
+// NewRoleMapper creates a mapper from a group CN -> role name table
+func NewRoleMapper(groupRoles map[string]string) *RoleMapper {
+	m := &RoleMapper{groupRoles: make(map[string]Role, len(groupRoles))}
+	for group, role := range groupRoles {
+		m.groupRoles[strings.ToLower(group)] = Role(strings.ToLower(role))
+	}
+	return m
+}
+
+// Principal builds a principal for uid from the CNs of the groups it belongs to
+func (m *RoleMapper) Principal(uid string, groups []string) *Principal {
+	p := &Principal{
+		UID:    uid,
+		Groups: groups,
+		Roles:  []Role{RoleUser},
+	}
+	for _, group := range groups {
+		role, ok := m.groupRoles[strings.ToLower(group)]
+		if ok && !p.HasRole(role) {
+			p.Roles = append(p.Roles, role)
+		}
+	}
+	return p
+}
+
+// ServiceMapper maps client certificate subjects to service principals
+type ServiceMapper struct {
+	identities map[string]Role
+}
+
+// NewServiceMapper creates a mapper from a certificate CN -> role name table
+func NewServiceMapper(identities map[string]string) *ServiceMapper {
+	m := &ServiceMapper{identities: make(map[string]Role, len(identities))}
+	for cn, role := range identities {
+		m.identities[cn] = Role(strings.ToLower(role))
+	}
+	return m
+}
+
+// Principal returns the service principal for a verified client certificate,
+// or false if its subject is not a registered service identity
+func (m *ServiceMapper) Principal(cert *x509.Certificate) (*Principal, bool) {
+	role, ok := m.identities[cert.Subject.CommonName]
+	if !ok {
+		return nil, false
+	}
+	return &Principal{
+		UID:     "service:" + cert.Subject.CommonName,
+		Roles:   []Role{role},
+		Service: true,
+	}, true
+}
+
+// Error is an authorization failure reported to GraphQL clients
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Extensions exposes the error code in the GraphQL error response
+func (e *Error) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// ErrUnauthenticated is returned when a rule needs a principal and there is none
+var ErrUnauthenticated = &Error{
+	Code:    "UNAUTHENTICATED",
+	Message: "authentication required",
+}
+
+// Forbidden returns the error reported when a principal lacks permission for a field
+func Forbidden(field string) *Error {
+	return &Error{
+		Code:    "FORBIDDEN",
+		Message: fmt.Sprintf("not authorized to access %s", field),
+	}
+}
@@ -0,0 +1,94 @@
+package authz_test
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+)
+
+func TestRoleMapper(t *testing.T) {
+	mapper := authz.NewRoleMapper(map[string]string{"Admins": "ADMIN", "auditors": "auditor", "ops": "admin"})
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   []authz.Role
+	}{
+		{"no groups", nil, []authz.Role{authz.RoleUser}},
+		{"unmapped group", []string{"developers"}, []authz.Role{authz.RoleUser}},
+		{"case of the group", []string{"ADMINS"}, []authz.Role{authz.RoleUser, authz.RoleAdmin}},
+		{"one role per name", []string{"admins", "ops", "auditors"}, []authz.Role{authz.RoleUser, authz.RoleAdmin, authz.RoleAuditor}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mapper.Principal("alice", tt.groups)
+			if p.UID != "alice" || p.Service {
+				t.Fatalf("principal = %+v, want the user alice", p)
+			}
+			if !reflect.DeepEqual(p.Roles, tt.want) {
+				t.Fatalf("roles = %v, want %v", p.Roles, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceMapper(t *testing.T) {
+	mapper := authz.NewServiceMapper(map[string]string{"backup": "Auditor"})
+	cert := func(cn string) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+	}
+
+	p, ok := mapper.Principal(cert("backup"))
+	if !ok || p.UID != "service:backup" || !p.Service || !p.HasRole(authz.RoleAuditor) {
+		t.Fatalf("principal = %+v, %v, want the auditor service backup", p, ok)
+	}
+	if p, ok := mapper.Principal(cert("intruder")); ok {
+		t.Fatalf("principal = %+v, want none for an unknown subject", p)
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := authz.Policy{
+		"Query.health": authz.Public(),
+		"Query.me":     authz.Authenticated(),
+		"Query.users":  authz.RequireRole(authz.RoleAdmin, authz.RoleAuditor),
+		"Query.user":   authz.SelfOrRole("uid", authz.RoleAdmin),
+	}
+	user := &authz.Principal{UID: "alice", Roles: []authz.Role{authz.RoleUser}}
+	auditor := &authz.Principal{UID: "carol", Roles: []authz.Role{authz.RoleUser, authz.RoleAuditor}}
+	// A service named like a user is not that user
+	service := &authz.Principal{UID: "alice", Roles: []authz.Role{authz.RoleAuditor}, Service: true}
+
+	tests := []struct {
+		name      string
+		principal *authz.Principal
+		field     string
+		args      map[string]interface{}
+		want      error
+	}{
+		{"public", nil, "Query.health", nil, nil},
+		{"anonymous", nil, "Query.me", nil, authz.ErrUnauthenticated},
+		{"authenticated", user, "Query.me", nil, nil},
+		{"role", auditor, "Query.users", nil, nil},
+		{"missing role", user, "Query.users", nil, authz.Forbidden("Query.users")},
+		{"self", user, "Query.user", map[string]interface{}{"uid": "alice"}, nil},
+		{"other user", user, "Query.user", map[string]interface{}{"uid": "bob"}, authz.Forbidden("Query.user")},
+		{"service as self", service, "Query.user", map[string]interface{}{"uid": "alice"}, authz.Forbidden("Query.user")},
+		{"field without rule", auditor, "Mutation.deleteUser", nil, authz.Forbidden("Mutation.deleteUser")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = authz.WithPrincipal(ctx, tt.principal)
+			}
+			if err := policy.Check(ctx, tt.field, tt.args); !reflect.DeepEqual(err, tt.want) {
+				t.Fatalf("Check = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
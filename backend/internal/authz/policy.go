@@ -0,0 +1,64 @@
+package authz
+
+import (
+	"context"
+)
+
+// Rule decides whether a principal may resolve a field with the given arguments.
+// p is nil for anonymous callers.
+type Rule func(p *Principal, args map[string]interface{}) bool
+
+// Policy maps "Type.field" names to the rule guarding them
+type Policy map[string]Rule
+
+// Check enforces the rule for field. Fields without a rule are denied.
+func (pol Policy) Check(ctx context.Context, field string, args map[string]interface{}) error {
+	p, _ := FromContext(ctx)
+
+	rule, ok := pol[field]
+	if !ok {
+		return Forbidden(field)
+	}
+	if rule(p, args) {
+		return nil
+	}
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	return Forbidden(field)
+}
+
+// Public allows every caller, authenticated or not
+func Public() Rule {
+	return func(p *Principal, args map[string]interface{}) bool {
+		return true
+	}
+}
+
+// Authenticated allows any authenticated principal
+func Authenticated() Rule {
+	return func(p *Principal, args map[string]interface{}) bool {
+		return p != nil
+	}
+}
+
+// RequireRole allows principals holding any of the given roles
+func RequireRole(roles ...Role) Rule {
+	return func(p *Principal, args map[string]interface{}) bool {
+		return p.HasRole(roles...)
+	}
+}
+
+// SelfOrRole allows the user whose uid equals the named argument, or
+// principals holding any of the given roles
+func SelfOrRole(arg string, roles ...Role) Rule {
+	return func(p *Principal, args map[string]interface{}) bool {
+		if p == nil {
+			return false
+		}
+		if uid, ok := args[arg].(string); ok && !p.Service && uid == p.UID {
+			return true
+		}
+		return p.HasRole(roles...)
+	}
+}
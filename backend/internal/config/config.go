@@ -2,15 +2,41 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
+// IDAllocatorCN is the CN of the entry tracking the next free uidNumber/gidNumber
+const IDAllocatorCN = "id-allocator"
+
+// MigrationsCN is the CN of the entry recording the migrations applied by "ldap-manager migrate"
+const MigrationsCN = "migrations"
+
+// LDAP endpoint roles: providers accept writes, consumers are read-only replicas
+const (
+	RoleProvider = "provider"
+	RoleConsumer = "consumer"
+)
+
+// LDAPEndpoint is one directory server the service talks to
+type LDAPEndpoint struct {
+	URL  string
+	Role string
+}
+
 // Config holds all configuration for the LDAP manager service
 type Config struct {
+	// Directory backend: ldap, or memory for local development and tests. The
+	// memory backend starts empty and loses everything on restart; with
+	// DIRECTORY_MEMORY_ADMIN_PASSWORD it is seeded with an admin user.
+	DirectoryBackend             string `envconfig:"DIRECTORY_BACKEND" default:"ldap"`
+	DirectoryMemoryAdminPassword string `envconfig:"DIRECTORY_MEMORY_ADMIN_PASSWORD"`
+
 	// LDAP configuration
-	LDAPURL             string        `envconfig:"LDAP_URL" required:"true"`
+	LDAPURL             string        `envconfig:"LDAP_URL"`
 	LDAPBaseDN          string        `envconfig:"LDAP_BASE_DN" required:"true"`
 	LDAPBindDN          string        `envconfig:"LDAP_BIND_DN" required:"true"`
 	LDAPBindPassword    string        `envconfig:"LDAP_BIND_PASSWORD" required:"true"`
@@ -19,16 +45,157 @@ type Config struct {
 	LDAPConnTimeout     time.Duration `envconfig:"LDAP_CONN_TIMEOUT" default:"10s"`
 	LDAPMaxConnLifetime time.Duration `envconfig:"LDAP_MAX_CONN_LIFETIME" default:"30m"`
 
+	// LDAP endpoints as role=url pairs, e.g. "provider=ldap://openldap1:389,consumer=ldap://openldap2:389".
+	// Writes go to providers and reads are spread across healthy consumers. Without it,
+	// LDAP_URL is the only provider. After a write the same caller reads from a provider
+	// for LDAP_READ_YOUR_WRITES, so it sees its change before it has replicated (0 disables).
+	LDAPEndpoints      []string      `envconfig:"LDAP_ENDPOINTS"`
+	LDAPReadYourWrites time.Duration `envconfig:"LDAP_READ_YOUR_WRITES" default:"5s"`
+
+	// cn=config credentials "ldap-manager migrate" installs the schema with, e.g.
+	// cn=admin,cn=config. Without them it tries LDAP_BIND_DN.
+	LDAPConfigBindDN       string `envconfig:"LDAP_CONFIG_BIND_DN"`
+	LDAPConfigBindPassword string `envconfig:"LDAP_CONFIG_BIND_PASSWORD"`
+
+	// LDAP connection pool: LDAP_POOL_SIZE is the upper bound, connections
+	// beyond LDAP_POOL_MIN_SIZE are closed after LDAP_POOL_IDLE_TIMEOUT
+	LDAPPoolMinSize          int           `envconfig:"LDAP_POOL_MIN_SIZE" default:"1"`
+	LDAPPoolIdleTimeout      time.Duration `envconfig:"LDAP_POOL_IDLE_TIMEOUT" default:"5m"`
+	LDAPHealthCheckInterval  time.Duration `envconfig:"LDAP_HEALTH_CHECK_INTERVAL" default:"30s"`
+	LDAPReconnectBackoffBase time.Duration `envconfig:"LDAP_RECONNECT_BACKOFF_BASE" default:"500ms"`
+	LDAPReconnectBackoffMax  time.Duration `envconfig:"LDAP_RECONNECT_BACKOFF_MAX" default:"30s"`
+
+	// LDAP searches: results are read in pages of LDAP_PAGE_SIZE (RFC 2696). With
+	// LDAP_SERVER_SIDE_SORT the server sorts users (RFC 2891, needs the sssvlv overlay),
+	// by LDAP_USER_SORT_ATTRIBUTE unless a query asks otherwise. Counts stop at
+	// LDAP_COUNT_LIMIT and are reported as estimated.
+	LDAPPageSize          int    `envconfig:"LDAP_PAGE_SIZE" default:"500"`
+	LDAPServerSideSort    bool   `envconfig:"LDAP_SERVER_SIDE_SORT" default:"false"`
+	LDAPUserSortAttribute string `envconfig:"LDAP_USER_SORT_ATTRIBUTE" default:"uid"`
+	LDAPCountLimit        int    `envconfig:"LDAP_COUNT_LIMIT" default:"10000"`
+
+	// Nested groups: how effective memberships are resolved. in_chain uses
+	// LDAP_MATCHING_RULE_IN_CHAIN filters (Active Directory), memberof follows the
+	// memberOf attributes kept by the memberof overlay, client walks member lists.
+	// auto uses in_chain when the server advertises it and client otherwise.
+	LDAPNestedGroups string `envconfig:"LDAP_NESTED_GROUPS" default:"auto"`
+
+	// LDAP TLS: used for ldaps:// URLs and for StartTLS on ldap:// URLs
+	LDAPStartTLS              bool   `envconfig:"LDAP_START_TLS" default:"false"`
+	LDAPCACertFile            string `envconfig:"LDAP_CA_CERT_FILE"`
+	LDAPClientCertFile        string `envconfig:"LDAP_CLIENT_CERT_FILE"`
+	LDAPClientKeyFile         string `envconfig:"LDAP_CLIENT_KEY_FILE"`
+	LDAPTLSMinVersion         string `envconfig:"LDAP_TLS_MIN_VERSION" default:"1.2"`
+	LDAPTLSServerName         string `envconfig:"LDAP_TLS_SERVER_NAME"`
+	LDAPTLSInsecureSkipVerify bool   `envconfig:"LDAP_TLS_INSECURE_SKIP_VERIFY" default:"false"`
+
 	// Server configuration
 	Port        int    `envconfig:"PORT" default:"8080"`
 	MetricsPort int    `envconfig:"METRICS_PORT" default:"9090"`
 	Environment string `envconfig:"ENVIRONMENT" default:"development"`
 	LogLevel    string `envconfig:"LOG_LEVEL" default:"info"`
+	// Addresses or CIDRs of reverse proxies whose X-Forwarded-For is believed,
+	// e.g. "10.0.0.0/8". Without them the client is the peer of the connection.
+	TrustedProxies []string `envconfig:"TRUSTED_PROXIES"`
+
+	// HTTPS for the API; setting TLS_CLIENT_CA_FILE enables client certificates
+	TLSCertFile     string `envconfig:"TLS_CERT_FILE"`
+	TLSKeyFile      string `envconfig:"TLS_KEY_FILE"`
+	TLSClientCAFile string `envconfig:"TLS_CLIENT_CA_FILE"`
+	TLSClientAuth   string `envconfig:"TLS_CLIENT_AUTH" default:"optional"`
+	TLSMinVersion   string `envconfig:"TLS_MIN_VERSION" default:"1.2"`
+	// Maps client certificate subject CNs to roles, e.g. "gitea-sync:admin,grafana:auditor"
+	TLSClientIdentities map[string]string `envconfig:"TLS_CLIENT_IDENTITIES"`
 
 	// JWT configuration
-	JWTSecret     string        `envconfig:"JWT_SECRET" required:"true"`
-	JWTExpiration time.Duration `envconfig:"JWT_EXPIRATION" default:"24h"`
-	// to redo as mtls or both
+	JWTSecret            string        `envconfig:"JWT_SECRET"`
+	JWTExpiration        time.Duration `envconfig:"JWT_EXPIRATION" default:"15m"`
+	JWTRefreshExpiration time.Duration `envconfig:"JWT_REFRESH_EXPIRATION" default:"720h"`
+	// Signing: HS256 uses JWT_SECRET; RS256, ES256 and EdDSA use PEM keys from JWT_KEYS_DIR
+	JWTSigningAlg         string        `envconfig:"JWT_SIGNING_ALG" default:"HS256"`
+	JWTKeysDir            string        `envconfig:"JWT_KEYS_DIR"`
+	JWTKeyReloadInterval  time.Duration `envconfig:"JWT_KEY_RELOAD_INTERVAL" default:"5m"`
+	JWTKeyActivationDelay time.Duration `envconfig:"JWT_KEY_ACTIVATION_DELAY" default:"10m"`
+
+	// OpenID Connect provider; requires an asymmetric JWT_SIGNING_ALG.
+	// OIDC_CLIENTS_FILE is a JSON array of {client_id, client_secret, name, redirect_uris}.
+	OIDCEnabled         bool          `envconfig:"OIDC_ENABLED" default:"false"`
+	OIDCIssuer          string        `envconfig:"OIDC_ISSUER"`
+	OIDCClientsFile     string        `envconfig:"OIDC_CLIENTS_FILE" default:"/etc/ldap-manager/oidc-clients.json"`
+	OIDCCodeExpiration  time.Duration `envconfig:"OIDC_CODE_EXPIRATION" default:"1m"`
+	OIDCTokenExpiration time.Duration `envconfig:"OIDC_TOKEN_EXPIRATION" default:"1h"`
+
+	// Password hashing: SSHA, SSHA512, CRYPT-SHA512 or ARGON2
+	PasswordScheme string `envconfig:"PASSWORD_SCHEME" default:"SSHA"`
+
+	// Password policy
+	PasswordMinLength   int      `envconfig:"PASSWORD_MIN_LENGTH" default:"10"`
+	PasswordMinClasses  int      `envconfig:"PASSWORD_MIN_CLASSES" default:"3"`
+	PasswordBannedWords []string `envconfig:"PASSWORD_BANNED_WORDS" default:"password,changeme,welcome,qwerty,devplatform"`
+	// Number of recent passwords that may not be reused; 0 disables history.
+	// Old hashes are kept in PasswordHistoryAttr, which the schema must allow;
+	// migration 6 adds the default passwordHistory.
+	PasswordHistory     int    `envconfig:"PASSWORD_HISTORY" default:"0"`
+	PasswordHistoryAttr string `envconfig:"PASSWORD_HISTORY_ATTRIBUTE" default:"passwordHistory"`
+
+	// Self-service password reset: the link sent is PASSWORD_RESET_URL?token=...
+	PasswordResetTTL time.Duration `envconfig:"PASSWORD_RESET_TTL" default:"30m"`
+	PasswordResetURL string        `envconfig:"PASSWORD_RESET_URL" default:"http://localhost:5173/reset-password"`
+
+	// Self-service registration: maps the requested userType to the groups joined on
+	// approval, several groups separated by ";", e.g. "developer:developers;gitea-users"
+	RegistrationEnabled    bool              `envconfig:"REGISTRATION_ENABLED" default:"true"`
+	RegistrationUserTypes  map[string]string `envconfig:"REGISTRATION_USER_TYPES" default:"developer:developers"`
+	RegistrationMaxPending int               `envconfig:"REGISTRATION_MAX_PENDING" default:"500"`
+	// Registrations one client IP may submit within LOGIN_FAILURE_WINDOW before
+	// it is throttled with the login backoff
+	RegistrationBackoffAfter int `envconfig:"REGISTRATION_BACKOFF_AFTER" default:"3"`
+
+	// Bulk user import: rows accepted per CSV and users created in parallel
+	UserImportMaxRows     int `envconfig:"USER_IMPORT_MAX_ROWS" default:"200"`
+	UserImportConcurrency int `envconfig:"USER_IMPORT_CONCURRENCY" default:"4"`
+	// Time after which an import starts no more users. The report holds the
+	// only copy of the initial passwords, so this must leave it time to reach
+	// the client within the server's 15s write timeout.
+	UserImportTimeout time.Duration `envconfig:"USER_IMPORT_TIMEOUT" default:"10s"`
+
+	// Notification delivery: "file" appends messages to NOTIFIER_FILE ("-" for stdout)
+	Notifier     string `envconfig:"NOTIFIER" default:"file"`
+	NotifierFile string `envconfig:"NOTIFIER_FILE" default:"-"`
+
+	// Login throttling: exponential backoff from LOGIN_BACKOFF_AFTER failures per uid
+	// (LOGIN_IP_BACKOFF_AFTER per client IP), lockout after LOGIN_MAX_FAILURES (0 disables).
+	// A LOGIN_LOCKOUT_DURATION of 0 keeps accounts locked until an admin unlocks them.
+	LoginMaxFailures     int           `envconfig:"LOGIN_MAX_FAILURES" default:"5"`
+	LoginLockoutDuration time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+	LoginFailureWindow   time.Duration `envconfig:"LOGIN_FAILURE_WINDOW" default:"15m"`
+	LoginBackoffAfter    int           `envconfig:"LOGIN_BACKOFF_AFTER" default:"3"`
+	LoginIPBackoffAfter  int           `envconfig:"LOGIN_IP_BACKOFF_AFTER" default:"10"`
+	LoginBackoffBase     time.Duration `envconfig:"LOGIN_BACKOFF_BASE" default:"1s"`
+	LoginBackoffMax      time.Duration `envconfig:"LOGIN_BACKOFF_MAX" default:"5m"`
+	// Where account locks are kept: memory, or ldap (pwdAccountLockedTime, needs the ppolicy schema)
+	LockoutStore string `envconfig:"LOCKOUT_STORE" default:"memory"`
+
+	// Where login sessions, OIDC authorization codes, password reset tokens,
+	// registrations and login failure counters are kept: memory, or ldap (entries
+	// below StateDN(), needs migration 4). Replicas only share them with ldap.
+	StateStore string `envconfig:"STATE_STORE" default:"memory"`
+
+	// Authorization: maps group CNs under GroupsDN() to roles, e.g. "admins:admin,auditors:auditor"
+	RoleGroups map[string]string `envconfig:"ROLE_GROUPS" default:"admins:admin"`
+	// How long the groups behind a user's roles are remembered between requests.
+	// Changes made through this replica drop the affected entries right away;
+	// changes made elsewhere take up to this long to change a user's roles.
+	PrincipalCacheTTL time.Duration `envconfig:"PRINCIPAL_CACHE_TTL" default:"5s"`
+	// Maps department OUs to the group CN their members belong to, e.g. "engineering:developers".
+	// moveUser swaps the group of the old department for the one of the new department.
+	DepartmentGroups map[string]string `envconfig:"DEPARTMENT_GROUPS"`
+
+	// Deleted users: deleteUser moves users to DeletedUsersDN(), where they can be
+	// restored for DELETED_USER_RETENTION. A job running every
+	// DELETED_USER_PURGE_INTERVAL removes them afterwards.
+	DeletedUserRetention     time.Duration `envconfig:"DELETED_USER_RETENTION" default:"720h"`
+	DeletedUserPurgeInterval time.Duration `envconfig:"DELETED_USER_PURGE_INTERVAL" default:"1h"`
 
 	// CORS configuration
 	CORSOrigins []string `envconfig:"CORS_ORIGINS" default:"*"`
@@ -36,7 +203,7 @@ type Config struct {
 	// Graceful shutdown timeout
 	ShutdownTimeout int `envconfig:"SHUTDOWN_TIMEOUT" default:"30"`
 
-	// Starting UID and GID for auto-increment
+	// Lowest UID and GID handed out by the directory-backed allocator
 	StartingUID int `envconfig:"STARTING_UID" default:"10000"`
 	StartingGID int `envconfig:"STARTING_GID" default:"10000"`
 }
@@ -51,6 +218,64 @@ func Load() *Config {
 	return &cfg
 }
 
+// LDAPEndpointList parses LDAP_ENDPOINTS, falling back to LDAP_URL as the only provider
+func (c *Config) LDAPEndpointList() ([]LDAPEndpoint, error) {
+	if len(c.LDAPEndpoints) == 0 {
+		if c.LDAPURL == "" {
+			return nil, fmt.Errorf("LDAP_URL or LDAP_ENDPOINTS is required")
+		}
+		return []LDAPEndpoint{{URL: c.LDAPURL, Role: RoleProvider}}, nil
+	}
+
+	var endpoints []LDAPEndpoint
+	providers := 0
+	for _, entry := range c.LDAPEndpoints {
+		role, url, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || url == "" {
+			return nil, fmt.Errorf("invalid LDAP_ENDPOINTS entry %q, expected role=url", entry)
+		}
+		role = strings.ToLower(role)
+		switch role {
+		case RoleProvider:
+			providers++
+		case RoleConsumer:
+		default:
+			return nil, fmt.Errorf("invalid LDAP endpoint role %q, expected %s or %s", role, RoleProvider, RoleConsumer)
+		}
+		endpoints = append(endpoints, LDAPEndpoint{URL: url, Role: role})
+	}
+	if providers == 0 {
+		return nil, fmt.Errorf("LDAP_ENDPOINTS needs at least one %s", RoleProvider)
+	}
+	return endpoints, nil
+}
+
+// TrustedProxyNets parses TRUSTED_PROXIES; single addresses become host networks
+func (c *Config) TrustedProxyNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range c.TrustedProxies {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q, expected an address or CIDR", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q, expected an address or CIDR", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
 // UserDN returns the full DN for a user
 func (c *Config) UserDN(uid string) string {
 	return fmt.Sprintf("uid=%s,ou=users,%s", uid, c.LDAPBaseDN)
@@ -66,6 +291,16 @@ func (c *Config) GroupDN(cn string) string {
 	return fmt.Sprintf("cn=%s,ou=groups,%s", cn, c.LDAPBaseDN)
 }
 
+// IDAllocatorDN returns the DN of the UID/GID allocator entry
+func (c *Config) IDAllocatorDN() string {
+	return fmt.Sprintf("cn=%s,%s", IDAllocatorCN, c.LDAPBaseDN)
+}
+
+// MigrationsDN returns the DN of the entry recording applied migrations
+func (c *Config) MigrationsDN() string {
+	return fmt.Sprintf("cn=%s,%s", MigrationsCN, c.LDAPBaseDN)
+}
+
 // UsersDN returns the base DN for all users
 func (c *Config) UsersDN() string {
 	return fmt.Sprintf("ou=users,%s", c.LDAPBaseDN)
@@ -76,6 +311,16 @@ func (c *Config) DepartmentsDN() string {
 	return fmt.Sprintf("ou=departments,%s", c.LDAPBaseDN)
 }
 
+// DeletedUsersDN returns the container soft-deleted users are moved to
+func (c *Config) DeletedUsersDN() string {
+	return fmt.Sprintf("ou=deleted,%s", c.LDAPBaseDN)
+}
+
+// StateDN returns the container of state shared between replicas
+func (c *Config) StateDN() string {
+	return fmt.Sprintf("ou=state,%s", c.LDAPBaseDN)
+}
+
 // GroupsDN returns the base DN for all groups
 func (c *Config) GroupsDN() string {
 	return fmt.Sprintf("ou=groups,%s", c.LDAPBaseDN)
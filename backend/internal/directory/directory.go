@@ -0,0 +1,93 @@
+package directory
+
+import (
+	"context"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/ldap"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
+)
+
+// Backends selectable with DIRECTORY_BACKEND
+const (
+	BackendLDAP   = "ldap"
+	BackendMemory = "memory"
+)
+
+// WithProvider marks ctx so that the LDAP backend reads from a provider
+// instead of a replica that may lag behind. The memory backend ignores it.
+func WithProvider(ctx context.Context) context.Context {
+	return ldap.WithProvider(ctx)
+}
+
+// Directory is the store of users, departments and groups behind the API.
+// *ldap.Manager implements it against OpenLDAP; MemoryDirectory keeps the
+// same entries in process memory for local development and tests.
+// Implementations must be safe for concurrent use.
+type Directory interface {
+	// Users
+	CreateUser(ctx context.Context, input *models.CreateUserInput) (*models.User, error)
+	GetUser(ctx context.Context, uid string) (*models.User, error)
+	ListUsers(ctx context.Context, filter *models.SearchFilter) ([]*models.User, error)
+	ListUsersPaginated(ctx context.Context, filter *models.SearchFilter, sort *models.UserSort, page int, limit int) (*models.UserPage, error)
+	ListUsersConnection(ctx context.Context, filter *models.SearchFilter, sort *models.UserSort, first int, after string) (*models.UserConnection, error)
+	FindUserByMail(ctx context.Context, mail string) (*models.User, error)
+	UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error)
+	DeleteUser(ctx context.Context, uid string) (*models.DeletionSummary, error)
+	RenameUser(ctx context.Context, oldUID, newUID string) (*models.User, error)
+	MoveUser(ctx context.Context, uid, department string) (*models.User, error)
+
+	// Account lifecycle
+	DisableUser(ctx context.Context, uid string) (*models.User, error)
+	EnableUser(ctx context.Context, uid string) (*models.User, error)
+	SoftDeleteUser(ctx context.Context, uid string) (*models.DeletionSummary, error)
+	RestoreUser(ctx context.Context, uid string) (*models.User, error)
+	PurgeDeletedUsers(ctx context.Context) (int, error)
+	StartPurge(interval time.Duration)
+
+	// Credentials
+	Authenticate(ctx context.Context, uid, password string) (*models.User, error)
+	PreparePassword(plain string, subject password.Subject) (string, error)
+	SetPassword(ctx context.Context, uid, plain string) error
+
+	// Departments
+	CreateDepartment(ctx context.Context, input *models.CreateDepartmentInput) (*models.Department, error)
+	GetDepartment(ctx context.Context, ou string) (*models.Department, error)
+	ListDepartments(ctx context.Context) ([]*models.Department, error)
+	UpdateDepartment(ctx context.Context, input *models.UpdateDepartmentInput) (*models.Department, error)
+	DeleteDepartment(ctx context.Context, ou, reassignTo string) (*models.DeletionSummary, error)
+	MoveDepartment(ctx context.Context, ou, parent string) (*models.Department, error)
+	AssignRepositoryToDepartment(ctx context.Context, ou string, repos []string) error
+	GetUsersByDepartment(ctx context.Context, department string, includeDescendants bool) ([]*models.User, error)
+	GetDepartmentChildren(ctx context.Context, ou string) ([]*models.Department, error)
+	GetDepartmentAncestors(ctx context.Context, ou string) ([]*models.Department, error)
+	GetDepartmentTree(ctx context.Context) ([]*models.Department, error)
+
+	// Groups
+	CreateGroup(ctx context.Context, cn, description string) (*models.Group, error)
+	GetGroup(ctx context.Context, cn string) (*models.Group, error)
+	ListGroups(ctx context.Context, filter *models.GroupFilter, page, limit int) (*models.GroupPage, error)
+	UpdateGroup(ctx context.Context, input *models.UpdateGroupInput) (*models.Group, error)
+	DeleteGroup(ctx context.Context, cn string) error
+	AddUserToGroup(ctx context.Context, uid, groupCN string) error
+	RemoveUserFromGroup(ctx context.Context, uid, groupCN string) error
+	SetGroupMembers(ctx context.Context, groupCN string, uids []string) (*models.Group, error)
+	GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error)
+	AddGroupToGroup(ctx context.Context, groupCN, parentCN string) error
+	RemoveGroupFromGroup(ctx context.Context, groupCN, parentCN string) error
+	EffectiveGroups(ctx context.Context, uid string) ([]*models.Group, error)
+	EffectiveMembers(ctx context.Context, cn string) ([]string, error)
+
+	// Operations
+	HealthCheck(ctx context.Context) error
+	CheckEndpoints(ctx context.Context) []*models.EndpointStats
+	GetStats() *models.Stats
+	IDAllocation(ctx context.Context) (*models.IDAllocation, error)
+	Close() error
+}
+
+var (
+	_ Directory = (*ldap.Manager)(nil)
+	_ Directory = (*MemoryDirectory)(nil)
+)
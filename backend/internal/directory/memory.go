@@ -0,0 +1,1083 @@
+package directory
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryDirectory keeps users, departments and groups in process memory,
+// laid out, validated and reported like the entries *ldap.Manager writes:
+// the same DNs, uniqueness rules, error messages and LDAP result codes.
+// Everything is lost on restart.
+type MemoryDirectory struct {
+	config         *config.Config
+	logger         *logrus.Logger
+	hasher         *password.Hasher
+	passwordPolicy *password.Policy
+
+	mu          sync.RWMutex
+	users       map[string]*memoryUser
+	deleted     map[string]*memoryUser
+	departments map[string]*memoryDepartment
+	groups      map[string]*memoryGroup
+	// seq orders entries by creation, the order OpenLDAP returns them in
+	seq     int
+	nextUID int
+	nextGID int
+	closed  bool
+	done    chan struct{}
+}
+
+type memoryUser struct {
+	seq          int
+	uid          string
+	cn           string
+	sn           string
+	givenName    string
+	mail         string
+	department   string
+	uidNumber    int
+	gidNumber    int
+	homeDir      string
+	repositories []string
+	password     string
+	history      []string
+	disabled     bool
+	// deletedAt is the day the user was soft deleted
+	deletedAt time.Time
+	// removedGroups and removedManaged are the group CNs and department OUs
+	// soft deletion took the user out of, for RestoreUser
+	removedGroups  []string
+	removedManaged []string
+}
+
+// NewMemoryDirectory creates an empty directory. When
+// DIRECTORY_MEMORY_ADMIN_PASSWORD is set, it is seeded with an admin user
+// in every group that grants the admin role, so the API can be used right away.
+func NewMemoryDirectory(cfg *config.Config, logger *logrus.Logger) (*MemoryDirectory, error) {
+	hasher, err := password.NewHasher(cfg.PasswordScheme)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MemoryDirectory{
+		config: cfg,
+		logger: logger,
+		hasher: hasher,
+		passwordPolicy: &password.Policy{
+			MinLength:   cfg.PasswordMinLength,
+			MinClasses:  cfg.PasswordMinClasses,
+			BannedWords: cfg.PasswordBannedWords,
+			HistorySize: cfg.PasswordHistory,
+		},
+		users:       make(map[string]*memoryUser),
+		deleted:     make(map[string]*memoryUser),
+		departments: make(map[string]*memoryDepartment),
+		groups:      make(map[string]*memoryGroup),
+		nextUID:     cfg.StartingUID,
+		nextGID:     cfg.StartingGID,
+		done:        make(chan struct{}),
+	}
+
+	if cfg.DirectoryMemoryAdminPassword != "" {
+		if err := m.seedAdmin(cfg.DirectoryMemoryAdminPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Warn("Using the in-memory directory, data is lost on restart")
+	return m, nil
+}
+
+// seedAdmin creates the user admin and adds it to the groups granting the admin role
+func (m *MemoryDirectory) seedAdmin(plain string) error {
+	// A development password need not satisfy the policy
+	hashed, err := m.hasher.Hash(plain)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	admin := m.addUser(&models.CreateUserInput{UID: "admin", CN: "Administrator", SN: "Administrator", GivenName: "Admin"}, hashed)
+
+	cns := make([]string, 0, len(m.config.RoleGroups))
+	for cn := range m.config.RoleGroups {
+		cns = append(cns, cn)
+	}
+	sort.Strings(cns)
+	for _, cn := range cns {
+		group := m.addGroup(cn, "")
+		if authz.Role(strings.ToLower(m.config.RoleGroups[cn])) == authz.RoleAdmin {
+			group.members = append(group.members, admin.uid)
+		}
+	}
+	m.logger.WithField("uid", admin.uid).Info("Seeded in-memory directory with admin user")
+	return nil
+}
+
+// Errors carry the result codes OpenLDAP would answer with, wrapped the way
+// *ldap.Manager wraps them
+
+func noSuchObject(dn string) error {
+	return ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no such entry %s", dn))
+}
+
+func alreadyExists(dn string) error {
+	return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, fmt.Errorf("entry %s already exists", dn))
+}
+
+func key(name string) string {
+	return strings.ToLower(name)
+}
+
+// CreateUser creates a new user
+func (m *MemoryDirectory) CreateUser(ctx context.Context, input *models.CreateUserInput) (*models.User, error) {
+	hashedPassword := input.PasswordHash
+	if hashedPassword == "" {
+		var err error
+		hashedPassword, err = m.PreparePassword(input.Password, password.Subject{
+			UID:       input.UID,
+			CN:        input.CN,
+			SN:        input.SN,
+			GivenName: input.GivenName,
+			Mail:      input.Mail,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.deleted[key(input.UID)]; ok {
+		return nil, fmt.Errorf("uid %s belongs to a deleted user; restore or purge it first", input.UID)
+	}
+	if _, ok := m.users[key(input.UID)]; ok {
+		return nil, fmt.Errorf("failed to add user: %w", alreadyExists(m.config.UserDN(input.UID)))
+	}
+
+	user := m.addUser(input, hashedPassword)
+	m.logger.WithField("uid", input.UID).Info("User created successfully")
+	return m.toUser(user), nil
+}
+
+func (m *MemoryDirectory) addUser(input *models.CreateUserInput, hashedPassword string) *memoryUser {
+	m.seq++
+	user := &memoryUser{
+		seq:          m.seq,
+		uid:          input.UID,
+		cn:           input.CN,
+		sn:           input.SN,
+		givenName:    input.GivenName,
+		mail:         input.Mail,
+		department:   input.Department,
+		uidNumber:    m.nextUID,
+		gidNumber:    m.nextGID,
+		homeDir:      fmt.Sprintf("/home/%s", input.UID),
+		repositories: append([]string{}, input.Repositories...),
+		password:     hashedPassword,
+	}
+	m.nextUID++
+	m.nextGID++
+	m.users[key(input.UID)] = user
+	return user
+}
+
+// GetUser retrieves a user by UID
+func (m *MemoryDirectory) GetUser(ctx context.Context, uid string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[key(uid)]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %s", uid)
+	}
+	return m.toUser(user), nil
+}
+
+// ListUsers lists users with optional filtering
+func (m *MemoryDirectory) ListUsers(ctx context.Context, filter *models.SearchFilter) ([]*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matches, err := m.searchUsers(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*models.User, 0, len(matches))
+	for _, user := range matches {
+		users = append(users, m.toUser(user))
+	}
+	return users, nil
+}
+
+// ListUsersPaginated returns one page of users
+func (m *MemoryDirectory) ListUsersPaginated(ctx context.Context, filter *models.SearchFilter, sort *models.UserSort, page int, limit int) (*models.UserPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matches, err := m.searchUsers(filter, sort)
+	if err != nil {
+		return nil, err
+	}
+	users, total, estimated, hasMore := m.userWindow(matches, (page-1)*limit, limit)
+
+	return &models.UserPage{
+		Items:          users,
+		Total:          total,
+		TotalEstimated: estimated,
+		Page:           page,
+		Limit:          limit,
+		HasNextPage:    hasMore,
+	}, nil
+}
+
+// ListUsersConnection returns the first users after the cursor after, in
+// the order of the LDAP backend: by the sort and then uid, or by uid without
+// server side sorting
+func (m *MemoryDirectory) ListUsersConnection(ctx context.Context, filter *models.SearchFilter, sortBy *models.UserSort, first int, after string) (*models.UserConnection, error) {
+	attribute, reverse := "uid", false
+	if m.config.LDAPServerSideSort {
+		attribute, reverse = m.config.LDAPUserSortAttribute, false
+		if sortBy != nil {
+			attribute, reverse = sortBy.Attribute, sortBy.Descending
+		}
+		if !sortAttributes[attribute] {
+			return nil, fmt.Errorf("cannot sort users by %q", attribute)
+		}
+	} else if sortBy != nil {
+		return nil, fmt.Errorf("sorting requires LDAP_SERVER_SIDE_SORT to be enabled")
+	}
+
+	var cursor *userCursor
+	if after != "" {
+		var err error
+		if cursor, err = decodeCursor(after); err != nil {
+			return nil, err
+		}
+		if cursor.Attribute != attribute || cursor.Descending != reverse {
+			return nil, fmt.Errorf("invalid cursor: it belongs to another sort order")
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matches, err := m.searchUsers(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	compare := func(value, uid string, user *memoryUser) int {
+		c := compareSortKeys(attribute, value, uid, userSortValue(user, attribute), user.uid)
+		if reverse {
+			return -c
+		}
+		return c
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compare(userSortValue(matches[i], attribute), matches[i].uid, matches[j]) < 0
+	})
+
+	offset := 0
+	if cursor != nil {
+		offset = sort.Search(len(matches), func(i int) bool {
+			return compare(cursor.Value, cursor.UID, matches[i]) < 0
+		})
+	}
+	end := offset + first
+	if end > len(matches) {
+		end = len(matches)
+	}
+	total, estimated, hasMore := windowTotal(len(matches), offset, first, m.config.LDAPCountLimit)
+
+	conn := &models.UserConnection{
+		Edges:          make([]*models.UserEdge, 0, end-offset),
+		Total:          total,
+		TotalEstimated: estimated,
+		PageInfo: &models.PageInfo{
+			HasNextPage:     hasMore,
+			HasPreviousPage: cursor != nil,
+		},
+	}
+	for _, user := range matches[offset:end] {
+		conn.Edges = append(conn.Edges, &models.UserEdge{
+			Cursor: encodeCursor(&userCursor{
+				Attribute:  attribute,
+				Descending: reverse,
+				Value:      userSortValue(user, attribute),
+				UID:        user.uid,
+			}),
+			Node: m.toUser(user),
+		})
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn, nil
+}
+
+// sortAttributes are the user attributes that may be sorted on
+var sortAttributes = map[string]bool{"uid": true, "cn": true, "sn": true, "givenName": true, "mail": true, "uidNumber": true}
+
+// userSortValue returns the value of a sort attribute of user, "" if unset
+func userSortValue(user *memoryUser, attribute string) string {
+	switch attribute {
+	case "uid":
+		return user.uid
+	case "cn":
+		return user.cn
+	case "sn":
+		return user.sn
+	case "givenName":
+		return user.givenName
+	case "mail":
+		return user.mail
+	case "uidNumber":
+		return strconv.Itoa(user.uidNumber)
+	}
+	return ""
+}
+
+// compareSortKeys orders two users by sort value and then uid. Users without
+// the sort attribute come last, as with server side sorting.
+func compareSortKeys(attribute, valueA, uidA, valueB, uidB string) int {
+	switch {
+	case valueA == "" && valueB != "":
+		return 1
+	case valueA != "" && valueB == "":
+		return -1
+	}
+	if attribute == "uidNumber" {
+		a, _ := strconv.Atoi(valueA)
+		b, _ := strconv.Atoi(valueB)
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	} else if c := strings.Compare(key(valueA), key(valueB)); c != 0 {
+		return c
+	}
+	return strings.Compare(key(uidA), key(uidB))
+}
+
+// userWindow returns up to limit users starting at offset, counting the
+// matches up to LDAP_COUNT_LIMIT like the LDAP backend does
+func (m *MemoryDirectory) userWindow(matches []*memoryUser, offset, limit int) ([]*models.User, int, bool, bool) {
+	users := make([]*models.User, 0, limit)
+	for i := offset; i < len(matches) && i < offset+limit; i++ {
+		users = append(users, m.toUser(matches[i]))
+	}
+	total, estimated, hasMore := windowTotal(len(matches), offset, limit, m.config.LDAPCountLimit)
+	return users, total, estimated, hasMore
+}
+
+func windowTotal(matches, offset, limit, countLimit int) (int, bool, bool) {
+	if matches <= offset+limit {
+		return matches, false, false
+	}
+	if countLimit > 0 && matches > countLimit {
+		return countLimit, true, true
+	}
+	return matches, false, true
+}
+
+// searchUsers returns the users matching filter in the order of sort
+func (m *MemoryDirectory) searchUsers(filter *models.SearchFilter, sortBy *models.UserSort) ([]*memoryUser, error) {
+	source := m.users
+	if filter != nil && filter.Status == models.UserStatusDeleted {
+		source = m.deleted
+	}
+
+	var departments []string
+	if filter != nil && filter.Department != "" {
+		departments = []string{filter.Department}
+		if filter.IncludeDescendants {
+			dept, ok := m.departments[key(filter.Department)]
+			if !ok {
+				return nil, fmt.Errorf("department not found: %s", filter.Department)
+			}
+			departments = m.departmentSubtree(dept)
+		}
+	}
+
+	matches := make([]*memoryUser, 0, len(source))
+	for _, user := range source {
+		if filter != nil {
+			if departments != nil && !containsFold(departments, user.department) {
+				continue
+			}
+			if filter.Mail != "" && !containsSubstring(user.mail, filter.Mail) {
+				continue
+			}
+			if filter.CN != "" && !containsSubstring(user.cn, filter.CN) {
+				continue
+			}
+			if filter.Status == models.UserStatusActive && user.disabled || filter.Status == models.UserStatusDisabled && !user.disabled {
+				continue
+			}
+		}
+		matches = append(matches, user)
+	}
+
+	if err := m.sortUsers(matches, sortBy); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// sortUsers orders users like the server side sort of the LDAP backend, or
+// in creation order when that is disabled
+func (m *MemoryDirectory) sortUsers(users []*memoryUser, sortBy *models.UserSort) error {
+	sort.Slice(users, func(i, j int) bool { return users[i].seq < users[j].seq })
+	if !m.config.LDAPServerSideSort {
+		if sortBy != nil {
+			return fmt.Errorf("sorting requires LDAP_SERVER_SIDE_SORT to be enabled")
+		}
+		return nil
+	}
+
+	attribute, reverse := m.config.LDAPUserSortAttribute, false
+	if sortBy != nil {
+		attribute, reverse = sortBy.Attribute, sortBy.Descending
+	}
+	var less func(a, b *memoryUser) bool
+	switch attribute {
+	case "uid":
+		less = func(a, b *memoryUser) bool { return key(a.uid) < key(b.uid) }
+	case "cn":
+		less = func(a, b *memoryUser) bool { return key(a.cn) < key(b.cn) }
+	case "sn":
+		less = func(a, b *memoryUser) bool { return key(a.sn) < key(b.sn) }
+	case "givenName":
+		less = func(a, b *memoryUser) bool { return key(a.givenName) < key(b.givenName) }
+	case "mail":
+		less = func(a, b *memoryUser) bool { return key(a.mail) < key(b.mail) }
+	case "uidNumber":
+		less = func(a, b *memoryUser) bool { return a.uidNumber < b.uidNumber }
+	default:
+		return fmt.Errorf("cannot sort users by %q", attribute)
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		if reverse {
+			return less(users[j], users[i])
+		}
+		return less(users[i], users[j])
+	})
+	return nil
+}
+
+// FindUserByMail returns the user with the given mail address
+func (m *MemoryDirectory) FindUserByMail(ctx context.Context, mail string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found []*memoryUser
+	for _, user := range m.users {
+		if strings.EqualFold(user.mail, mail) {
+			found = append(found, user)
+		}
+	}
+	// A shared address cannot identify a single account
+	if len(found) != 1 {
+		return nil, fmt.Errorf("no unique user with mail %s", mail)
+	}
+	return m.toUser(found[0]), nil
+}
+
+// UpdateUser updates user attributes
+func (m *MemoryDirectory) UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[key(input.UID)]
+	if !ok {
+		if input.Password != nil {
+			return nil, fmt.Errorf("user not found: %s", input.UID)
+		}
+		return nil, fmt.Errorf("failed to modify user: %w", noSuchObject(m.config.UserDN(input.UID)))
+	}
+
+	updated := *user
+	if input.CN != nil {
+		updated.cn = *input.CN
+	}
+	if input.SN != nil {
+		updated.sn = *input.SN
+	}
+	if input.GivenName != nil {
+		updated.givenName = *input.GivenName
+	}
+	if input.Mail != nil {
+		updated.mail = *input.Mail
+	}
+	if input.Department != nil {
+		updated.department = *input.Department
+	}
+	if input.Password != nil {
+		hashedPassword, history, err := m.newPassword(*input.Password, updated.subject(), user)
+		if err != nil {
+			return nil, err
+		}
+		updated.password = hashedPassword
+		if history != nil {
+			updated.history = history
+		}
+	}
+	if len(input.Repositories) > 0 {
+		updated.repositories = append([]string{}, input.Repositories...)
+	}
+	*user = updated
+
+	m.logger.WithField("uid", input.UID).Info("User updated successfully")
+	return m.toUser(user), nil
+}
+
+// DeleteUser permanently deletes a user, removing it from groups and from
+// the departments it manages first. A soft-deleted user is purged.
+func (m *MemoryDirectory) DeleteUser(ctx context.Context, uid string) (*models.DeletionSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	summary := newSummary()
+	user, ok := m.users[key(uid)]
+	if !ok {
+		deleted, ok := m.deleted[key(uid)]
+		if !ok {
+			return nil, fmt.Errorf("user not found: %s", uid)
+		}
+		delete(m.deleted, key(uid))
+		summary.Deleted = append(summary.Deleted, m.deletedUserDN(deleted.uid))
+		return summary, nil
+	}
+
+	m.removeUserReferences(user.uid, summary)
+	delete(m.users, key(uid))
+	summary.Deleted = append(summary.Deleted, m.config.UserDN(user.uid))
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":      uid,
+		"modified": len(summary.Modified),
+	}).Info("User deleted successfully")
+	return summary, nil
+}
+
+// removeUserReferences takes a user out of every group and department it is
+// referenced by, and returns the CNs and OUs of those
+func (m *MemoryDirectory) removeUserReferences(uid string, summary *models.DeletionSummary) (groups, managed []string) {
+	for _, group := range m.sortedGroups() {
+		if containsFold(group.members, uid) {
+			group.members = removeFold(group.members, uid)
+			groups = append(groups, group.cn)
+			summary.Modified = append(summary.Modified, &models.EntryChange{DN: m.config.GroupDN(group.cn), Change: "removed member " + uid})
+		}
+	}
+	for _, dept := range m.sortedDepartments() {
+		if strings.EqualFold(dept.manager, uid) {
+			dept.manager = ""
+			managed = append(managed, dept.ou)
+			summary.Modified = append(summary.Modified, &models.EntryChange{DN: m.departmentDN(dept), Change: "removed manager " + uid})
+		}
+	}
+	return groups, managed
+}
+
+// restoreUserReferences puts a restored user back into the groups and
+// department manager slots soft deletion took it out of. Groups and
+// departments removed since, and departments that got another manager, are skipped.
+func (m *MemoryDirectory) restoreUserReferences(user *memoryUser) {
+	for _, cn := range user.removedGroups {
+		if group, ok := m.groups[key(cn)]; ok && !containsFold(group.members, user.uid) {
+			group.members = append(group.members, user.uid)
+		}
+	}
+	for _, ou := range user.removedManaged {
+		if dept, ok := m.departments[key(ou)]; ok && dept.manager == "" {
+			dept.manager = user.uid
+		}
+	}
+	user.removedGroups, user.removedManaged = nil, nil
+}
+
+// RenameUser changes the uid of a user, rewriting the group memberships and
+// department managers that refer to it
+func (m *MemoryDirectory) RenameUser(ctx context.Context, oldUID, newUID string) (*models.User, error) {
+	if oldUID == newUID {
+		return nil, fmt.Errorf("new uid is the same as the old one")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[key(oldUID)]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %s", oldUID)
+	}
+	if _, ok := m.deleted[key(newUID)]; ok {
+		return nil, fmt.Errorf("uid %s belongs to a deleted user; restore or purge it first", newUID)
+	}
+	if _, ok := m.users[key(newUID)]; ok && !strings.EqualFold(oldUID, newUID) {
+		return nil, fmt.Errorf("user already exists: %s", newUID)
+	}
+
+	for _, group := range m.groups {
+		for i, member := range group.members {
+			if strings.EqualFold(member, oldUID) {
+				group.members[i] = newUID
+			}
+		}
+	}
+	for _, dept := range m.departments {
+		if strings.EqualFold(dept.manager, oldUID) {
+			dept.manager = newUID
+		}
+	}
+	delete(m.users, key(oldUID))
+	user.uid = newUID
+	m.users[key(newUID)] = user
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":    oldUID,
+		"newUid": newUID,
+	}).Info("User renamed successfully")
+	return m.toUser(user), nil
+}
+
+// MoveUser moves a user to another department, swapping the repositories and
+// group of the old department for those of the new one
+func (m *MemoryDirectory) MoveUser(ctx context.Context, uid, department string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[key(uid)]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %s", uid)
+	}
+	newDept, ok := m.departments[key(department)]
+	if !ok {
+		return nil, fmt.Errorf("department not found: %s", department)
+	}
+	var oldRepos []string
+	if oldDept, ok := m.departments[key(user.department)]; ok && user.department != "" {
+		oldRepos = oldDept.repositories
+	}
+
+	// Check both groups before changing anything, so a failed move leaves no trace
+	oldGroupCN, newGroupCN := m.config.DepartmentGroups[user.department], m.config.DepartmentGroups[department]
+	var oldGroup, newGroup *memoryGroup
+	if oldGroupCN != newGroupCN {
+		if oldGroupCN != "" {
+			if oldGroup, ok = m.groups[key(oldGroupCN)]; !ok {
+				return nil, fmt.Errorf("group not found: %s", oldGroupCN)
+			}
+		}
+		if newGroupCN != "" {
+			if newGroup, ok = m.groups[key(newGroupCN)]; !ok {
+				return nil, fmt.Errorf("group not found: %s", newGroupCN)
+			}
+		}
+	}
+
+	user.department = department
+	user.repositories = departmentRepositories(user.repositories, oldRepos, newDept.repositories)
+	if oldGroup != nil {
+		oldGroup.members = removeFold(oldGroup.members, user.uid)
+	}
+	if newGroup != nil && !containsFold(newGroup.members, user.uid) {
+		newGroup.members = append(newGroup.members, user.uid)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":        uid,
+		"department": department,
+	}).Info("User moved successfully")
+	return m.toUser(user), nil
+}
+
+// DisableUser blocks a user from logging in without removing the account
+func (m *MemoryDirectory) DisableUser(ctx context.Context, uid string) (*models.User, error) {
+	return m.setDisabled(uid, true)
+}
+
+// EnableUser lets a disabled user log in again
+func (m *MemoryDirectory) EnableUser(ctx context.Context, uid string) (*models.User, error) {
+	return m.setDisabled(uid, false)
+}
+
+func (m *MemoryDirectory) setDisabled(uid string, disabled bool) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[key(uid)]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %s", uid)
+	}
+	user.disabled = disabled
+	return m.toUser(user), nil
+}
+
+// SoftDeleteUser removes a user from groups and department managers and
+// moves it to the deleted users, where it can be restored until purged
+func (m *MemoryDirectory) SoftDeleteUser(ctx context.Context, uid string) (*models.DeletionSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[key(uid)]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %s", uid)
+	}
+	if _, ok := m.deleted[key(uid)]; ok {
+		return nil, fmt.Errorf("a deleted user %s already exists; purge it first", uid)
+	}
+
+	summary := newSummary()
+	user.removedGroups, user.removedManaged = m.removeUserReferences(user.uid, summary)
+
+	userDN := m.config.UserDN(user.uid)
+	user.deletedAt = time.Now().UTC().Truncate(24 * time.Hour)
+	delete(m.users, key(uid))
+	m.deleted[key(uid)] = user
+	summary.Modified = append(summary.Modified,
+		&models.EntryChange{DN: userDN, Change: "marked deleted"},
+		&models.EntryChange{DN: userDN, Change: "moved to " + m.deletedUserDN(user.uid)},
+	)
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":      uid,
+		"modified": len(summary.Modified),
+	}).Info("User soft deleted successfully")
+	return summary, nil
+}
+
+// RestoreUser brings back a soft-deleted user with its groups and managed
+// departments, still disabled
+func (m *MemoryDirectory) RestoreUser(ctx context.Context, uid string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.deleted[key(uid)]
+	if !ok {
+		return nil, fmt.Errorf("deleted user not found: %s", uid)
+	}
+	if m.retentionElapsed(user, time.Now()) {
+		return nil, fmt.Errorf("deleted user %s is past its retention period", uid)
+	}
+	if _, ok := m.users[key(uid)]; ok {
+		return nil, fmt.Errorf("uid %s has been taken by another user", uid)
+	}
+
+	delete(m.deleted, key(uid))
+	user.deletedAt = time.Time{}
+	user.disabled = true
+	m.users[key(uid)] = user
+	m.restoreUserReferences(user)
+
+	m.logger.WithField("uid", uid).Info("User restored successfully")
+	return m.toUser(user), nil
+}
+
+// PurgeDeletedUsers removes soft-deleted users whose retention period has passed
+func (m *MemoryDirectory) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now, purged := time.Now(), 0
+	for k, user := range m.deleted {
+		if m.retentionElapsed(user, now) {
+			delete(m.deleted, k)
+			purged++
+			m.logger.WithField("uid", user.uid).Info("Deleted user purged")
+		}
+	}
+	return purged, nil
+}
+
+// StartPurge runs PurgeDeletedUsers every interval until the directory is closed
+func (m *MemoryDirectory) StartPurge(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := m.PurgeDeletedUsers(context.Background()); err != nil {
+					m.logger.WithError(err).Error("Failed to purge deleted users")
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// retentionElapsed matches the LDAP backend, which stores the deletion day only
+func (m *MemoryDirectory) retentionElapsed(user *memoryUser, now time.Time) bool {
+	return now.After(user.deletedAt.Add(24 * time.Hour).Add(m.config.DeletedUserRetention))
+}
+
+// Authenticate checks the password of an active user
+func (m *MemoryDirectory) Authenticate(ctx context.Context, uid, plain string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[key(uid)]
+	if !ok {
+		return nil, fmt.Errorf("user not found: %w", fmt.Errorf("user not found: %s", uid))
+	}
+	if user.disabled || !password.Verify(plain, user.password) {
+		m.logger.WithField("uid", uid).Warn("Authentication failed")
+		return nil, fmt.Errorf("authentication failed")
+	}
+
+	m.logger.WithField("uid", uid).Info("User authenticated successfully")
+	return m.toUser(user), nil
+}
+
+// PreparePassword validates plain against the password policy for a new
+// account and returns the hash to store
+func (m *MemoryDirectory) PreparePassword(plain string, subject password.Subject) (string, error) {
+	hashed, _, err := m.newPassword(plain, subject, nil)
+	return hashed, err
+}
+
+// SetPassword validates plain against the password policy and stores it as
+// the password of uid, updating the password history
+func (m *MemoryDirectory) SetPassword(ctx context.Context, uid, plain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[key(uid)]
+	if !ok {
+		return fmt.Errorf("user not found: %s", uid)
+	}
+
+	hashedPassword, history, err := m.newPassword(plain, user.subject(), user)
+	if err != nil {
+		return err
+	}
+	user.password = hashedPassword
+	if history != nil {
+		user.history = history
+	}
+
+	m.logger.WithField("uid", uid).Info("Password changed")
+	return nil
+}
+
+// newPassword validates and hashes plain like the LDAP backend, returning
+// the password history to keep, most recent first
+func (m *MemoryDirectory) newPassword(plain string, subject password.Subject, user *memoryUser) (string, []string, error) {
+	var previous []string
+	if user != nil {
+		previous = append(append(previous, user.password), user.history...)
+	}
+
+	if err := m.passwordPolicy.Validate(plain, subject, previous); err != nil {
+		return "", nil, err
+	}
+
+	hashed, err := m.hasher.Hash(plain)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var history []string
+	if m.passwordPolicy.HistorySize > 0 {
+		// The new password counts towards the limit, so keep HistorySize-1 old ones
+		history = previous
+		if len(history) > m.passwordPolicy.HistorySize-1 {
+			history = history[:m.passwordPolicy.HistorySize-1]
+		}
+		if history == nil {
+			history = []string{}
+		}
+	}
+	return hashed, history, nil
+}
+
+func (u *memoryUser) subject() password.Subject {
+	return password.Subject{
+		UID:       u.uid,
+		CN:        u.cn,
+		SN:        u.sn,
+		GivenName: u.givenName,
+		Mail:      u.mail,
+	}
+}
+
+// HealthCheck always succeeds
+func (m *MemoryDirectory) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// CheckEndpoints reports the directory as a single healthy provider
+func (m *MemoryDirectory) CheckEndpoints(ctx context.Context) []*models.EndpointStats {
+	return []*models.EndpointStats{{URL: BackendMemory, Role: config.RoleProvider, Healthy: true}}
+}
+
+// GetStats reports the directory as a single healthy provider without connections
+func (m *MemoryDirectory) GetStats() *models.Stats {
+	return &models.Stats{
+		Healthy:   true,
+		Endpoints: m.CheckEndpoints(context.Background()),
+	}
+}
+
+// IDAllocation reports the configured ranges and how far allocation has progressed
+func (m *MemoryDirectory) IDAllocation(ctx context.Context) (*models.IDAllocation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return &models.IDAllocation{
+		UID: &models.IDRange{
+			Start:     m.config.StartingUID,
+			Next:      m.nextUID,
+			Allocated: m.nextUID - m.config.StartingUID,
+		},
+		GID: &models.IDRange{
+			Start:     m.config.StartingGID,
+			Next:      m.nextGID,
+			Allocated: m.nextGID - m.config.StartingGID,
+		},
+	}, nil
+}
+
+// Close stops the purge job
+func (m *MemoryDirectory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+	return nil
+}
+
+func (m *MemoryDirectory) toUser(user *memoryUser) *models.User {
+	u := &models.User{
+		UID:          user.uid,
+		CN:           user.cn,
+		SN:           user.sn,
+		GivenName:    user.givenName,
+		Mail:         user.mail,
+		Department:   user.department,
+		UIDNumber:    user.uidNumber,
+		GIDNumber:    user.gidNumber,
+		HomeDir:      user.homeDir,
+		Repositories: append([]string{}, user.repositories...),
+		DN:           m.config.UserDN(user.uid),
+		Status:       models.UserStatusActive,
+	}
+	switch {
+	case !user.deletedAt.IsZero():
+		deletedAt := user.deletedAt
+		u.DN = m.deletedUserDN(user.uid)
+		u.Status = models.UserStatusDeleted
+		u.DeletedAt = &deletedAt
+	case user.disabled:
+		u.Status = models.UserStatusDisabled
+	}
+	return u
+}
+
+func (m *MemoryDirectory) deletedUserDN(uid string) string {
+	return "uid=" + ldap.EscapeDN(uid) + "," + m.config.DeletedUsersDN()
+}
+
+func newSummary() *models.DeletionSummary {
+	return &models.DeletionSummary{
+		Deleted:  []string{},
+		Modified: []*models.EntryChange{},
+	}
+}
+
+// departmentRepositories returns the repositories of a user who leaves a
+// department with oldRepos for one with newRepos, as the LDAP backend does
+func departmentRepositories(repos, oldRepos, newRepos []string) []string {
+	result := make([]string, 0, len(repos)+len(newRepos))
+	for _, repo := range repos {
+		if !containsFold(oldRepos, repo) {
+			result = append(result, repo)
+		}
+	}
+	for _, repo := range newRepos {
+		if !containsFold(result, repo) {
+			result = append(result, repo)
+		}
+	}
+	return result
+}
+
+// Cursors use the format of the LDAP backend
+const cursorPrefix = "user:"
+
+// userCursor is the position after a user in a sorted users connection
+type userCursor struct {
+	Attribute  string `json:"a"`
+	Descending bool   `json:"d,omitempty"`
+	Value      string `json:"v,omitempty"`
+	UID        string `json:"u"`
+}
+
+func encodeCursor(cursor *userCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(append([]byte(cursorPrefix), data...))
+}
+
+func decodeCursor(cursor string) (*userCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(raw), cursorPrefix) {
+		var c userCursor
+		err := json.Unmarshal(raw[len(cursorPrefix):], &c)
+		if err == nil && sortAttributes[c.Attribute] && c.UID != "" {
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid cursor")
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeFold(values []string, value string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !strings.EqualFold(v, value) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// containsSubstring matches like an LDAP substring filter on a case-ignoring attribute
+func containsSubstring(value, substring string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(substring))
+}
@@ -0,0 +1,368 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Departments nest like the organizational units of the LDAP backend. Names
+// are unique across the tree and users reference their department by name.
+
+type memoryDepartment struct {
+	seq          int
+	ou           string
+	parent       string
+	description  string
+	manager      string
+	repositories []string
+}
+
+// CreateDepartment creates a new department
+func (m *MemoryDirectory) CreateDepartment(ctx context.Context, input *models.CreateDepartmentInput) (*models.Department, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.departments[key(input.OU)]; ok {
+		return nil, fmt.Errorf("department %s already exists", input.OU)
+	}
+	parent := ""
+	if input.Parent != "" {
+		parentDept, ok := m.departments[key(input.Parent)]
+		if !ok {
+			return nil, fmt.Errorf("department not found: %s", input.Parent)
+		}
+		parent = parentDept.ou
+	}
+	if input.Manager != "" {
+		if _, ok := m.users[key(input.Manager)]; !ok {
+			return nil, fmt.Errorf("invalid manager: user not found: %s", input.Manager)
+		}
+	}
+
+	m.seq++
+	dept := &memoryDepartment{
+		seq:          m.seq,
+		ou:           input.OU,
+		parent:       parent,
+		description:  input.Description,
+		manager:      input.Manager,
+		repositories: append([]string{}, input.Repositories...),
+	}
+	m.departments[key(input.OU)] = dept
+
+	m.logger.WithField("ou", input.OU).Info("Department created successfully")
+	return m.toDepartment(dept, m.departmentMembers(dept.ou)), nil
+}
+
+// GetDepartment retrieves a department by OU
+func (m *MemoryDirectory) GetDepartment(ctx context.Context, ou string) (*models.Department, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dept, ok := m.departments[key(ou)]
+	if !ok {
+		return nil, fmt.Errorf("department not found: %s", ou)
+	}
+	return m.toDepartment(dept, m.departmentMembers(dept.ou)), nil
+}
+
+// ListDepartments lists all departments
+func (m *MemoryDirectory) ListDepartments(ctx context.Context) ([]*models.Department, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sorted := m.sortedDepartments()
+	departments := make([]*models.Department, 0, len(sorted))
+	for _, dept := range sorted {
+		departments = append(departments, m.toDepartment(dept, m.departmentMembers(dept.ou)))
+	}
+	return departments, nil
+}
+
+// UpdateDepartment changes the description and manager of a department
+func (m *MemoryDirectory) UpdateDepartment(ctx context.Context, input *models.UpdateDepartmentInput) (*models.Department, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dept, ok := m.departments[key(input.OU)]
+	if !ok {
+		return nil, fmt.Errorf("department not found: %s", input.OU)
+	}
+	if input.Manager != nil && *input.Manager != "" {
+		if _, ok := m.users[key(*input.Manager)]; !ok {
+			return nil, fmt.Errorf("invalid manager: user not found: %s", *input.Manager)
+		}
+	}
+
+	if input.Description != nil {
+		dept.description = *input.Description
+	}
+	if input.Manager != nil {
+		dept.manager = *input.Manager
+	}
+	return m.toDepartment(dept, m.departmentMembers(dept.ou)), nil
+}
+
+// DeleteDepartment deletes a department. Members are moved to reassignTo;
+// without it the department must have no members left.
+func (m *MemoryDirectory) DeleteDepartment(ctx context.Context, ou, reassignTo string) (*models.DeletionSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dept, ok := m.departments[key(ou)]
+	if !ok {
+		return nil, fmt.Errorf("department not found: %s", ou)
+	}
+	if len(m.departmentChildren(dept)) > 0 {
+		return nil, fmt.Errorf("department %s has sub-departments; move or delete them first", ou)
+	}
+
+	target := ""
+	if reassignTo != "" {
+		if strings.EqualFold(reassignTo, ou) {
+			return nil, fmt.Errorf("cannot reassign members of department %s to itself", ou)
+		}
+		targetDept, ok := m.departments[key(reassignTo)]
+		if !ok {
+			return nil, fmt.Errorf("department not found: %s", reassignTo)
+		}
+		target = targetDept.ou
+	}
+
+	var members []*memoryUser
+	for _, user := range m.sortedUsers() {
+		if strings.EqualFold(user.department, ou) {
+			members = append(members, user)
+		}
+	}
+	if len(members) > 0 && target == "" {
+		return nil, fmt.Errorf("department %s still has %d members; reassign them first", ou, len(members))
+	}
+
+	summary := newSummary()
+	for _, user := range members {
+		user.department = target
+		summary.Modified = append(summary.Modified, &models.EntryChange{DN: m.config.UserDN(user.uid), Change: "moved to department " + target})
+	}
+	summary.Deleted = append(summary.Deleted, m.departmentDN(dept))
+	delete(m.departments, key(ou))
+
+	m.logger.WithField("ou", ou).Info("Department deleted successfully")
+	return summary, nil
+}
+
+// MoveDepartment moves a department and everything below it under a new
+// parent, or to the top level when parent is empty
+func (m *MemoryDirectory) MoveDepartment(ctx context.Context, ou, parent string) (*models.Department, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dept, ok := m.departments[key(ou)]
+	if !ok {
+		return nil, fmt.Errorf("department not found: %s", ou)
+	}
+	newParent := ""
+	if parent != "" {
+		parentDept, ok := m.departments[key(parent)]
+		if !ok {
+			return nil, fmt.Errorf("department not found: %s", parent)
+		}
+		if containsFold(m.departmentPath(parentDept), ou) {
+			return nil, fmt.Errorf("cannot move department %s below itself", ou)
+		}
+		newParent = parentDept.ou
+	}
+
+	if !strings.EqualFold(dept.parent, newParent) {
+		dept.parent = newParent
+		m.logger.WithFields(logrus.Fields{
+			"ou":     ou,
+			"parent": parent,
+		}).Info("Department moved successfully")
+	}
+	return m.toDepartment(dept, m.departmentMembers(dept.ou)), nil
+}
+
+// AssignRepositoryToDepartment assigns repositories to a department
+func (m *MemoryDirectory) AssignRepositoryToDepartment(ctx context.Context, ou string, repos []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dept, ok := m.departments[key(ou)]
+	if !ok {
+		return fmt.Errorf("department not found: %s", ou)
+	}
+	dept.repositories = append([]string{}, repos...)
+	return nil
+}
+
+// GetUsersByDepartment retrieves all users in a department, and optionally
+// in the departments below it
+func (m *MemoryDirectory) GetUsersByDepartment(ctx context.Context, department string, includeDescendants bool) ([]*models.User, error) {
+	return m.ListUsers(ctx, &models.SearchFilter{
+		Department:         department,
+		IncludeDescendants: includeDescendants,
+	})
+}
+
+// GetDepartmentChildren returns the departments directly below a department
+func (m *MemoryDirectory) GetDepartmentChildren(ctx context.Context, ou string) ([]*models.Department, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dept, ok := m.departments[key(ou)]
+	if !ok {
+		return nil, fmt.Errorf("department not found: %s", ou)
+	}
+
+	children := make([]*models.Department, 0)
+	for _, child := range m.departmentChildren(dept) {
+		children = append(children, m.toDepartment(child, []string{}))
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return strings.ToLower(children[i].OU) < strings.ToLower(children[j].OU)
+	})
+	return children, nil
+}
+
+// GetDepartmentAncestors returns the departments above a department, top-level first
+func (m *MemoryDirectory) GetDepartmentAncestors(ctx context.Context, ou string) ([]*models.Department, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dept, ok := m.departments[key(ou)]
+	if !ok {
+		return nil, fmt.Errorf("department not found: %s", ou)
+	}
+
+	path := m.departmentPath(dept)
+	ancestors := make([]*models.Department, 0, len(path))
+	for _, name := range path[:len(path)-1] {
+		ancestors = append(ancestors, m.toDepartment(m.departments[key(name)], []string{}))
+	}
+	return ancestors, nil
+}
+
+// GetDepartmentTree returns every department, each parent before its
+// children and siblings ordered by name
+func (m *MemoryDirectory) GetDepartmentTree(ctx context.Context) ([]*models.Department, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	departments := make([]*models.Department, 0, len(m.departments))
+	byOU := make(map[string]*models.Department, len(m.departments))
+	for _, dept := range m.sortedDepartments() {
+		d := m.toDepartment(dept, []string{})
+		departments = append(departments, d)
+		byOU[key(d.OU)] = d
+	}
+	sort.Slice(departments, func(i, j int) bool {
+		return comparePaths(departments[i].Path, departments[j].Path) < 0
+	})
+
+	for _, user := range m.sortedUsers() {
+		if d, ok := byOU[key(user.department)]; ok {
+			d.Members = append(d.Members, user.uid)
+		}
+	}
+	return departments, nil
+}
+
+// departmentPath returns the OUs from the top-level department down to dept
+func (m *MemoryDirectory) departmentPath(dept *memoryDepartment) []string {
+	path := []string{dept.ou}
+	for dept.parent != "" {
+		dept = m.departments[key(dept.parent)]
+		path = append([]string{dept.ou}, path...)
+	}
+	return path
+}
+
+func (m *MemoryDirectory) departmentDN(dept *memoryDepartment) string {
+	if dept.parent == "" {
+		return m.config.DepartmentDN(dept.ou)
+	}
+	return "ou=" + ldap.EscapeDN(dept.ou) + "," + m.departmentDN(m.departments[key(dept.parent)])
+}
+
+// departmentChildren returns the departments directly below dept in creation order
+func (m *MemoryDirectory) departmentChildren(dept *memoryDepartment) []*memoryDepartment {
+	var children []*memoryDepartment
+	for _, child := range m.sortedDepartments() {
+		if strings.EqualFold(child.parent, dept.ou) {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// departmentSubtree returns the OU of a department and of every department below it
+func (m *MemoryDirectory) departmentSubtree(dept *memoryDepartment) []string {
+	ous := []string{dept.ou}
+	for _, child := range m.departmentChildren(dept) {
+		ous = append(ous, m.departmentSubtree(child)...)
+	}
+	return ous
+}
+
+// departmentMembers returns the uids of the users in a department
+func (m *MemoryDirectory) departmentMembers(ou string) []string {
+	members := []string{}
+	matches, err := m.searchUsers(&models.SearchFilter{Department: ou}, nil)
+	if err != nil {
+		m.logger.WithError(err).Warn("Failed to get department members")
+		return members
+	}
+	for _, user := range matches {
+		members = append(members, user.uid)
+	}
+	return members
+}
+
+func (m *MemoryDirectory) sortedDepartments() []*memoryDepartment {
+	departments := make([]*memoryDepartment, 0, len(m.departments))
+	for _, dept := range m.departments {
+		departments = append(departments, dept)
+	}
+	sort.Slice(departments, func(i, j int) bool { return departments[i].seq < departments[j].seq })
+	return departments
+}
+
+func (m *MemoryDirectory) sortedUsers() []*memoryUser {
+	users := make([]*memoryUser, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].seq < users[j].seq })
+	return users
+}
+
+func (m *MemoryDirectory) toDepartment(dept *memoryDepartment, members []string) *models.Department {
+	path := m.departmentPath(dept)
+	return &models.Department{
+		OU:           dept.ou,
+		Parent:       dept.parent,
+		Path:         path,
+		Description:  dept.description,
+		Manager:      dept.manager,
+		Members:      members,
+		Repositories: append([]string{}, dept.repositories...),
+		DN:           m.departmentDN(dept),
+	}
+}
+
+// comparePaths orders department paths depth-first
+func comparePaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(strings.ToLower(a[i]), strings.ToLower(b[i])); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
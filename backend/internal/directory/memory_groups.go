@@ -0,0 +1,375 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+type memoryGroup struct {
+	seq          int
+	cn           string
+	description  string
+	gidNumber    int
+	members      []string
+	memberGroups []string
+}
+
+// CreateGroup creates a new group
+func (m *MemoryDirectory) CreateGroup(ctx context.Context, cn, description string) (*models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[key(cn)]; ok {
+		return nil, fmt.Errorf("failed to add group: %w", alreadyExists(m.config.GroupDN(cn)))
+	}
+	group := m.addGroup(cn, description)
+
+	m.logger.WithField("cn", cn).Info("Group created successfully")
+	return m.toGroup(group), nil
+}
+
+func (m *MemoryDirectory) addGroup(cn, description string) *memoryGroup {
+	m.seq++
+	group := &memoryGroup{
+		seq:          m.seq,
+		cn:           cn,
+		description:  description,
+		gidNumber:    m.nextGID,
+		members:      []string{},
+		memberGroups: []string{},
+	}
+	m.nextGID++
+	m.groups[key(cn)] = group
+	return group
+}
+
+// GetGroup retrieves a group by CN
+func (m *MemoryDirectory) GetGroup(ctx context.Context, cn string) (*models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	group, ok := m.groups[key(cn)]
+	if !ok {
+		return nil, fmt.Errorf("group not found: %s", cn)
+	}
+	return m.toGroup(group), nil
+}
+
+// ListGroups returns one page of groups matching filter
+func (m *MemoryDirectory) ListGroups(ctx context.Context, filter *models.GroupFilter, page, limit int) (*models.GroupPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []*memoryGroup
+	for _, group := range m.sortedGroups() {
+		if filter != nil {
+			if filter.CN != "" && !containsSubstring(group.cn, filter.CN) {
+				continue
+			}
+			if filter.Member != "" && !containsFold(group.members, filter.Member) {
+				continue
+			}
+		}
+		matches = append(matches, group)
+	}
+
+	offset := (page - 1) * limit
+	groupPage := &models.GroupPage{
+		Items: make([]*models.Group, 0, limit),
+		Page:  page,
+		Limit: limit,
+	}
+	for i := offset; i < len(matches) && i < offset+limit; i++ {
+		groupPage.Items = append(groupPage.Items, m.toGroup(matches[i]))
+	}
+	groupPage.Total, groupPage.TotalEstimated, groupPage.HasNextPage = windowTotal(len(matches), offset, limit, m.config.LDAPCountLimit)
+	return groupPage, nil
+}
+
+// UpdateGroup changes the description of a group
+func (m *MemoryDirectory) UpdateGroup(ctx context.Context, input *models.UpdateGroupInput) (*models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.groups[key(input.CN)]
+	if !ok {
+		return nil, fmt.Errorf("group not found: %s", input.CN)
+	}
+	if input.Description != nil {
+		group.description = *input.Description
+	}
+
+	m.logger.WithField("cn", input.CN).Info("Group updated successfully")
+	return m.toGroup(group), nil
+}
+
+// DeleteGroup deletes a group. Groups that grant roles cannot be deleted.
+func (m *MemoryDirectory) DeleteGroup(ctx context.Context, cn string) error {
+	for group := range m.config.RoleGroups {
+		if strings.EqualFold(group, cn) {
+			return fmt.Errorf("group %s grants a role and cannot be deleted", cn)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.groups[key(cn)]; !ok {
+		return fmt.Errorf("failed to delete group: %w", noSuchObject(m.config.GroupDN(cn)))
+	}
+	delete(m.groups, key(cn))
+	for _, parent := range m.groups {
+		parent.memberGroups = removeFold(parent.memberGroups, cn)
+	}
+
+	m.logger.WithField("cn", cn).Info("Group deleted successfully")
+	return nil
+}
+
+// AddUserToGroup adds a user to a group
+func (m *MemoryDirectory) AddUserToGroup(ctx context.Context, uid, groupCN string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.groups[key(groupCN)]
+	if !ok {
+		return fmt.Errorf("group not found: %s", groupCN)
+	}
+	user, ok := m.users[key(uid)]
+	if !ok {
+		return fmt.Errorf("user not found: %s", uid)
+	}
+	if containsFold(group.members, uid) {
+		return nil
+	}
+	group.members = append(group.members, user.uid)
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":   uid,
+		"group": groupCN,
+	}).Info("User added to group successfully")
+	return nil
+}
+
+// RemoveUserFromGroup removes a user from a group
+func (m *MemoryDirectory) RemoveUserFromGroup(ctx context.Context, uid, groupCN string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.groups[key(groupCN)]
+	if !ok {
+		return fmt.Errorf("group not found: %s", groupCN)
+	}
+	if !containsFold(group.members, uid) {
+		return fmt.Errorf("user %s is not a member of group %s", uid, groupCN)
+	}
+	group.members = removeFold(group.members, uid)
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":   uid,
+		"group": groupCN,
+	}).Info("User removed from group successfully")
+	return nil
+}
+
+// SetGroupMembers replaces the user members of a group with the given users.
+// Nested groups stay members.
+func (m *MemoryDirectory) SetGroupMembers(ctx context.Context, groupCN string, uids []string) (*models.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.groups[key(groupCN)]
+	if !ok {
+		return nil, fmt.Errorf("group not found: %s", groupCN)
+	}
+
+	members := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		if containsFold(members, uid) {
+			continue
+		}
+		if _, ok := m.users[key(uid)]; !ok {
+			return nil, fmt.Errorf("user not found: %s", uid)
+		}
+		members = append(members, uid)
+	}
+	group.members = members
+
+	return m.toGroup(group), nil
+}
+
+// GetUserGroups retrieves all groups a user is a direct member of
+func (m *MemoryDirectory) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	groups := make([]*models.Group, 0)
+	for _, group := range m.sortedGroups() {
+		if containsFold(group.members, uid) {
+			groups = append(groups, m.toGroup(group))
+		}
+	}
+	return groups, nil
+}
+
+// AddGroupToGroup makes a group a member of another group. Memberships that
+// would make a group contain itself are rejected.
+func (m *MemoryDirectory) AddGroupToGroup(ctx context.Context, groupCN, parentCN string) error {
+	if strings.EqualFold(groupCN, parentCN) {
+		return fmt.Errorf("group %s cannot be a member of itself", groupCN)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, ok := m.groups[key(parentCN)]
+	if !ok {
+		return fmt.Errorf("group not found: %s", parentCN)
+	}
+	child, ok := m.groups[key(groupCN)]
+	if !ok {
+		return fmt.Errorf("group not found: %s", groupCN)
+	}
+
+	// The parent must not already be nested inside the group
+	nested := m.walkGroups(child.memberGroups, func(group *memoryGroup) []string { return group.memberGroups })
+	for _, group := range nested {
+		if strings.EqualFold(group.cn, parentCN) {
+			return fmt.Errorf("adding group %s to %s would create a cycle", groupCN, parentCN)
+		}
+	}
+
+	if !containsFold(parent.memberGroups, child.cn) {
+		parent.memberGroups = append(parent.memberGroups, child.cn)
+		m.logger.WithFields(logrus.Fields{
+			"group":  groupCN,
+			"parent": parentCN,
+		}).Info("Group added to group successfully")
+	}
+	return nil
+}
+
+// RemoveGroupFromGroup removes a nested group from its parent
+func (m *MemoryDirectory) RemoveGroupFromGroup(ctx context.Context, groupCN, parentCN string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, ok := m.groups[key(parentCN)]
+	if !ok {
+		return fmt.Errorf("group not found: %s", parentCN)
+	}
+	if !containsFold(parent.memberGroups, groupCN) {
+		return fmt.Errorf("group %s is not a member of group %s", groupCN, parentCN)
+	}
+	parent.memberGroups = removeFold(parent.memberGroups, groupCN)
+
+	m.logger.WithFields(logrus.Fields{
+		"group":  groupCN,
+		"parent": parentCN,
+	}).Info("Group removed from group successfully")
+	return nil
+}
+
+// EffectiveGroups returns every group a user belongs to, directly or through
+// nested groups
+func (m *MemoryDirectory) EffectiveGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var direct []string
+	for _, group := range m.sortedGroups() {
+		if containsFold(group.members, uid) {
+			direct = append(direct, group.cn)
+		}
+	}
+	// Walk upwards: the next level are the groups listing a group found so far
+	entries := m.walkGroups(direct, func(group *memoryGroup) []string {
+		var parents []string
+		for _, parent := range m.sortedGroups() {
+			if containsFold(parent.memberGroups, group.cn) {
+				parents = append(parents, parent.cn)
+			}
+		}
+		return parents
+	})
+
+	groups := make([]*models.Group, 0, len(entries))
+	for _, group := range entries {
+		groups = append(groups, m.toGroup(group))
+	}
+	return groups, nil
+}
+
+// EffectiveMembers returns the uids of every user in a group, directly or
+// through nested groups
+func (m *MemoryDirectory) EffectiveMembers(ctx context.Context, cn string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := m.walkGroups([]string{cn}, func(group *memoryGroup) []string { return group.memberGroups })
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("group not found: %s", cn)
+	}
+
+	uids := []string{}
+	for _, group := range entries {
+		for _, uid := range group.members {
+			if !containsFold(uids, uid) {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	return uids, nil
+}
+
+// walkGroups returns the groups named by cns, then level by level the groups
+// named by next. Groups already seen are not expanded again, which ends the
+// walk at cycles.
+func (m *MemoryDirectory) walkGroups(cns []string, next func(*memoryGroup) []string) []*memoryGroup {
+	seen := make(map[string]bool)
+	var groups []*memoryGroup
+
+	for len(cns) > 0 {
+		var level []*memoryGroup
+		for _, cn := range cns {
+			group, ok := m.groups[key(cn)]
+			if !ok || seen[key(cn)] {
+				continue
+			}
+			seen[key(cn)] = true
+			level = append(level, group)
+		}
+
+		groups = append(groups, level...)
+		cns = nil
+		for _, group := range level {
+			cns = append(cns, next(group)...)
+		}
+	}
+	return groups
+}
+
+func (m *MemoryDirectory) sortedGroups() []*memoryGroup {
+	groups := make([]*memoryGroup, 0, len(m.groups))
+	for _, group := range m.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].seq < groups[j].seq })
+	return groups
+}
+
+func (m *MemoryDirectory) toGroup(group *memoryGroup) *models.Group {
+	return &models.Group{
+		CN:           group.cn,
+		GIDNumber:    group.gidNumber,
+		Description:  group.description,
+		Members:      append([]string{}, group.members...),
+		MemberGroups: append([]string{}, group.memberGroups...),
+		DN:           m.config.GroupDN(group.cn),
+	}
+}
@@ -0,0 +1,95 @@
+package graphql
+
+import (
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Schema) resolveDepartmentTree(p graphql.ResolveParams) (interface{}, error) {
+	return s.dir.GetDepartmentTree(p.Context)
+}
+
+func (s *Schema) resolveUpdateDepartment(p graphql.ResolveParams) (interface{}, error) {
+	input := &models.UpdateDepartmentInput{
+		OU: p.Args["ou"].(string),
+	}
+	if desc, ok := p.Args["description"].(string); ok {
+		input.Description = &desc
+	}
+	if manager, ok := p.Args["manager"].(string); ok {
+		input.Manager = &manager
+	}
+
+	return s.dir.UpdateDepartment(p.Context, input)
+}
+
+func (s *Schema) resolveMoveDepartment(p graphql.ResolveParams) (interface{}, error) {
+	ou := p.Args["ou"].(string)
+	parent, _ := p.Args["parent"].(string)
+	return s.dir.MoveDepartment(p.Context, ou, parent)
+}
+
+// resolveDepartmentMembers resolves Department.members, widened to the
+// departments below when includeDescendants is set
+func (s *Schema) resolveDepartmentMembers(p graphql.ResolveParams) (interface{}, error) {
+	dept, ok := p.Source.(*models.Department)
+	if !ok || dept == nil {
+		return nil, nil
+	}
+	if includeDescendants, _ := p.Args["includeDescendants"].(bool); !includeDescendants {
+		return dept.Members, nil
+	}
+
+	users, err := s.dir.GetUsersByDepartment(p.Context, dept.OU, true)
+	if err != nil {
+		return nil, err
+	}
+	uids := make([]string, 0, len(users))
+	for _, user := range users {
+		uids = append(uids, user.UID)
+	}
+	return uids, nil
+}
+
+// resolveDepartmentManager resolves Department.manager. A manager whose entry
+// is gone resolves to null rather than failing the whole department.
+func (s *Schema) resolveDepartmentManager(p graphql.ResolveParams) (interface{}, error) {
+	dept, ok := p.Source.(*models.Department)
+	if !ok || dept == nil || dept.Manager == "" {
+		return nil, nil
+	}
+	user, err := s.dir.GetUser(p.Context, dept.Manager)
+	if err != nil {
+		s.logger.WithError(err).WithFields(logrus.Fields{
+			"ou":      dept.OU,
+			"manager": dept.Manager,
+		}).Warn("Failed to resolve department manager")
+		return nil, nil
+	}
+	return user, nil
+}
+
+func (s *Schema) resolveDepartmentParent(p graphql.ResolveParams) (interface{}, error) {
+	dept, ok := p.Source.(*models.Department)
+	if !ok || dept == nil || dept.Parent == "" {
+		return nil, nil
+	}
+	return s.dir.GetDepartment(p.Context, dept.Parent)
+}
+
+func (s *Schema) resolveDepartmentChildren(p graphql.ResolveParams) (interface{}, error) {
+	dept, ok := p.Source.(*models.Department)
+	if !ok || dept == nil {
+		return nil, nil
+	}
+	return s.dir.GetDepartmentChildren(p.Context, dept.OU)
+}
+
+func (s *Schema) resolveDepartmentAncestors(p graphql.ResolveParams) (interface{}, error) {
+	dept, ok := p.Source.(*models.Department)
+	if !ok || dept == nil {
+		return nil, nil
+	}
+	return s.dir.GetDepartmentAncestors(p.Context, dept.OU)
+}
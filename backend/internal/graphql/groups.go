@@ -0,0 +1,144 @@
+package graphql
+
+import (
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+func (s *Schema) defineGroupFilterInput() *graphql.InputObject {
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "GroupFilterInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"cn":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"member": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+}
+
+func (s *Schema) defineGroupPageType(groupType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "GroupPage",
+		Fields: graphql.Fields{
+			"items":          &graphql.Field{Type: graphql.NewList(groupType)},
+			"total":          &graphql.Field{Type: graphql.Int},
+			"totalEstimated": &graphql.Field{Type: graphql.Boolean},
+			"page":           &graphql.Field{Type: graphql.Int},
+			"limit":          &graphql.Field{Type: graphql.Int},
+			"hasNextPage":    &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+}
+
+func (s *Schema) resolveGroups(p graphql.ResolveParams) (interface{}, error) {
+	var filter *models.GroupFilter
+	if filterInput, ok := p.Args["filter"].(map[string]interface{}); ok {
+		filter = &models.GroupFilter{}
+		filter.CN, _ = filterInput["cn"].(string)
+		filter.Member, _ = filterInput["member"].(string)
+	}
+
+	page := 1
+	limit := 20
+	if pArgs, ok := p.Args["pagination"].(map[string]interface{}); ok {
+		if v, ok := pArgs["page"].(int); ok && v > 0 {
+			page = v
+		}
+		if v, ok := pArgs["limit"].(int); ok && v > 0 {
+			limit = v
+		}
+	}
+	if limit > maxPageSize {
+		return nil, fmt.Errorf("limit must not exceed %d", maxPageSize)
+	}
+
+	return s.dir.ListGroups(p.Context, filter, page, limit)
+}
+
+// resolveUserGroups resolves User.groups, the groups the user is a direct member of
+func (s *Schema) resolveUserGroups(p graphql.ResolveParams) (interface{}, error) {
+	user, ok := p.Source.(*models.User)
+	if !ok || user == nil {
+		return nil, nil
+	}
+	return s.dir.GetUserGroups(p.Context, user.UID)
+}
+
+// resolveUserEffectiveGroups resolves User.effectiveGroups, including groups reached through nested groups
+func (s *Schema) resolveUserEffectiveGroups(p graphql.ResolveParams) (interface{}, error) {
+	user, ok := p.Source.(*models.User)
+	if !ok || user == nil {
+		return nil, nil
+	}
+	return s.dir.EffectiveGroups(p.Context, user.UID)
+}
+
+// resolveGroupEffectiveMembers resolves Group.effectiveMembers, including members of nested groups
+func (s *Schema) resolveGroupEffectiveMembers(p graphql.ResolveParams) (interface{}, error) {
+	group, ok := p.Source.(*models.Group)
+	if !ok || group == nil {
+		return nil, nil
+	}
+	return s.dir.EffectiveMembers(p.Context, group.CN)
+}
+
+func (s *Schema) resolveUpdateGroup(p graphql.ResolveParams) (interface{}, error) {
+	input := &models.UpdateGroupInput{
+		CN: p.Args["cn"].(string),
+	}
+	if desc, ok := p.Args["description"].(string); ok {
+		input.Description = &desc
+	}
+
+	return s.dir.UpdateGroup(p.Context, input)
+}
+
+func (s *Schema) resolveDeleteGroup(p graphql.ResolveParams) (interface{}, error) {
+	cn := p.Args["cn"].(string)
+	err := s.dir.DeleteGroup(p.Context, cn)
+	s.principals.clear()
+	return err == nil, err
+}
+
+func (s *Schema) resolveRemoveUserFromGroup(p graphql.ResolveParams) (interface{}, error) {
+	uid := p.Args["uid"].(string)
+	groupCn := p.Args["groupCn"].(string)
+
+	err := s.dir.RemoveUserFromGroup(p.Context, uid, groupCn)
+	s.principals.invalidate(uid)
+	return err == nil, err
+}
+
+func (s *Schema) resolveSetGroupMembers(p graphql.ResolveParams) (interface{}, error) {
+	cn := p.Args["cn"].(string)
+	memberInterfaces := p.Args["members"].([]interface{})
+
+	members := make([]string, len(memberInterfaces))
+	for i, m := range memberInterfaces {
+		members[i] = m.(string)
+	}
+
+	group, err := s.dir.SetGroupMembers(p.Context, cn, members)
+	// Previous members are not known here, and the group may be nested in others
+	s.principals.clear()
+	return group, err
+}
+
+func (s *Schema) resolveAddGroupToGroup(p graphql.ResolveParams) (interface{}, error) {
+	groupCn := p.Args["groupCn"].(string)
+	parentCn := p.Args["parentCn"].(string)
+
+	err := s.dir.AddGroupToGroup(p.Context, groupCn, parentCn)
+	s.principals.clear()
+	return err == nil, err
+}
+
+func (s *Schema) resolveRemoveGroupFromGroup(p graphql.ResolveParams) (interface{}, error) {
+	groupCn := p.Args["groupCn"].(string)
+	parentCn := p.Args["parentCn"].(string)
+
+	err := s.dir.RemoveGroupFromGroup(p.Context, groupCn, parentCn)
+	s.principals.clear()
+	return err == nil, err
+}
@@ -0,0 +1,85 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// LDIFDirectory is a directory that can export and import LDIF. Only the
+// LDAP backend is one.
+type LDIFDirectory interface {
+	ExportLDIF(ctx context.Context, scope string, w io.Writer) error
+	ImportLDIF(ctx context.Context, r io.Reader, dryRun bool) (*models.LDIFImportReport, error)
+}
+
+func (s *Schema) defineLDIFScopeEnum() *graphql.Enum {
+	return graphql.NewEnum(graphql.EnumConfig{
+		Name: "LdifScope",
+		Values: graphql.EnumValueConfigMap{
+			"USERS":       &graphql.EnumValueConfig{Value: models.LDIFScopeUsers},
+			"GROUPS":      &graphql.EnumValueConfig{Value: models.LDIFScopeGroups},
+			"DEPARTMENTS": &graphql.EnumValueConfig{Value: models.LDIFScopeDepartments},
+			"ALL":         &graphql.EnumValueConfig{Value: models.LDIFScopeAll},
+		},
+	})
+}
+
+func (s *Schema) defineLDIFImportReportType() *graphql.Object {
+	recordType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "LdifRecordResult",
+		Fields: graphql.Fields{
+			"line":       &graphql.Field{Type: graphql.Int},
+			"dn":         &graphql.Field{Type: graphql.String},
+			"changeType": &graphql.Field{Type: graphql.String},
+			"status":     &graphql.Field{Type: graphql.String},
+			"error":      &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "LdifImportReport",
+		Fields: graphql.Fields{
+			"dryRun":  &graphql.Field{Type: graphql.Boolean},
+			"applied": &graphql.Field{Type: graphql.Int},
+			"failed":  &graphql.Field{Type: graphql.Int},
+			"records": &graphql.Field{Type: graphql.NewList(recordType)},
+		},
+	})
+}
+
+func (s *Schema) ldifDirectory() (LDIFDirectory, error) {
+	dir, ok := s.dir.(LDIFDirectory)
+	if !ok {
+		return nil, fmt.Errorf("LDIF needs DIRECTORY_BACKEND=ldap")
+	}
+	return dir, nil
+}
+
+// resolveExportLDIF returns the export as one string; GET /ldif streams it
+func (s *Schema) resolveExportLDIF(p graphql.ResolveParams) (interface{}, error) {
+	dir, err := s.ldifDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+	if err := dir.ExportLDIF(p.Context, p.Args["scope"].(string), &out); err != nil {
+		return nil, err
+	}
+	return out.String(), nil
+}
+
+func (s *Schema) resolveImportLDIF(p graphql.ResolveParams) (interface{}, error) {
+	dir, err := s.ldifDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun, _ := p.Args["dryRun"].(bool)
+	return dir.ImportLDIF(p.Context, strings.NewReader(p.Args["ldif"].(string)), dryRun)
+}
@@ -0,0 +1,65 @@
+package graphql
+
+import (
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+func (s *Schema) defineUserStatusEnum() *graphql.Enum {
+	return graphql.NewEnum(graphql.EnumConfig{
+		Name: "UserStatus",
+		Values: graphql.EnumValueConfigMap{
+			"ACTIVE":   &graphql.EnumValueConfig{Value: models.UserStatusActive},
+			"DISABLED": &graphql.EnumValueConfig{Value: models.UserStatusDisabled},
+			"DELETED":  &graphql.EnumValueConfig{Value: models.UserStatusDeleted},
+		},
+	})
+}
+
+func (s *Schema) resolveDisableUser(p graphql.ResolveParams) (interface{}, error) {
+	uid := p.Args["uid"].(string)
+	user, err := s.dir.DisableUser(p.Context, uid)
+	s.principals.invalidate(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.RevokeUserSessions(p.Context, uid); err != nil {
+		s.logger.WithError(err).Error("Failed to revoke sessions of disabled user")
+	}
+	return user, nil
+}
+
+func (s *Schema) resolveEnableUser(p graphql.ResolveParams) (interface{}, error) {
+	return s.dir.EnableUser(p.Context, p.Args["uid"].(string))
+}
+
+func (s *Schema) resolveRestoreUser(p graphql.ResolveParams) (interface{}, error) {
+	return s.dir.RestoreUser(p.Context, p.Args["uid"].(string))
+}
+
+func (s *Schema) resolveRenameUser(p graphql.ResolveParams) (interface{}, error) {
+	oldUID := p.Args["oldUid"].(string)
+	newUID := p.Args["newUid"].(string)
+	if !usernamePattern.MatchString(newUID) {
+		return nil, fmt.Errorf("uid must start with a letter and contain only lowercase letters, digits, '.', '_' or '-'")
+	}
+
+	user, err := s.dir.RenameUser(p.Context, oldUID, newUID)
+	s.principals.invalidate(oldUID, newUID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens carry the old uid and no longer resolve to a user
+	if _, err := s.RevokeUserSessions(p.Context, oldUID); err != nil {
+		s.logger.WithError(err).Error("Failed to revoke sessions of renamed user")
+	}
+	return user, nil
+}
+
+func (s *Schema) resolveMoveUser(p graphql.ResolveParams) (interface{}, error) {
+	return s.dir.MoveUser(p.Context, p.Args["uid"].(string), p.Args["department"].(string))
+}
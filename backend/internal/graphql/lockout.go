@@ -0,0 +1,40 @@
+package graphql
+
+import (
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+func (s *Schema) defineAccountLockType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "AccountLock",
+		Fields: graphql.Fields{
+			"uid":       &graphql.Field{Type: graphql.String},
+			"lockedAt":  &graphql.Field{Type: graphql.DateTime},
+			"expiresAt": &graphql.Field{Type: graphql.DateTime},
+		},
+	})
+}
+
+func (s *Schema) resolveLockedAccounts(p graphql.ResolveParams) (interface{}, error) {
+	return s.guard.Locked(p.Context)
+}
+
+func (s *Schema) resolveUnlockUser(p graphql.ResolveParams) (interface{}, error) {
+	uid := p.Args["uid"].(string)
+
+	if err := s.guard.Unlock(p.Context, uid); err != nil {
+		return false, err
+	}
+
+	admin := ""
+	if principal, ok := authz.FromContext(p.Context); ok {
+		admin = principal.UID
+	}
+	s.logger.WithFields(logrus.Fields{
+		"uid":   uid,
+		"admin": admin,
+	}).Info("Account unlocked")
+	return true, nil
+}
@@ -0,0 +1,116 @@
+package graphql
+
+import (
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+)
+
+// maxPageSize bounds the number of users returned by one page
+const maxPageSize = 1000
+
+func (s *Schema) defineUserSortInput() *graphql.InputObject {
+	sortField := graphql.NewEnum(graphql.EnumConfig{
+		Name: "UserSortField",
+		Values: graphql.EnumValueConfigMap{
+			"UID":        &graphql.EnumValueConfig{Value: "uid"},
+			"CN":         &graphql.EnumValueConfig{Value: "cn"},
+			"SN":         &graphql.EnumValueConfig{Value: "sn"},
+			"GIVEN_NAME": &graphql.EnumValueConfig{Value: "givenName"},
+			"MAIL":       &graphql.EnumValueConfig{Value: "mail"},
+			"UID_NUMBER": &graphql.EnumValueConfig{Value: "uidNumber"},
+		},
+	})
+
+	return graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "UserSortInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"field":      &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(sortField)},
+			"descending": &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		},
+	})
+}
+
+func (s *Schema) defineUserConnectionType(userType *graphql.Object) *graphql.Object {
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage":     &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"hasPreviousPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"startCursor":     &graphql.Field{Type: graphql.String},
+			"endCursor":       &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"node":   &graphql.Field{Type: userType},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"edges":    &graphql.Field{Type: graphql.NewList(edgeType)},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+			"total":    &graphql.Field{Type: graphql.Int},
+			// True when the directory holds more matches than LDAP_COUNT_LIMIT
+			"totalEstimated": &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+}
+
+func (s *Schema) resolveUsersConnection(p graphql.ResolveParams) (interface{}, error) {
+	first := 10
+	if v, ok := p.Args["first"].(int); ok {
+		if v < 1 || v > maxPageSize {
+			return nil, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+		}
+		first = v
+	}
+	after, _ := p.Args["after"].(string)
+
+	return s.dir.ListUsersConnection(p.Context, parseSearchFilter(p.Args), parseUserSort(p.Args), first, after)
+}
+
+// parseSearchFilter reads the optional filter argument of a users query
+func parseSearchFilter(args map[string]interface{}) *models.SearchFilter {
+	filterInput, ok := args["filter"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	filter := &models.SearchFilter{}
+	if v, ok := filterInput["department"].(string); ok {
+		filter.Department = v
+	}
+	if v, ok := filterInput["includeDescendants"].(bool); ok {
+		filter.IncludeDescendants = v
+	}
+	if v, ok := filterInput["mail"].(string); ok {
+		filter.Mail = v
+	}
+	if v, ok := filterInput["cn"].(string); ok {
+		filter.CN = v
+	}
+	if v, ok := filterInput["status"].(string); ok {
+		filter.Status = v
+	}
+	return filter
+}
+
+// parseUserSort reads the optional sort argument of a users query
+func parseUserSort(args map[string]interface{}) *models.UserSort {
+	sortInput, ok := args["sort"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	sort := &models.UserSort{}
+	sort.Attribute, _ = sortInput["field"].(string)
+	sort.Descending, _ = sortInput["descending"].(bool)
+	return sort
+}
@@ -0,0 +1,189 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/notify"
+	"github.com/devplatform/ldap-manager/internal/password"
+	"github.com/devplatform/ldap-manager/internal/reset"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+// resetCooldown limits how often reset mails are sent to the same user
+const resetCooldown = time.Minute
+
+func (s *Schema) resolveChangePassword(p graphql.ResolveParams) (interface{}, error) {
+	principal, ok := authz.FromContext(p.Context)
+	if !ok || principal.Service {
+		return nil, authz.ErrUnauthenticated
+	}
+	uid := principal.UID
+	oldPassword := p.Args["oldPassword"].(string)
+	newPassword := p.Args["newPassword"].(string)
+
+	// Verifying the old password is a login attempt and is throttled like one
+	ip := session.ClientFromContext(p.Context).IP
+	if err := s.guard.Check(p.Context, uid, ip); err != nil {
+		return false, err
+	}
+	if _, err := s.dir.Authenticate(p.Context, uid, oldPassword); err != nil {
+		s.guard.Failure(p.Context, uid, ip)
+		s.logger.WithField("uid", uid).Warn("Password change rejected, wrong current password")
+		return false, fmt.Errorf("current password is incorrect")
+	}
+	s.guard.Success(p.Context, uid)
+
+	if err := s.dir.SetPassword(p.Context, uid, newPassword); err != nil {
+		return false, passwordError(err)
+	}
+
+	// Keep the session that changed the password, end all others
+	currentID := ""
+	if claims, ok := claimsFromContext(p.Context); ok {
+		currentID = claims.SessionID
+	}
+	if err := s.revokeOtherSessions(p.Context, uid, currentID); err != nil {
+		s.logger.WithError(err).WithField("uid", uid).Warn("Failed to revoke sessions after password change")
+	}
+
+	return true, nil
+}
+
+func (s *Schema) resolveRequestPasswordReset(p graphql.ResolveParams) (interface{}, error) {
+	uid, _ := p.Args["uid"].(string)
+	mail, _ := p.Args["mail"].(string)
+	if uid == "" && mail == "" {
+		return false, fmt.Errorf("uid or mail is required")
+	}
+
+	// The answer is the same whether or not the account exists, so the
+	// mutation cannot be used to discover accounts
+	var user *models.User
+	var err error
+	if uid != "" {
+		user, err = s.dir.GetUser(p.Context, uid)
+	} else {
+		user, err = s.dir.FindUserByMail(p.Context, mail)
+	}
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{"uid": uid, "mail": mail}).Info("Password reset requested for unknown account")
+		return true, nil
+	}
+	if user.Status != models.UserStatusActive {
+		s.logger.WithField("uid", user.UID).Info("Password reset requested for disabled account")
+		return true, nil
+	}
+	if user.Mail == "" {
+		s.logger.WithField("uid", user.UID).Warn("Password reset requested for account without mail")
+		return true, nil
+	}
+	if last, ok := s.resets.LastIssued(p.Context, user.UID); ok && time.Since(last) < resetCooldown {
+		s.logger.WithField("uid", user.UID).Info("Password reset requested again too soon, ignoring")
+		return true, nil
+	}
+
+	if err := s.sendResetToken(p.Context, user); err != nil {
+		s.logger.WithError(err).WithField("uid", user.UID).Error("Failed to send password reset")
+		return false, fmt.Errorf("failed to send password reset")
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveResetPassword(p graphql.ResolveParams) (interface{}, error) {
+	secret := p.Args["token"].(string)
+	newPassword := p.Args["newPassword"].(string)
+
+	token, err := s.resets.Consume(p.Context, reset.HashSecret(secret))
+	if err != nil {
+		return false, reset.ErrInvalidToken
+	}
+
+	if err := s.dir.SetPassword(p.Context, token.UID, newPassword); err != nil {
+		// Taking the token first keeps it single-use under concurrent requests;
+		// put it back so the user can retry with a password the policy accepts
+		if restoreErr := s.resets.Restore(p.Context, token); restoreErr != nil {
+			s.logger.WithError(restoreErr).WithField("uid", token.UID).Warn("Failed to restore reset token")
+		}
+		return false, passwordError(err)
+	}
+
+	if _, err := s.RevokeUserSessions(p.Context, token.UID); err != nil {
+		s.logger.WithError(err).WithField("uid", token.UID).Warn("Failed to revoke sessions after password reset")
+	}
+	if err := s.guard.Unlock(p.Context, token.UID); err != nil {
+		s.logger.WithError(err).WithField("uid", token.UID).Warn("Failed to unlock account after password reset")
+	}
+
+	s.logger.WithField("uid", token.UID).Info("Password reset completed")
+	return true, nil
+}
+
+// sendResetToken issues a reset token for user and delivers the link
+func (s *Schema) sendResetToken(ctx context.Context, user *models.User) error {
+	secret, token, err := reset.NewToken(user.UID, s.config.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	if err := s.resets.Put(ctx, token); err != nil {
+		return fmt.Errorf("failed to store reset token: %w", err)
+	}
+
+	link := s.config.PasswordResetURL
+	if strings.Contains(link, "?") {
+		link += "&token=" + url.QueryEscape(secret)
+	} else {
+		link += "?token=" + url.QueryEscape(secret)
+	}
+
+	err = s.notifier.Send(ctx, &notify.Message{
+		To:      user.Mail,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"a password reset was requested for your account %s.\n"+
+			"Open the link below to choose a new password. It can be used once and expires in %s.\n\n"+
+			"%s\n\n"+
+			"If you did not request this, you can ignore this message.",
+			user.CN, user.UID, s.config.PasswordResetTTL, link),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.WithField("uid", user.UID).Info("Password reset token sent")
+	return nil
+}
+
+// revokeOtherSessions ends every session of uid except keepID
+func (s *Schema) revokeOtherSessions(ctx context.Context, uid, keepID string) error {
+	sessions, err := s.sessions.ListByUser(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, sess := range sessions {
+		if sess.ID == keepID {
+			continue
+		}
+		if err := s.revokeSession(ctx, sess); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// passwordError returns policy violations unwrapped so their extensions reach the client
+func passwordError(err error) error {
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr
+	}
+	return fmt.Errorf("failed to set password: %w", err)
+}
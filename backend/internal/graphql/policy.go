@@ -0,0 +1,204 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultPolicy returns the authorization rule for every root field.
+// Fields missing from the policy are denied.
+func defaultPolicy() authz.Policy {
+	admin := authz.RequireRole(authz.RoleAdmin)
+	reader := authz.RequireRole(authz.RoleAdmin, authz.RoleAuditor)
+
+	return authz.Policy{
+		// Queries
+		"Query.me":              authz.Authenticated(),
+		"Query.user":            authz.SelfOrRole("uid", authz.RoleAdmin, authz.RoleAuditor),
+		"Query.users":           reader,
+		"Query.usersConnection": reader,
+		"Query.department":      authz.Authenticated(),
+		"Query.departments":     authz.Authenticated(),
+		"Query.departmentUsers": authz.Authenticated(),
+		"Query.departmentTree":  authz.Authenticated(),
+		"Query.group":           authz.Authenticated(),
+		"Query.groups":          authz.Authenticated(),
+		"Query.health":          authz.Public(),
+		"Query.stats":           reader,
+		"Query.idAllocation":    reader,
+		"Query.mySessions":      authz.Authenticated(),
+		"Query.lockedAccounts":  reader,
+		// Exports include password hashes
+		"Query.exportLdif": admin,
+		// Department managers review registrations for their own departments
+		"Query.pendingRegistrations": authz.Authenticated(),
+
+		// Mutations
+		"Mutation.login":                  authz.Public(),
+		"Mutation.refreshToken":           authz.Public(),
+		"Mutation.logout":                 authz.Authenticated(),
+		"Mutation.logoutAllSessions":      authz.Authenticated(),
+		"Mutation.unlockUser":             admin,
+		"Mutation.changePassword":         authz.Authenticated(),
+		"Mutation.requestPasswordReset":   authz.Public(),
+		"Mutation.resetPassword":          authz.Public(),
+		"Mutation.register":               authz.Public(),
+		"Mutation.approveRegistration":    authz.Authenticated(),
+		"Mutation.rejectRegistration":     authz.Authenticated(),
+		"Mutation.createUser":             admin,
+		"Mutation.importUsers":            admin,
+		"Mutation.updateUser":             admin,
+		"Mutation.deleteUser":             admin,
+		"Mutation.disableUser":            admin,
+		"Mutation.enableUser":             admin,
+		"Mutation.restoreUser":            admin,
+		"Mutation.renameUser":             admin,
+		"Mutation.moveUser":               admin,
+		"Mutation.createDepartment":       admin,
+		"Mutation.deleteDepartment":       admin,
+		"Mutation.updateDepartment":       admin,
+		"Mutation.moveDepartment":         admin,
+		"Mutation.assignRepoToDepartment": admin,
+		"Mutation.assignRepoToUser":       admin,
+		"Mutation.createGroup":            admin,
+		"Mutation.addUserToGroup":         admin,
+		"Mutation.removeUserFromGroup":    admin,
+		"Mutation.addGroupToGroup":        admin,
+		"Mutation.removeGroupFromGroup":   admin,
+		"Mutation.setGroupMembers":        admin,
+		"Mutation.updateGroup":            admin,
+		"Mutation.deleteGroup":            admin,
+		"Mutation.importLdif":             admin,
+	}
+}
+
+// secure wraps every resolver of a root type with its policy check
+func (s *Schema) secure(typeName string, fields graphql.Fields) graphql.Fields {
+	for name, field := range fields {
+		key := typeName + "." + name
+		if _, ok := s.policy[key]; !ok {
+			s.logger.WithField("field", key).Warn("No authorization rule for field, access will be denied")
+		}
+
+		resolve := field.Resolve
+		field.Resolve = func(p graphql.ResolveParams) (interface{}, error) {
+			if err := s.policy.Check(p.Context, key, p.Args); err != nil {
+				principal, _ := authz.FromContext(p.Context)
+				uid := ""
+				if principal != nil {
+					uid = principal.UID
+				}
+				s.logger.WithFields(logrus.Fields{
+					"field": key,
+					"uid":   uid,
+				}).Warn("Authorization denied")
+				return nil, err
+			}
+			return resolve(p)
+		}
+	}
+	return fields
+}
+
+// ResolvePrincipal builds the authorization principal for an authenticated user
+func (s *Schema) ResolvePrincipal(ctx context.Context, user *models.User) (*authz.Principal, error) {
+	if cns, ok := s.principals.get(user.UID); ok {
+		return s.roles.Principal(user.UID, cns), nil
+	}
+
+	// Roles granted to a group extend to the members of groups nested in it
+	groups, err := s.dir.EffectiveGroups(ctx, user.UID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load groups: %w", err)
+	}
+
+	cns := make([]string, 0, len(groups))
+	for _, group := range groups {
+		cns = append(cns, group.CN)
+	}
+	s.principals.put(user.UID, cns)
+
+	return s.roles.Principal(user.UID, cns), nil
+}
+
+// groupCache remembers the effective group CNs of users for a short while,
+// since every authenticated request resolves its principal
+type groupCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cachedGroups
+}
+
+type cachedGroups struct {
+	cns       []string
+	expiresAt time.Time
+}
+
+func newGroupCache(ttl time.Duration) *groupCache {
+	return &groupCache{ttl: ttl, entries: make(map[string]cachedGroups)}
+}
+
+func (c *groupCache) get(uid string) ([]string, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[uid]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.cns, true
+}
+
+func (c *groupCache) put(uid string, cns []string) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	// Drop expired entries as the cache grows, so users who stopped calling
+	// do not stay in memory
+	if len(c.entries) >= groupCacheSweepSize {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+	}
+	c.entries[uid] = cachedGroups{cns: cns, expiresAt: now.Add(c.ttl)}
+}
+
+// invalidate forgets the cached groups of the given users
+func (c *groupCache) invalidate(uids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, uid := range uids {
+		delete(c.entries, uid)
+	}
+}
+
+// clear forgets the cached groups of every user, for changes whose effect on
+// nested memberships is not known up front
+func (c *groupCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[string]cachedGroups)
+}
+
+// groupCacheSweepSize is the number of cached users above which expired entries are swept
+const groupCacheSweepSize = 1024
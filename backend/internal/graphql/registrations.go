@@ -0,0 +1,362 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/notify"
+	"github.com/devplatform/ldap-manager/internal/password"
+	"github.com/devplatform/ldap-manager/internal/registration"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9._-]{1,31}$`)
+
+func (s *Schema) defineRegistrationType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Registration",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.String},
+			"username":   &graphql.Field{Type: graphql.String},
+			"email":      &graphql.Field{Type: graphql.String},
+			"firstName":  &graphql.Field{Type: graphql.String},
+			"lastName":   &graphql.Field{Type: graphql.String},
+			"userType":   &graphql.Field{Type: graphql.String},
+			"department": &graphql.Field{Type: graphql.String},
+			"status":     &graphql.Field{Type: graphql.String},
+			"reason":     &graphql.Field{Type: graphql.String},
+			"createdAt":  &graphql.Field{Type: graphql.DateTime},
+			"reviewedAt": &graphql.Field{Type: graphql.DateTime},
+			"reviewedBy": &graphql.Field{Type: graphql.String},
+		},
+	})
+}
+
+func (s *Schema) resolveRegister(p graphql.ResolveParams) (interface{}, error) {
+	if !s.config.RegistrationEnabled {
+		return nil, fmt.Errorf("registration is disabled")
+	}
+	// Anyone may register, so limit how often one client can
+	if err := s.guard.Throttle(p.Context, "register", session.ClientFromContext(p.Context).IP, s.config.RegistrationBackoffAfter); err != nil {
+		return nil, err
+	}
+
+	reg := &models.Registration{
+		Username:  strings.ToLower(strings.TrimSpace(p.Args["username"].(string))),
+		Email:     strings.TrimSpace(p.Args["email"].(string)),
+		FirstName: strings.TrimSpace(p.Args["firstName"].(string)),
+		LastName:  strings.TrimSpace(p.Args["lastName"].(string)),
+		UserType:  strings.ToLower(strings.TrimSpace(p.Args["userType"].(string))),
+		Status:    registration.StatusPending,
+		CreatedAt: time.Now(),
+	}
+	if department, ok := p.Args["department"].(string); ok {
+		reg.Department = strings.TrimSpace(department)
+	}
+
+	if !usernamePattern.MatchString(reg.Username) {
+		return nil, fmt.Errorf("username must start with a letter and contain only lowercase letters, digits, '.', '_' or '-'")
+	}
+	if reg.FirstName == "" || reg.LastName == "" {
+		return nil, fmt.Errorf("first and last name are required")
+	}
+	if !strings.Contains(reg.Email, "@") {
+		return nil, fmt.Errorf("a valid email address is required")
+	}
+	if _, ok := s.config.RegistrationUserTypes[reg.UserType]; !ok {
+		return nil, fmt.Errorf("unknown user type %q, expected one of: %s", reg.UserType, strings.Join(s.userTypes(), ", "))
+	}
+	if reg.Department != "" {
+		if _, err := s.dir.GetDepartment(p.Context, reg.Department); err != nil {
+			return nil, fmt.Errorf("unknown department %q", reg.Department)
+		}
+	}
+	if err := s.checkRegistrationAvailable(p.Context, reg); err != nil {
+		return nil, err
+	}
+
+	hash, err := s.dir.PreparePassword(p.Args["password"].(string), password.Subject{
+		UID:       reg.Username,
+		CN:        reg.FirstName + " " + reg.LastName,
+		SN:        reg.LastName,
+		GivenName: reg.FirstName,
+		Mail:      reg.Email,
+	})
+	if err != nil {
+		return nil, passwordError(err)
+	}
+	reg.PasswordHash = hash
+
+	reg.ID, err = session.NewID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registration: %w", err)
+	}
+	if err := s.registrations.Create(p.Context, reg); err != nil {
+		return nil, fmt.Errorf("failed to create registration: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"id":         reg.ID,
+		"username":   reg.Username,
+		"userType":   reg.UserType,
+		"department": reg.Department,
+		"ip":         session.ClientFromContext(p.Context).IP,
+	}).Info("Registration submitted")
+	return reg, nil
+}
+
+// checkRegistrationAvailable rejects usernames and addresses that are taken
+// by an account or by another pending registration
+func (s *Schema) checkRegistrationAvailable(ctx context.Context, reg *models.Registration) error {
+	if _, err := s.dir.GetUser(ctx, reg.Username); err == nil {
+		return fmt.Errorf("username %s is already taken", reg.Username)
+	}
+
+	pending, err := s.registrations.List(ctx, registration.StatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to list registrations: %w", err)
+	}
+	if len(pending) >= s.config.RegistrationMaxPending {
+		return fmt.Errorf("too many pending registrations, please try again later")
+	}
+	for _, other := range pending {
+		if other.Username == reg.Username {
+			return fmt.Errorf("username %s is already taken", reg.Username)
+		}
+		if strings.EqualFold(other.Email, reg.Email) {
+			return fmt.Errorf("a registration for %s is already pending", reg.Email)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) resolvePendingRegistrations(p graphql.ResolveParams) (interface{}, error) {
+	principal, ok := authz.FromContext(p.Context)
+	if !ok {
+		return nil, authz.ErrUnauthenticated
+	}
+
+	pending, err := s.registrations.List(p.Context, registration.StatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list registrations: %w", err)
+	}
+	if principal.HasRole(authz.RoleAdmin, authz.RoleAuditor) {
+		return pending, nil
+	}
+
+	// Department managers only see requests for the departments they manage
+	managed := s.managedDepartments(p.Context, principal)
+	visible := make([]*models.Registration, 0, len(pending))
+	for _, reg := range pending {
+		if reg.Department != "" && managed[reg.Department] {
+			visible = append(visible, reg)
+		}
+	}
+	return visible, nil
+}
+
+func (s *Schema) resolveApproveRegistration(p graphql.ResolveParams) (interface{}, error) {
+	principal, ok := authz.FromContext(p.Context)
+	if !ok {
+		return nil, authz.ErrUnauthenticated
+	}
+
+	reg, err := s.registrations.Get(p.Context, p.Args["id"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if reg.Status != registration.StatusPending {
+		return nil, registration.ErrConflict
+	}
+	// Only admins may file a request under a different department
+	if department, ok := p.Args["department"].(string); ok && department != "" && department != reg.Department {
+		if !principal.HasRole(authz.RoleAdmin) {
+			return nil, authz.Forbidden("Mutation.approveRegistration")
+		}
+		reg.Department = department
+	}
+	if reg.Department == "" {
+		return nil, fmt.Errorf("a department is required to approve the registration")
+	}
+	if !s.canReview(p.Context, principal, reg.Department) {
+		return nil, authz.Forbidden("Mutation.approveRegistration")
+	}
+
+	// Make sure the groups exist before creating the account
+	groups := s.userTypeGroups(reg.UserType)
+	for _, cn := range groups {
+		if _, err := s.dir.GetGroup(p.Context, cn); err != nil {
+			return nil, fmt.Errorf("group %s for user type %s does not exist", cn, reg.UserType)
+		}
+	}
+
+	// Claim the request so a concurrent review fails, and release it if creation fails
+	now := time.Now()
+	reg.Status = registration.StatusApproved
+	reg.ReviewedAt = &now
+	reg.ReviewedBy = principal.UID
+	if err := s.registrations.Update(p.Context, reg, registration.StatusPending); err != nil {
+		return nil, err
+	}
+
+	user, err := s.dir.CreateUser(p.Context, &models.CreateUserInput{
+		UID:          reg.Username,
+		CN:           reg.FirstName + " " + reg.LastName,
+		SN:           reg.LastName,
+		GivenName:    reg.FirstName,
+		Mail:         reg.Email,
+		Department:   reg.Department,
+		PasswordHash: reg.PasswordHash,
+	})
+	if err != nil {
+		reg.Status = registration.StatusPending
+		reg.ReviewedAt = nil
+		reg.ReviewedBy = ""
+		if restoreErr := s.registrations.Update(p.Context, reg, registration.StatusApproved); restoreErr != nil {
+			s.logger.WithError(restoreErr).WithField("id", reg.ID).Error("Failed to release registration")
+		}
+		return nil, err
+	}
+
+	for _, cn := range groups {
+		if err := s.dir.AddUserToGroup(p.Context, user.UID, cn); err != nil {
+			s.logger.WithError(err).WithFields(logrus.Fields{
+				"uid":   user.UID,
+				"group": cn,
+			}).Error("Failed to add approved user to group")
+		}
+	}
+
+	// The hash now lives in the directory only
+	reg.PasswordHash = ""
+	if err := s.registrations.Update(p.Context, reg, registration.StatusApproved); err != nil {
+		s.logger.WithError(err).WithField("id", reg.ID).Warn("Failed to clear registration password hash")
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"id":         reg.ID,
+		"uid":        user.UID,
+		"department": reg.Department,
+		"groups":     groups,
+		"reviewer":   principal.UID,
+	}).Info("Registration approved")
+
+	s.notifyApplicant(p.Context, reg, "Your account request was approved",
+		fmt.Sprintf("Hello %s,\n\nyour account %s has been approved. You can now sign in.", reg.FirstName, reg.Username))
+	return user, nil
+}
+
+func (s *Schema) resolveRejectRegistration(p graphql.ResolveParams) (interface{}, error) {
+	principal, ok := authz.FromContext(p.Context)
+	if !ok {
+		return nil, authz.ErrUnauthenticated
+	}
+
+	reg, err := s.registrations.Get(p.Context, p.Args["id"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if reg.Status != registration.StatusPending {
+		return nil, registration.ErrConflict
+	}
+	if !s.canReview(p.Context, principal, reg.Department) {
+		return nil, authz.Forbidden("Mutation.rejectRegistration")
+	}
+
+	now := time.Now()
+	reg.Status = registration.StatusRejected
+	reg.ReviewedAt = &now
+	reg.ReviewedBy = principal.UID
+	reg.PasswordHash = ""
+	if reason, ok := p.Args["reason"].(string); ok {
+		reg.Reason = reason
+	}
+	if err := s.registrations.Update(p.Context, reg, registration.StatusPending); err != nil {
+		return nil, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"id":       reg.ID,
+		"username": reg.Username,
+		"reviewer": principal.UID,
+	}).Info("Registration rejected")
+
+	body := fmt.Sprintf("Hello %s,\n\nyour request for the account %s was declined.", reg.FirstName, reg.Username)
+	if reg.Reason != "" {
+		body += "\n\nReason: " + reg.Reason
+	}
+	s.notifyApplicant(p.Context, reg, "Your account request was declined", body)
+	return reg, nil
+}
+
+// canReview reports whether principal may decide on registrations for department:
+// admins always, department managers for their own department
+func (s *Schema) canReview(ctx context.Context, principal *authz.Principal, department string) bool {
+	if principal.HasRole(authz.RoleAdmin) {
+		return true
+	}
+	if principal.Service || department == "" {
+		return false
+	}
+	dept, err := s.dir.GetDepartment(ctx, department)
+	return err == nil && dept.Manager == principal.UID
+}
+
+// managedDepartments returns the OUs of the departments principal manages
+func (s *Schema) managedDepartments(ctx context.Context, principal *authz.Principal) map[string]bool {
+	managed := make(map[string]bool)
+	if principal.Service {
+		return managed
+	}
+	departments, err := s.dir.ListDepartments(ctx)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to list departments for registration review")
+		return managed
+	}
+	for _, dept := range departments {
+		if dept.Manager == principal.UID {
+			managed[dept.OU] = true
+		}
+	}
+	return managed
+}
+
+// userTypeGroups returns the group CNs joined by users of the given type
+func (s *Schema) userTypeGroups(userType string) []string {
+	var groups []string
+	for _, cn := range strings.Split(s.config.RegistrationUserTypes[userType], ";") {
+		if cn = strings.TrimSpace(cn); cn != "" {
+			groups = append(groups, cn)
+		}
+	}
+	return groups
+}
+
+func (s *Schema) userTypes() []string {
+	types := make([]string, 0, len(s.config.RegistrationUserTypes))
+	for userType := range s.config.RegistrationUserTypes {
+		types = append(types, userType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// notifyApplicant tells the applicant about the decision; failures are only logged
+func (s *Schema) notifyApplicant(ctx context.Context, reg *models.Registration, subject, body string) {
+	err := s.notifier.Send(ctx, &notify.Message{
+		To:      reg.Email,
+		Subject: subject,
+		Body:    body,
+	})
+	if err != nil {
+		s.logger.WithError(err).WithField("id", reg.ID).Warn("Failed to notify applicant")
+	}
+}
@@ -5,9 +5,16 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/devplatform/ldap-manager/internal/authz"
 	"github.com/devplatform/ldap-manager/internal/config"
-	"github.com/devplatform/ldap-manager/internal/ldap"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/lockout"
 	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/notify"
+	"github.com/devplatform/ldap-manager/internal/registration"
+	"github.com/devplatform/ldap-manager/internal/reset"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/devplatform/ldap-manager/internal/token"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/graphql-go/graphql"
 	"github.com/sirupsen/logrus"
@@ -15,10 +22,19 @@ import (
 
 // Schema represents the GraphQL schema
 type Schema struct {
-	schema     graphql.Schema
-	ldapMgr    *ldap.Manager
-	config     *config.Config
-	logger     *logrus.Logger
+	schema        graphql.Schema
+	dir           directory.Directory
+	sessions      session.Store
+	keys          *token.KeySet
+	guard         *lockout.Guard
+	resets        reset.Store
+	registrations registration.Store
+	notifier      notify.Notifier
+	config        *config.Config
+	logger        *logrus.Logger
+	policy        authz.Policy
+	roles         *authz.RoleMapper
+	principals    *groupCache
 }
 
 // JWT Claims
@@ -26,6 +42,7 @@ type Claims struct {
 	UID        string `json:"uid"`
 	Mail       string `json:"mail"`
 	Department string `json:"department"`
+	SessionID  string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -41,13 +58,16 @@ func (s *Schema) definePaginationInput() *graphql.InputObject {
 }
 
 // Extend SearchFilterInput
-func (s *Schema) defineSearchFilterInput() *graphql.InputObject {
+func (s *Schema) defineSearchFilterInput(userStatusType *graphql.Enum) *graphql.InputObject {
 	return graphql.NewInputObject(graphql.InputObjectConfig{
 		Name: "SearchFilterInput",
 		Fields: graphql.InputObjectConfigFieldMap{
 			"department": &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"mail":       &graphql.InputObjectFieldConfig{Type: graphql.String},
-			"cn":         &graphql.InputObjectFieldConfig{Type: graphql.String},
+			// Also match users of the departments below department
+			"includeDescendants": &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+			"mail":               &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"cn":                 &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"status":             &graphql.InputObjectFieldConfig{Type: userStatusType},
 		},
 	})
 }
@@ -72,39 +92,65 @@ func (s *Schema) defineUserPageType(userType *graphql.Object) *graphql.Object {
 			"hasNextPage": &graphql.Field{
 				Type: graphql.Boolean,
 			},
+			// True when the directory holds more matches than LDAP_COUNT_LIMIT
+			"totalEstimated": &graphql.Field{
+				Type: graphql.Boolean,
+			},
 		},
 	})
 }
 
 
 // NewSchema creates a new GraphQL schema
-func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger) *Schema {
+func NewSchema(dir directory.Directory, sessions session.Store, keys *token.KeySet, guard *lockout.Guard, resets reset.Store, registrations registration.Store, notifier notify.Notifier, cfg *config.Config, logger *logrus.Logger) *Schema {
 	s := &Schema{
-		ldapMgr: ldapMgr,
-		config:  cfg,
-		logger:  logger,
+		dir:           dir,
+		sessions:      sessions,
+		keys:          keys,
+		guard:         guard,
+		resets:        resets,
+		registrations: registrations,
+		notifier:      notifier,
+		config:        cfg,
+		logger:        logger,
+		policy:        defaultPolicy(),
+		roles:         authz.NewRoleMapper(cfg.RoleGroups),
+		principals:    newGroupCache(cfg.PrincipalCacheTTL),
 	}
 
 	// Define types
-	userType := s.defineUserType()
-	departmentType := s.defineDepartmentType()
 	groupType := s.defineGroupType()
+	userStatusType := s.defineUserStatusEnum()
+	userType := s.defineUserType(groupType, userStatusType)
+	departmentType := s.defineDepartmentType(userType)
 	authPayloadType := s.defineAuthPayloadType(userType)
 	statsType := s.defineStatsType()
 	healthType := s.defineHealthType()
+	idAllocationType := s.defineIDAllocationType()
+	sessionType := s.defineSessionType()
+	accountLockType := s.defineAccountLockType()
+	registrationType := s.defineRegistrationType()
 	userPageType := s.defineUserPageType(userType)
+	userConnectionType := s.defineUserConnectionType(userType)
+	groupPageType := s.defineGroupPageType(groupType)
+	deletionSummaryType := s.defineDeletionSummaryType()
+	ldifScopeType := s.defineLDIFScopeEnum()
+	ldifImportReportType := s.defineLDIFImportReportType()
+	userImportReportType := s.defineUserImportReportType()
 
 	// Define input types
 	createUserInputType := s.defineCreateUserInput()
 	updateUserInputType := s.defineUpdateUserInput()
 	createDepartmentInputType := s.defineCreateDepartmentInput()
-	searchFilterInputType := s.defineSearchFilterInput()
+	searchFilterInputType := s.defineSearchFilterInput(userStatusType)
 	paginationInputType := s.definePaginationInput()
+	userSortInputType := s.defineUserSortInput()
+	groupFilterInputType := s.defineGroupFilterInput()
 	
 	// Define root query
 	queryType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Query",
-		Fields: graphql.Fields{
+		Fields: s.secure("Query", graphql.Fields{
 			"me": &graphql.Field{
 				Type:    userType,
 				Resolve: s.resolveMe,
@@ -127,9 +173,30 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 					"pagination": &graphql.ArgumentConfig{
 						Type: paginationInputType,
 					},
+					"sort": &graphql.ArgumentConfig{
+						Type: userSortInputType,
+					},
 				},
 				Resolve: s.resolveUsers,
 			},
+			"usersConnection": &graphql.Field{
+				Type: userConnectionType,
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{
+						Type: searchFilterInputType,
+					},
+					"sort": &graphql.ArgumentConfig{
+						Type: userSortInputType,
+					},
+					"first": &graphql.ArgumentConfig{
+						Type: graphql.Int,
+					},
+					"after": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveUsersConnection,
+			},
 			"department": &graphql.Field{
 				Type: departmentType,
 				Args: graphql.FieldConfigArgument{
@@ -143,12 +210,20 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				Type:    graphql.NewList(departmentType),
 				Resolve: s.resolveDepartments,
 			},
+			"departmentTree": &graphql.Field{
+				Type:    graphql.NewList(departmentType),
+				Resolve: s.resolveDepartmentTree,
+			},
 			"departmentUsers": &graphql.Field{
 				Type: graphql.NewList(userType),
 				Args: graphql.FieldConfigArgument{
 					"department": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
 					},
+					"includeDescendants": &graphql.ArgumentConfig{
+						Type:         graphql.Boolean,
+						DefaultValue: false,
+					},
 				},
 				Resolve: s.resolveDepartmentUsers,
 			},
@@ -161,6 +236,18 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				},
 				Resolve: s.resolveGroup,
 			},
+			"groups": &graphql.Field{
+				Type: groupPageType,
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{
+						Type: groupFilterInputType,
+					},
+					"pagination": &graphql.ArgumentConfig{
+						Type: paginationInputType,
+					},
+				},
+				Resolve: s.resolveGroups,
+			},
 			"health": &graphql.Field{
 				Type:    healthType,
 				Resolve: s.resolveHealth,
@@ -169,13 +256,39 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				Type:    statsType,
 				Resolve: s.resolveStats,
 			},
-		},
+			"idAllocation": &graphql.Field{
+				Type:    idAllocationType,
+				Resolve: s.resolveIDAllocation,
+			},
+			"mySessions": &graphql.Field{
+				Type:    graphql.NewList(sessionType),
+				Resolve: s.resolveMySessions,
+			},
+			"lockedAccounts": &graphql.Field{
+				Type:    graphql.NewList(accountLockType),
+				Resolve: s.resolveLockedAccounts,
+			},
+			"pendingRegistrations": &graphql.Field{
+				Type:    graphql.NewList(registrationType),
+				Resolve: s.resolvePendingRegistrations,
+			},
+			"exportLdif": &graphql.Field{
+				Type: graphql.String,
+				Args: graphql.FieldConfigArgument{
+					"scope": &graphql.ArgumentConfig{
+						Type:         ldifScopeType,
+						DefaultValue: models.LDIFScopeAll,
+					},
+				},
+				Resolve: s.resolveExportLDIF,
+			},
+		}),
 	})
 
 	// Define root mutation
 	mutationType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Mutation",
-		Fields: graphql.Fields{
+		Fields: s.secure("Mutation", graphql.Fields{
 			"login": &graphql.Field{
 				Type: authPayloadType,
 				Args: graphql.FieldConfigArgument{
@@ -188,6 +301,119 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				},
 				Resolve: s.resolveLogin,
 			},
+			"refreshToken": &graphql.Field{
+				Type: authPayloadType,
+				Args: graphql.FieldConfigArgument{
+					"refreshToken": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveRefreshToken,
+			},
+			"logout": &graphql.Field{
+				Type:    graphql.Boolean,
+				Resolve: s.resolveLogout,
+			},
+			"logoutAllSessions": &graphql.Field{
+				Type:    graphql.Int,
+				Resolve: s.resolveLogoutAllSessions,
+			},
+			"register": &graphql.Field{
+				Type: registrationType,
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"password": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"email": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"firstName": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"lastName": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"userType": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"department": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveRegister,
+			},
+			"approveRegistration": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"department": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveApproveRegistration,
+			},
+			"rejectRegistration": &graphql.Field{
+				Type: registrationType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"reason": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveRejectRegistration,
+			},
+			"changePassword": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"oldPassword": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"newPassword": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveChangePassword,
+			},
+			"requestPasswordReset": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"mail": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveRequestPasswordReset,
+			},
+			"resetPassword": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"token": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"newPassword": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveResetPassword,
+			},
+			"unlockUser": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveUnlockUser,
+			},
 			"createUser": &graphql.Field{
 				Type: userType,
 				Args: graphql.FieldConfigArgument{
@@ -197,6 +423,19 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				},
 				Resolve: s.resolveCreateUser,
 			},
+			"importUsers": &graphql.Field{
+				Type: userImportReportType,
+				Args: graphql.FieldConfigArgument{
+					"csv": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"dryRun": &graphql.ArgumentConfig{
+						Type:         graphql.Boolean,
+						DefaultValue: false,
+					},
+				},
+				Resolve: s.resolveImportUsers,
+			},
 			"updateUser": &graphql.Field{
 				Type: userType,
 				Args: graphql.FieldConfigArgument{
@@ -206,15 +445,72 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				},
 				Resolve: s.resolveUpdateUser,
 			},
+			// Soft deletes unless permanent; permanently deleting a
+			// soft-deleted user purges it
 			"deleteUser": &graphql.Field{
-				Type: graphql.Boolean,
+				Type: deletionSummaryType,
 				Args: graphql.FieldConfigArgument{
 					"uid": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
 					},
+					"permanent": &graphql.ArgumentConfig{
+						Type:         graphql.Boolean,
+						DefaultValue: false,
+					},
 				},
 				Resolve: s.resolveDeleteUser,
 			},
+			"disableUser": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveDisableUser,
+			},
+			"enableUser": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveEnableUser,
+			},
+			"restoreUser": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveRestoreUser,
+			},
+			"renameUser": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"oldUid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"newUid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveRenameUser,
+			},
+			"moveUser": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"department": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveMoveUser,
+			},
 			"createDepartment": &graphql.Field{
 				Type: departmentType,
 				Args: graphql.FieldConfigArgument{
@@ -224,12 +520,45 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				},
 				Resolve: s.resolveCreateDepartment,
 			},
+			"updateDepartment": &graphql.Field{
+				Type: departmentType,
+				Args: graphql.FieldConfigArgument{
+					"ou": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"description": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					// uid of the manager; an empty string removes the manager
+					"manager": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveUpdateDepartment,
+			},
+			"moveDepartment": &graphql.Field{
+				Type: departmentType,
+				Args: graphql.FieldConfigArgument{
+					"ou": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					// Omit to move the department to the top level
+					"parent": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveMoveDepartment,
+			},
 			"deleteDepartment": &graphql.Field{
-				Type: graphql.Boolean,
+				Type: deletionSummaryType,
 				Args: graphql.FieldConfigArgument{
 					"ou": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
 					},
+					// Department that takes over the members; required while members remain
+					"reassignTo": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
 				},
 				Resolve: s.resolveDeleteDepartment,
 			},
@@ -281,7 +610,89 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 				},
 				Resolve: s.resolveAddUserToGroup,
 			},
-		},
+			"removeUserFromGroup": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"groupCn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveRemoveUserFromGroup,
+			},
+			"addGroupToGroup": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"groupCn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"parentCn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveAddGroupToGroup,
+			},
+			"removeGroupFromGroup": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"groupCn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"parentCn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveRemoveGroupFromGroup,
+			},
+			"setGroupMembers": &graphql.Field{
+				Type: groupType,
+				Args: graphql.FieldConfigArgument{
+					"cn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"members": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					},
+				},
+				Resolve: s.resolveSetGroupMembers,
+			},
+			"updateGroup": &graphql.Field{
+				Type: groupType,
+				Args: graphql.FieldConfigArgument{
+					"cn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"description": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: s.resolveUpdateGroup,
+			},
+			"deleteGroup": &graphql.Field{
+				Type: graphql.Boolean,
+				Args: graphql.FieldConfigArgument{
+					"cn": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: s.resolveDeleteGroup,
+			},
+			"importLdif": &graphql.Field{
+				Type: ldifImportReportType,
+				Args: graphql.FieldConfigArgument{
+					"ldif": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"dryRun": &graphql.ArgumentConfig{
+						Type:         graphql.Boolean,
+						DefaultValue: false,
+					},
+				},
+				Resolve: s.resolveImportLDIF,
+			},
+		}),
 	})
 
 	// Create schema
@@ -301,20 +712,7 @@ func NewSchema(ldapMgr *ldap.Manager, cfg *config.Config, logger *logrus.Logger)
 
 
 func (s *Schema) resolveUsers(p graphql.ResolveParams) (interface{}, error) {
-	// Filters
-	var filter *models.SearchFilter
-	if filterInput, ok := p.Args["filter"].(map[string]interface{}); ok {
-		filter = &models.SearchFilter{}
-		if v, ok := filterInput["department"].(string); ok {
-			filter.Department = v
-		}
-		if v, ok := filterInput["mail"].(string); ok {
-			filter.Mail = v
-		}
-		if v, ok := filterInput["cn"].(string); ok {
-			filter.CN = v
-		}
-	}
+	filter := parseSearchFilter(p.Args)
 
 	// Pagination defaults
 	page := 1
@@ -328,8 +726,11 @@ func (s *Schema) resolveUsers(p graphql.ResolveParams) (interface{}, error) {
 			limit = v
 		}
 	}
+	if limit > maxPageSize {
+		return nil, fmt.Errorf("limit must not exceed %d", maxPageSize)
+	}
 
-	return s.ldapMgr.ListUsersPaginated(p.Context, filter, page, limit)
+	return s.dir.ListUsersPaginated(p.Context, filter, parseUserSort(p.Args), page, limit)
 }
 
 // GetSchema returns the GraphQL schema
@@ -339,7 +740,7 @@ func (s *Schema) GetSchema() graphql.Schema {
 
 // Type Definitions
 
-func (s *Schema) defineUserType() *graphql.Object {
+func (s *Schema) defineUserType(groupType *graphql.Object, userStatusType *graphql.Enum) *graphql.Object {
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name: "User",
 		Fields: graphql.Fields{
@@ -354,32 +755,97 @@ func (s *Schema) defineUserType() *graphql.Object {
 			"homeDirectory": &graphql.Field{Type: graphql.String},
 			"repositories": &graphql.Field{Type: graphql.NewList(graphql.String)},
 			"dn":           &graphql.Field{Type: graphql.String},
+			"status":       &graphql.Field{Type: userStatusType},
+			"deletedAt":    &graphql.Field{Type: graphql.DateTime},
+			"groups": &graphql.Field{
+				Type:    graphql.NewList(groupType),
+				Resolve: s.resolveUserGroups,
+			},
+			"effectiveGroups": &graphql.Field{
+				Type:    graphql.NewList(groupType),
+				Resolve: s.resolveUserEffectiveGroups,
+			},
 		},
 	})
 }
 
-func (s *Schema) defineDepartmentType() *graphql.Object {
-	return graphql.NewObject(graphql.ObjectConfig{
+func (s *Schema) defineDepartmentType(userType *graphql.Object) *graphql.Object {
+	var departmentType *graphql.Object
+	departmentType = graphql.NewObject(graphql.ObjectConfig{
 		Name: "Department",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"ou":           &graphql.Field{Type: graphql.String},
+				"description":  &graphql.Field{Type: graphql.String},
+				"repositories": &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"dn":           &graphql.Field{Type: graphql.String},
+				"path":         &graphql.Field{Type: graphql.NewList(graphql.String)},
+				"manager": &graphql.Field{
+					Type:    userType,
+					Resolve: s.resolveDepartmentManager,
+				},
+				"members": &graphql.Field{
+					Type: graphql.NewList(graphql.String),
+					Args: graphql.FieldConfigArgument{
+						"includeDescendants": &graphql.ArgumentConfig{
+							Type:         graphql.Boolean,
+							DefaultValue: false,
+						},
+					},
+					Resolve: s.resolveDepartmentMembers,
+				},
+				"parent": &graphql.Field{
+					Type:    departmentType,
+					Resolve: s.resolveDepartmentParent,
+				},
+				"children": &graphql.Field{
+					Type:    graphql.NewList(departmentType),
+					Resolve: s.resolveDepartmentChildren,
+				},
+				"ancestors": &graphql.Field{
+					Type:    graphql.NewList(departmentType),
+					Resolve: s.resolveDepartmentAncestors,
+				},
+			}
+		}),
+	})
+	return departmentType
+}
+
+func (s *Schema) defineGroupType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Group",
 		Fields: graphql.Fields{
-			"ou":           &graphql.Field{Type: graphql.String},
+			"cn":           &graphql.Field{Type: graphql.String},
+			"gidNumber":    &graphql.Field{Type: graphql.Int},
 			"description":  &graphql.Field{Type: graphql.String},
-			"manager":      &graphql.Field{Type: graphql.String},
 			"members":      &graphql.Field{Type: graphql.NewList(graphql.String)},
-			"repositories": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"memberGroups": &graphql.Field{Type: graphql.NewList(graphql.String)},
 			"dn":           &graphql.Field{Type: graphql.String},
+			"effectiveMembers": &graphql.Field{
+				Type:    graphql.NewList(graphql.String),
+				Resolve: s.resolveGroupEffectiveMembers,
+			},
 		},
 	})
 }
 
-func (s *Schema) defineGroupType() *graphql.Object {
+func (s *Schema) defineDeletionSummaryType() *graphql.Object {
+	entryChangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "EntryChange",
+		Fields: graphql.Fields{
+			"dn":     &graphql.Field{Type: graphql.String},
+			"change": &graphql.Field{Type: graphql.String},
+		},
+	})
+
 	return graphql.NewObject(graphql.ObjectConfig{
-		Name: "Group",
+		Name: "DeletionSummary",
 		Fields: graphql.Fields{
-			"cn":        &graphql.Field{Type: graphql.String},
-			"gidNumber": &graphql.Field{Type: graphql.Int},
-			"members":   &graphql.Field{Type: graphql.NewList(graphql.String)},
-			"dn":        &graphql.Field{Type: graphql.String},
+			// DNs of the removed entries
+			"deleted": &graphql.Field{Type: graphql.NewList(graphql.String)},
+			// Entries changed to keep references consistent
+			"modified": &graphql.Field{Type: graphql.NewList(entryChangeType)},
 		},
 	})
 }
@@ -388,20 +854,40 @@ func (s *Schema) defineAuthPayloadType(userType *graphql.Object) *graphql.Object
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name: "AuthPayload",
 		Fields: graphql.Fields{
-			"token": &graphql.Field{Type: graphql.String},
-			"user":  &graphql.Field{Type: userType},
+			"token":        &graphql.Field{Type: graphql.String},
+			"refreshToken": &graphql.Field{Type: graphql.String},
+			"expiresAt":    &graphql.Field{Type: graphql.DateTime},
+			"user":         &graphql.Field{Type: userType},
 		},
 	})
 }
 
 func (s *Schema) defineStatsType() *graphql.Object {
+	endpointStatsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "EndpointStats",
+		Fields: graphql.Fields{
+			"url":       &graphql.Field{Type: graphql.String},
+			"role":      &graphql.Field{Type: graphql.String},
+			"healthy":   &graphql.Field{Type: graphql.Boolean},
+			"lastError": &graphql.Field{Type: graphql.String},
+			"poolSize":  &graphql.Field{Type: graphql.Int},
+			"open":      &graphql.Field{Type: graphql.Int},
+			"available": &graphql.Field{Type: graphql.Int},
+			"inUse":     &graphql.Field{Type: graphql.Int},
+		},
+	})
+
 	return graphql.NewObject(graphql.ObjectConfig{
 		Name: "Stats",
 		Fields: graphql.Fields{
 			"poolSize":      &graphql.Field{Type: graphql.Int},
+			"open":          &graphql.Field{Type: graphql.Int},
 			"available":     &graphql.Field{Type: graphql.Int},
 			"inUse":         &graphql.Field{Type: graphql.Int},
 			"totalRequests": &graphql.Field{Type: graphql.Int},
+			"healthy":       &graphql.Field{Type: graphql.Boolean},
+			"lastError":     &graphql.Field{Type: graphql.String},
+			"endpoints":     &graphql.Field{Type: graphql.NewList(endpointStatsType)},
 		},
 	})
 }
@@ -417,6 +903,25 @@ func (s *Schema) defineHealthType() *graphql.Object {
 	})
 }
 
+func (s *Schema) defineIDAllocationType() *graphql.Object {
+	idRangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "IDRange",
+		Fields: graphql.Fields{
+			"start":     &graphql.Field{Type: graphql.Int},
+			"next":      &graphql.Field{Type: graphql.Int},
+			"allocated": &graphql.Field{Type: graphql.Int},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "IDAllocation",
+		Fields: graphql.Fields{
+			"uid": &graphql.Field{Type: idRangeType},
+			"gid": &graphql.Field{Type: idRangeType},
+		},
+	})
+}
+
 // Input Type Definitions
 
 func (s *Schema) defineCreateUserInput() *graphql.InputObject {
@@ -456,6 +961,7 @@ func (s *Schema) defineCreateDepartmentInput() *graphql.InputObject {
 		Name: "CreateDepartmentInput",
 		Fields: graphql.InputObjectConfigFieldMap{
 			"ou":           &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"parent":       &graphql.InputObjectFieldConfig{Type: graphql.String},
 			"description":  &graphql.InputObjectFieldConfig{Type: graphql.String},
 			"manager":      &graphql.InputObjectFieldConfig{Type: graphql.String},
 			"repositories": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.String)},
@@ -475,31 +981,32 @@ func (s *Schema) resolveMe(p graphql.ResolveParams) (interface{}, error) {
 
 func (s *Schema) resolveUser(p graphql.ResolveParams) (interface{}, error) {
 	uid := p.Args["uid"].(string)
-	return s.ldapMgr.GetUser(p.Context, uid)
+	return s.dir.GetUser(p.Context, uid)
 }
 
 
 func (s *Schema) resolveDepartment(p graphql.ResolveParams) (interface{}, error) {
 	ou := p.Args["ou"].(string)
-	return s.ldapMgr.GetDepartment(p.Context, ou)
+	return s.dir.GetDepartment(p.Context, ou)
 }
 
 func (s *Schema) resolveDepartments(p graphql.ResolveParams) (interface{}, error) {
-	return s.ldapMgr.ListDepartments(p.Context)
+	return s.dir.ListDepartments(p.Context)
 }
 
 func (s *Schema) resolveDepartmentUsers(p graphql.ResolveParams) (interface{}, error) {
 	department := p.Args["department"].(string)
-	return s.ldapMgr.GetUsersByDepartment(p.Context, department)
+	includeDescendants, _ := p.Args["includeDescendants"].(bool)
+	return s.dir.GetUsersByDepartment(p.Context, department, includeDescendants)
 }
 
 func (s *Schema) resolveGroup(p graphql.ResolveParams) (interface{}, error) {
 	cn := p.Args["cn"].(string)
-	return s.ldapMgr.GetGroup(p.Context, cn)
+	return s.dir.GetGroup(p.Context, cn)
 }
 
 func (s *Schema) resolveHealth(p graphql.ResolveParams) (interface{}, error) {
-	ldapHealthy := s.ldapMgr.HealthCheck(p.Context) == nil
+	ldapHealthy := s.dir.HealthCheck(p.Context) == nil
 	status := "healthy"
 	if !ldapHealthy {
 		status = "unhealthy"
@@ -513,7 +1020,11 @@ func (s *Schema) resolveHealth(p graphql.ResolveParams) (interface{}, error) {
 }
 
 func (s *Schema) resolveStats(p graphql.ResolveParams) (interface{}, error) {
-	return s.ldapMgr.GetStats(), nil
+	return s.dir.GetStats(), nil
+}
+
+func (s *Schema) resolveIDAllocation(p graphql.ResolveParams) (interface{}, error) {
+	return s.dir.IDAllocation(p.Context)
 }
 
 // Mutation Resolvers
@@ -522,22 +1033,28 @@ func (s *Schema) resolveLogin(p graphql.ResolveParams) (interface{}, error) {
 	uid := p.Args["uid"].(string)
 	password := p.Args["password"].(string)
 
-	user, err := s.ldapMgr.Authenticate(p.Context, uid, password)
+	// Throttled and locked attempts are refused before binding to LDAP
+	ip := session.ClientFromContext(p.Context).IP
+	if err := s.guard.Check(p.Context, uid, ip); err != nil {
+		s.logger.WithError(err).WithFields(logrus.Fields{"uid": uid, "ip": ip}).Warn("Login refused")
+		return nil, err
+	}
+
+	user, err := s.dir.Authenticate(p.Context, uid, password)
 	if err != nil {
+		s.guard.Failure(p.Context, uid, ip)
 		s.logger.WithError(err).Warn("Login failed")
 		return nil, fmt.Errorf("authentication failed")
 	}
+	s.guard.Success(p.Context, uid)
 
-	token, err := s.generateJWT(user)
+	payload, err := s.issueSession(p.Context, user)
 	if err != nil {
-		s.logger.WithError(err).Error("Failed to generate JWT")
+		s.logger.WithError(err).Error("Failed to start session")
 		return nil, fmt.Errorf("failed to generate token")
 	}
 
-	return &models.AuthPayload{
-		Token: token,
-		User:  user,
-	}, nil
+	return payload, nil
 }
 
 func (s *Schema) resolveCreateUser(p graphql.ResolveParams) (interface{}, error) {
@@ -560,7 +1077,7 @@ func (s *Schema) resolveCreateUser(p graphql.ResolveParams) (interface{}, error)
 		}
 	}
 
-	return s.ldapMgr.CreateUser(p.Context, input)
+	return s.dir.CreateUser(p.Context, input)
 }
 
 func (s *Schema) resolveUpdateUser(p graphql.ResolveParams) (interface{}, error) {
@@ -595,13 +1112,25 @@ func (s *Schema) resolveUpdateUser(p graphql.ResolveParams) (interface{}, error)
 		}
 	}
 
-	return s.ldapMgr.UpdateUser(p.Context, input)
+	return s.dir.UpdateUser(p.Context, input)
 }
 
 func (s *Schema) resolveDeleteUser(p graphql.ResolveParams) (interface{}, error) {
 	uid := p.Args["uid"].(string)
-	err := s.ldapMgr.DeleteUser(p.Context, uid)
-	return err == nil, err
+	deleteUser := s.dir.SoftDeleteUser
+	if permanent, _ := p.Args["permanent"].(bool); permanent {
+		deleteUser = s.dir.DeleteUser
+	}
+	summary, err := deleteUser(p.Context, uid)
+	s.principals.invalidate(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.RevokeUserSessions(p.Context, uid); err != nil {
+		s.logger.WithError(err).Error("Failed to revoke sessions of deleted user")
+	}
+	return summary, nil
 }
 
 func (s *Schema) resolveCreateDepartment(p graphql.ResolveParams) (interface{}, error) {
@@ -611,6 +1140,9 @@ func (s *Schema) resolveCreateDepartment(p graphql.ResolveParams) (interface{},
 		OU: inputMap["ou"].(string),
 	}
 
+	if parent, ok := inputMap["parent"].(string); ok {
+		input.Parent = parent
+	}
 	if desc, ok := inputMap["description"].(string); ok {
 		input.Description = desc
 	}
@@ -624,13 +1156,13 @@ func (s *Schema) resolveCreateDepartment(p graphql.ResolveParams) (interface{},
 		}
 	}
 
-	return s.ldapMgr.CreateDepartment(p.Context, input)
+	return s.dir.CreateDepartment(p.Context, input)
 }
 
 func (s *Schema) resolveDeleteDepartment(p graphql.ResolveParams) (interface{}, error) {
 	ou := p.Args["ou"].(string)
-	err := s.ldapMgr.DeleteDepartment(p.Context, ou)
-	return err == nil, err
+	reassignTo, _ := p.Args["reassignTo"].(string)
+	return s.dir.DeleteDepartment(p.Context, ou, reassignTo)
 }
 
 func (s *Schema) resolveAssignRepoToDepartment(p graphql.ResolveParams) (interface{}, error) {
@@ -642,11 +1174,11 @@ func (s *Schema) resolveAssignRepoToDepartment(p graphql.ResolveParams) (interfa
 		repos[i] = r.(string)
 	}
 
-	if err := s.ldapMgr.AssignRepositoryToDepartment(p.Context, ou, repos); err != nil {
+	if err := s.dir.AssignRepositoryToDepartment(p.Context, ou, repos); err != nil {
 		return nil, err
 	}
 
-	return s.ldapMgr.GetDepartment(p.Context, ou)
+	return s.dir.GetDepartment(p.Context, ou)
 }
 
 func (s *Schema) resolveAssignRepoToUser(p graphql.ResolveParams) (interface{}, error) {
@@ -663,7 +1195,7 @@ func (s *Schema) resolveAssignRepoToUser(p graphql.ResolveParams) (interface{},
 		Repositories: repos,
 	}
 
-	return s.ldapMgr.UpdateUser(p.Context, input)
+	return s.dir.UpdateUser(p.Context, input)
 }
 
 func (s *Schema) resolveCreateGroup(p graphql.ResolveParams) (interface{}, error) {
@@ -673,57 +1205,83 @@ func (s *Schema) resolveCreateGroup(p graphql.ResolveParams) (interface{}, error
 		description = desc
 	}
 
-	return s.ldapMgr.CreateGroup(p.Context, cn, description)
+	return s.dir.CreateGroup(p.Context, cn, description)
 }
 
 func (s *Schema) resolveAddUserToGroup(p graphql.ResolveParams) (interface{}, error) {
 	uid := p.Args["uid"].(string)
 	groupCn := p.Args["groupCn"].(string)
 
-	err := s.ldapMgr.AddUserToGroup(p.Context, uid, groupCn)
+	err := s.dir.AddUserToGroup(p.Context, uid, groupCn)
+	s.principals.invalidate(uid)
 	return err == nil, err
 }
 
 // JWT Functions
 
-func (s *Schema) generateJWT(user *models.User) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func (s *Schema) generateJWT(user *models.User, sessionID string) (string, string, time.Time, error) {
+	jti, err := session.NewID()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(s.config.JWTExpiration)
 
 	claims := &Claims{
 		UID:        user.UID,
 		Mail:       user.Mail,
 		Department: user.Department,
+		SessionID:  sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   user.UID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    "ldap-manager",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.config.JWTSecret))
+	signed, err := s.keys.Sign(claims)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return signed, jti, expirationTime, nil
 }
 
-// ExtractUserFromToken validates JWT and extracts user information
-func (s *Schema) ExtractUserFromToken(tokenString string) (*models.User, error) {
+// ExtractUserFromToken validates JWT and extracts user information.
+// Revoked tokens and tokens whose session has ended are rejected.
+func (s *Schema) ExtractUserFromToken(ctx context.Context, tokenString string) (*models.User, *Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.config.JWTSecret), nil
-	})
-
+	token, err := s.keys.Parse(tokenString, claims)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
+		return nil, nil, fmt.Errorf("invalid token")
 	}
 
-	// Fetch full user details from LDAP
-	ctx := context.Background()
-	return s.ldapMgr.GetUser(ctx, claims.UID)
+	revoked, err := s.sessions.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check revocation: %w", err)
+	}
+	if revoked {
+		return nil, nil, fmt.Errorf("token has been revoked")
+	}
+	if _, err := s.sessions.Get(ctx, claims.SessionID); err != nil {
+		return nil, nil, fmt.Errorf("session has ended")
+	}
+
+	// Fetch full user details from a provider; deleted users fail here, and
+	// disabled ones below even before replicas have caught up
+	user, err := s.dir.GetUser(directory.WithProvider(ctx), claims.UID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Status != models.UserStatusActive {
+		return nil, nil, fmt.Errorf("account is disabled")
+	}
+	return user, claims, nil
 }
@@ -0,0 +1,332 @@
+package graphql_test
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/graphql"
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/notify"
+	"github.com/devplatform/ldap-manager/internal/password"
+	"github.com/devplatform/ldap-manager/internal/registration"
+	"github.com/devplatform/ldap-manager/internal/reset"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/devplatform/ldap-manager/internal/token"
+	gql "github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+// Passwords of the fixture users
+const (
+	alicePassword = "Alice-Pass-123"
+	bobPassword   = "Bob-Pass-123"
+	carolPassword = "Carol-Pass-123"
+	newPassword   = "Fresh-Start-456"
+)
+
+// env is the in-memory directory the resolvers under test serve
+type env struct {
+	dir directory.Directory
+	cfg *config.Config
+}
+
+// newEnv returns an in-memory directory holding the fixture of the
+// directory tests in ldaptest:
+//
+//	departments  Engineering (manager alice) > Platform, Sales
+//	users        alice (Engineering), bob (Platform), carol (Sales)
+//	groups       admins {bob}, developers {alice}, sales {}
+func newEnv(t *testing.T) *env {
+	t.Helper()
+
+	t.Setenv("LDAP_BASE_DN", "dc=devplatform,dc=local")
+	t.Setenv("LDAP_BIND_DN", "cn=admin,dc=devplatform,dc=local")
+	t.Setenv("LDAP_BIND_PASSWORD", "admin-secret")
+	t.Setenv("STARTING_GID", "10100")
+	t.Setenv("DEPARTMENT_GROUPS", "Engineering:developers,Sales:sales")
+	cfg := config.Load()
+	dir, err := directory.NewMemoryDirectory(cfg, quietLogger())
+	if err != nil {
+		t.Fatalf("NewMemoryDirectory: %v", err)
+	}
+	t.Cleanup(func() { dir.Close() })
+
+	hasher, err := password.NewHasher("SSHA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, cn := range []string{"admins", "developers", "sales"} {
+		result(dir.CreateGroup(ctx, cn, "")).must(t)
+	}
+	result(dir.CreateDepartment(ctx, &models.CreateDepartmentInput{
+		OU: "Engineering", Description: "Builds things", Repositories: []string{"devplatform/api"},
+	})).must(t)
+	result(dir.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Platform", Parent: "Engineering"})).must(t)
+	result(dir.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Sales", Repositories: []string{"devplatform/crm"}})).must(t)
+
+	// Hashed up front, since the passwords break the policy
+	user := func(uid, given, sn, department, plain string) {
+		hashed, err := hasher.Hash(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result(dir.CreateUser(ctx, &models.CreateUserInput{
+			UID: uid, CN: given + " " + sn, SN: sn, GivenName: given, Mail: uid + "@devplatform.local",
+			Department: department, PasswordHash: hashed,
+		})).must(t)
+	}
+	user("alice", "Alice", "Archer", "Engineering", alicePassword)
+	user("bob", "Bob", "Baker", "Platform", bobPassword)
+	user("carol", "Carol", "Cooper", "Sales", carolPassword)
+	manager := "alice"
+	result(dir.UpdateDepartment(ctx, &models.UpdateDepartmentInput{OU: "Engineering", Manager: &manager})).must(t)
+	check(t, dir.AddUserToGroup(ctx, "bob", "admins"))
+	check(t, dir.AddUserToGroup(ctx, "alice", "developers"))
+
+	return &env{dir: dir, cfg: cfg}
+}
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func cns(groups []*models.Group) []string {
+	out := make([]string, 0, len(groups))
+	for _, group := range groups {
+		out = append(out, group.CN)
+	}
+	return out
+}
+
+// outcome holds the two results of a method, so a test can require success
+type outcome[T any] struct {
+	value T
+	err   error
+}
+
+func result[T any](value T, err error) outcome[T] {
+	return outcome[T]{value, err}
+}
+
+// must returns the value, failing the test if the method returned an error
+func (o outcome[T]) must(t *testing.T) T {
+	t.Helper()
+	if o.err != nil {
+		t.Fatalf("unexpected error: %v", o.err)
+	}
+	return o.value
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func equal[T any](t *testing.T, what string, got, want T) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s = %v, want %v", what, got, want)
+	}
+}
+
+// newResolvers returns the GraphQL schema serving the in-memory fixture
+func newResolvers(t *testing.T) (*env, *graphql.Schema) {
+	t.Helper()
+
+	t.Setenv("JWT_SECRET", "resolver-test-secret")
+	e := newEnv(t)
+	logger := quietLogger()
+	keys, err := token.NewKeySet(e.cfg, logger)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	sessions := session.NewMemoryStore(time.Minute)
+	t.Cleanup(sessions.Close)
+	guard := lockout.NewGuard(e.cfg, lockout.NewMemoryStore(), lockout.NewMemoryCounterStore(), logger)
+
+	s := graphql.NewSchema(e.dir, sessions, keys, guard, reset.NewMemoryStore(), registration.NewMemoryStore(),
+		notify.NewWriterNotifier(io.Discard), e.cfg, logger)
+	return e, s
+}
+
+// as returns a context authenticated as uid, resolved the way the server does
+func as(t *testing.T, e *env, s *graphql.Schema, uid string) context.Context {
+	t.Helper()
+	user := result(e.dir.GetUser(context.Background(), uid)).must(t)
+	ctx := context.WithValue(context.Background(), "user", user)
+	principal := result(s.ResolvePrincipal(ctx, user)).must(t)
+	return authz.WithPrincipal(ctx, principal)
+}
+
+// query runs request and returns its data, failing on any error
+func query(t *testing.T, s *graphql.Schema, ctx context.Context, request string, vars map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	res := gql.Do(gql.Params{Schema: s.GetSchema(), RequestString: request, VariableValues: vars, Context: ctx})
+	if len(res.Errors) > 0 {
+		t.Fatalf("%s: %v", request, res.Errors)
+	}
+	return res.Data.(map[string]interface{})
+}
+
+// denied runs request and fails unless it is rejected with contains
+func denied(t *testing.T, s *graphql.Schema, ctx context.Context, request string, vars map[string]interface{}, contains string) {
+	t.Helper()
+	res := gql.Do(gql.Params{Schema: s.GetSchema(), RequestString: request, VariableValues: vars, Context: ctx})
+	if len(res.Errors) == 0 || !strings.Contains(res.Errors[0].Message, contains) {
+		t.Fatalf("%s: errors = %v, want %q", request, res.Errors, contains)
+	}
+}
+
+func TestResolvers(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, e *env, s *graphql.Schema)
+	}{
+		{"Login", func(t *testing.T, e *env, s *graphql.Schema) {
+			login := `mutation($uid: String!, $password: String!) { login(uid: $uid, password: $password) { token user { uid } } }`
+			data := query(t, s, context.Background(), login, map[string]interface{}{"uid": "alice", "password": alicePassword})
+			payload := data["login"].(map[string]interface{})
+			equal(t, "uid", payload["user"].(map[string]interface{})["uid"], interface{}("alice"))
+
+			user, _, err := s.ExtractUserFromToken(context.Background(), payload["token"].(string))
+			check(t, err)
+			equal(t, "token uid", user.UID, "alice")
+
+			denied(t, s, context.Background(), login, map[string]interface{}{"uid": "alice", "password": "wrong"}, "authentication failed")
+		}},
+		{"Me", func(t *testing.T, e *env, s *graphql.Schema) {
+			data := query(t, s, as(t, e, s, "bob"), `{ me { uid department } }`, nil)
+			me := data["me"].(map[string]interface{})
+			equal(t, "uid", me["uid"], interface{}("bob"))
+			equal(t, "department", me["department"], interface{}("Platform"))
+
+			denied(t, s, context.Background(), `{ me { uid } }`, nil, "authentication required")
+		}},
+		{"User", func(t *testing.T, e *env, s *graphql.Schema) {
+			lookup := `query($uid: String!) { user(uid: $uid) { uid mail } }`
+			data := query(t, s, as(t, e, s, "alice"), lookup, map[string]interface{}{"uid": "alice"})
+			equal(t, "mail", data["user"].(map[string]interface{})["mail"], interface{}("alice@devplatform.local"))
+
+			denied(t, s, as(t, e, s, "alice"), lookup, map[string]interface{}{"uid": "carol"}, "not authorized")
+			data = query(t, s, as(t, e, s, "bob"), lookup, map[string]interface{}{"uid": "carol"})
+			equal(t, "uid", data["user"].(map[string]interface{})["uid"], interface{}("carol"))
+		}},
+		{"UsersConnection", func(t *testing.T, e *env, s *graphql.Schema) {
+			page := `query($after: String) { usersConnection(first: 2, after: $after) { edges { node { uid } } pageInfo { hasNextPage endCursor } } }`
+			var got []string
+			var after interface{}
+			for {
+				data := query(t, s, as(t, e, s, "bob"), page, map[string]interface{}{"after": after})
+				conn := data["usersConnection"].(map[string]interface{})
+				for _, edge := range conn["edges"].([]interface{}) {
+					got = append(got, edge.(map[string]interface{})["node"].(map[string]interface{})["uid"].(string))
+				}
+				info := conn["pageInfo"].(map[string]interface{})
+				if !info["hasNextPage"].(bool) {
+					break
+				}
+				after = info["endCursor"]
+			}
+			equal(t, "uids", got, []string{"alice", "bob", "carol"})
+
+			denied(t, s, as(t, e, s, "alice"), page, nil, "not authorized")
+		}},
+		{"CreateUser", func(t *testing.T, e *env, s *graphql.Schema) {
+			create := `mutation($input: CreateUserInput!) { createUser(input: $input) { uid department } }`
+			input := map[string]interface{}{
+				"uid": "dave", "cn": "Dave Dunn", "sn": "Dunn", "givenName": "Dave",
+				"mail": "dave@devplatform.local", "department": "Sales", "password": newPassword,
+			}
+			vars := map[string]interface{}{"input": input}
+
+			denied(t, s, as(t, e, s, "alice"), create, vars, "not authorized")
+			data := query(t, s, as(t, e, s, "bob"), create, vars)
+			equal(t, "department", data["createUser"].(map[string]interface{})["department"], interface{}("Sales"))
+			equal(t, "stored uid", result(e.dir.GetUser(context.Background(), "dave")).must(t).UID, "dave")
+		}},
+		{"ImportUsers", func(t *testing.T, e *env, s *graphql.Schema) {
+			importUsers := `mutation($csv: String!, $dryRun: Boolean) { importUsers(csv: $csv, dryRun: $dryRun) { created valid skipped failed rows { uid status initialPassword } } }`
+			csv := "uid,givenName,sn,mail,department,groups\n" +
+				"dave,Dave,Dunn,dave@devplatform.local,Sales,sales\n" +
+				"alice,Alice,Archer,alice@devplatform.local,Engineering,\n" +
+				"erin,Erin,Evans,erin@devplatform.local,Marketing,\n"
+			counts := func(report map[string]interface{}) []interface{} {
+				return []interface{}{report["created"], report["valid"], report["skipped"], report["failed"]}
+			}
+			statuses := func(report map[string]interface{}) []string {
+				var out []string
+				for _, row := range report["rows"].([]interface{}) {
+					out = append(out, row.(map[string]interface{})["status"].(string))
+				}
+				return out
+			}
+
+			data := query(t, s, as(t, e, s, "bob"), importUsers, map[string]interface{}{"csv": csv, "dryRun": true})
+			report := data["importUsers"].(map[string]interface{})
+			equal(t, "dry run counts", counts(report), []interface{}{0, 1, 1, 1})
+			equal(t, "dry run statuses", statuses(report), []string{models.UserImportValid, models.UserImportSkipped, models.UserImportError})
+			if _, err := e.dir.GetUser(context.Background(), "dave"); err == nil {
+				t.Fatal("dry run created a user")
+			}
+
+			data = query(t, s, as(t, e, s, "bob"), importUsers, map[string]interface{}{"csv": csv})
+			report = data["importUsers"].(map[string]interface{})
+			equal(t, "counts", counts(report), []interface{}{1, 0, 1, 1})
+			equal(t, "statuses", statuses(report), []string{models.UserImportSuccess, models.UserImportSkipped, models.UserImportError})
+			if report["rows"].([]interface{})[0].(map[string]interface{})["initialPassword"] == nil {
+				t.Fatal("created user has no initial password")
+			}
+			equal(t, "groups", cns(result(e.dir.GetUserGroups(context.Background(), "dave")).must(t)), []string{"sales"})
+
+			// Rows are only started within the time limit
+			e.cfg.UserImportTimeout = time.Nanosecond
+			denied(t, s, as(t, e, s, "bob"), importUsers, map[string]interface{}{"csv": csv}, "import fewer rows at once")
+		}},
+		{"Groups", func(t *testing.T, e *env, s *graphql.Schema) {
+			data := query(t, s, as(t, e, s, "carol"), `{ group(cn: "developers") { cn members } }`, nil)
+			group := data["group"].(map[string]interface{})
+			equal(t, "members", group["members"], interface{}([]interface{}{"alice"}))
+		}},
+		{"PrincipalCacheInvalidation", func(t *testing.T, e *env, s *graphql.Schema) {
+			isAdmin := func(uid string) bool {
+				t.Helper()
+				principal, _ := authz.FromContext(as(t, e, s, uid))
+				return principal.HasRole(authz.RoleAdmin)
+			}
+			admin := as(t, e, s, "bob")
+			membership := map[string]interface{}{"uid": "carol", "groupCn": "admins"}
+
+			equal(t, "carol before", isAdmin("carol"), false)
+			query(t, s, admin, `mutation($uid: String!, $groupCn: String!) { addUserToGroup(uid: $uid, groupCn: $groupCn) }`, membership)
+			equal(t, "carol added", isAdmin("carol"), true)
+			query(t, s, admin, `mutation($uid: String!, $groupCn: String!) { removeUserFromGroup(uid: $uid, groupCn: $groupCn) }`, membership)
+			equal(t, "carol removed", isAdmin("carol"), false)
+
+			equal(t, "alice before", isAdmin("alice"), false)
+			query(t, s, admin, `mutation { addGroupToGroup(groupCn: "developers", parentCn: "admins") }`, nil)
+			equal(t, "alice nested", isAdmin("alice"), true)
+			query(t, s, admin, `mutation { setGroupMembers(cn: "developers", members: []) { cn } }`, nil)
+			equal(t, "alice cleared", isAdmin("alice"), false)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, s := newResolvers(t)
+			tt.run(t, e, s)
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+var errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+type claimsKey struct{}
+
+// WithClaims returns a copy of ctx carrying the validated access token claims
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+func claimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
+
+func (s *Schema) defineSessionType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Session",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.String},
+			"clientIp":   &graphql.Field{Type: graphql.String},
+			"userAgent":  &graphql.Field{Type: graphql.String},
+			"createdAt":  &graphql.Field{Type: graphql.DateTime},
+			"lastUsedAt": &graphql.Field{Type: graphql.DateTime},
+			"expiresAt":  &graphql.Field{Type: graphql.DateTime},
+			"current":    &graphql.Field{Type: graphql.Boolean},
+		},
+	})
+}
+
+// issueSession starts a new session for user and returns its first token pair
+func (s *Schema) issueSession(ctx context.Context, user *models.User) (*models.AuthPayload, error) {
+	sessionID, err := session.NewID()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshHash, err := session.NewRefreshToken(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	token, jti, expiresAt, err := s.generateJWT(user, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	client := session.ClientFromContext(ctx)
+	now := time.Now()
+	sess := &session.Session{
+		ID:          sessionID,
+		UID:         user.UID,
+		RefreshHash: refreshHash,
+		AccessJTI:   jti,
+		AccessExp:   expiresAt,
+		ClientIP:    client.IP,
+		UserAgent:   client.UserAgent,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(s.config.JWTRefreshExpiration),
+	}
+	if err := s.sessions.Create(ctx, sess); err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return &models.AuthPayload{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+		User:         user,
+	}, nil
+}
+
+// revokeSession ends a session and blocks its outstanding access token
+func (s *Schema) revokeSession(ctx context.Context, sess *session.Session) error {
+	if err := s.sessions.Revoke(ctx, sess.AccessJTI, sess.AccessExp); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if err := s.sessions.Delete(ctx, sess.ID); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every session of uid and returns how many were ended
+func (s *Schema) RevokeUserSessions(ctx context.Context, uid string) (int, error) {
+	sessions, err := s.sessions.ListByUser(ctx, uid)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for _, sess := range sessions {
+		if err := s.revokeSession(ctx, sess); err != nil {
+			return 0, err
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"uid":      uid,
+		"sessions": len(sessions),
+	}).Info("User sessions revoked")
+	return len(sessions), nil
+}
+
+func (s *Schema) resolveRefreshToken(p graphql.ResolveParams) (interface{}, error) {
+	sessionID, secret, err := session.ParseRefreshToken(p.Args["refreshToken"].(string))
+	if err != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	sess, err := s.sessions.Get(p.Context, sessionID)
+	if err != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	if !sess.MatchesRefresh(secret) {
+		// A rotated-out token was presented again, so it has leaked: end the session
+		s.logger.WithFields(logrus.Fields{
+			"uid":     sess.UID,
+			"session": sess.ID,
+		}).Warn("Refresh token reuse detected, revoking session")
+		if err := s.revokeSession(p.Context, sess); err != nil {
+			s.logger.WithError(err).Error("Failed to revoke session")
+		}
+		return nil, errInvalidRefreshToken
+	}
+
+	// The account may have been removed or disabled since the session started
+	user, err := s.dir.GetUser(directory.WithProvider(p.Context), sess.UID)
+	if err == nil && user.Status != models.UserStatusActive {
+		err = fmt.Errorf("account is disabled")
+	}
+	if err != nil {
+		if err := s.revokeSession(p.Context, sess); err != nil {
+			s.logger.WithError(err).Error("Failed to revoke session")
+		}
+		return nil, errInvalidRefreshToken
+	}
+
+	refreshToken, refreshHash, err := session.NewRefreshToken(sess.ID)
+	if err != nil {
+		return nil, err
+	}
+	token, jti, expiresAt, err := s.generateJWT(user, sess.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	if err := s.sessions.Revoke(p.Context, sess.AccessJTI, sess.AccessExp); err != nil {
+		return nil, fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	client := session.ClientFromContext(p.Context)
+	read := *sess
+	sess.RefreshHash = refreshHash
+	sess.AccessJTI = jti
+	sess.AccessExp = expiresAt
+	sess.ClientIP = client.IP
+	sess.UserAgent = client.UserAgent
+	sess.LastUsedAt = time.Now()
+	// A concurrent refresh with the same token rotated it first
+	if err := s.sessions.Update(p.Context, sess, &read); err != nil {
+		if !errors.Is(err, session.ErrConflict) {
+			s.logger.WithError(err).Error("Failed to update session")
+		}
+		return nil, errInvalidRefreshToken
+	}
+
+	return &models.AuthPayload{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+		User:         user,
+	}, nil
+}
+
+func (s *Schema) resolveLogout(p graphql.ResolveParams) (interface{}, error) {
+	claims, ok := claimsFromContext(p.Context)
+	if !ok {
+		return nil, authz.ErrUnauthenticated
+	}
+
+	if claims.ExpiresAt != nil {
+		if err := s.sessions.Revoke(p.Context, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return nil, fmt.Errorf("failed to revoke token: %w", err)
+		}
+	}
+
+	sess, err := s.sessions.Get(p.Context, claims.SessionID)
+	if errors.Is(err, session.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.revokeSession(p.Context, sess); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+func (s *Schema) resolveLogoutAllSessions(p graphql.ResolveParams) (interface{}, error) {
+	principal, ok := authz.FromContext(p.Context)
+	if !ok {
+		return nil, authz.ErrUnauthenticated
+	}
+	return s.RevokeUserSessions(p.Context, principal.UID)
+}
+
+func (s *Schema) resolveMySessions(p graphql.ResolveParams) (interface{}, error) {
+	principal, ok := authz.FromContext(p.Context)
+	if !ok {
+		return nil, authz.ErrUnauthenticated
+	}
+
+	sessions, err := s.sessions.ListByUser(p.Context, principal.UID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	currentID := ""
+	if claims, ok := claimsFromContext(p.Context); ok {
+		currentID = claims.SessionID
+	}
+
+	result := make([]*models.Session, 0, len(sessions))
+	for _, sess := range sessions {
+		result = append(result, &models.Session{
+			ID:         sess.ID,
+			ClientIP:   sess.ClientIP,
+			UserAgent:  sess.UserAgent,
+			CreatedAt:  sess.CreatedAt,
+			LastUsedAt: sess.LastUsedAt,
+			ExpiresAt:  sess.ExpiresAt,
+			Current:    sess.ID == currentID,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,420 @@
+package graphql
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+// CSV columns of a user import. cn defaults to "givenName sn"; groups and
+// repositories take several values separated by ";".
+var (
+	userImportColumns         = []string{"uid", "givenName", "sn", "cn", "mail", "department", "groups", "repositories"}
+	userImportRequiredColumns = []string{"uid", "givenName", "sn", "mail", "department"}
+)
+
+// minGeneratedPasswordLength applies when the policy asks for less
+const minGeneratedPasswordLength = 16
+
+// userImport is a CSV row on its way into the directory
+type userImport struct {
+	result *models.UserImportRow
+	input  *models.CreateUserInput
+	groups []string
+}
+
+func (s *Schema) defineUserImportReportType() *graphql.Object {
+	rowType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserImportRow",
+		Fields: graphql.Fields{
+			"line":            &graphql.Field{Type: graphql.Int},
+			"uid":             &graphql.Field{Type: graphql.String},
+			"status":          &graphql.Field{Type: graphql.String},
+			"reason":          &graphql.Field{Type: graphql.String},
+			"initialPassword": &graphql.Field{Type: graphql.String},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserImportReport",
+		Fields: graphql.Fields{
+			"dryRun":  &graphql.Field{Type: graphql.Boolean},
+			"created": &graphql.Field{Type: graphql.Int},
+			"valid":   &graphql.Field{Type: graphql.Int},
+			"skipped": &graphql.Field{Type: graphql.Int},
+			"failed":  &graphql.Field{Type: graphql.Int},
+			"rows":    &graphql.Field{Type: graphql.NewList(rowType)},
+		},
+	})
+}
+
+// resolveImportUsers creates a user for every valid CSV row. Rows of users
+// that exist are skipped, so a partly failed import can be run again.
+// Users are only started within USER_IMPORT_TIMEOUT, since the report holds
+// the only copy of their initial passwords and must reach the client.
+func (s *Schema) resolveImportUsers(p graphql.ResolveParams) (interface{}, error) {
+	dryRun, _ := p.Args["dryRun"].(bool)
+
+	rows, err := s.parseUserImport(p.Args["csv"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(p.Context, s.config.UserImportTimeout)
+	defer cancel()
+	if err := s.checkUserImport(ctx, rows); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("checking the rows took longer than %s, import fewer rows at once", s.config.UserImportTimeout)
+		}
+		return nil, err
+	}
+	if !dryRun {
+		s.createImportedUsers(ctx, rows)
+	}
+
+	report := &models.UserImportReport{DryRun: dryRun, Rows: make([]*models.UserImportRow, 0, len(rows))}
+	for _, row := range rows {
+		if row.result.Status == "" {
+			row.result.Status = models.UserImportSuccess
+			if dryRun {
+				row.result.Status = models.UserImportValid
+			}
+		}
+		switch row.result.Status {
+		case models.UserImportSuccess:
+			report.Created++
+		case models.UserImportValid:
+			report.Valid++
+		case models.UserImportSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+		report.Rows = append(report.Rows, row.result)
+	}
+
+	admin := ""
+	if principal, ok := authz.FromContext(p.Context); ok {
+		admin = principal.UID
+	}
+	s.logger.WithFields(logrus.Fields{
+		"dryRun":  dryRun,
+		"created": report.Created,
+		"valid":   report.Valid,
+		"skipped": report.Skipped,
+		"failed":  report.Failed,
+		"admin":   admin,
+	}).Info("Users imported")
+	return report, nil
+}
+
+// parseUserImport reads the header and rows of a user import. Errors in the
+// header fail the import; errors in a row only fail that row.
+func (s *Schema) parseUserImport(data string) ([]*userImport, error) {
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV is empty, expected a header with the columns %s", strings.Join(userImportColumns, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		known := ""
+		for _, column := range userImportColumns {
+			if strings.EqualFold(name, column) {
+				known = column
+			}
+		}
+		if known == "" {
+			return nil, fmt.Errorf("unknown CSV column %q, expected %s", name, strings.Join(userImportColumns, ", "))
+		}
+		if _, ok := columns[known]; ok {
+			return nil, fmt.Errorf("CSV column %s is given twice", known)
+		}
+		columns[known] = i
+	}
+	for _, column := range userImportRequiredColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("CSV column %s is missing", column)
+		}
+	}
+
+	var rows []*userImport
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		row := &userImport{result: &models.UserImportRow{}}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			row.result.Line = parseErr.StartLine
+			row.result.Status = models.UserImportError
+			row.result.Reason = parseErr.Err.Error()
+			rows = append(rows, row)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		row.result.Line, _ = reader.FieldPos(0)
+		field := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row.input = &models.CreateUserInput{
+			UID:          strings.ToLower(field("uid")),
+			GivenName:    field("givenName"),
+			SN:           field("sn"),
+			CN:           field("cn"),
+			Mail:         field("mail"),
+			Department:   field("department"),
+			Repositories: splitList(field("repositories")),
+		}
+		if row.input.CN == "" {
+			row.input.CN = strings.TrimSpace(row.input.GivenName + " " + row.input.SN)
+		}
+		row.groups = splitList(field("groups"))
+		row.result.UID = row.input.UID
+
+		if len(record) != len(header) {
+			row.result.Status = models.UserImportError
+			row.result.Reason = fmt.Sprintf("row has %d fields, the header %d", len(record), len(header))
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) > s.config.UserImportMaxRows {
+		return nil, fmt.Errorf("CSV has %d rows, at most %d can be imported at once", len(rows), s.config.UserImportMaxRows)
+	}
+	return rows, nil
+}
+
+// splitList splits a ";" separated CSV field
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// checkUserImport marks the rows that cannot be created as failed, and those
+// of existing users as skipped. Uids and addresses must also be unique
+// within the file.
+func (s *Schema) checkUserImport(ctx context.Context, rows []*userImport) error {
+	deleted, err := s.dir.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusDeleted})
+	if err != nil {
+		return fmt.Errorf("failed to list deleted users: %w", err)
+	}
+	deletedUIDs := make(map[string]bool, len(deleted))
+	for _, user := range deleted {
+		deletedUIDs[strings.ToLower(user.UID)] = true
+	}
+
+	departments := make(map[string]bool)
+	groups := make(map[string]bool)
+	exists := func(known map[string]bool, name string, get func() error) bool {
+		key := strings.ToLower(name)
+		if _, ok := known[key]; !ok {
+			known[key] = get() == nil
+		}
+		return known[key]
+	}
+
+	uidLines := make(map[string]int)
+	mailLines := make(map[string]int)
+	for _, row := range rows {
+		if row.result.Status != "" {
+			continue
+		}
+		input := row.input
+		reason := ""
+		status := models.UserImportError
+
+		switch {
+		case !usernamePattern.MatchString(input.UID):
+			reason = "uid must start with a letter and contain only lowercase letters, digits, '.', '_' or '-'"
+		case uidLines[input.UID] > 0:
+			reason = fmt.Sprintf("uid %s is already on line %d", input.UID, uidLines[input.UID])
+		case input.GivenName == "" || input.SN == "":
+			reason = "givenName and sn are required"
+		case !strings.Contains(input.Mail, "@"):
+			reason = "a valid mail address is required"
+		case mailLines[strings.ToLower(input.Mail)] > 0:
+			reason = fmt.Sprintf("mail %s is already on line %d", input.Mail, mailLines[strings.ToLower(input.Mail)])
+		case input.Department == "":
+			reason = "department is required"
+		}
+		if reason == "" {
+			uidLines[input.UID] = row.result.Line
+			mailLines[strings.ToLower(input.Mail)] = row.result.Line
+		}
+
+		if reason == "" {
+			if _, err := s.dir.GetUser(ctx, input.UID); err == nil {
+				status, reason = models.UserImportSkipped, "user already exists"
+			} else if deletedUIDs[input.UID] {
+				reason = fmt.Sprintf("uid %s belongs to a deleted user; restore or purge it first", input.UID)
+			} else if user, err := s.dir.FindUserByMail(ctx, input.Mail); err == nil {
+				reason = fmt.Sprintf("mail %s is already used by %s", input.Mail, user.UID)
+			} else if !exists(departments, input.Department, func() error {
+				_, err := s.dir.GetDepartment(ctx, input.Department)
+				return err
+			}) {
+				reason = fmt.Sprintf("unknown department %q", input.Department)
+			}
+		}
+		for _, cn := range row.groups {
+			if reason != "" {
+				break
+			}
+			if !exists(groups, cn, func() error {
+				_, err := s.dir.GetGroup(ctx, cn)
+				return err
+			}) {
+				reason = fmt.Sprintf("unknown group %q", cn)
+			}
+		}
+
+		if reason != "" {
+			row.result.Status = status
+			row.result.Reason = reason
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createImportedUsers creates the users of the rows that passed the checks,
+// UserImportConcurrency at a time. Rows not started before ctx ends fail.
+func (s *Schema) createImportedUsers(ctx context.Context, rows []*userImport) {
+	concurrency := s.config.UserImportConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for _, row := range rows {
+		if row.result.Status != "" {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(row *userImport) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			s.createImportedUser(ctx, row)
+		}(row)
+	}
+	wg.Wait()
+}
+
+// createImportedUser creates the user of row with a generated password and
+// adds it to its groups. A user whose groups could not all be joined still
+// exists, so its password is reported along with the error.
+func (s *Schema) createImportedUser(ctx context.Context, row *userImport) {
+	fail := func(reason string) {
+		row.result.Status = models.UserImportError
+		row.result.Reason = reason
+	}
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			fail(fmt.Sprintf("not created within %s, import the file again to create the remaining users", s.config.UserImportTimeout))
+		} else {
+			fail(err.Error())
+		}
+		return
+	}
+	// A started user is finished, so its password makes it into the report
+	ctx = context.WithoutCancel(ctx)
+
+	plain, hash, err := s.generateInitialPassword(row.input)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	row.input.PasswordHash = hash
+
+	if _, err := s.dir.CreateUser(ctx, row.input); err != nil {
+		fail(err.Error())
+		return
+	}
+	row.result.InitialPassword = plain
+
+	var failed []string
+	for _, cn := range row.groups {
+		if err := s.dir.AddUserToGroup(ctx, row.input.UID, cn); err != nil {
+			s.logger.WithError(err).WithFields(logrus.Fields{
+				"uid":   row.input.UID,
+				"group": cn,
+			}).Error("Failed to add imported user to group")
+			failed = append(failed, cn)
+		}
+	}
+	if len(failed) > 0 {
+		fail(fmt.Sprintf("user created, but adding it to %s failed", strings.Join(failed, ", ")))
+	}
+}
+
+// generateInitialPassword returns a random password that satisfies the
+// policy for the user of input, and its hash
+func (s *Schema) generateInitialPassword(input *models.CreateUserInput) (string, string, error) {
+	length := s.config.PasswordMinLength
+	if length < minGeneratedPasswordLength {
+		length = minGeneratedPasswordLength
+	}
+	subject := password.Subject{
+		UID:       input.UID,
+		CN:        input.CN,
+		SN:        input.SN,
+		GivenName: input.GivenName,
+		Mail:      input.Mail,
+	}
+
+	// A random password rarely contains a banned word or part of the name;
+	// another one is drawn when it does
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		var plain, hash string
+		if plain, err = password.Generate(length); err != nil {
+			return "", "", err
+		}
+		if hash, err = s.dir.PreparePassword(plain, subject); err == nil {
+			return plain, hash, nil
+		}
+		var policyErr *password.PolicyError
+		if !errors.As(err, &policyErr) {
+			break
+		}
+	}
+	return "", "", fmt.Errorf("failed to generate a password: %w", err)
+}
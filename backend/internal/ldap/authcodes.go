@@ -0,0 +1,53 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// codesContainer is the container below StateDN() holding OIDC authorization codes
+const codesContainer = "codes"
+
+// AuthCodeStore keeps OIDC authorization codes in the directory, so the token
+// request may reach another replica than the login. It satisfies oidc.CodeStore.
+type AuthCodeStore struct {
+	m *Manager
+}
+
+// AuthCodes returns the LDAP-backed authorization code store
+func (m *Manager) AuthCodes() *AuthCodeStore {
+	return &AuthCodeStore{m: m}
+}
+
+// Put stores a code under the hash of its value
+func (s *AuthCodeStore) Put(ctx context.Context, hash string, code *models.AuthCode) error {
+	data, err := encodeState(code)
+	if err != nil {
+		return err
+	}
+	return s.m.addState(ctx, codesContainer, &stateEntry{key: hash, uid: code.UID, expiresAt: code.ExpiresAt, data: data})
+}
+
+// Take returns and removes a live code. Of concurrent requests for the same
+// code only the one whose delete succeeds gets it.
+func (s *AuthCodeStore) Take(ctx context.Context, hash string) (*models.AuthCode, error) {
+	e, err := s.m.readState(ctx, codesContainer, hash)
+	if errors.Is(err, errStateNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.m.deleteState(ctx, codesContainer, hash)
+	if err != nil || !deleted {
+		return nil, err
+	}
+	var code models.AuthCode
+	if err := e.decode(&code); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
@@ -0,0 +1,44 @@
+package ldap
+
+import (
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// changeSet records the modifications of an operation that touches several
+// entries, so they can be reported and undone if a later step fails
+type changeSet struct {
+	undo    []*ldap.ModifyRequest
+	summary models.DeletionSummary
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{summary: models.DeletionSummary{
+		Deleted:  []string{},
+		Modified: []*models.EntryChange{},
+	}}
+}
+
+// modify applies req and remembers undo and a description of the change
+func (c *changeSet) modify(conn *ldap.Conn, req, undo *ldap.ModifyRequest, change string) error {
+	if len(req.Changes) == 0 {
+		return nil
+	}
+	if err := conn.Modify(req); err != nil {
+		return err
+	}
+	c.undo = append(c.undo, undo)
+	c.summary.Modified = append(c.summary.Modified, &models.EntryChange{DN: req.DN, Change: change})
+	return nil
+}
+
+// rollback reverts the changes recorded so far, newest first. LDAP has no
+// transactions across entries, so failures are logged and the rest still undone.
+func (m *Manager) rollback(conn *ldap.Conn, changes *changeSet) {
+	for i := len(changes.undo) - 1; i >= 0; i-- {
+		if err := conn.Modify(changes.undo[i]); err != nil {
+			m.logger.WithError(err).WithField("dn", changes.undo[i].DN).Error("Failed to roll back change")
+		}
+	}
+	changes.undo = nil
+}
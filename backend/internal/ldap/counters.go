@@ -0,0 +1,92 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	// countersContainer is the container below StateDN() holding login failure counters
+	countersContainer = "counters"
+	// counterRetries bounds the attempts of an update racing other replicas
+	counterRetries = 5
+)
+
+// LoginCounterStore keeps login failure counters in the directory, so all
+// replicas count against the same limits. It satisfies lockout.CounterStore.
+type LoginCounterStore struct {
+	m *Manager
+}
+
+// LoginCounters returns the LDAP-backed failure counter store
+func (m *Manager) LoginCounters() *LoginCounterStore {
+	return &LoginCounterStore{m: m}
+}
+
+// Get returns the live counter of key, or nil
+func (s *LoginCounterStore) Get(ctx context.Context, key string) (*lockout.Counter, error) {
+	e, err := s.m.readState(ctx, countersContainer, key)
+	if errors.Is(err, errStateNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c lockout.Counter
+	if err := e.decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Update applies fn to the counter of key. The stored document is swapped
+// in one modify that deletes the exact value read, and fn is applied again
+// when another replica got there first.
+func (s *LoginCounterStore) Update(ctx context.Context, key string, fn func(*lockout.Counter)) (*lockout.Counter, error) {
+	for attempt := 0; attempt < counterRetries; attempt++ {
+		current, err := s.m.lookupState(ctx, countersContainer, key)
+		if err != nil && !errors.Is(err, errStateNotFound) {
+			return nil, err
+		}
+
+		c := &lockout.Counter{}
+		if current != nil && !current.expired(time.Now()) {
+			if err := current.decode(c); err != nil {
+				return nil, err
+			}
+		}
+		fn(c)
+		data, err := encodeState(c)
+		if err != nil {
+			return nil, err
+		}
+
+		e := &stateEntry{key: key, expiresAt: c.ExpiresAt, data: data}
+		if current == nil {
+			err = s.m.addState(ctx, countersContainer, e)
+		} else {
+			err = s.m.replaceState(ctx, countersContainer, e, current.data)
+		}
+		var ldapErr *ldap.Error
+		if errors.As(err, &ldapErr) && (ldapErr.ResultCode == ldap.LDAPResultEntryAlreadyExists ||
+			ldapErr.ResultCode == ldap.LDAPResultNoSuchAttribute || ldapErr.ResultCode == ldap.LDAPResultNoSuchObject) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("counter %s kept changing, gave up after %d attempts", key, counterRetries)
+}
+
+// Delete forgets the counter of key
+func (s *LoginCounterStore) Delete(ctx context.Context, key string) error {
+	_, err := s.m.deleteState(ctx, countersContainer, key)
+	return err
+}
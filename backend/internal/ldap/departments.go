@@ -0,0 +1,372 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Departments nest as organizational units below DepartmentsDN(), e.g.
+// ou=SRE,ou=Platform,ou=Engineering,ou=departments. Users reference their
+// department by ou alone, so department names are unique across the tree and
+// moving a subtree leaves users untouched.
+
+// departmentAttributes are the attributes read for every department entry
+var departmentAttributes = []string{"ou", "description", "manager", "githubRepository"}
+
+// readDepartment finds a department anywhere in the tree
+func (m *Manager) readDepartment(conn *ldap.Conn, ou string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentsDN(),
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&(objectClass=organizationalUnit)(ou=%s))", ldap.EscapeFilter(ou)),
+		departmentAttributes,
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	for _, entry := range result.Entries {
+		// The base entry itself is not a department
+		if normalizeDN(entry.DN) != normalizeDN(m.config.DepartmentsDN()) {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("department not found: %s", ou)
+}
+
+// searchDepartments returns the departments below baseDN, excluding baseDN itself
+func (m *Manager) searchDepartments(conn *ldap.Conn, baseDN string, scope int) ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		scope,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=organizationalUnit)",
+		departmentAttributes,
+		nil,
+	)
+
+	var entries []*ldap.Entry
+	base := normalizeDN(baseDN)
+	err := m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		if normalizeDN(entry.DN) != base {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	return entries, err
+}
+
+// searchDepartmentsByFilter returns the departments anywhere in the tree matching filter
+func (m *Manager) searchDepartmentsByFilter(conn *ldap.Conn, filter string) ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentsDN(),
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&(objectClass=organizationalUnit)%s)", filter),
+		departmentAttributes,
+		nil,
+	)
+
+	var entries []*ldap.Entry
+	err := m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	return entries, err
+}
+
+// departmentPath returns the OUs from the top-level department down to dn
+func (m *Manager) departmentPath(dn string) []string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil
+	}
+	base, err := ldap.ParseDN(m.config.DepartmentsDN())
+	if err != nil || len(parsed.RDNs) <= len(base.RDNs) {
+		return nil
+	}
+
+	rdns := parsed.RDNs[:len(parsed.RDNs)-len(base.RDNs)]
+	path := make([]string, 0, len(rdns))
+	for i := len(rdns) - 1; i >= 0; i-- {
+		if len(rdns[i].Attributes) > 0 {
+			path = append(path, rdns[i].Attributes[0].Value)
+		}
+	}
+	return path
+}
+
+// GetDepartmentChildren returns the departments directly below a department
+func (m *Manager) GetDepartmentChildren(ctx context.Context, ou string) ([]*models.Department, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readDepartment(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.searchDepartments(conn, entry.DN, ldap.ScopeSingleLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make([]*models.Department, 0, len(entries))
+	for _, child := range entries {
+		children = append(children, m.entryToDepartment(child))
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return strings.ToLower(children[i].OU) < strings.ToLower(children[j].OU)
+	})
+	return children, nil
+}
+
+// GetDepartmentAncestors returns the departments above a department, top-level first
+func (m *Manager) GetDepartmentAncestors(ctx context.Context, ou string) ([]*models.Department, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readDepartment(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every ancestor DN is a suffix of the department's own DN
+	parsed, err := ldap.ParseDN(entry.DN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid department DN %q: %w", entry.DN, err)
+	}
+	path := m.departmentPath(entry.DN)
+	ancestors := make([]*models.Department, 0, len(path))
+	for depth := 1; depth < len(path); depth++ {
+		ancestorDN := &ldap.DN{RDNs: parsed.RDNs[len(path)-depth:]}
+		searchRequest := ldap.NewSearchRequest(
+			ancestorDN.String(),
+			ldap.ScopeBaseObject,
+			ldap.NeverDerefAliases,
+			0,
+			0,
+			false,
+			"(objectClass=organizationalUnit)",
+			departmentAttributes,
+			nil,
+		)
+		result, err := conn.Search(searchRequest)
+		if err != nil {
+			return nil, fmt.Errorf("search failed: %w", err)
+		}
+		if len(result.Entries) > 0 {
+			ancestors = append(ancestors, m.entryToDepartment(result.Entries[0]))
+		}
+	}
+	return ancestors, nil
+}
+
+// GetDepartmentTree returns every department, each parent before its
+// children and siblings ordered by name
+func (m *Manager) GetDepartmentTree(ctx context.Context) ([]*models.Department, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entries, err := m.searchDepartments(conn, m.config.DepartmentsDN(), ldap.ScopeWholeSubtree)
+	if err != nil {
+		return nil, err
+	}
+
+	departments := make([]*models.Department, 0, len(entries))
+	byOU := make(map[string]*models.Department, len(entries))
+	for _, entry := range entries {
+		dept := m.entryToDepartment(entry)
+		departments = append(departments, dept)
+		byOU[strings.ToLower(dept.OU)] = dept
+	}
+	sort.Slice(departments, func(i, j int) bool {
+		return comparePaths(departments[i].Path, departments[j].Path) < 0
+	})
+
+	// One pass over the users fills in the members of every department
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(&(objectClass=inetOrgPerson)(departmentNumber=*))",
+		[]string{"uid", "departmentNumber"},
+		nil,
+	)
+	err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		if dept, ok := byOU[strings.ToLower(entry.GetAttributeValue("departmentNumber"))]; ok {
+			dept.Members = append(dept.Members, entry.GetAttributeValue("uid"))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return departments, nil
+}
+
+// comparePaths orders department paths depth-first
+func comparePaths(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(strings.ToLower(a[i]), strings.ToLower(b[i])); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// MoveDepartment moves a department and everything below it under a new
+// parent, or to the top level when parent is empty
+func (m *Manager) MoveDepartment(ctx context.Context, ou, parent string) (*models.Department, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readDepartment(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+
+	newSuperior := m.config.DepartmentsDN()
+	if parent != "" {
+		parentEntry, err := m.readDepartment(conn, parent)
+		if err != nil {
+			return nil, err
+		}
+		if containsFold(m.departmentPath(parentEntry.DN), ou) {
+			return nil, fmt.Errorf("cannot move department %s below itself", ou)
+		}
+		newSuperior = parentEntry.DN
+	}
+
+	parsed, err := ldap.ParseDN(entry.DN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid department DN %q: %w", entry.DN, err)
+	}
+	current := &ldap.DN{RDNs: parsed.RDNs[1:]}
+	if target, err := ldap.ParseDN(newSuperior); err == nil && current.EqualFold(target) {
+		return m.entryToDepartment(entry), nil
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"ou":     ou,
+		"parent": parent,
+	}).Info("Moving department")
+
+	rdn := "ou=" + ldap.EscapeDN(entry.GetAttributeValue("ou"))
+	if err := conn.ModifyDN(ldap.NewModifyDNRequest(entry.DN, rdn, true, newSuperior)); err != nil {
+		m.logger.WithError(err).Error("Failed to move department")
+		return nil, fmt.Errorf("failed to move department: %w", err)
+	}
+
+	m.logger.WithField("ou", ou).Info("Department moved successfully")
+	return m.GetDepartment(withProvider(ctx), ou)
+}
+
+// UpdateDepartment changes the description and manager of a department
+func (m *Manager) UpdateDepartment(ctx context.Context, input *models.UpdateDepartmentInput) (*models.Department, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readDepartment(conn, input.OU)
+	if err != nil {
+		return nil, err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	if input.Description != nil {
+		if *input.Description == "" {
+			modifyRequest.Replace("description", []string{})
+		} else {
+			modifyRequest.Replace("description", []string{*input.Description})
+		}
+	}
+	if input.Manager != nil {
+		if *input.Manager == "" {
+			modifyRequest.Replace("manager", []string{})
+		} else {
+			if err := m.requireUser(conn, *input.Manager); err != nil {
+				return nil, fmt.Errorf("invalid manager: %w", err)
+			}
+			modifyRequest.Replace("manager", []string{m.config.UserDN(*input.Manager)})
+		}
+	}
+
+	if len(modifyRequest.Changes) > 0 {
+		m.logger.WithField("ou", input.OU).Info("Updating department")
+
+		if err := conn.Modify(modifyRequest); err != nil {
+			m.logger.WithError(err).Error("Failed to update department")
+			return nil, fmt.Errorf("failed to update department: %w", err)
+		}
+
+		m.logger.WithField("ou", input.OU).Info("Department updated successfully")
+	}
+	return m.GetDepartment(withProvider(ctx), input.OU)
+}
+
+// departmentSubtree returns the OU of a department and of every department below it
+func (m *Manager) departmentSubtree(conn *ldap.Conn, ou string) ([]string, error) {
+	entry, err := m.readDepartment(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := m.searchDepartments(conn, entry.DN, ldap.ScopeWholeSubtree)
+	if err != nil {
+		return nil, err
+	}
+
+	ous := []string{entry.GetAttributeValue("ou")}
+	for _, descendant := range entries {
+		ous = append(ous, descendant.GetAttributeValue("ou"))
+	}
+	return ous, nil
+}
+
+// userSearchFilter builds the filter of a users search, widening the
+// department filter to sub-departments when asked to
+func (m *Manager) userSearchFilter(conn *ldap.Conn, filter *models.SearchFilter) (string, error) {
+	if filter == nil || filter.Department == "" || !filter.IncludeDescendants {
+		return userFilter(filter, nil), nil
+	}
+	departments, err := m.departmentSubtree(conn, filter.Department)
+	if err != nil {
+		return "", err
+	}
+	return userFilter(filter, departments), nil
+}
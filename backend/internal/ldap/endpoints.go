@@ -0,0 +1,237 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/authz"
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// endpoint is one directory server with its own connection pool
+type endpoint struct {
+	url       string
+	role      string
+	tlsConfig *tls.Config
+	pool      *pool
+}
+
+func (ep *endpoint) healthy() bool {
+	return ep.pool.stats().Healthy
+}
+
+func (ep *endpoint) stats() *models.EndpointStats {
+	stats := ep.pool.stats()
+	return &models.EndpointStats{
+		URL:       ep.url,
+		Role:      ep.role,
+		Healthy:   stats.Healthy,
+		LastError: stats.LastError,
+		PoolSize:  ep.pool.config.MaxSize,
+		Open:      stats.Open,
+		Available: stats.Idle,
+		InUse:     stats.InUse,
+	}
+}
+
+// writeEndpoints returns the providers, healthy ones first
+func (m *Manager) writeEndpoints() []*endpoint {
+	var healthy, down []*endpoint
+	for _, ep := range m.endpoints {
+		if ep.role != config.RoleProvider {
+			continue
+		}
+		if ep.healthy() {
+			healthy = append(healthy, ep)
+		} else {
+			down = append(down, ep)
+		}
+	}
+	return append(healthy, down...)
+}
+
+// readEndpoints returns the endpoints to try for a read: healthy consumers in
+// rotating order, then the providers, then consumers that are down. Reads
+// that must see recent writes try the providers first.
+func (m *Manager) readEndpoints(ctx context.Context) []*endpoint {
+	var healthy, down []*endpoint
+	for _, ep := range m.endpoints {
+		if ep.role != config.RoleConsumer {
+			continue
+		}
+		if ep.healthy() {
+			healthy = append(healthy, ep)
+		} else {
+			down = append(down, ep)
+		}
+	}
+	if len(healthy) > 1 {
+		offset := int(atomic.AddUint64(&m.readCursor, 1) % uint64(len(healthy)))
+		healthy = append(healthy[offset:], healthy[:offset]...)
+	}
+
+	providers := m.writeEndpoints()
+	candidates := make([]*endpoint, 0, len(m.endpoints))
+	if m.needsProvider(ctx) {
+		candidates = append(candidates, providers...)
+		candidates = append(candidates, healthy...)
+	} else {
+		candidates = append(candidates, healthy...)
+		candidates = append(candidates, providers...)
+	}
+	return append(candidates, down...)
+}
+
+// connect checks out a connection from the first candidate that can provide one
+func (m *Manager) connect(ctx context.Context, candidates []*endpoint) (*ldap.Conn, error) {
+	atomic.AddInt64(&m.totalRequests, 1)
+
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return nil, fmt.Errorf("connection pool is closed")
+	}
+	m.mu.RUnlock()
+
+	lastErr := fmt.Errorf("no LDAP endpoint available")
+	for i, ep := range candidates {
+		conn, err := ep.pool.get(ctx)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+		if i < len(candidates)-1 {
+			m.logger.WithError(err).WithField("endpoint", ep.url).Debug("LDAP endpoint unavailable, failing over")
+		}
+	}
+	return nil, lastErr
+}
+
+// getReadConnection retrieves a connection for a read that may be served by a replica
+func (m *Manager) getReadConnection(ctx context.Context) (*ldap.Conn, error) {
+	return m.connect(ctx, m.readEndpoints(ctx))
+}
+
+// getWriteConnection retrieves a provider connection and records the write for read-your-writes
+func (m *Manager) getWriteConnection(ctx context.Context) (*ldap.Conn, error) {
+	m.noteWrite(ctx)
+	return m.getConnection(ctx)
+}
+
+// dialForBind opens an unpooled connection for binds with user credentials.
+// Providers come first, so disabled accounts and changed passwords take
+// effect before replication catches up; consumers are only a fallback.
+func (m *Manager) dialForBind(ctx context.Context) (*ldap.Conn, error) {
+	lastErr := fmt.Errorf("no LDAP endpoint available")
+	for _, ep := range m.readEndpoints(withProvider(ctx)) {
+		conn, err := m.dial(ep)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+type providerKey struct{}
+
+// withProvider marks ctx so that reads go to a provider, e.g. to read back a write
+func withProvider(ctx context.Context) context.Context {
+	return context.WithValue(ctx, providerKey{}, true)
+}
+
+// WithProvider is withProvider for callers outside the package, for reads a
+// lagging replica must not answer, such as account status checks
+func WithProvider(ctx context.Context) context.Context {
+	return withProvider(ctx)
+}
+
+// needsProvider reports whether reads for ctx must see the latest writes
+func (m *Manager) needsProvider(ctx context.Context) bool {
+	if forced, _ := ctx.Value(providerKey{}).(bool); forced {
+		return true
+	}
+	key := callerKey(ctx)
+	if key == "" || m.config.LDAPReadYourWrites <= 0 {
+		return false
+	}
+
+	m.writesMu.Lock()
+	defer m.writesMu.Unlock()
+	last, ok := m.lastWrites[key]
+	return ok && time.Since(last) < m.config.LDAPReadYourWrites
+}
+
+// noteWrite records that the caller of ctx is writing
+func (m *Manager) noteWrite(ctx context.Context) {
+	key := callerKey(ctx)
+	if key == "" || m.config.LDAPReadYourWrites <= 0 {
+		return
+	}
+
+	now := time.Now()
+	m.writesMu.Lock()
+	defer m.writesMu.Unlock()
+	m.lastWrites[key] = now
+
+	// Forget callers whose window has passed so the map stays small
+	if len(m.lastWrites) > 1024 {
+		for k, t := range m.lastWrites {
+			if now.Sub(t) >= m.config.LDAPReadYourWrites {
+				delete(m.lastWrites, k)
+			}
+		}
+	}
+}
+
+// callerKey identifies the authenticated caller of ctx, or returns "" for anonymous requests
+func callerKey(ctx context.Context) string {
+	principal, ok := authz.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if principal.Service {
+		return "service:" + principal.UID
+	}
+	return "user:" + principal.UID
+}
+
+// CheckEndpoints pings every endpoint and reports its health and pool usage
+func (m *Manager) CheckEndpoints(ctx context.Context) []*models.EndpointStats {
+	results := make([]*models.EndpointStats, len(m.endpoints))
+
+	var wg sync.WaitGroup
+	for i, ep := range m.endpoints {
+		wg.Add(1)
+		go func(i int, ep *endpoint) {
+			defer wg.Done()
+
+			conn, err := ep.pool.get(ctx)
+			if err == nil {
+				if !m.testConnection(conn) {
+					err = fmt.Errorf("connection test failed")
+				}
+				ep.pool.put(conn)
+			}
+
+			status := ep.stats()
+			status.Healthy = err == nil
+			if err != nil {
+				status.LastError = err.Error()
+			}
+			results[i] = status
+		}(i, ep)
+	}
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,442 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Object classes of new groups. With RFC 2307bis, groupOfMembers allows empty
+// groups and the auxiliary posixGroup adds gidNumber and the memberUid list
+// read by NSS clients, which is kept in sync with member. The nis schema
+// lacks groupOfMembers and makes posixGroup structural, so there groups are
+// posixGroup entries that extensibleObject lets hold member.
+var (
+	rfc2307bisGroupClasses = []string{"groupOfMembers", "posixGroup"}
+	nisGroupClasses        = []string{"posixGroup", "extensibleObject"}
+)
+
+// resolveGroupClasses returns the object classes of new groups for the schema
+// the server has loaded, asking it on first use
+func (m *Manager) resolveGroupClasses(conn *ldap.Conn) []string {
+	m.groupMu.Lock()
+	defer m.groupMu.Unlock()
+
+	if m.groupClasses != nil {
+		return m.groupClasses
+	}
+
+	subschema, err := readSubschema(conn)
+	if err != nil || subschema == nil {
+		// Not cached, the next group asks again
+		m.logger.WithError(err).Debug("Failed to read subschema, assuming RFC 2307bis groups")
+		return rfc2307bisGroupClasses
+	}
+
+	classes := subschema.GetAttributeValues("objectClasses")
+	posixGroup := findDefinition(classes, "posixGroup")
+	if findDefinition(classes, "groupOfMembers") != "" && (posixGroup == "" || definitionHasKeyword(posixGroup, "AUXILIARY")) {
+		m.groupClasses = rfc2307bisGroupClasses
+	} else {
+		m.logger.Warn("Directory uses the nis schema, creating groups as posixGroup with extensibleObject")
+		m.groupClasses = nisGroupClasses
+	}
+	return m.groupClasses
+}
+
+// groupAttributes are the attributes read for every group entry
+var groupAttributes = []string{"objectClass", "cn", "gidNumber", "description", "member", "memberUid"}
+
+// groupFilter matches groups created by this service, including older groupOfNames groups
+const groupFilter = "(|(objectClass=groupOfMembers)(objectClass=groupOfNames)(objectClass=posixGroup))"
+
+// legacyPlaceholderDN is the fake member older versions put into groupOfNames
+// groups, which cannot be empty
+func (m *Manager) legacyPlaceholderDN() string {
+	return "cn=placeholder,ou=groups," + m.config.LDAPBaseDN
+}
+
+// ListGroups returns one page of groups matching filter
+func (m *Manager) ListGroups(ctx context.Context, filter *models.GroupFilter, page, limit int) (*models.GroupPage, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	filters := []string{groupFilter}
+	if filter != nil {
+		if filter.CN != "" {
+			filters = append(filters, fmt.Sprintf("(cn=*%s*)", ldap.EscapeFilter(filter.CN)))
+		}
+		if filter.Member != "" {
+			filters = append(filters, m.memberFilter(filter.Member))
+		}
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.GroupsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&%s)", strings.Join(filters, "")),
+		groupAttributes,
+		nil,
+	)
+
+	groupPage := &models.GroupPage{
+		Items: make([]*models.Group, 0, limit),
+		Page:  page,
+		Limit: limit,
+	}
+	groupPage.Total, groupPage.TotalEstimated, groupPage.HasNextPage, err = m.searchWindow(conn, searchRequest, (page-1)*limit, limit, func(entry *ldap.Entry) {
+		groupPage.Items = append(groupPage.Items, m.entryToGroup(entry))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groupPage, nil
+}
+
+// UpdateGroup changes the description of a group
+func (m *Manager) UpdateGroup(ctx context.Context, input *models.UpdateGroupInput) (*models.Group, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	if _, err := m.readGroup(conn, input.CN); err != nil {
+		return nil, err
+	}
+
+	if input.Description != nil {
+		modifyRequest := ldap.NewModifyRequest(m.config.GroupDN(input.CN), nil)
+		if *input.Description == "" {
+			modifyRequest.Replace("description", []string{})
+		} else {
+			modifyRequest.Replace("description", []string{*input.Description})
+		}
+		if err := conn.Modify(modifyRequest); err != nil {
+			m.logger.WithError(err).Error("Failed to update group")
+			return nil, fmt.Errorf("failed to update group: %w", err)
+		}
+	}
+
+	m.logger.WithField("cn", input.CN).Info("Group updated successfully")
+	return m.GetGroup(withProvider(ctx), input.CN)
+}
+
+// DeleteGroup deletes a group and removes it from the groups it is nested
+// in. Groups that grant roles cannot be deleted.
+func (m *Manager) DeleteGroup(ctx context.Context, cn string) error {
+	for group := range m.config.RoleGroups {
+		if strings.EqualFold(group, cn) {
+			return fmt.Errorf("group %s grants a role and cannot be deleted", cn)
+		}
+	}
+
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	m.logger.WithField("cn", cn).Info("Deleting group")
+
+	groupDN := m.config.GroupDN(cn)
+	parents, err := m.searchGroups(conn, fmt.Sprintf("(&%s(member=%s))", groupFilter, ldap.EscapeFilter(groupDN)), groupAttributes)
+	if err != nil {
+		return fmt.Errorf("failed to find parent groups: %w", err)
+	}
+
+	changes := newChangeSet()
+	for _, parent := range parents {
+		if isLegacyGroup(parent) && len(parent.GetAttributeValues("member")) == 1 {
+			m.rollback(conn, changes)
+			return fmt.Errorf("group %s is the only member of groupOfNames group %s; remove it from there first", cn, parent.GetAttributeValue("cn"))
+		}
+		modifyRequest := ldap.NewModifyRequest(parent.DN, nil)
+		modifyRequest.Delete("member", []string{groupDN})
+		undoRequest := ldap.NewModifyRequest(parent.DN, nil)
+		undoRequest.Add("member", []string{groupDN})
+		if err := changes.modify(conn, modifyRequest, undoRequest, "removed member group "+cn); err != nil {
+			m.rollback(conn, changes)
+			return fmt.Errorf("failed to remove group from group %s: %w", parent.GetAttributeValue("cn"), err)
+		}
+	}
+
+	if err := conn.Del(ldap.NewDelRequest(groupDN, nil)); err != nil {
+		m.logger.WithError(err).Error("Failed to delete group")
+		m.rollback(conn, changes)
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+
+	m.logger.WithField("cn", cn).Info("Group deleted successfully")
+	return nil
+}
+
+// RemoveUserFromGroup removes a user from a group
+func (m *Manager) RemoveUserFromGroup(ctx context.Context, uid, groupCN string) error {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readGroup(conn, groupCN)
+	if err != nil {
+		return err
+	}
+	userDN := m.config.UserDN(uid)
+	hasMember := containsDN(entry.GetAttributeValues("member"), userDN)
+	hasMemberUID := containsFold(entry.GetAttributeValues("memberUid"), uid)
+	if !hasMember && !hasMemberUID {
+		return fmt.Errorf("user %s is not a member of group %s", uid, groupCN)
+	}
+	if hasMember && isLegacyGroup(entry) && len(entry.GetAttributeValues("member")) == 1 {
+		return fmt.Errorf("group %s is a groupOfNames group and cannot be left empty", groupCN)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":   uid,
+		"group": groupCN,
+	}).Info("Removing user from group")
+
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	if hasMember {
+		modifyRequest.Delete("member", []string{userDN})
+	}
+	if hasMemberUID {
+		modifyRequest.Delete("memberUid", []string{uid})
+	}
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to remove user from group")
+		return fmt.Errorf("failed to remove user from group: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":   uid,
+		"group": groupCN,
+	}).Info("User removed from group successfully")
+	return nil
+}
+
+// SetGroupMembers replaces the user members of a group with the given users.
+// Nested groups stay members.
+func (m *Manager) SetGroupMembers(ctx context.Context, groupCN string, uids []string) (*models.Group, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readGroup(conn, groupCN)
+	if err != nil {
+		return nil, err
+	}
+
+	memberDNs := make([]string, 0, len(uids))
+	memberUIDs := make([]string, 0, len(uids))
+	for _, memberDN := range entry.GetAttributeValues("member") {
+		if m.memberGroupCN(memberDN) != "" {
+			memberDNs = append(memberDNs, memberDN)
+		}
+	}
+	for _, uid := range uids {
+		if containsFold(memberUIDs, uid) {
+			continue
+		}
+		if err := m.requireUser(conn, uid); err != nil {
+			return nil, err
+		}
+		memberDNs = append(memberDNs, m.config.UserDN(uid))
+		memberUIDs = append(memberUIDs, uid)
+	}
+	if len(memberDNs) == 0 && isLegacyGroup(entry) {
+		return nil, fmt.Errorf("group %s is a groupOfNames group and cannot be left empty", groupCN)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"group":   groupCN,
+		"members": len(memberDNs),
+	}).Info("Setting group members")
+
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	modifyRequest.Replace("member", memberDNs)
+	if hasObjectClass(entry, "posixGroup") {
+		modifyRequest.Replace("memberUid", memberUIDs)
+	}
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to set group members")
+		return nil, fmt.Errorf("failed to set group members: %w", err)
+	}
+
+	return m.GetGroup(withProvider(ctx), groupCN)
+}
+
+// readGroup reads the entry of a group
+func (m *Manager) readGroup(conn *ldap.Conn, cn string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.GroupDN(cn),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		groupAttributes,
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("group not found: %s", cn)
+		}
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("group not found: %s", cn)
+	}
+	return result.Entries[0], nil
+}
+
+// requireUser fails unless the user entry exists
+func (m *Manager) requireUser(conn *ldap.Conn, uid string) error {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UserDN(uid),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"1.1"},
+		nil,
+	)
+
+	if _, err := conn.Search(searchRequest); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return fmt.Errorf("user not found: %s", uid)
+		}
+		return fmt.Errorf("search failed: %w", err)
+	}
+	return nil
+}
+
+// memberAddition returns the change that adds uid to group, and its undo
+func (m *Manager) memberAddition(group *ldap.Entry, uid string) (*ldap.ModifyRequest, *ldap.ModifyRequest) {
+	userDN := m.config.UserDN(uid)
+	modifyRequest := ldap.NewModifyRequest(group.DN, nil)
+	undoRequest := ldap.NewModifyRequest(group.DN, nil)
+	if !containsDN(group.GetAttributeValues("member"), userDN) {
+		modifyRequest.Add("member", []string{userDN})
+		undoRequest.Delete("member", []string{userDN})
+	}
+	if hasObjectClass(group, "posixGroup") && !containsFold(group.GetAttributeValues("memberUid"), uid) {
+		modifyRequest.Add("memberUid", []string{uid})
+		undoRequest.Delete("memberUid", []string{uid})
+	}
+	if containsDN(group.GetAttributeValues("member"), m.legacyPlaceholderDN()) {
+		modifyRequest.Delete("member", []string{m.legacyPlaceholderDN()})
+		undoRequest.Add("member", []string{m.legacyPlaceholderDN()})
+	}
+	return modifyRequest, undoRequest
+}
+
+// memberRemoval returns the change that takes uid out of group, and its undo
+func (m *Manager) memberRemoval(group *ldap.Entry, uid string) (*ldap.ModifyRequest, *ldap.ModifyRequest) {
+	userDN := m.config.UserDN(uid)
+	modifyRequest := ldap.NewModifyRequest(group.DN, nil)
+	undoRequest := ldap.NewModifyRequest(group.DN, nil)
+	members := group.GetAttributeValues("member")
+	if containsDN(members, userDN) {
+		// groupOfNames cannot be empty, so the last member makes way for the placeholder
+		if isLegacyGroup(group) && len(members) == 1 {
+			modifyRequest.Add("member", []string{m.legacyPlaceholderDN()})
+			undoRequest.Delete("member", []string{m.legacyPlaceholderDN()})
+		}
+		modifyRequest.Delete("member", []string{userDN})
+		undoRequest.Add("member", []string{userDN})
+	}
+	if containsFold(group.GetAttributeValues("memberUid"), uid) {
+		modifyRequest.Delete("memberUid", []string{uid})
+		undoRequest.Add("memberUid", []string{uid})
+	}
+	return modifyRequest, undoRequest
+}
+
+// memberFilter matches groups that list uid as a member
+func (m *Manager) memberFilter(uid string) string {
+	return fmt.Sprintf("(|(member=%s)(memberUid=%s))",
+		ldap.EscapeFilter(m.config.UserDN(uid)), ldap.EscapeFilter(uid))
+}
+
+func isLegacyGroup(entry *ldap.Entry) bool {
+	return hasObjectClass(entry, "groupOfNames")
+}
+
+func hasObjectClass(entry *ldap.Entry, class string) bool {
+	return containsFold(entry.GetAttributeValues("objectClass"), class)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsDN compares DNs the way the directory does, ignoring case and spacing
+func containsDN(dns []string, dn string) bool {
+	want, err := ldap.ParseDN(dn)
+	if err != nil {
+		return containsFold(dns, dn)
+	}
+	for _, value := range dns {
+		if parsed, err := ldap.ParseDN(value); err == nil && parsed.EqualFold(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// memberUID returns the uid of a member DN below the users OU, or "" for other members
+func (m *Manager) memberUID(memberDN string) string {
+	return childRDNValue(memberDN, "uid", m.config.UsersDN())
+}
+
+// memberGroupCN returns the cn of a member DN below the groups OU, or "" for
+// other members and the legacy placeholder
+func (m *Manager) memberGroupCN(memberDN string) string {
+	if containsDN([]string{m.legacyPlaceholderDN()}, memberDN) {
+		return ""
+	}
+	return childRDNValue(memberDN, "cn", m.config.GroupsDN())
+}
+
+// childRDNValue returns the value of the attr RDN of dn when dn sits directly below parentDN
+func childRDNValue(dn, attr, parentDN string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) != 1 {
+		return ""
+	}
+	rdn := parsed.RDNs[0].Attributes[0]
+	if !strings.EqualFold(rdn.Type, attr) {
+		return ""
+	}
+	parent, err := ldap.ParseDN(parentDN)
+	if err != nil || !(&ldap.DN{RDNs: parsed.RDNs[1:]}).EqualFold(parent) {
+		return ""
+	}
+	return rdn.Value
+}
@@ -0,0 +1,251 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAllocAttempts bounds retries when another replica races us for the same number
+const maxAllocAttempts = 20
+
+// The allocator entry stores the next free uidNumber/gidNumber. Numbers are
+// claimed with a single modify that deletes the value we read and adds its
+// successor; LDAP applies both atomically, so a concurrent writer makes our
+// delete fail with noSuchAttribute and we retry with the fresh value.
+
+// nextUID allocates the next free UID number
+func (m *Manager) nextUID(ctx context.Context, conn *ldap.Conn) (int, error) {
+	return m.allocateID(ctx, conn, "uidNumber")
+}
+
+// nextGID allocates the next free GID number
+func (m *Manager) nextGID(ctx context.Context, conn *ldap.Conn) (int, error) {
+	return m.allocateID(ctx, conn, "gidNumber")
+}
+
+func (m *Manager) allocateID(ctx context.Context, conn *ldap.Conn, attr string) (int, error) {
+	for attempt := 0; attempt < maxAllocAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		current, err := m.readAllocator(conn, attr)
+		if err != nil {
+			return 0, err
+		}
+
+		modifyRequest := ldap.NewModifyRequest(m.config.IDAllocatorDN(), nil)
+		modifyRequest.Delete(attr, []string{strconv.Itoa(current)})
+		modifyRequest.Add(attr, []string{strconv.Itoa(current + 1)})
+
+		err = conn.Modify(modifyRequest)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+			m.logger.WithFields(logrus.Fields{
+				"attribute": attr,
+				"attempt":   attempt + 1,
+			}).Debug("ID allocation conflict, retrying")
+			time.Sleep(time.Duration(rand.Intn(10*(attempt+1))) * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to update ID allocator: %w", err)
+		}
+
+		// Entries created outside the service may already use this number
+		inUse, err := m.idInUse(conn, attr, current)
+		if err != nil {
+			return 0, err
+		}
+		if inUse {
+			// Move past every number in use at once instead of probing them one by one
+			highest, err := m.maxID(conn, m.config.LDAPBaseDN, attr)
+			if err != nil {
+				return 0, err
+			}
+			m.logger.WithFields(logrus.Fields{
+				"attribute": attr,
+				"value":     current,
+				"next":      highest + 1,
+			}).Warn("Skipping IDs already in use")
+			if highest > current {
+				jumpRequest := ldap.NewModifyRequest(m.config.IDAllocatorDN(), nil)
+				jumpRequest.Delete(attr, []string{strconv.Itoa(current + 1)})
+				jumpRequest.Add(attr, []string{strconv.Itoa(highest + 1)})
+				// Another allocation moved the counter first, the next attempt reads it
+				if err := conn.Modify(jumpRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) {
+					return 0, fmt.Errorf("failed to update ID allocator: %w", err)
+				}
+			}
+			continue
+		}
+
+		return current, nil
+	}
+
+	return 0, fmt.Errorf("failed to allocate %s after %d attempts", attr, maxAllocAttempts)
+}
+
+// readAllocator returns the next free value of attr, creating the allocator entry if needed
+func (m *Manager) readAllocator(conn *ldap.Conn, attr string) (int, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.IDAllocatorDN(),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{attr},
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		if err := m.initAllocator(conn); err != nil {
+			return 0, err
+		}
+		result, err = conn.Search(searchRequest)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read ID allocator: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return 0, fmt.Errorf("ID allocator entry not found")
+	}
+
+	value, err := strconv.Atoi(result.Entries[0].GetAttributeValue(attr))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s in ID allocator: %w", attr, err)
+	}
+	return value, nil
+}
+
+// initAllocator creates the allocator entry seeded above the highest number in use
+func (m *Manager) initAllocator(conn *ldap.Conn) error {
+	maxUID, err := m.maxID(conn, m.config.UsersDN(), "uidNumber")
+	if err != nil {
+		return err
+	}
+	maxGID, err := m.maxID(conn, m.config.LDAPBaseDN, "gidNumber")
+	if err != nil {
+		return err
+	}
+
+	nextUID := m.config.StartingUID
+	if maxUID >= nextUID {
+		nextUID = maxUID + 1
+	}
+	nextGID := m.config.StartingGID
+	if maxGID >= nextGID {
+		nextGID = maxGID + 1
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uidNumber": nextUID,
+		"gidNumber": nextGID,
+	}).Info("Initializing ID allocator")
+
+	addRequest := ldap.NewAddRequest(m.config.IDAllocatorDN(), nil)
+	addRequest.Attribute("objectClass", []string{"applicationProcess", "extensibleObject"})
+	addRequest.Attribute("cn", []string{config.IDAllocatorCN})
+	addRequest.Attribute("description", []string{fmt.Sprintf("Next free uidNumber/gidNumber (UID start %d, GID start %d)", m.config.StartingUID, m.config.StartingGID)})
+	addRequest.Attribute("uidNumber", []string{strconv.Itoa(nextUID)})
+	addRequest.Attribute("gidNumber", []string{strconv.Itoa(nextGID)})
+
+	err = conn.Add(addRequest)
+	// Another replica may have created it first, which is fine
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+		return fmt.Errorf("failed to create ID allocator: %w", err)
+	}
+	return nil
+}
+
+// maxID returns the highest value of attr below baseDN, or 0 if none
+func (m *Manager) maxID(conn *ldap.Conn, baseDN, attr string) (int, error) {
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&(%s=*)(!(cn=%s)))", attr, config.IDAllocatorCN),
+		[]string{attr},
+		nil,
+	)
+
+	highest := 0
+	err := m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		if value, err := strconv.Atoi(entry.GetAttributeValue(attr)); err == nil && value > highest {
+			highest = value
+		}
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan %s: %w", attr, err)
+	}
+	return highest, nil
+}
+
+// idInUse checks whether any managed entry already carries attr=value
+func (m *Manager) idInUse(conn *ldap.Conn, attr string, value int) (bool, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.LDAPBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		1,
+		0,
+		false,
+		fmt.Sprintf("(&(%s=%d)(!(cn=%s)))", attr, value, config.IDAllocatorCN),
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", attr, err)
+	}
+	return len(result.Entries) > 0, nil
+}
+
+// IDAllocation reports the configured ranges and how far allocation has progressed
+func (m *Manager) IDAllocation(ctx context.Context) (*models.IDAllocation, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	nextUID, err := m.readAllocator(conn, "uidNumber")
+	if err != nil {
+		return nil, err
+	}
+	nextGID, err := m.readAllocator(conn, "gidNumber")
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.IDAllocation{
+		UID: &models.IDRange{
+			Start:     m.config.StartingUID,
+			Next:      nextUID,
+			Allocated: nextUID - m.config.StartingUID,
+		},
+		GID: &models.IDRange{
+			Start:     m.config.StartingGID,
+			Next:      nextGID,
+			Allocated: nextGID - m.config.StartingGID,
+		},
+	}, nil
+}
@@ -0,0 +1,423 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/ldif"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// exportContainers returns the subtrees an export of scope covers, in an
+// order that imports cleanly: departments before the users in them, users
+// before the groups they are members of
+func (m *Manager) exportContainers(scope string) ([]string, error) {
+	departments := []string{m.config.DepartmentsDN()}
+	users := []string{m.config.UsersDN(), m.config.DeletedUsersDN()}
+	groups := []string{m.config.GroupsDN()}
+
+	switch scope {
+	case models.LDIFScopeDepartments:
+		return departments, nil
+	case models.LDIFScopeUsers:
+		return users, nil
+	case models.LDIFScopeGroups:
+		return groups, nil
+	case models.LDIFScopeAll:
+		return append(append(departments, users...), groups...), nil
+	}
+	return nil, fmt.Errorf("invalid LDIF scope %q", scope)
+}
+
+// ExportLDIF writes the entries of scope to w as LDIF, page by page
+func (m *Manager) ExportLDIF(ctx context.Context, scope string, w io.Writer) error {
+	containers, err := m.exportContainers(scope)
+	if err != nil {
+		return err
+	}
+
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	writer := ldif.NewWriter(w)
+	exported := 0
+	for _, container := range containers {
+		// Departments nest, and a moved department can be stored after its
+		// children. There are few of them, so they are sorted parents first.
+		if container == m.config.DepartmentsDN() {
+			var entries []*ldap.Entry
+			err = m.searchPaged(conn, exportSearchRequest(container, ldap.ScopeWholeSubtree), uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+				entries = append(entries, entry)
+				return true
+			})
+			sort.SliceStable(entries, func(i, j int) bool {
+				return dnDepth(entries[i].DN) < dnDepth(entries[j].DN)
+			})
+			for _, entry := range entries {
+				if err == nil {
+					err = writeLDIFEntry(writer, entry)
+					exported++
+				}
+			}
+		} else {
+			// Users and groups sit directly in their container, which is
+			// written first and its entries streamed after it
+			var result *ldap.SearchResult
+			result, err = conn.Search(exportSearchRequest(container, ldap.ScopeBaseObject))
+			if err == nil && len(result.Entries) > 0 {
+				err = writeLDIFEntry(writer, result.Entries[0])
+				exported++
+			}
+			var writeErr error
+			if err == nil {
+				err = m.searchPaged(conn, exportSearchRequest(container, ldap.ScopeSingleLevel), uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+					writeErr = writeLDIFEntry(writer, entry)
+					exported++
+					return writeErr == nil && ctx.Err() == nil
+				})
+			}
+			if err == nil {
+				err = writeErr
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+		}
+
+		// The deleted users container only exists once a user was soft-deleted
+		var ldapErr *ldap.Error
+		if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject && container == m.config.DeletedUsersDN() {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to export %s: %w", container, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"scope":   scope,
+		"entries": exported,
+	}).Info("LDIF exported")
+	return nil
+}
+
+func exportSearchRequest(baseDN string, scope int) *ldap.SearchRequest {
+	return ldap.NewSearchRequest(baseDN, scope, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"*"}, nil)
+}
+
+func writeLDIFEntry(writer *ldif.Writer, entry *ldap.Entry) error {
+	attrs := make([]ldif.Attribute, 0, len(entry.Attributes))
+	for _, attr := range entry.Attributes {
+		attrs = append(attrs, ldif.Attribute{Name: attr.Name, Values: attr.Values})
+	}
+	return writer.WriteEntry(entry.DN, attrs)
+}
+
+// dnDepth returns the number of RDNs of dn
+func dnDepth(dn string) int {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return 0
+	}
+	return len(parsed.RDNs)
+}
+
+// ImportLDIF applies the add, modify and delete records read from r in order.
+// Every record is reported; a failed record does not stop the import.
+// Cleartext passwords are checked against the policy and hashed, and users
+// added without uidNumber or gidNumber get them from the ID allocator. With
+// dryRun the records are only checked against the DN layout, the password
+// policy and the entries present.
+func (m *Manager) ImportLDIF(ctx context.Context, r io.Reader, dryRun bool) (*models.LDIFImportReport, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	report := &models.LDIFImportReport{DryRun: dryRun, Records: []*models.LDIFRecordResult{}}
+	// Entries added (true) or deleted (false) by earlier records of a dry run
+	planned := make(map[string]bool)
+
+	reader := ldif.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *ldif.ParseError
+		if errors.As(err, &parseErr) {
+			report.Failed++
+			report.Records = append(report.Records, &models.LDIFRecordResult{
+				Line:   parseErr.Line,
+				DN:     parseErr.DN,
+				Status: models.LDIFRecordFailed,
+				Error:  parseErr.Msg,
+			})
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read LDIF: %w", err)
+		}
+
+		result := &models.LDIFRecordResult{Line: record.Line, DN: record.DN, ChangeType: record.ChangeType}
+		report.Records = append(report.Records, result)
+
+		if dryRun {
+			err = m.checkLDIFRecord(conn, record, planned)
+		} else {
+			err = m.applyLDIFRecord(ctx, conn, record)
+		}
+		switch {
+		case err != nil:
+			report.Failed++
+			result.Status = models.LDIFRecordFailed
+			result.Error = err.Error()
+		case dryRun:
+			report.Applied++
+			result.Status = models.LDIFRecordValid
+		default:
+			report.Applied++
+			result.Status = models.LDIFRecordApplied
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"dry_run": dryRun,
+		"applied": report.Applied,
+		"failed":  report.Failed,
+	}).Info("LDIF imported")
+	return report, nil
+}
+
+// applyLDIFRecord checks the DN of a record and makes its change
+func (m *Manager) applyLDIFRecord(ctx context.Context, conn *ldap.Conn, record *ldif.Record) error {
+	if err := m.checkLDIFLayout(record.DN); err != nil {
+		return err
+	}
+	if err := m.hashLDIFPasswords(record); err != nil {
+		return err
+	}
+
+	switch record.ChangeType {
+	case ldif.ChangeAdd:
+		if err := m.allocateLDIFIDs(ctx, conn, record); err != nil {
+			return err
+		}
+		addRequest := ldap.NewAddRequest(record.DN, nil)
+		for _, attr := range record.Attributes {
+			addRequest.Attribute(attr.Name, attr.Values)
+		}
+		return conn.Add(addRequest)
+	case ldif.ChangeDelete:
+		return conn.Del(ldap.NewDelRequest(record.DN, nil))
+	case ldif.ChangeModify:
+		modifyRequest := ldap.NewModifyRequest(record.DN, nil)
+		for _, mod := range record.Modifications {
+			switch mod.Op {
+			case ldif.ModAdd:
+				modifyRequest.Add(mod.Attribute.Name, mod.Attribute.Values)
+			case ldif.ModDelete:
+				modifyRequest.Delete(mod.Attribute.Name, mod.Attribute.Values)
+			case ldif.ModReplace:
+				modifyRequest.Replace(mod.Attribute.Name, mod.Attribute.Values)
+			}
+		}
+		return conn.Modify(modifyRequest)
+	}
+	return fmt.Errorf("changetype %s is not supported", record.ChangeType)
+}
+
+// hashLDIFPasswords replaces the cleartext userPassword values of a record
+// with hashes, validating them against the password policy first
+func (m *Manager) hashLDIFPasswords(record *ldif.Record) error {
+	var values [][]string
+	switch record.ChangeType {
+	case ldif.ChangeAdd:
+		for _, attr := range record.Attributes {
+			if strings.EqualFold(attr.Name, "userPassword") {
+				values = append(values, attr.Values)
+			}
+		}
+	case ldif.ChangeModify:
+		for _, mod := range record.Modifications {
+			// Deleted values must match the stored hashes as given
+			if mod.Op != ldif.ModDelete && strings.EqualFold(mod.Attribute.Name, "userPassword") {
+				values = append(values, mod.Attribute.Values)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	subject := password.Subject{
+		UID:       ldifValue(record, "uid"),
+		CN:        ldifValue(record, "cn"),
+		SN:        ldifValue(record, "sn"),
+		GivenName: ldifValue(record, "givenName"),
+		Mail:      ldifValue(record, "mail"),
+	}
+	if subject.UID == "" {
+		subject.UID = childRDNValue(record.DN, "uid", m.config.UsersDN())
+	}
+	for _, vals := range values {
+		for i, value := range vals {
+			if password.IsHashed(value) {
+				continue
+			}
+			hashed, err := m.PreparePassword(value, subject)
+			if err != nil {
+				return err
+			}
+			vals[i] = hashed
+		}
+	}
+	return nil
+}
+
+// allocateLDIFIDs gives a user added without uidNumber or gidNumber the next
+// free numbers, as CreateUser does
+func (m *Manager) allocateLDIFIDs(ctx context.Context, conn *ldap.Conn, record *ldif.Record) error {
+	if childRDNValue(record.DN, "uid", m.config.UsersDN()) == "" {
+		return nil
+	}
+
+	for _, attr := range []string{"uidNumber", "gidNumber"} {
+		if ldifValue(record, attr) != "" {
+			continue
+		}
+		value, err := m.allocateID(ctx, conn, attr)
+		if err != nil {
+			return fmt.Errorf("failed to allocate %s: %w", attr, err)
+		}
+		record.Attributes = append(record.Attributes, ldif.Attribute{Name: attr, Values: []string{strconv.Itoa(value)}})
+	}
+	return nil
+}
+
+// ldifValue returns the first value of attr in an add record
+func ldifValue(record *ldif.Record, attr string) string {
+	for _, a := range record.Attributes {
+		if strings.EqualFold(a.Name, attr) && len(a.Values) > 0 {
+			return a.Values[0]
+		}
+	}
+	return ""
+}
+
+// checkLDIFRecord checks what it can of a record without changing anything:
+// the DN layout, and that the entry and its parent exist as the change needs,
+// counting the changes of earlier records
+func (m *Manager) checkLDIFRecord(conn *ldap.Conn, record *ldif.Record, planned map[string]bool) error {
+	if err := m.checkLDIFLayout(record.DN); err != nil {
+		return err
+	}
+	if err := m.hashLDIFPasswords(record); err != nil {
+		return err
+	}
+	key := normalizeDN(record.DN)
+
+	exists, err := m.plannedExists(conn, record.DN, planned)
+	if err != nil {
+		return err
+	}
+	switch record.ChangeType {
+	case ldif.ChangeAdd:
+		if exists {
+			return fmt.Errorf("entry already exists")
+		}
+		parsed, _ := ldap.ParseDN(record.DN)
+		parent := (&ldap.DN{RDNs: parsed.RDNs[1:]}).String()
+		parentExists, err := m.plannedExists(conn, parent, planned)
+		if err != nil {
+			return err
+		}
+		if !parentExists {
+			return fmt.Errorf("parent %s does not exist", parent)
+		}
+		planned[key] = true
+	case ldif.ChangeDelete:
+		if !exists {
+			return fmt.Errorf("entry does not exist")
+		}
+		planned[key] = false
+	case ldif.ChangeModify:
+		if !exists {
+			return fmt.Errorf("entry does not exist")
+		}
+	}
+	return nil
+}
+
+// plannedExists reports whether dn exists after the changes planned so far
+func (m *Manager) plannedExists(conn *ldap.Conn, dn string, planned map[string]bool) (bool, error) {
+	if exists, ok := planned[normalizeDN(dn)]; ok {
+		return exists, nil
+	}
+
+	searchRequest := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"1.1"}, nil)
+	_, err := conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up %s: %w", dn, err)
+	}
+	return true, nil
+}
+
+// checkLDIFLayout accepts only the entries the service manages: the users,
+// groups, departments and deleted users containers, users and groups
+// directly in theirs, and departments nested below ou=departments
+func (m *Manager) checkLDIFLayout(dn string) error {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return fmt.Errorf("invalid DN %q", dn)
+	}
+
+	containers := []string{m.config.UsersDN(), m.config.GroupsDN(), m.config.DepartmentsDN(), m.config.DeletedUsersDN()}
+	for _, container := range containers {
+		if containerDN, err := ldap.ParseDN(container); err == nil && containerDN.EqualFold(parsed) {
+			return nil
+		}
+	}
+
+	if childRDNValue(dn, "uid", m.config.UsersDN()) != "" ||
+		childRDNValue(dn, "uid", m.config.DeletedUsersDN()) != "" ||
+		childRDNValue(dn, "cn", m.config.GroupsDN()) != "" {
+		return nil
+	}
+
+	departments, err := ldap.ParseDN(m.config.DepartmentsDN())
+	if err == nil && departments.AncestorOfFold(parsed) {
+		nested := parsed.RDNs[:len(parsed.RDNs)-len(departments.RDNs)]
+		valid := true
+		for _, rdn := range nested {
+			valid = valid && len(rdn.Attributes) == 1 && strings.EqualFold(rdn.Attributes[0].Type, "ou")
+		}
+		if valid {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s is not a user, group or department entry of %s", dn, m.config.LDAPBaseDN)
+}
@@ -0,0 +1,478 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Account state is kept in shadowExpire, which PAM and NSS clients already
+// honour: disabled users expire on day 1 of the epoch, soft-deleted users on
+// the day they were deleted, which is also where the retention window starts.
+// Accounts given an expiry date elsewhere count as disabled once it passes;
+// -1 means no expiry.
+const disabledShadowExpire = "1"
+
+const secondsPerDay = 24 * 60 * 60
+
+// removedReferenceAttr keeps, on a soft-deleted user, the DNs of the groups
+// and departments it was removed from, so RestoreUser can put it back
+const removedReferenceAttr = "devplatformRemovedReference"
+
+// shadowDay returns t as a shadowExpire value, in days since the epoch
+func shadowDay(t time.Time) string {
+	return strconv.FormatInt(t.Unix()/secondsPerDay, 10)
+}
+
+// shadowDate parses a shadowExpire value, returning nil if it is not set
+func shadowDate(value string) *time.Time {
+	days, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	t := time.Unix(days*secondsPerDay, 0).UTC()
+	return &t
+}
+
+// shadowExpired reports whether a shadowExpire value has passed at now.
+// Accounts expire at the start of the given day.
+func shadowExpired(value string, now time.Time) bool {
+	days, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || days == -1 {
+		return false
+	}
+	return days <= now.Unix()/secondsPerDay
+}
+
+// expiredFilter matches users whose shadowExpire has passed at now, the
+// same users shadowExpired reports. shadowExpire has no ORDERING rule in
+// the nis schema, so the comparison uses an extensible match.
+func expiredFilter(now time.Time) string {
+	return fmt.Sprintf("(&(shadowExpire:integerOrderingMatch:=%d)(!(shadowExpire=-1)))", now.Unix()/secondsPerDay+1)
+}
+
+// DisableUser blocks a user from logging in without removing the account
+func (m *Manager) DisableUser(ctx context.Context, uid string) (*models.User, error) {
+	return m.setShadowExpire(ctx, uid, func(string) []string {
+		return []string{disabledShadowExpire}
+	}, "Disabling user")
+}
+
+// EnableUser lets a disabled user log in again. An expiry date still to
+// come is kept.
+func (m *Manager) EnableUser(ctx context.Context, uid string) (*models.User, error) {
+	return m.setShadowExpire(ctx, uid, func(current string) []string {
+		if current == "" || !shadowExpired(current, time.Now()) {
+			return nil
+		}
+		return []string{}
+	}, "Enabling user")
+}
+
+// setShadowExpire replaces the shadowExpire of a user with what value
+// returns for the current one, unless that is nil
+func (m *Manager) setShadowExpire(ctx context.Context, uid string, value func(current string) []string, action string) (*models.User, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readUserEntry(conn, m.config.UserDN(uid), uid)
+	if err != nil {
+		return nil, err
+	}
+	next := value(entry.GetAttributeValue("shadowExpire"))
+	if next == nil {
+		return m.GetUser(withProvider(ctx), uid)
+	}
+
+	m.logger.WithField("uid", uid).Info(action)
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	modifyRequest.Replace("shadowExpire", next)
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to update account status")
+		return nil, fmt.Errorf("failed to update account status: %w", err)
+	}
+
+	return m.GetUser(withProvider(ctx), uid)
+}
+
+// SoftDeleteUser removes a user from groups and department managers and
+// moves the entry to the deleted users container, where it keeps its
+// uidNumber, repositories, password history and the references removed
+// until restored or purged
+func (m *Manager) SoftDeleteUser(ctx context.Context, uid string) (*models.DeletionSummary, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readUserEntry(conn, m.config.UserDN(uid), uid)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := m.readDeletedUser(conn, uid); err == nil {
+		return nil, fmt.Errorf("a deleted user %s already exists; purge it first", uid)
+	}
+
+	m.logger.WithField("uid", uid).Info("Soft deleting user")
+
+	changes := newChangeSet()
+	if err := m.removeUserReferences(conn, uid, changes); err != nil {
+		m.rollback(conn, changes)
+		return nil, err
+	}
+
+	references := make([]string, 0, len(changes.summary.Modified))
+	for _, change := range changes.summary.Modified {
+		references = append(references, change.DN)
+	}
+
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	modifyRequest.Replace("shadowExpire", []string{shadowDay(time.Now())})
+	if len(references) > 0 {
+		modifyRequest.Replace(removedReferenceAttr, references)
+	}
+	undoRequest := ldap.NewModifyRequest(entry.DN, nil)
+	undoRequest.Replace("shadowExpire", entry.GetAttributeValues("shadowExpire"))
+	err = changes.modify(conn, modifyRequest, undoRequest, "marked deleted")
+	if err != nil && len(references) > 0 && ldap.IsErrorWithCode(err, ldap.LDAPResultUndefinedAttributeType) {
+		// Directories without migration 5 cannot keep the references
+		m.logger.WithField("uid", uid).Warn("Directory lacks " + removedReferenceAttr + ", run migrate so restored users get their groups back")
+		modifyRequest = ldap.NewModifyRequest(entry.DN, nil)
+		modifyRequest.Replace("shadowExpire", []string{shadowDay(time.Now())})
+		err = changes.modify(conn, modifyRequest, undoRequest, "marked deleted")
+	}
+	if err != nil {
+		m.rollback(conn, changes)
+		return nil, fmt.Errorf("failed to mark user deleted: %w", err)
+	}
+
+	if err := m.moveUserEntry(conn, entry.DN, uid, m.config.DeletedUsersDN()); err != nil {
+		m.logger.WithError(err).Error("Failed to move user to deleted users")
+		m.rollback(conn, changes)
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	deletedDN := m.deletedUserDN(uid)
+	changes.summary.Modified = append(changes.summary.Modified, &models.EntryChange{DN: entry.DN, Change: "moved to " + deletedDN})
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":      uid,
+		"modified": len(changes.summary.Modified),
+	}).Info("User soft deleted successfully")
+	return &changes.summary, nil
+}
+
+// RestoreUser moves a soft-deleted user back into the groups and department
+// manager slots it was removed from. The account stays disabled until enabled.
+func (m *Manager) RestoreUser(ctx context.Context, uid string) (*models.User, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readDeletedUser(conn, uid)
+	if err != nil {
+		return nil, err
+	}
+	if m.retentionElapsed(entry, time.Now()) {
+		return nil, fmt.Errorf("deleted user %s is past its retention period", uid)
+	}
+
+	m.logger.WithField("uid", uid).Info("Restoring user")
+
+	rdn := "uid=" + ldap.EscapeDN(uid)
+	if err := conn.ModifyDN(ldap.NewModifyDNRequest(entry.DN, rdn, true, m.config.UsersDN())); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return nil, fmt.Errorf("uid %s has been taken by another user", uid)
+		}
+		m.logger.WithError(err).Error("Failed to restore user")
+		return nil, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	references := entry.GetAttributeValues(removedReferenceAttr)
+	restored := m.restoreUserReferences(conn, uid, references)
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	modifyRequest.Replace("shadowExpire", []string{disabledShadowExpire})
+	if len(references) > 0 {
+		modifyRequest.Replace(removedReferenceAttr, []string{})
+	}
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to disable restored user")
+		return nil, fmt.Errorf("failed to disable restored user: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":        uid,
+		"references": restored,
+	}).Info("User restored successfully")
+	return m.GetUser(withProvider(ctx), uid)
+}
+
+// PurgeDeletedUsers removes soft-deleted users whose retention period has
+// passed and returns how many were removed
+func (m *Manager) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DeletedUsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=inetOrgPerson)",
+		[]string{"uid", "shadowExpire"},
+		nil,
+	)
+
+	now := time.Now()
+	var expired []*ldap.Entry
+	err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		if m.retentionElapsed(entry, now) {
+			expired = append(expired, entry)
+		}
+		return true
+	})
+	if err != nil {
+		if isNoSuchObject(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	purged := 0
+	for _, entry := range expired {
+		if err := conn.Del(ldap.NewDelRequest(entry.DN, nil)); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			m.logger.WithError(err).WithField("dn", entry.DN).Error("Failed to purge deleted user")
+			continue
+		}
+		purged++
+		m.logger.WithField("uid", entry.GetAttributeValue("uid")).Info("Deleted user purged")
+	}
+	return purged, nil
+}
+
+// StartPurge runs PurgeDeletedUsers every interval until the manager is closed
+func (m *Manager) StartPurge(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := m.PurgeDeletedUsers(context.Background()); err != nil {
+					m.logger.WithError(err).Error("Failed to purge deleted users")
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// retentionElapsed reports whether a soft-deleted entry may be purged. The
+// deletion day is stored with day precision, so purging happens up to a day late.
+func (m *Manager) retentionElapsed(entry *ldap.Entry, now time.Time) bool {
+	deletedAt := shadowDate(entry.GetAttributeValue("shadowExpire"))
+	if deletedAt == nil {
+		return false
+	}
+	return now.After(deletedAt.Add(24 * time.Hour).Add(m.config.DeletedUserRetention))
+}
+
+// removeUserReferences takes a user out of every group and department it is referenced by
+func (m *Manager) removeUserReferences(conn *ldap.Conn, uid string, changes *changeSet) error {
+	userDN := m.config.UserDN(uid)
+
+	groups, err := m.searchGroups(conn, fmt.Sprintf("(&%s%s)", groupFilter, m.memberFilter(uid)), groupAttributes)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		modifyRequest, undoRequest := m.memberRemoval(group, uid)
+		if err := changes.modify(conn, modifyRequest, undoRequest, "removed member "+uid); err != nil {
+			return fmt.Errorf("failed to remove user from group %s: %w", group.GetAttributeValue("cn"), err)
+		}
+	}
+
+	managed, err := m.searchDepartmentsByFilter(conn, fmt.Sprintf("(manager=%s)", ldap.EscapeFilter(userDN)))
+	if err != nil {
+		return err
+	}
+	for _, dept := range managed {
+		modifyRequest := ldap.NewModifyRequest(dept.DN, nil)
+		modifyRequest.Delete("manager", []string{userDN})
+		undoRequest := ldap.NewModifyRequest(dept.DN, nil)
+		undoRequest.Add("manager", []string{userDN})
+		if err := changes.modify(conn, modifyRequest, undoRequest, "removed manager "+uid); err != nil {
+			return fmt.Errorf("failed to clear manager of department %s: %w", dept.GetAttributeValue("ou"), err)
+		}
+	}
+	return nil
+}
+
+// restoreUserReferences puts a restored user back into the groups and
+// departments removeUserReferences took it out of, and returns how many it
+// restored. Groups and departments removed since, and departments that got
+// another manager, are skipped.
+func (m *Manager) restoreUserReferences(conn *ldap.Conn, uid string, references []string) int {
+	userDN := m.config.UserDN(uid)
+	restored := 0
+	for _, dn := range references {
+		logger := m.logger.WithFields(logrus.Fields{"uid": uid, "dn": dn})
+
+		var modifyRequest *ldap.ModifyRequest
+		if cn := childRDNValue(dn, "cn", m.config.GroupsDN()); cn != "" {
+			group, err := m.readGroup(conn, cn)
+			if err != nil {
+				logger.WithError(err).Warn("Group of restored user is gone, not adding it back")
+				continue
+			}
+			modifyRequest, _ = m.memberAddition(group, uid)
+		} else {
+			dept, err := m.readDepartment(conn, firstRDNValue(dn))
+			if err != nil {
+				logger.WithError(err).Warn("Department of restored user is gone, not restoring its manager")
+				continue
+			}
+			if manager := dept.GetAttributeValue("manager"); manager != "" {
+				if !containsDN([]string{manager}, userDN) {
+					logger.Info("Department got another manager, not restoring the restored user as its manager")
+				}
+				continue
+			}
+			modifyRequest = ldap.NewModifyRequest(dept.DN, nil)
+			modifyRequest.Add("manager", []string{userDN})
+		}
+
+		if len(modifyRequest.Changes) == 0 {
+			continue
+		}
+		if err := conn.Modify(modifyRequest); err != nil {
+			logger.WithError(err).Error("Failed to restore reference to user")
+			continue
+		}
+		restored++
+	}
+	return restored
+}
+
+// firstRDNValue returns the value of the first RDN of dn
+func firstRDNValue(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// moveUserEntry moves a user entry below newParent, creating the container if it is missing
+func (m *Manager) moveUserEntry(conn *ldap.Conn, dn, uid, newParent string) error {
+	rdn := "uid=" + ldap.EscapeDN(uid)
+	err := conn.ModifyDN(ldap.NewModifyDNRequest(dn, rdn, true, newParent))
+	if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return err
+	}
+
+	// The user entry exists, so a missing object is the container
+	addRequest := ldap.NewAddRequest(newParent, nil)
+	addRequest.Attribute("objectClass", []string{"organizationalUnit"})
+	addRequest.Attribute("ou", []string{"deleted"})
+	if err := conn.Add(addRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+		return fmt.Errorf("failed to create %s: %w", newParent, err)
+	}
+	return conn.ModifyDN(ldap.NewModifyDNRequest(dn, rdn, true, newParent))
+}
+
+// deletedUserDN returns the DN a user has while soft-deleted
+func (m *Manager) deletedUserDN(uid string) string {
+	return "uid=" + ldap.EscapeDN(uid) + "," + m.config.DeletedUsersDN()
+}
+
+// isDeletedUserDN reports whether dn is a soft-deleted user
+func (m *Manager) isDeletedUserDN(dn string) bool {
+	return childRDNValue(dn, "uid", m.config.DeletedUsersDN()) != ""
+}
+
+// readDeletedUser reads the entry of a soft-deleted user, with the references
+// removed on deletion
+func (m *Manager) readDeletedUser(conn *ldap.Conn, uid string) (*ldap.Entry, error) {
+	entry, err := m.readUserEntry(conn, m.deletedUserDN(uid), uid, removedReferenceAttr)
+	if err != nil {
+		return nil, fmt.Errorf("deleted user not found: %s", uid)
+	}
+	return entry, nil
+}
+
+// readUserEntry reads a user entry by DN, with extra attributes besides the user attributes
+func (m *Manager) readUserEntry(conn *ldap.Conn, dn, uid string, extra ...string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=inetOrgPerson)",
+		append(append([]string{}, userAttributes...), extra...),
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("user not found: %s", uid)
+		}
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("user not found: %s", uid)
+	}
+	return result.Entries[0], nil
+}
+
+// userSearchBase returns where a users search looks: the deleted users
+// container for deleted users, the users OU otherwise
+func (m *Manager) userSearchBase(filter *models.SearchFilter) string {
+	if filter != nil && filter.Status == models.UserStatusDeleted {
+		return m.config.DeletedUsersDN()
+	}
+	return m.config.UsersDN()
+}
+
+func isNoSuchObject(err error) bool {
+	var ldapErr *ldap.Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject
+}
+
+func isNoSuchAttribute(err error) bool {
+	var ldapErr *ldap.Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchAttribute
+}
+
+func isEntryAlreadyExists(err error) bool {
+	var ldapErr *ldap.Error
+	return errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultEntryAlreadyExists
+}
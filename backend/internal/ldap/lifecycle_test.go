@@ -0,0 +1,33 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShadowExpired(t *testing.T) {
+	// Day 20000 since the epoch
+	now := time.Unix(20000*secondsPerDay+3600, 0)
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{disabledShadowExpire, true},
+		{"19999", true},
+		{"20000", true},
+		{"20001", false},
+		{"-1", false},
+		{"", false},
+		{"never", false},
+	}
+	for _, tt := range tests {
+		if got := shadowExpired(tt.value, now); got != tt.want {
+			t.Errorf("shadowExpired(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	if got, want := expiredFilter(now), "(&(shadowExpire:integerOrderingMatch:=20001)(!(shadowExpire=-1)))"; got != want {
+		t.Errorf("expiredFilter = %s, want %s", got, want)
+	}
+}
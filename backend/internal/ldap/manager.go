@@ -2,14 +2,16 @@ package ldap
 
 import (
 	"context"
-	"net"
 	"fmt"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/devplatform/ldap-manager/internal/config"
 	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
+	"github.com/devplatform/ldap-manager/internal/tlsconfig"
 	ldap "github.com/go-ldap/ldap/v3"
 	"github.com/sirupsen/logrus"
 )
@@ -17,57 +19,104 @@ import (
 // Manager handles LDAP connections and operations
 type Manager struct {
 	config         *config.Config
-	pool           chan *ldap.Conn
-	poolSize       int
+	endpoints      []*endpoint
 	mu             sync.RWMutex
 	closed         bool
 	logger         *logrus.Logger
-	uidCounter     int32
-	gidCounter     int32
+	hasher         *password.Hasher
+	passwordPolicy *password.Policy
 	totalRequests  int64
 	createdAt      time.Time
+
+	// readCursor rotates reads across consumers
+	readCursor uint64
+	// lastWrites maps callers to the time of their last write, for read-your-writes
+	writesMu   sync.Mutex
+	lastWrites map[string]time.Time
+
+	// nestedMode is the resolved LDAP_NESTED_GROUPS mode, set on first use
+	nestedMu   sync.Mutex
+	nestedMode string
+
+	// groupClasses are the object classes of new groups, set on first use
+	groupMu      sync.Mutex
+	groupClasses []string
+
+	// done is closed by Close to stop background jobs
+	done chan struct{}
 }
 
-// NewManager creates a new LDAP manager with connection pool
+// NewManager creates a new LDAP manager with one connection pool per endpoint
 func NewManager(cfg *config.Config, logger *logrus.Logger) (*Manager, error) {
+	hasher, err := password.NewHasher(cfg.PasswordScheme)
+	if err != nil {
+		return nil, err
+	}
+	endpointCfgs, err := cfg.LDAPEndpointList()
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.LDAPNestedGroups {
+	case nestedAuto, nestedInChain, nestedMemberOf, nestedClient:
+	default:
+		return nil, fmt.Errorf("invalid LDAP_NESTED_GROUPS %q", cfg.LDAPNestedGroups)
+	}
+
 	m := &Manager{
-		config:     cfg,
-		pool:       make(chan *ldap.Conn, cfg.LDAPPoolSize),
-		poolSize:   cfg.LDAPPoolSize,
-		logger:     logger,
-		uidCounter: int32(cfg.StartingUID),
-		gidCounter: int32(cfg.StartingGID),
+		config: cfg,
+		logger: logger,
+		hasher: hasher,
+		passwordPolicy: &password.Policy{
+			MinLength:   cfg.PasswordMinLength,
+			MinClasses:  cfg.PasswordMinClasses,
+			BannedWords: cfg.PasswordBannedWords,
+			HistorySize: cfg.PasswordHistory,
+		},
 		createdAt:  time.Now(),
+		lastWrites: make(map[string]time.Time),
+		done:       make(chan struct{}),
 	}
 
-	// Pre-populate the connection pool
-	for i := 0; i < cfg.LDAPPoolSize; i++ {
-		conn, err := m.createConnection()
+	for _, endpointCfg := range endpointCfgs {
+		tlsCfg, err := tlsconfig.LDAP(cfg, endpointCfg.URL)
 		if err != nil {
-			m.logger.WithError(err).Error("Failed to create initial connection")
-			// Close existing connections
-			close(m.pool)
-			for c := range m.pool {
-				c.Close()
-			}
-			return nil, fmt.Errorf("failed to initialize connection pool: %w", err)
+			return nil, err
 		}
-		m.pool <- conn
+		ep := &endpoint{url: endpointCfg.URL, role: endpointCfg.Role, tlsConfig: tlsCfg}
+
+		// Connections are opened in the background, so the manager starts even
+		// while the directory is unreachable
+		ep.pool = newPool(poolConfig{
+			MinSize:        cfg.LDAPPoolMinSize,
+			MaxSize:        cfg.LDAPPoolSize,
+			WaitTimeout:    cfg.LDAPPoolTimeout,
+			IdleTimeout:    cfg.LDAPPoolIdleTimeout,
+			MaxLifetime:    cfg.LDAPMaxConnLifetime,
+			HealthInterval: cfg.LDAPHealthCheckInterval,
+			BackoffBase:    cfg.LDAPReconnectBackoffBase,
+			BackoffMax:     cfg.LDAPReconnectBackoffMax,
+		}, func() (*ldap.Conn, error) { return m.createConnection(ep) }, logger.WithFields(logrus.Fields{
+			"endpoint": ep.url,
+			"role":     ep.role,
+		}))
+		m.endpoints = append(m.endpoints, ep)
 	}
 
-	m.logger.WithField("pool_size", cfg.LDAPPoolSize).Info("LDAP connection pool initialized")
+	m.logger.WithFields(logrus.Fields{
+		"endpoints": len(m.endpoints),
+		"min_size":  cfg.LDAPPoolMinSize,
+		"max_size":  cfg.LDAPPoolSize,
+	}).Info("LDAP connection pools initialized")
 	return m, nil
 }
 
-// createConnection creates a new LDAP connection
-func (m *Manager) createConnection() (*ldap.Conn, error) {
-	m.logger.WithField("url", m.config.LDAPURL).Debug("Creating new LDAP connection")
+// createConnection creates a new LDAP connection bound as the service account
+func (m *Manager) createConnection(ep *endpoint) (*ldap.Conn, error) {
+	m.logger.WithField("url", ep.url).Debug("Creating new LDAP connection")
 
-	conn, err := ldap.DialURL(m.config.LDAPURL, ldap.DialWithDialer(&net.Dialer{
-		Timeout: m.config.LDAPConnTimeout,
-	}))
+	conn, err := m.dial(ep)
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial LDAP: %w", err)
+		return nil, err
 	}
 
 	// Bind with admin credentials
@@ -80,59 +129,49 @@ func (m *Manager) createConnection() (*ldap.Conn, error) {
 	return conn, nil
 }
 
-// getConnection retrieves a connection from the pool
-func (m *Manager) getConnection(ctx context.Context) (*ldap.Conn, error) {
-	atomic.AddInt64(&m.totalRequests, 1)
+// dial opens a connection to an endpoint, negotiating TLS when configured.
+// It is shared by pooled connections and the bind-only connections of Authenticate.
+func (m *Manager) dial(ep *endpoint) (*ldap.Conn, error) {
+	opts := []ldap.DialOpt{
+		ldap.DialWithDialer(&net.Dialer{Timeout: m.config.LDAPConnTimeout}),
+	}
+	if ep.tlsConfig != nil && !m.config.LDAPStartTLS {
+		opts = append(opts, ldap.DialWithTLSConfig(ep.tlsConfig))
+	}
 
-	m.mu.RLock()
-	if m.closed {
-		m.mu.RUnlock()
-		return nil, fmt.Errorf("connection pool is closed")
+	conn, err := ldap.DialURL(ep.url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial LDAP: %w", err)
 	}
-	m.mu.RUnlock()
 
-	select {
-	case conn := <-m.pool:
-		// Test the connection
-		if !m.testConnection(conn) {
-			m.logger.Debug("Connection test failed, creating new connection")
+	if m.config.LDAPStartTLS {
+		if err := conn.StartTLS(ep.tlsConfig.Clone()); err != nil {
 			conn.Close()
-			newConn, err := m.createConnection()
-			if err != nil {
-				return nil, fmt.Errorf("failed to create new connection: %w", err)
-			}
-			return newConn, nil
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
 		}
-		return conn, nil
-	case <-time.After(m.config.LDAPPoolTimeout):
-		return nil, fmt.Errorf("timeout waiting for connection from pool")
-	case <-ctx.Done():
-		return nil, ctx.Err()
 	}
+
+	return conn, nil
+}
+
+// getConnection retrieves a connection to a provider. It is used for writes
+// and for reads that must see the latest state.
+func (m *Manager) getConnection(ctx context.Context) (*ldap.Conn, error) {
+	return m.connect(ctx, m.writeEndpoints())
 }
 
-// returnConnection returns a connection to the pool
+// returnConnection returns a connection to the pool it came from
 func (m *Manager) returnConnection(conn *ldap.Conn) {
 	if conn == nil {
 		return
 	}
-
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	if m.closed {
-		conn.Close()
-		return
-	}
-
-	// Non-blocking send
-	select {
-	case m.pool <- conn:
-	default:
-		// Pool is full, close the connection
-		m.logger.Warn("Connection pool full, closing connection")
-		conn.Close()
+	for _, ep := range m.endpoints {
+		if ep.pool.owns(conn) {
+			ep.pool.put(conn)
+			return
+		}
 	}
+	conn.Close()
 }
 
 // testConnection tests if a connection is still alive
@@ -158,73 +197,66 @@ func (m *Manager) testConnection(conn *ldap.Conn) bool {
 	return err == nil
 }
 
-// HealthCheck performs a health check on the LDAP connection
+// HealthCheck succeeds when at least one endpoint answers
 func (m *Manager) HealthCheck(ctx context.Context) error {
-	conn, err := m.getConnection(ctx)
-	if err != nil {
-		return fmt.Errorf("health check failed: %w", err)
-	}
-	defer m.returnConnection(conn)
-
-	if !m.testConnection(conn) {
-		return fmt.Errorf("health check failed: connection test failed")
+	var lastErr error
+	for _, status := range m.CheckEndpoints(ctx) {
+		if status.Healthy {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %s", status.URL, status.LastError)
 	}
-
-	return nil
+	return fmt.Errorf("health check failed: %w", lastErr)
 }
 
+// ListUsersPaginated returns one page of users without loading the others
 func (m *Manager) ListUsersPaginated(
 	ctx context.Context,
 	filter *models.SearchFilter,
+	sort *models.UserSort,
 	page int,
 	limit int,
 ) (*models.UserPage, error) {
 
-	users, err := m.ListUsers(ctx, filter)
+	window, err := m.listUserWindow(ctx, filter, sort, (page-1)*limit, limit)
 	if err != nil {
 		return nil, err
 	}
 
-	total := len(users)
-	start := (page - 1) * limit
-	end := start + limit
-
-	if start > total {
-		start = total
-	}
-	if end > total {
-		end = total
-	}
-
-	items := users[start:end]
-
 	return &models.UserPage{
-		Items:       items,
-		Total:       total,
-		Page:        page,
-		Limit:       limit,
-		HasNextPage: end < total,
+		Items:          window.Users,
+		Total:          window.Total,
+		TotalEstimated: window.TotalEstimated,
+		Page:           page,
+		Limit:          limit,
+		HasNextPage:    window.HasMore,
 	}, nil
 }
 
-
-// GetStats returns connection pool statistics
+// GetStats returns connection pool statistics, in total and per endpoint
 func (m *Manager) GetStats() *models.Stats {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	available := len(m.pool)
-	inUse := m.poolSize - available
-
-	return &models.Stats{
-		PoolSize:      m.poolSize,
-		Available:     available,
-		InUse:         inUse,
+	stats := &models.Stats{
 		TotalRequests: int(atomic.LoadInt64(&m.totalRequests)),
+		Healthy:       true,
 	}
+	for _, ep := range m.endpoints {
+		epStats := ep.stats()
+		stats.PoolSize += epStats.PoolSize
+		stats.Open += epStats.Open
+		stats.Available += epStats.Available
+		stats.InUse += epStats.InUse
+		if !epStats.Healthy {
+			stats.Healthy = false
+			if stats.LastError == "" {
+				stats.LastError = epStats.LastError
+			}
+		}
+		stats.Endpoints = append(stats.Endpoints, epStats)
+	}
+	return stats
 }
 
-// Close closes all connections in the pool
+// Close closes the connection pools of all endpoints
 func (m *Manager) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -234,25 +266,12 @@ func (m *Manager) Close() error {
 	}
 
 	m.closed = true
-	close(m.pool)
-
-	// Close all connections
+	close(m.done)
 	count := 0
-	for conn := range m.pool {
-		conn.Close()
-		count++
+	for _, ep := range m.endpoints {
+		count += ep.pool.close()
 	}
 
-	m.logger.WithField("connections_closed", count).Info("LDAP connection pool closed")
+	m.logger.WithField("connections_closed", count).Info("LDAP connection pools closed")
 	return nil
 }
-
-// nextUID returns the next available UID number
-func (m *Manager) nextUID() int {
-	return int(atomic.AddInt32(&m.uidCounter, 1))
-}
-
-// nextGID returns the next available GID number
-func (m *Manager) nextGID() int {
-	return int(atomic.AddInt32(&m.gidCounter, 1))
-}
@@ -0,0 +1,538 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// Migration statuses reported by Migrate
+const (
+	MigrationApplied = "applied"
+	// MigrationPending is reported by dry runs for migrations that would be applied
+	MigrationPending = "pending"
+	// MigrationSkipped migrations could not be applied and are tried again on the next run
+	MigrationSkipped = "skipped"
+	MigrationCurrent = "up to date"
+)
+
+// schemaDN is where the service's schema is added below cn=config. OpenLDAP
+// renames it to cn={N}devplatform once loaded.
+const schemaDN = "cn=devplatform,cn=schema,cn=config"
+
+// schemaOID is the arc the schema's OIDs are taken from. It lies in OpenLDAP's
+// experimental arc, which is fine for a schema private to this service.
+const schemaOID = "1.3.6.1.4.1.4203.666.11.42"
+
+// departmentObjectClass is the auxiliary class of department entries
+const departmentObjectClass = "devplatformDepartment"
+
+// schemaAttributeTypes and schemaObjectClasses define the attributes the
+// service stores beyond the standard schemas. Definitions are only ever
+// appended; a migration running migrateSchema adds them to existing
+// directories.
+var (
+	schemaAttributeTypes = []string{
+		"( devplatform:1.1 NAME 'githubRepository' DESC 'GitHub repository as owner/name' " +
+			"EQUALITY caseExactMatch SUBSTR caseExactSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+		"( devplatform:1.2 NAME 'devplatformExpiresAt' DESC 'Time after which a state entry is discarded' " +
+			"EQUALITY generalizedTimeMatch ORDERING generalizedTimeOrderingMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE )",
+		"( devplatform:1.3 NAME 'devplatformStateData' DESC 'JSON document of a state entry' " +
+			"EQUALITY caseExactMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 SINGLE-VALUE )",
+		"( devplatform:1.4 NAME 'devplatformRemovedReference' DESC 'Group or department a soft-deleted user was removed from' " +
+			"EQUALITY distinguishedNameMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.12 )",
+		"( devplatform:1.5 NAME 'passwordHistory' DESC 'Earlier password hashes of a user, refused as new passwords' " +
+			"EQUALITY octetStringMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.40 )",
+	}
+	schemaObjectClasses = []string{
+		"( devplatform:2.1 NAME 'devplatformDepartment' DESC 'Department of the developer platform' " +
+			"SUP top AUXILIARY MAY ( manager $ githubRepository ) )",
+		"( devplatform:2.2 NAME 'devplatformStateEntry' DESC 'State shared between replicas of the service' " +
+			"SUP top STRUCTURAL MUST cn MAY ( uid $ devplatformExpiresAt $ devplatformStateData ) )",
+	}
+)
+
+// MigrationResult reports what Migrate did, or would do, for one migration
+type MigrationResult struct {
+	Version int
+	Name    string
+	Status  string
+	// Changes describes the writes made, or planned in a dry run
+	Changes []string
+	// Reason explains why a migration was skipped
+	Reason string
+}
+
+// migration is one step in bringing a directory up to date. run must be
+// idempotent: a migration that failed halfway is run again in full.
+type migration struct {
+	version int
+	name    string
+	run     func(m *Manager, ctx context.Context, dryRun bool) ([]string, error)
+}
+
+// migrations in the order they are applied. Versions are recorded in the
+// directory, so they must never be renumbered.
+var migrations = []migration{
+	{1, "directory schema", (*Manager).migrateSchema},
+	{2, "base organizational units", (*Manager).migrateBaseOUs},
+	{3, "department object class", (*Manager).migrateDepartmentClass},
+	{4, "state schema", (*Manager).migrateSchema},
+	{5, "deleted user references", (*Manager).migrateSchema},
+	{6, "password history", (*Manager).migrateSchema},
+}
+
+// skippedError stops a migration that cannot be applied with the current
+// credentials without failing the others
+type skippedError struct {
+	reason string
+}
+
+func (e *skippedError) Error() string {
+	return e.reason
+}
+
+// Migrate applies the migrations not yet recorded in the directory. With
+// dryRun it only reports the changes it would make.
+func (m *Manager) Migrate(ctx context.Context, dryRun bool) ([]*MigrationResult, error) {
+	applied, err := m.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*MigrationResult
+	for _, mig := range migrations {
+		result := &MigrationResult{Version: mig.version, Name: mig.name}
+		results = append(results, result)
+		if applied[mig.version] {
+			result.Status = MigrationCurrent
+			continue
+		}
+
+		changes, err := mig.run(m, ctx, dryRun)
+		result.Changes = changes
+		var skipped *skippedError
+		switch {
+		case errors.As(err, &skipped):
+			result.Status = MigrationSkipped
+			result.Reason = skipped.reason
+			continue
+		case err != nil:
+			return results, fmt.Errorf("migration %d (%s) failed: %w", mig.version, mig.name, err)
+		case dryRun:
+			result.Status = MigrationPending
+			continue
+		}
+
+		if err := m.recordMigration(ctx, mig); err != nil {
+			return results, err
+		}
+		result.Status = MigrationApplied
+		m.logger.WithFields(logrus.Fields{
+			"version": mig.version,
+			"name":    mig.name,
+			"changes": len(changes),
+		}).Info("Migration applied")
+	}
+	return results, nil
+}
+
+// appliedMigrations returns the versions recorded in the migrations entry
+func (m *Manager) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.MigrationsDN(),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"description"},
+		nil,
+	)
+
+	applied := make(map[int]bool)
+	result, err := conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return applied, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for _, entry := range result.Entries {
+		for _, value := range entry.GetAttributeValues("description") {
+			// Values are "<version> <name>"
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				continue
+			}
+			if version, err := strconv.Atoi(fields[0]); err == nil {
+				applied[version] = true
+			}
+		}
+	}
+	return applied, nil
+}
+
+// recordMigration adds a migration to the migrations entry, creating it on first use
+func (m *Manager) recordMigration(ctx context.Context, mig migration) error {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	value := fmt.Sprintf("%d %s", mig.version, mig.name)
+	modifyRequest := ldap.NewModifyRequest(m.config.MigrationsDN(), nil)
+	modifyRequest.Add("description", []string{value})
+	err = conn.Modify(modifyRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		addRequest := ldap.NewAddRequest(m.config.MigrationsDN(), nil)
+		addRequest.Attribute("objectClass", []string{"applicationProcess"})
+		addRequest.Attribute("cn", []string{config.MigrationsCN})
+		addRequest.Attribute("description", []string{value})
+		err = conn.Add(addRequest)
+	}
+	// A concurrent run may have recorded it first
+	if err != nil && !ldap.IsErrorAnyOf(err, ldap.LDAPResultAttributeOrValueExists, ldap.LDAPResultEntryAlreadyExists) {
+		return fmt.Errorf("failed to record migration %d: %w", mig.version, err)
+	}
+	return nil
+}
+
+// migrateSchema installs the service's schema through cn=config on every
+// endpoint, consumers included, since they must know the attributes they
+// replicate
+func (m *Manager) migrateSchema(ctx context.Context, dryRun bool) ([]string, error) {
+	bindDN, bindPassword := m.config.LDAPConfigBindDN, m.config.LDAPConfigBindPassword
+	if bindDN == "" {
+		bindDN, bindPassword = m.config.LDAPBindDN, m.config.LDAPBindPassword
+	}
+
+	var changes []string
+	for _, ep := range m.endpoints {
+		if err := ctx.Err(); err != nil {
+			return changes, err
+		}
+		change, err := m.installSchema(ep, bindDN, bindPassword, dryRun)
+		if err != nil {
+			return changes, err
+		}
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+// installSchema adds the schema to one endpoint, or the definitions missing
+// from an earlier version of it, returning the change made
+func (m *Manager) installSchema(ep *endpoint, bindDN, bindPassword string, dryRun bool) (string, error) {
+	conn, err := m.dial(ep)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s: %w", ep.url, err)
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(bindDN, bindPassword); err != nil {
+		return "", fmt.Errorf("failed to bind to %s as %s: %w", ep.url, bindDN, err)
+	}
+
+	loaded, err := schemaLoaded(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read schema of %s: %w", ep.url, err)
+	}
+	if loaded {
+		return "", nil
+	}
+
+	// OpenLDAP hides entries the bind DN may not read, so a missing
+	// cn=schema,cn=config can as well mean no access
+	searchRequest := ldap.NewSearchRequest(
+		"cn=schema,cn=config",
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(|(cn=devplatform)(cn=*}devplatform))",
+		[]string{"olcAttributeTypes", "olcObjectClasses"},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorAnyOf(err, ldap.LDAPResultNoSuchObject, ldap.LDAPResultInsufficientAccessRights) {
+			return "", &skippedError{reason: fmt.Sprintf("cn=config on %s is not available to %s; set LDAP_CONFIG_BIND_DN or load the schema by hand", ep.url, bindDN)}
+		}
+		return "", fmt.Errorf("failed to read cn=config of %s: %w", ep.url, err)
+	}
+
+	var change string
+	var write func() error
+	if len(result.Entries) == 0 {
+		change = fmt.Sprintf("add %s on %s", schemaDN, ep.url)
+		write = func() error {
+			addRequest := ldap.NewAddRequest(schemaDN, nil)
+			addRequest.Attribute("objectClass", []string{"olcSchemaConfig"})
+			addRequest.Attribute("cn", []string{"devplatform"})
+			addRequest.Attribute("olcObjectIdentifier", []string{"devplatform " + schemaOID})
+			addRequest.Attribute("olcAttributeTypes", schemaAttributeTypes)
+			addRequest.Attribute("olcObjectClasses", schemaObjectClasses)
+			return conn.Add(addRequest)
+		}
+	} else {
+		// An earlier version of the schema is loaded; attribute types go
+		// first since the new object classes refer to them
+		entry := result.Entries[0]
+		attributeTypes := missingDefinitions(entry.GetAttributeValues("olcAttributeTypes"), schemaAttributeTypes)
+		objectClasses := missingDefinitions(entry.GetAttributeValues("olcObjectClasses"), schemaObjectClasses)
+		change = fmt.Sprintf("add %d attribute types and %d object classes to %s on %s", len(attributeTypes), len(objectClasses), entry.DN, ep.url)
+		write = func() error {
+			modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+			if len(attributeTypes) > 0 {
+				modifyRequest.Add("olcAttributeTypes", attributeTypes)
+			}
+			if len(objectClasses) > 0 {
+				modifyRequest.Add("olcObjectClasses", objectClasses)
+			}
+			return conn.Modify(modifyRequest)
+		}
+	}
+	if dryRun {
+		return change, nil
+	}
+
+	err = write()
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInsufficientAccessRights) {
+		return "", &skippedError{reason: fmt.Sprintf("%s may not change cn=config on %s; set LDAP_CONFIG_BIND_DN or load the schema by hand", bindDN, ep.url)}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to add schema to %s: %w", ep.url, err)
+	}
+	return change, nil
+}
+
+// schemaLoaded reports whether the subschema of a server defines the
+// service's attribute types and object classes
+func schemaLoaded(conn *ldap.Conn) (bool, error) {
+	subschema, err := readSubschema(conn)
+	if err != nil || subschema == nil {
+		return false, err
+	}
+	return definesNames(subschema.GetAttributeValues("attributeTypes"), schemaAttributeTypes) &&
+		definesNames(subschema.GetAttributeValues("objectClasses"), schemaObjectClasses), nil
+}
+
+// readSubschema returns the subschema entry of a server, or nil if it
+// publishes none
+func readSubschema(conn *ldap.Conn) (*ldap.Entry, error) {
+	rootDSE, err := conn.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"subschemaSubentry"}, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(rootDSE.Entries) == 0 || rootDSE.Entries[0].GetAttributeValue("subschemaSubentry") == "" {
+		return nil, nil
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		rootDSE.Entries[0].GetAttributeValue("subschemaSubentry"),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=subschema)",
+		[]string{"attributeTypes", "objectClasses"},
+		nil,
+	))
+	if err != nil || len(result.Entries) == 0 {
+		return nil, err
+	}
+	return result.Entries[0], nil
+}
+
+// definesNames reports whether the NAMEs of all wanted definitions appear in definitions
+func definesNames(definitions, wanted []string) bool {
+	for _, want := range wanted {
+		name := definitionName(want)
+		found := false
+		for _, definition := range definitions {
+			if strings.EqualFold(definitionName(definition), name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// missingDefinitions returns the wanted definitions whose NAME does not appear in definitions
+func missingDefinitions(definitions, wanted []string) []string {
+	var missing []string
+	for _, want := range wanted {
+		if !definesNames(definitions, []string{want}) {
+			missing = append(missing, want)
+		}
+	}
+	return missing
+}
+
+// findDefinition returns the definition called name, or "" if there is none
+func findDefinition(definitions []string, name string) string {
+	for _, definition := range definitions {
+		if strings.EqualFold(definitionName(definition), name) {
+			return definition
+		}
+	}
+	return ""
+}
+
+// definitionHasKeyword reports whether a definition carries a bare keyword
+// such as AUXILIARY
+func definitionHasKeyword(definition, keyword string) bool {
+	for _, field := range strings.Fields(definition) {
+		if field == keyword {
+			return true
+		}
+	}
+	return false
+}
+
+// definitionName returns the first NAME of an RFC 4512 schema definition
+func definitionName(definition string) string {
+	_, rest, ok := strings.Cut(definition, "NAME ")
+	if !ok {
+		return ""
+	}
+	rest = strings.TrimLeft(rest, "( ")
+	name, _, _ := strings.Cut(strings.TrimPrefix(rest, "'"), "'")
+	return name
+}
+
+// migrateBaseOUs creates the containers of users, groups and departments
+func (m *Manager) migrateBaseOUs(ctx context.Context, dryRun bool) ([]string, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	containers := []struct {
+		dn          string
+		ou          string
+		description string
+	}{
+		{m.config.UsersDN(), "users", "Users"},
+		{m.config.GroupsDN(), "groups", "Groups"},
+		{m.config.DepartmentsDN(), "departments", "Departments"},
+	}
+
+	var changes []string
+	for _, container := range containers {
+		searchRequest := ldap.NewSearchRequest(container.dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"1.1"}, nil)
+		_, err := conn.Search(searchRequest)
+		if err == nil {
+			continue
+		}
+		if !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return changes, fmt.Errorf("failed to look up %s: %w", container.dn, err)
+		}
+
+		changes = append(changes, "add "+container.dn)
+		if dryRun {
+			continue
+		}
+		addRequest := ldap.NewAddRequest(container.dn, nil)
+		addRequest.Attribute("objectClass", []string{"organizationalUnit"})
+		addRequest.Attribute("ou", []string{container.ou})
+		addRequest.Attribute("description", []string{container.description})
+		if err := conn.Add(addRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return changes, fmt.Errorf("failed to create %s: %w", container.dn, err)
+		}
+	}
+	return changes, nil
+}
+
+// migrateDepartmentClass gives departments created with extensibleObject,
+// before the schema existed, the devplatformDepartment class instead
+func (m *Manager) migrateDepartmentClass(ctx context.Context, dryRun bool) ([]string, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	loaded, err := schemaLoaded(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	if !loaded {
+		return nil, &skippedError{reason: "the directory schema is not loaded yet, see migration 1"}
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.DepartmentsDN(),
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&(objectClass=organizationalUnit)(!(objectClass=%s)))", departmentObjectClass),
+		[]string{"objectClass"},
+		nil,
+	)
+	var entries []*ldap.Entry
+	err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	// Without a departments container there is nothing to convert
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) && ldapErr.ResultCode == ldap.LDAPResultNoSuchObject {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for _, entry := range entries {
+		if normalizeDN(entry.DN) == normalizeDN(m.config.DepartmentsDN()) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return changes, err
+		}
+
+		changes = append(changes, fmt.Sprintf("add objectClass %s to %s", departmentObjectClass, entry.DN))
+		if dryRun {
+			continue
+		}
+		modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+		modifyRequest.Add("objectClass", []string{departmentObjectClass})
+		for _, class := range entry.GetAttributeValues("objectClass") {
+			if strings.EqualFold(class, "extensibleObject") {
+				modifyRequest.Delete("objectClass", []string{class})
+			}
+		}
+		if err := conn.Modify(modifyRequest); err != nil {
+			return changes, fmt.Errorf("failed to update %s: %w", entry.DN, err)
+		}
+	}
+	return changes, nil
+}
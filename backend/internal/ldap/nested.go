@@ -0,0 +1,383 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// LDAP_NESTED_GROUPS modes
+const (
+	nestedAuto     = "auto"
+	nestedInChain  = "in_chain"
+	nestedMemberOf = "memberof"
+	nestedClient   = "client"
+)
+
+// matchingRuleInChain is LDAP_MATCHING_RULE_IN_CHAIN. A member or memberOf
+// filter using it follows nested groups on the server.
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// activeDirectoryCapability is advertised in the root DSE by Active Directory,
+// which evaluates matchingRuleInChain. OpenLDAP ignores unknown matching rules
+// and silently matches nothing, so in_chain is never guessed for other servers.
+const activeDirectoryCapability = "1.2.840.113556.1.4.800"
+
+// groupWalkBatch bounds the number of alternatives in one filter of a group walk
+const groupWalkBatch = 100
+
+// resolveNestedMode returns the configured nested group mode, asking the
+// server on first use in auto mode
+func (m *Manager) resolveNestedMode(conn *ldap.Conn) string {
+	m.nestedMu.Lock()
+	defer m.nestedMu.Unlock()
+
+	if m.nestedMode != "" {
+		return m.nestedMode
+	}
+	if m.config.LDAPNestedGroups != nestedAuto {
+		m.nestedMode = m.config.LDAPNestedGroups
+		return m.nestedMode
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"supportedCapabilities"},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil || len(result.Entries) == 0 {
+		// Not cached, the next lookup asks again
+		m.logger.WithError(err).Debug("Failed to read root DSE, resolving nested groups on the client")
+		return nestedClient
+	}
+
+	m.nestedMode = nestedClient
+	if containsFold(result.Entries[0].GetAttributeValues("supportedCapabilities"), activeDirectoryCapability) {
+		m.nestedMode = nestedInChain
+	}
+	m.logger.WithField("mode", m.nestedMode).Info("Resolved nested group mode")
+	return m.nestedMode
+}
+
+// EffectiveGroups returns every group a user belongs to, directly or through
+// nested groups
+func (m *Manager) EffectiveGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	var entries []*ldap.Entry
+	switch m.resolveNestedMode(conn) {
+	case nestedInChain:
+		entries, err = m.searchGroups(conn, fmt.Sprintf("(&%s(|(member:%s:=%s)(memberUid=%s)))",
+			groupFilter, matchingRuleInChain, ldap.EscapeFilter(m.config.UserDN(uid)), ldap.EscapeFilter(uid)), groupAttributes)
+	case nestedMemberOf:
+		entries, err = m.effectiveGroupsByMemberOf(conn, uid)
+	default:
+		// Start with the direct groups, then look for groups listing the groups found so far
+		entries, err = m.walkGroups(conn, []string{m.memberFilter(uid)}, groupAttributes, func(entry *ldap.Entry) []string {
+			return []string{fmt.Sprintf("(member=%s)", ldap.EscapeFilter(entry.DN))}
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*models.Group, 0, len(entries))
+	for _, entry := range entries {
+		groups = append(groups, m.entryToGroup(entry))
+	}
+	return groups, nil
+}
+
+// effectiveGroupsByMemberOf follows memberOf upwards from the user entry.
+// The overlay only tracks member, so groups listing the user in memberUid alone are missed.
+func (m *Manager) effectiveGroupsByMemberOf(conn *ldap.Conn, uid string) ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UserDN(uid),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"memberOf"},
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, fmt.Errorf("user not found: %s", uid)
+		}
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("user not found: %s", uid)
+	}
+
+	attributes := append(append([]string{}, groupAttributes...), "memberOf")
+	return m.walkGroups(conn, m.groupTerms(result.Entries[0].GetAttributeValues("memberOf")), attributes, func(entry *ldap.Entry) []string {
+		return m.groupTerms(entry.GetAttributeValues("memberOf"))
+	})
+}
+
+// EffectiveMembers returns the uids of every user in a group, directly or
+// through nested groups
+func (m *Manager) EffectiveMembers(ctx context.Context, cn string) ([]string, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	if m.resolveNestedMode(conn) == nestedInChain {
+		entry, err := m.readGroup(conn, cn)
+		if err != nil {
+			return nil, err
+		}
+		uids := m.entryToGroup(entry).Members
+
+		searchRequest := ldap.NewSearchRequest(
+			m.config.UsersDN(),
+			ldap.ScopeSingleLevel,
+			ldap.NeverDerefAliases,
+			0,
+			0,
+			false,
+			fmt.Sprintf("(&(objectClass=inetOrgPerson)(memberOf:%s:=%s))", matchingRuleInChain, ldap.EscapeFilter(entry.DN)),
+			[]string{"uid"},
+			nil,
+		)
+		err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(user *ldap.Entry) bool {
+			if uid := user.GetAttributeValue("uid"); !containsFold(uids, uid) {
+				uids = append(uids, uid)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		return uids, nil
+	}
+
+	// memberOf points upwards only, so the memberof mode walks member lists too
+	entries, err := m.walkGroups(conn, []string{fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(cn))}, groupAttributes, func(entry *ldap.Entry) []string {
+		return m.groupTerms(entry.GetAttributeValues("member"))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("group not found: %s", cn)
+	}
+
+	uids := []string{}
+	for _, entry := range entries {
+		for _, uid := range m.entryToGroup(entry).Members {
+			if !containsFold(uids, uid) {
+				uids = append(uids, uid)
+			}
+		}
+	}
+	return uids, nil
+}
+
+// AddGroupToGroup makes a group a member of another group. Memberships that
+// would make a group contain itself are rejected.
+func (m *Manager) AddGroupToGroup(ctx context.Context, groupCN, parentCN string) error {
+	if strings.EqualFold(groupCN, parentCN) {
+		return fmt.Errorf("group %s cannot be a member of itself", groupCN)
+	}
+
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	parent, err := m.readGroup(conn, parentCN)
+	if err != nil {
+		return err
+	}
+	child, err := m.readGroup(conn, groupCN)
+	if err != nil {
+		return err
+	}
+
+	// The parent must not already be nested inside the group
+	nested, err := m.walkGroups(conn, m.groupTerms(child.GetAttributeValues("member")), groupAttributes, func(entry *ldap.Entry) []string {
+		return m.groupTerms(entry.GetAttributeValues("member"))
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range nested {
+		if strings.EqualFold(entry.GetAttributeValue("cn"), parentCN) {
+			return fmt.Errorf("adding group %s to %s would create a cycle", groupCN, parentCN)
+		}
+	}
+
+	modifyRequest := ldap.NewModifyRequest(parent.DN, nil)
+	if !containsDN(parent.GetAttributeValues("member"), child.DN) {
+		modifyRequest.Add("member", []string{child.DN})
+	}
+	if containsDN(parent.GetAttributeValues("member"), m.legacyPlaceholderDN()) {
+		modifyRequest.Delete("member", []string{m.legacyPlaceholderDN()})
+	}
+	if len(modifyRequest.Changes) == 0 {
+		return nil
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"group":  groupCN,
+		"parent": parentCN,
+	}).Info("Adding group to group")
+
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to add group to group")
+		return fmt.Errorf("failed to add group to group: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"group":  groupCN,
+		"parent": parentCN,
+	}).Info("Group added to group successfully")
+	return nil
+}
+
+// RemoveGroupFromGroup removes a nested group from its parent
+func (m *Manager) RemoveGroupFromGroup(ctx context.Context, groupCN, parentCN string) error {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	parent, err := m.readGroup(conn, parentCN)
+	if err != nil {
+		return err
+	}
+	groupDN := m.config.GroupDN(groupCN)
+	if !containsDN(parent.GetAttributeValues("member"), groupDN) {
+		return fmt.Errorf("group %s is not a member of group %s", groupCN, parentCN)
+	}
+	if isLegacyGroup(parent) && len(parent.GetAttributeValues("member")) == 1 {
+		return fmt.Errorf("group %s is a groupOfNames group and cannot be left empty", parentCN)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"group":  groupCN,
+		"parent": parentCN,
+	}).Info("Removing group from group")
+
+	modifyRequest := ldap.NewModifyRequest(parent.DN, nil)
+	modifyRequest.Delete("member", []string{groupDN})
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).Error("Failed to remove group from group")
+		return fmt.Errorf("failed to remove group from group: %w", err)
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"group":  groupCN,
+		"parent": parentCN,
+	}).Info("Group removed from group successfully")
+	return nil
+}
+
+// walkGroups reads groups level by level, starting with the groups matching
+// any of terms. next returns the filter terms for the groups one level
+// further out. Groups already seen are not expanded again, which ends the
+// walk at cycles.
+func (m *Manager) walkGroups(conn *ldap.Conn, terms []string, attributes []string, next func(*ldap.Entry) []string) ([]*ldap.Entry, error) {
+	seen := make(map[string]bool)
+	var groups []*ldap.Entry
+
+	for len(terms) > 0 {
+		var level []*ldap.Entry
+		for start := 0; start < len(terms); start += groupWalkBatch {
+			end := min(start+groupWalkBatch, len(terms))
+			entries, err := m.searchGroups(conn, fmt.Sprintf("(&%s(|%s))", groupFilter, strings.Join(terms[start:end], "")), attributes)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				key := normalizeDN(entry.DN)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				level = append(level, entry)
+			}
+		}
+
+		groups = append(groups, level...)
+		terms = nil
+		for _, entry := range level {
+			terms = append(terms, next(entry)...)
+		}
+	}
+	return groups, nil
+}
+
+// searchGroups returns all groups matching filter
+func (m *Manager) searchGroups(conn *ldap.Conn, filter string, attributes []string) ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.GroupsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	var entries []*ldap.Entry
+	err := m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	return entries, err
+}
+
+// groupTerms returns cn filter terms for the DNs that name groups
+func (m *Manager) groupTerms(dns []string) []string {
+	var terms []string
+	for _, dn := range dns {
+		if cn := m.memberGroupCN(dn); cn != "" {
+			terms = append(terms, fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(cn)))
+		}
+	}
+	return terms
+}
+
+// normalizeDN returns a key under which equal DNs compare equal
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, attr := range rdn.Attributes {
+			attrs = append(attrs, strings.ToLower(attr.Type)+"="+strings.ToLower(attr.Value))
+		}
+		rdns = append(rdns, strings.Join(attrs, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
@@ -4,22 +4,50 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
 	ldap "github.com/go-ldap/ldap/v3"
 	"github.com/sirupsen/logrus"
 )
 
 // CreateUser creates a new user in LDAP
 func (m *Manager) CreateUser(ctx context.Context, input *models.CreateUserInput) (*models.User, error) {
-	conn, err := m.getConnection(ctx)
+	hashedPassword := input.PasswordHash
+	if hashedPassword == "" {
+		var err error
+		hashedPassword, err = m.PreparePassword(input.Password, password.Subject{
+			UID:       input.UID,
+			CN:        input.CN,
+			SN:        input.SN,
+			GivenName: input.GivenName,
+			Mail:      input.Mail,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	uidNumber := m.nextUID()
-	gidNumber := m.nextGID()
+	// A soft-deleted user keeps its uid until purged, so it can be restored
+	if _, err := m.readDeletedUser(conn, input.UID); err == nil {
+		return nil, fmt.Errorf("uid %s belongs to a deleted user; restore or purge it first", input.UID)
+	}
+
+	uidNumber, err := m.nextUID(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate uidNumber: %w", err)
+	}
+	gidNumber, err := m.nextGID(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate gidNumber: %w", err)
+	}
 	userDN := m.config.UserDN(input.UID)
 
 	m.logger.WithFields(logrus.Fields{
@@ -39,7 +67,7 @@ func (m *Manager) CreateUser(ctx context.Context, input *models.CreateUserInput)
 	addRequest.Attribute("uidNumber", []string{fmt.Sprintf("%d", uidNumber)})
 	addRequest.Attribute("gidNumber", []string{fmt.Sprintf("%d", gidNumber)})
 	addRequest.Attribute("homeDirectory", []string{fmt.Sprintf("/home/%s", input.UID)})
-	addRequest.Attribute("userPassword", []string{input.Password})
+	addRequest.Attribute("userPassword", []string{hashedPassword})
 
 	if len(input.Repositories) > 0 {
 		addRequest.Attribute("githubRepository", input.Repositories)
@@ -51,12 +79,12 @@ func (m *Manager) CreateUser(ctx context.Context, input *models.CreateUserInput)
 	}
 
 	m.logger.WithField("uid", input.UID).Info("User created successfully")
-	return m.GetUser(ctx, input.UID)
+	return m.GetUser(withProvider(ctx), input.UID)
 }
 
 // GetUser retrieves a user by UID
 func (m *Manager) GetUser(ctx context.Context, uid string) (*models.User, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
@@ -70,7 +98,7 @@ func (m *Manager) GetUser(ctx context.Context, uid string) (*models.User, error)
 		0,
 		false,
 		fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid)),
-		[]string{"uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "gidNumber", "homeDirectory", "githubRepository"},
+		userAttributes,
 		nil,
 	)
 
@@ -88,51 +116,50 @@ func (m *Manager) GetUser(ctx context.Context, uid string) (*models.User, error)
 
 // ListUsers lists users with optional filtering
 func (m *Manager) ListUsers(ctx context.Context, filter *models.SearchFilter) ([]*models.User, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	filterStr := "(objectClass=inetOrgPerson)"
-	if filter != nil {
-		filters := []string{"(objectClass=inetOrgPerson)"}
-		if filter.Department != "" {
-			filters = append(filters, fmt.Sprintf("(departmentNumber=%s)", ldap.EscapeFilter(filter.Department)))
-		}
-		if filter.Mail != "" {
-			filters = append(filters,
-				fmt.Sprintf("(mail=*%s*)", ldap.EscapeFilter(filter.Mail)),
-			)
-		}
-		if filter.CN != "" {
-			filters = append(filters, fmt.Sprintf("(cn=*%s*)", ldap.EscapeFilter(filter.CN)))
-		}
-		if len(filters) > 1 {
-			filterStr = fmt.Sprintf("(&%s)", strings.Join(filters, ""))
-		}
+	sortCtrl, err := m.userSortControl(nil)
+	if err != nil {
+		return nil, err
+	}
+	var controls []ldap.Control
+	if sortCtrl != nil {
+		controls = append(controls, sortCtrl)
+	}
+
+	filterStr, err := m.userSearchFilter(conn, filter)
+	if err != nil {
+		return nil, err
 	}
 
 	searchRequest := ldap.NewSearchRequest(
-		m.config.UsersDN(),
+		m.userSearchBase(filter),
 		ldap.ScopeSingleLevel,
 		ldap.NeverDerefAliases,
 		0,
 		0,
 		false,
 		filterStr,
-		[]string{"uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "gidNumber", "homeDirectory", "githubRepository"},
-		nil,
+		userAttributes,
+		controls,
 	)
 
-	result, err := conn.Search(searchRequest)
-	if err != nil {
-		return nil, fmt.Errorf("search failed: %w", err)
-	}
-
-	users := make([]*models.User, 0, len(result.Entries))
-	for _, entry := range result.Entries {
+	// Paged, so directories larger than the server size limit are listed completely
+	users := make([]*models.User, 0)
+	err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
 		users = append(users, m.entryToUser(entry))
+		return true
+	})
+	if err != nil {
+		// Nothing has been deleted yet
+		if isNoSuchObject(err) {
+			return users, nil
+		}
+		return nil, err
 	}
 
 	return users, nil
@@ -140,7 +167,7 @@ func (m *Manager) ListUsers(ctx context.Context, filter *models.SearchFilter) ([
 
 // UpdateUser updates user attributes
 func (m *Manager) UpdateUser(ctx context.Context, input *models.UpdateUserInput) (*models.User, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
@@ -168,7 +195,33 @@ func (m *Manager) UpdateUser(ctx context.Context, input *models.UpdateUserInput)
 		modifyRequest.Replace("departmentNumber", []string{*input.Department})
 	}
 	if input.Password != nil {
-		modifyRequest.Replace("userPassword", []string{*input.Password})
+		state, err := m.readPasswordState(conn, input.UID)
+		if err != nil {
+			return nil, err
+		}
+
+		subject := state.subject
+		if input.CN != nil {
+			subject.CN = *input.CN
+		}
+		if input.SN != nil {
+			subject.SN = *input.SN
+		}
+		if input.GivenName != nil {
+			subject.GivenName = *input.GivenName
+		}
+		if input.Mail != nil {
+			subject.Mail = *input.Mail
+		}
+
+		hashedPassword, history, err := m.newPassword(*input.Password, subject, state)
+		if err != nil {
+			return nil, err
+		}
+		modifyRequest.Replace("userPassword", []string{hashedPassword})
+		if history != nil {
+			modifyRequest.Replace(m.config.PasswordHistoryAttr, history)
+		}
 	}
 	if len(input.Repositories) > 0 {
 		modifyRequest.Replace("githubRepository", input.Repositories)
@@ -180,41 +233,70 @@ func (m *Manager) UpdateUser(ctx context.Context, input *models.UpdateUserInput)
 	}
 
 	m.logger.WithField("uid", input.UID).Info("User updated successfully")
-	return m.GetUser(ctx, input.UID)
+	return m.GetUser(withProvider(ctx), input.UID)
 }
 
-// DeleteUser deletes a user from LDAP
-func (m *Manager) DeleteUser(ctx context.Context, uid string) error {
-	conn, err := m.getConnection(ctx)
+// DeleteUser permanently deletes a user from LDAP, removing it from groups
+// and from the departments it manages first. A soft-deleted user is purged.
+func (m *Manager) DeleteUser(ctx context.Context, uid string) (*models.DeletionSummary, error) {
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get connection: %w", err)
+		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
 	userDN := m.config.UserDN(uid)
+	if _, err := m.readUserEntry(conn, userDN, uid); err != nil {
+		deleted, derr := m.readDeletedUser(conn, uid)
+		if derr != nil {
+			return nil, err
+		}
+		// References were removed when the user was soft deleted
+		if err := conn.Del(ldap.NewDelRequest(deleted.DN, nil)); err != nil {
+			m.logger.WithError(err).Error("Failed to purge deleted user")
+			return nil, fmt.Errorf("failed to delete user: %w", err)
+		}
+		m.logger.WithField("uid", uid).Info("Deleted user purged")
+		return &models.DeletionSummary{Deleted: []string{deleted.DN}, Modified: []*models.EntryChange{}}, nil
+	}
 
 	m.logger.WithField("uid", uid).Info("Deleting user")
 
+	changes := newChangeSet()
+	if err := m.removeUserReferences(conn, uid, changes); err != nil {
+		m.rollback(conn, changes)
+		return nil, err
+	}
+
 	deleteRequest := ldap.NewDelRequest(userDN, nil)
 	if err := conn.Del(deleteRequest); err != nil {
 		m.logger.WithError(err).Error("Failed to delete user")
-		return fmt.Errorf("failed to delete user: %w", err)
+		m.rollback(conn, changes)
+		return nil, fmt.Errorf("failed to delete user: %w", err)
 	}
 
-	m.logger.WithField("uid", uid).Info("User deleted successfully")
-	return nil
+	m.logger.WithFields(logrus.Fields{
+		"uid":      uid,
+		"modified": len(changes.summary.Modified),
+	}).Info("User deleted successfully")
+	changes.summary.Deleted = append(changes.summary.Deleted, userDN)
+	return &changes.summary, nil
 }
 
 // Authenticate authenticates a user with their password
 func (m *Manager) Authenticate(ctx context.Context, uid, password string) (*models.User, error) {
-	// First, get the user to retrieve their DN
-	user, err := m.GetUser(ctx, uid)
+	// The status comes from a provider, so a user disabled moments ago is refused
+	user, err := m.GetUser(withProvider(ctx), uid)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
 	}
+	if user.Status != models.UserStatusActive {
+		m.logger.WithField("uid", uid).Warn("Authentication refused for disabled user")
+		return nil, fmt.Errorf("authentication failed")
+	}
 
 	// Create a new connection for authentication (don't use pool)
-	conn, err := ldap.DialURL(m.config.LDAPURL)
+	conn, err := m.dialForBind(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
@@ -235,26 +317,42 @@ func (m *Manager) Authenticate(ctx context.Context, uid, password string) (*mode
 
 // CreateDepartment creates a new department
 func (m *Manager) CreateDepartment(ctx context.Context, input *models.CreateDepartmentInput) (*models.Department, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
+	// Users reference departments by ou, so names must be unique across the tree
+	if _, err := m.readDepartment(conn, input.OU); err == nil {
+		return nil, fmt.Errorf("department %s already exists", input.OU)
+	}
 	deptDN := m.config.DepartmentDN(input.OU)
+	if input.Parent != "" {
+		parent, err := m.readDepartment(conn, input.Parent)
+		if err != nil {
+			return nil, err
+		}
+		deptDN = "ou=" + ldap.EscapeDN(input.OU) + "," + parent.DN
+	}
 
-	m.logger.WithField("ou", input.OU).Info("Creating department")
+	m.logger.WithFields(logrus.Fields{
+		"ou":     input.OU,
+		"parent": input.Parent,
+	}).Info("Creating department")
 
 	addRequest := ldap.NewAddRequest(deptDN, nil)
-	addRequest.Attribute("objectClass", []string{"organizationalUnit", "extensibleObject"})
+	addRequest.Attribute("objectClass", []string{"organizationalUnit", departmentObjectClass})
 	addRequest.Attribute("ou", []string{input.OU})
 
 	if input.Description != "" {
 		addRequest.Attribute("description", []string{input.Description})
 	}
 	if input.Manager != "" {
-		managerDN := m.config.UserDN(input.Manager)
-		addRequest.Attribute("manager", []string{managerDN})
+		if err := m.requireUser(conn, input.Manager); err != nil {
+			return nil, fmt.Errorf("invalid manager: %w", err)
+		}
+		addRequest.Attribute("manager", []string{m.config.UserDN(input.Manager)})
 	}
 	if len(input.Repositories) > 0 {
 		addRequest.Attribute("githubRepository", input.Repositories)
@@ -266,42 +364,26 @@ func (m *Manager) CreateDepartment(ctx context.Context, input *models.CreateDepa
 	}
 
 	m.logger.WithField("ou", input.OU).Info("Department created successfully")
-	return m.GetDepartment(ctx, input.OU)
+	return m.GetDepartment(withProvider(ctx), input.OU)
 }
 
 // GetDepartment retrieves a department by OU
 func (m *Manager) GetDepartment(ctx context.Context, ou string) (*models.Department, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	searchRequest := ldap.NewSearchRequest(
-		m.config.DepartmentsDN(),
-		ldap.ScopeSingleLevel,
-		ldap.NeverDerefAliases,
-		0,
-		0,
-		false,
-		fmt.Sprintf("(ou=%s)", ldap.EscapeFilter(ou)),
-		[]string{"ou", "description", "manager", "githubRepository"},
-		nil,
-	)
-
-	result, err := conn.Search(searchRequest)
+	entry, err := m.readDepartment(conn, ou)
 	if err != nil {
-		return nil, fmt.Errorf("search failed: %w", err)
-	}
-
-	if len(result.Entries) == 0 {
-		return nil, fmt.Errorf("department not found: %s", ou)
+		return nil, err
 	}
 
-	dept := m.entryToDepartment(result.Entries[0])
+	dept := m.entryToDepartment(entry)
 
 	// Get members
-	members, err := m.GetUsersByDepartment(ctx, ou)
+	members, err := m.GetUsersByDepartment(ctx, ou, false)
 	if err != nil {
 		m.logger.WithError(err).Warn("Failed to get department members")
 	} else {
@@ -317,36 +399,24 @@ func (m *Manager) GetDepartment(ctx context.Context, ou string) (*models.Departm
 
 // ListDepartments lists all departments
 func (m *Manager) ListDepartments(ctx context.Context) ([]*models.Department, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	searchRequest := ldap.NewSearchRequest(
-		m.config.DepartmentsDN(),
-		ldap.ScopeSingleLevel,
-		ldap.NeverDerefAliases,
-		0,
-		0,
-		false,
-		"(objectClass=organizationalUnit)",
-		[]string{"ou", "description", "manager", "githubRepository"},
-		nil,
-	)
-
-	result, err := conn.Search(searchRequest)
+	entries, err := m.searchDepartments(conn, m.config.DepartmentsDN(), ldap.ScopeWholeSubtree)
 	if err != nil {
-		return nil, fmt.Errorf("search failed: %w", err)
+		return nil, err
 	}
 
-	departments := make([]*models.Department, 0, len(result.Entries))
-	for _, entry := range result.Entries {
+	departments := make([]*models.Department, 0, len(entries))
+	for _, entry := range entries {
 		dept := m.entryToDepartment(entry)
 
 		// Get members count
 		ou := entry.GetAttributeValue("ou")
-		members, err := m.GetUsersByDepartment(ctx, ou)
+		members, err := m.GetUsersByDepartment(ctx, ou, false)
 		if err == nil {
 			memberUIDs := make([]string, 0, len(members))
 			for _, member := range members {
@@ -361,44 +431,111 @@ func (m *Manager) ListDepartments(ctx context.Context) ([]*models.Department, er
 	return departments, nil
 }
 
-// DeleteDepartment deletes a department
-func (m *Manager) DeleteDepartment(ctx context.Context, ou string) error {
-	conn, err := m.getConnection(ctx)
+// DeleteDepartment deletes a department. Members are moved to reassignTo;
+// without it the department must have no members left.
+func (m *Manager) DeleteDepartment(ctx context.Context, ou, reassignTo string) (*models.DeletionSummary, error) {
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get connection: %w", err)
+		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	deptDN := m.config.DepartmentDN(ou)
+	entry, err := m.readDepartment(conn, ou)
+	if err != nil {
+		return nil, err
+	}
+	children, err := m.searchDepartments(conn, entry.DN, ldap.ScopeSingleLevel)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) > 0 {
+		return nil, fmt.Errorf("department %s has sub-departments; move or delete them first", ou)
+	}
+
+	target := ""
+	if reassignTo != "" {
+		if strings.EqualFold(reassignTo, ou) {
+			return nil, fmt.Errorf("cannot reassign members of department %s to itself", ou)
+		}
+		targetEntry, err := m.readDepartment(conn, reassignTo)
+		if err != nil {
+			return nil, err
+		}
+		target = targetEntry.GetAttributeValue("ou")
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&(objectClass=inetOrgPerson)(departmentNumber=%s))", ldap.EscapeFilter(ou)),
+		[]string{"uid", "departmentNumber"},
+		nil,
+	)
+	var members []*ldap.Entry
+	err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(member *ldap.Entry) bool {
+		members = append(members, member)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(members) > 0 && target == "" {
+		return nil, fmt.Errorf("department %s still has %d members; reassign them first", ou, len(members))
+	}
 
-	m.logger.WithField("ou", ou).Info("Deleting department")
+	m.logger.WithFields(logrus.Fields{
+		"ou":         ou,
+		"reassignTo": target,
+		"members":    len(members),
+	}).Info("Deleting department")
+
+	changes := newChangeSet()
+	for _, member := range members {
+		modifyRequest := ldap.NewModifyRequest(member.DN, nil)
+		modifyRequest.Replace("departmentNumber", []string{target})
+		undoRequest := ldap.NewModifyRequest(member.DN, nil)
+		undoRequest.Replace("departmentNumber", member.GetAttributeValues("departmentNumber"))
+		if err := changes.modify(conn, modifyRequest, undoRequest, "moved to department "+target); err != nil {
+			m.rollback(conn, changes)
+			return nil, fmt.Errorf("failed to reassign user %s: %w", member.GetAttributeValue("uid"), err)
+		}
+	}
 
-	deleteRequest := ldap.NewDelRequest(deptDN, nil)
+	deleteRequest := ldap.NewDelRequest(entry.DN, nil)
 	if err := conn.Del(deleteRequest); err != nil {
 		m.logger.WithError(err).Error("Failed to delete department")
-		return fmt.Errorf("failed to delete department: %w", err)
+		m.rollback(conn, changes)
+		return nil, fmt.Errorf("failed to delete department: %w", err)
 	}
 
 	m.logger.WithField("ou", ou).Info("Department deleted successfully")
-	return nil
+	changes.summary.Deleted = append(changes.summary.Deleted, entry.DN)
+	return &changes.summary, nil
 }
 
 // AssignRepositoryToDepartment assigns repositories to a department
 func (m *Manager) AssignRepositoryToDepartment(ctx context.Context, ou string, repos []string) error {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	deptDN := m.config.DepartmentDN(ou)
+	entry, err := m.readDepartment(conn, ou)
+	if err != nil {
+		return err
+	}
 
 	m.logger.WithFields(logrus.Fields{
 		"ou":    ou,
 		"repos": len(repos),
 	}).Info("Assigning repositories to department")
 
-	modifyRequest := ldap.NewModifyRequest(deptDN, nil)
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
 	modifyRequest.Replace("githubRepository", repos)
 
 	if err := conn.Modify(modifyRequest); err != nil {
@@ -410,33 +547,36 @@ func (m *Manager) AssignRepositoryToDepartment(ctx context.Context, ou string, r
 	return nil
 }
 
-// GetUsersByDepartment retrieves all users in a department
-func (m *Manager) GetUsersByDepartment(ctx context.Context, department string) ([]*models.User, error) {
+// GetUsersByDepartment retrieves all users in a department, and optionally
+// in the departments below it
+func (m *Manager) GetUsersByDepartment(ctx context.Context, department string, includeDescendants bool) ([]*models.User, error) {
 	filter := &models.SearchFilter{
-		Department: department,
+		Department:         department,
+		IncludeDescendants: includeDescendants,
 	}
 	return m.ListUsers(ctx, filter)
 }
 
 // CreateGroup creates a new group
 func (m *Manager) CreateGroup(ctx context.Context, cn, description string) (*models.Group, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	gidNumber := m.nextGID()
+	gidNumber, err := m.nextGID(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate gidNumber: %w", err)
+	}
 	groupDN := m.config.GroupDN(cn)
 
 	m.logger.WithField("cn", cn).Info("Creating group")
 
 	addRequest := ldap.NewAddRequest(groupDN, nil)
-	addRequest.Attribute("objectClass", []string{"groupOfNames", "posixGroup"})
+	addRequest.Attribute("objectClass", m.resolveGroupClasses(conn))
 	addRequest.Attribute("cn", []string{cn})
 	addRequest.Attribute("gidNumber", []string{fmt.Sprintf("%d", gidNumber)})
-	// groupOfNames requires at least one member, use a placeholder
-	addRequest.Attribute("member", []string{"cn=placeholder,ou=groups," + m.config.LDAPBaseDN})
 
 	if description != "" {
 		addRequest.Attribute("description", []string{description})
@@ -448,12 +588,12 @@ func (m *Manager) CreateGroup(ctx context.Context, cn, description string) (*mod
 	}
 
 	m.logger.WithField("cn", cn).Info("Group created successfully")
-	return m.GetGroup(ctx, cn)
+	return m.GetGroup(withProvider(ctx), cn)
 }
 
 // GetGroup retrieves a group by CN
 func (m *Manager) GetGroup(ctx context.Context, cn string) (*models.Group, error) {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getReadConnection(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get connection: %w", err)
 	}
@@ -466,8 +606,8 @@ func (m *Manager) GetGroup(ctx context.Context, cn string) (*models.Group, error
 		0,
 		0,
 		false,
-		fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(cn)),
-		[]string{"cn", "gidNumber", "member"},
+		fmt.Sprintf("(&%s(cn=%s))", groupFilter, ldap.EscapeFilter(cn)),
+		groupAttributes,
 		nil,
 	)
 
@@ -485,23 +625,31 @@ func (m *Manager) GetGroup(ctx context.Context, cn string) (*models.Group, error
 
 // AddUserToGroup adds a user to a group
 func (m *Manager) AddUserToGroup(ctx context.Context, uid, groupCN string) error {
-	conn, err := m.getConnection(ctx)
+	conn, err := m.getWriteConnection(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get connection: %w", err)
 	}
 	defer m.returnConnection(conn)
 
-	userDN := m.config.UserDN(uid)
-	groupDN := m.config.GroupDN(groupCN)
+	entry, err := m.readGroup(conn, groupCN)
+	if err != nil {
+		return err
+	}
+	if err := m.requireUser(conn, uid); err != nil {
+		return err
+	}
+
+	// Groups created by older versions carry a placeholder member; it is dropped once there is a real one
+	modifyRequest, _ := m.memberAddition(entry, uid)
+	if len(modifyRequest.Changes) == 0 {
+		return nil
+	}
 
 	m.logger.WithFields(logrus.Fields{
 		"uid":   uid,
 		"group": groupCN,
 	}).Info("Adding user to group")
 
-	modifyRequest := ldap.NewModifyRequest(groupDN, nil)
-	modifyRequest.Add("member", []string{userDN})
-
 	if err := conn.Modify(modifyRequest); err != nil {
 		m.logger.WithError(err).Error("Failed to add user to group")
 		return fmt.Errorf("failed to add user to group: %w", err)
@@ -514,6 +662,39 @@ func (m *Manager) AddUserToGroup(ctx context.Context, uid, groupCN string) error
 	return nil
 }
 
+// GetUserGroups retrieves all groups a user is a direct member of
+func (m *Manager) GetUserGroups(ctx context.Context, uid string) ([]*models.Group, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.GroupsDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&%s%s)", groupFilter, m.memberFilter(uid)),
+		groupAttributes,
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	groups := make([]*models.Group, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		groups = append(groups, m.entryToGroup(entry))
+	}
+
+	return groups, nil
+}
+
 // Helper functions to convert LDAP entries to models
 
 func (m *Manager) entryToUser(entry *ldap.Entry) *models.User {
@@ -522,6 +703,15 @@ func (m *Manager) entryToUser(entry *ldap.Entry) *models.User {
 	fmt.Sscanf(entry.GetAttributeValue("uidNumber"), "%d", &uidNumber)
 	fmt.Sscanf(entry.GetAttributeValue("gidNumber"), "%d", &gidNumber)
 
+	status := models.UserStatusActive
+	var deletedAt *time.Time
+	if m.isDeletedUserDN(entry.DN) {
+		status = models.UserStatusDeleted
+		deletedAt = shadowDate(entry.GetAttributeValue("shadowExpire"))
+	} else if shadowExpired(entry.GetAttributeValue("shadowExpire"), time.Now()) {
+		status = models.UserStatusDisabled
+	}
+
 	return &models.User{
 		UID:          entry.GetAttributeValue("uid"),
 		CN:           entry.GetAttributeValue("cn"),
@@ -534,24 +724,24 @@ func (m *Manager) entryToUser(entry *ldap.Entry) *models.User {
 		HomeDir:      entry.GetAttributeValue("homeDirectory"),
 		Repositories: entry.GetAttributeValues("githubRepository"),
 		DN:           entry.DN,
+		Status:       status,
+		DeletedAt:    deletedAt,
 	}
 }
 
 func (m *Manager) entryToDepartment(entry *ldap.Entry) *models.Department {
-	manager := entry.GetAttributeValue("manager")
-	// Extract UID from manager DN if present
-	if manager != "" {
-		parts := strings.Split(manager, ",")
-		if len(parts) > 0 {
-			uidPart := strings.TrimPrefix(parts[0], "uid=")
-			manager = uidPart
-		}
+	path := m.departmentPath(entry.DN)
+	parent := ""
+	if len(path) > 1 {
+		parent = path[len(path)-2]
 	}
 
 	return &models.Department{
 		OU:           entry.GetAttributeValue("ou"),
+		Parent:       parent,
+		Path:         path,
 		Description:  entry.GetAttributeValue("description"),
-		Manager:      manager,
+		Manager:      m.memberUID(entry.GetAttributeValue("manager")),
 		Members:      []string{}, // Will be populated by caller
 		Repositories: entry.GetAttributeValues("githubRepository"),
 		DN:           entry.DN,
@@ -562,26 +752,28 @@ func (m *Manager) entryToGroup(entry *ldap.Entry) *models.Group {
 	gidNumber := 0
 	fmt.Sscanf(entry.GetAttributeValue("gidNumber"), "%d", &gidNumber)
 
-	members := entry.GetAttributeValues("member")
-	memberUIDs := make([]string, 0, len(members))
-
-	for _, memberDN := range members {
-		// Skip placeholder
-		if strings.Contains(memberDN, "placeholder") {
-			continue
+	// member holds DNs, memberUid plain uids; groups edited by other tools may only use one of them
+	memberUIDs := make([]string, 0, len(entry.GetAttributeValues("member")))
+	memberGroups := []string{}
+	for _, memberDN := range entry.GetAttributeValues("member") {
+		if uid := m.memberUID(memberDN); uid != "" && !containsFold(memberUIDs, uid) {
+			memberUIDs = append(memberUIDs, uid)
+		} else if cn := m.memberGroupCN(memberDN); cn != "" && !containsFold(memberGroups, cn) {
+			memberGroups = append(memberGroups, cn)
 		}
-		// Extract UID from DN
-		parts := strings.Split(memberDN, ",")
-		if len(parts) > 0 {
-			uidPart := strings.TrimPrefix(parts[0], "uid=")
-			memberUIDs = append(memberUIDs, uidPart)
+	}
+	for _, uid := range entry.GetAttributeValues("memberUid") {
+		if !containsFold(memberUIDs, uid) {
+			memberUIDs = append(memberUIDs, uid)
 		}
 	}
 
 	return &models.Group{
-		CN:        entry.GetAttributeValue("cn"),
-		GIDNumber: gidNumber,
-		Members:   memberUIDs,
-		DN:        entry.DN,
+		CN:           entry.GetAttributeValue("cn"),
+		GIDNumber:    gidNumber,
+		Description:  entry.GetAttributeValue("description"),
+		Members:      memberUIDs,
+		MemberGroups: memberGroups,
+		DN:           entry.DN,
 	}
 }
@@ -0,0 +1,160 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// passwordState holds the identity and stored secrets of an existing user
+type passwordState struct {
+	subject password.Subject
+	current []string
+	history []string
+}
+
+// readPasswordState loads what the password policy needs to know about uid
+func (m *Manager) readPasswordState(conn *ldap.Conn, uid string) (*passwordState, error) {
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid)),
+		[]string{"uid", "cn", "sn", "givenName", "mail", "userPassword", m.config.PasswordHistoryAttr},
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, fmt.Errorf("user not found: %s", uid)
+	}
+
+	entry := result.Entries[0]
+	return &passwordState{
+		subject: password.Subject{
+			UID:       entry.GetAttributeValue("uid"),
+			CN:        entry.GetAttributeValue("cn"),
+			SN:        entry.GetAttributeValue("sn"),
+			GivenName: entry.GetAttributeValue("givenName"),
+			Mail:      entry.GetAttributeValue("mail"),
+		},
+		current: entry.GetAttributeValues("userPassword"),
+		history: entry.GetAttributeValues(m.config.PasswordHistoryAttr),
+	}, nil
+}
+
+// newPassword validates plain against the password policy and hashes it.
+// For existing users it also returns the password history to store, most
+// recent first; the returned history is nil when history is disabled.
+func (m *Manager) newPassword(plain string, subject password.Subject, state *passwordState) (string, []string, error) {
+	var previous []string
+	if state != nil {
+		previous = append(append(previous, state.current...), state.history...)
+	}
+
+	if err := m.passwordPolicy.Validate(plain, subject, previous); err != nil {
+		return "", nil, err
+	}
+
+	hashed, err := m.hasher.Hash(plain)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var history []string
+	if m.passwordPolicy.HistorySize > 0 {
+		// The new password counts towards the limit, so keep HistorySize-1 old ones
+		history = previous
+		if len(history) > m.passwordPolicy.HistorySize-1 {
+			history = history[:m.passwordPolicy.HistorySize-1]
+		}
+		if history == nil {
+			history = []string{}
+		}
+	}
+
+	return hashed, history, nil
+}
+
+// PreparePassword validates plain against the password policy for a new
+// account and returns the hash to store
+func (m *Manager) PreparePassword(plain string, subject password.Subject) (string, error) {
+	hashed, _, err := m.newPassword(plain, subject, nil)
+	return hashed, err
+}
+
+// SetPassword validates plain against the password policy and stores it as
+// the password of uid, updating the password history
+func (m *Manager) SetPassword(ctx context.Context, uid, plain string) error {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	state, err := m.readPasswordState(conn, uid)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, history, err := m.newPassword(plain, state.subject, state)
+	if err != nil {
+		return err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(m.config.UserDN(uid), nil)
+	modifyRequest.Replace("userPassword", []string{hashedPassword})
+	if history != nil {
+		modifyRequest.Replace(m.config.PasswordHistoryAttr, history)
+	}
+
+	if err := conn.Modify(modifyRequest); err != nil {
+		m.logger.WithError(err).WithField("uid", uid).Error("Failed to set password")
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+
+	m.logger.WithField("uid", uid).Info("Password changed")
+	return nil
+}
+
+// FindUserByMail returns the user with the given mail address
+func (m *Manager) FindUserByMail(ctx context.Context, mail string) (*models.User, error) {
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.UsersDN(),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		2,
+		0,
+		false,
+		fmt.Sprintf("(&(objectClass=inetOrgPerson)(mail=%s))", ldap.EscapeFilter(mail)),
+		userAttributes,
+		nil,
+	)
+
+	result, err := conn.Search(searchRequest)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	// A shared address cannot identify a single account
+	if result == nil || len(result.Entries) != 1 {
+		return nil, fmt.Errorf("no unique user with mail %s", mail)
+	}
+
+	return m.entryToUser(result.Entries[0]), nil
+}
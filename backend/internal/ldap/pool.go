@@ -0,0 +1,416 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// poolConfig sizes and ages the connections of a pool
+type poolConfig struct {
+	MinSize        int
+	MaxSize        int
+	WaitTimeout    time.Duration
+	IdleTimeout    time.Duration
+	MaxLifetime    time.Duration
+	HealthInterval time.Duration
+	BackoffBase    time.Duration
+	BackoffMax     time.Duration
+}
+
+type pooledConn struct {
+	conn      *ldap.Conn
+	createdAt time.Time
+	lastUsed  time.Time
+	checkedAt time.Time
+}
+
+// poolStats is a snapshot of a pool's counters
+type poolStats struct {
+	Open      int
+	Idle      int
+	InUse     int
+	Healthy   bool
+	LastError string
+}
+
+// pool hands out bound connections. Connections are created lazily up to
+// MaxSize, recycled after MaxLifetime or IdleTimeout, and checked in the
+// background rather than on every checkout. After a failed dial, further
+// dials wait for a jittered exponential backoff so an unavailable server is
+// not hammered; checkouts fail fast in the meantime.
+type pool struct {
+	config poolConfig
+	dial   func() (*ldap.Conn, error)
+	logger logrus.FieldLogger
+
+	// slots holds one token per checked-out connection, bounding the pool size
+	slots chan struct{}
+
+	mu          sync.Mutex
+	idle        []*pooledConn
+	conns       map[*ldap.Conn]*pooledConn
+	closed      bool
+	failures    int
+	nextDial    time.Time
+	lastErr     error
+	everHealthy bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newPool(cfg poolConfig, dial func() (*ldap.Conn, error), logger logrus.FieldLogger) *pool {
+	if cfg.MaxSize < 1 {
+		cfg.MaxSize = 1
+	}
+	if cfg.MinSize > cfg.MaxSize {
+		cfg.MinSize = cfg.MaxSize
+	}
+
+	p := &pool{
+		config: cfg,
+		dial:   dial,
+		logger: logger,
+		slots:  make(chan struct{}, cfg.MaxSize),
+		conns:  make(map[*ldap.Conn]*pooledConn),
+		done:   make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.maintain()
+	return p
+}
+
+// get checks out a connection, reusing an idle one or dialing a new one
+func (p *pool) get(ctx context.Context) (*ldap.Conn, error) {
+	timer := time.NewTimer(p.config.WaitTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, fmt.Errorf("timeout waiting for connection from pool")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := p.acquire()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// acquire returns a usable idle connection or dials a new one. The caller holds a slot.
+func (p *pool) acquire() (*ldap.Conn, error) {
+	now := time.Now()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("connection pool is closed")
+	}
+	for len(p.idle) > 0 {
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.usable(pc, now) {
+			pc.lastUsed = now
+			p.mu.Unlock()
+			return pc.conn, nil
+		}
+		p.discardLocked(pc)
+	}
+	if wait := p.nextDial.Sub(now); wait > 0 {
+		lastErr := p.lastErr
+		p.mu.Unlock()
+		return nil, fmt.Errorf("LDAP server unavailable, retrying in %s: %w", wait.Round(time.Millisecond), lastErr)
+	}
+	p.mu.Unlock()
+
+	return p.open()
+}
+
+// open dials a new connection and registers it, updating the backoff state
+func (p *pool) open() (*ldap.Conn, error) {
+	conn, err := p.dial()
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.failures++
+		p.lastErr = err
+		p.nextDial = now.Add(p.backoff(p.failures))
+		p.logger.WithError(err).WithFields(logrus.Fields{
+			"failures":   p.failures,
+			"retry_in":   p.nextDial.Sub(now).Round(time.Millisecond).String(),
+			"open_conns": len(p.conns),
+		}).Warn("Failed to open LDAP connection")
+		return nil, err
+	}
+
+	if p.closed {
+		conn.Close()
+		return nil, fmt.Errorf("connection pool is closed")
+	}
+	if p.failures > 0 || !p.everHealthy {
+		p.logger.WithField("after_failures", p.failures).Info("LDAP connection established")
+	}
+	p.failures = 0
+	p.lastErr = nil
+	p.nextDial = time.Time{}
+	p.everHealthy = true
+
+	p.conns[conn] = &pooledConn{conn: conn, createdAt: now, lastUsed: now}
+	return conn, nil
+}
+
+// put returns a checked-out connection and releases its slot
+func (p *pool) put(conn *ldap.Conn) {
+	if conn == nil {
+		return
+	}
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc, ok := p.conns[conn]
+	if !ok {
+		conn.Close()
+		return
+	}
+	now := time.Now()
+	if p.closed || !p.usable(pc, now) {
+		p.discardLocked(pc)
+		return
+	}
+	pc.lastUsed = now
+	p.idle = append(p.idle, pc)
+}
+
+// owns reports whether conn was opened by this pool
+func (p *pool) owns(conn *ldap.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.conns[conn]
+	return ok
+}
+
+// usable reports whether a connection may be handed out again. Must be called with p.mu held.
+func (p *pool) usable(pc *pooledConn, now time.Time) bool {
+	if pc.conn.IsClosing() {
+		return false
+	}
+	if p.config.MaxLifetime > 0 && now.Sub(pc.createdAt) > p.config.MaxLifetime {
+		return false
+	}
+	return true
+}
+
+// discardLocked closes a connection and forgets it. Must be called with p.mu held.
+func (p *pool) discardLocked(pc *pooledConn) {
+	delete(p.conns, pc.conn)
+	pc.conn.Close()
+}
+
+// backoff returns the jittered delay before the next dial after n failures
+func (p *pool) backoff(n int) time.Duration {
+	delay := p.config.BackoffBase
+	for i := 1; i < n && delay < p.config.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > p.config.BackoffMax {
+		delay = p.config.BackoffMax
+	}
+	// Full jitter in [delay/2, delay) spreads out reconnects of many replicas
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// maintain runs the background health checks until the pool is closed
+func (p *pool) maintain() {
+	defer p.wg.Done()
+
+	// Warm up immediately so the service becomes ready as soon as LDAP answers
+	p.fill()
+
+	ticker := time.NewTicker(p.config.HealthInterval)
+	defer ticker.Stop()
+
+	// While the server is unreachable, retry on the backoff schedule instead of the health interval
+	retry := time.NewTimer(0)
+	<-retry.C
+	defer retry.Stop()
+
+	for {
+		p.mu.Lock()
+		wait := time.Until(p.nextDial)
+		p.mu.Unlock()
+		if wait > 0 {
+			retry.Reset(wait)
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkIdle()
+			p.fill()
+		case <-retry.C:
+			p.fill()
+		}
+	}
+}
+
+// checkIdle recycles aged connections and pings the remaining idle ones.
+// Each connection under test holds a slot so the pool never exceeds MaxSize.
+func (p *pool) checkIdle() {
+	now := time.Now()
+
+	p.mu.Lock()
+	kept := make([]*pooledConn, 0, len(p.idle))
+	for _, pc := range p.idle {
+		idleTooLong := p.config.IdleTimeout > 0 && now.Sub(pc.lastUsed) > p.config.IdleTimeout && len(p.conns) > p.config.MinSize
+		if !p.usable(pc, now) || idleTooLong {
+			p.discardLocked(pc)
+		} else {
+			kept = append(kept, pc)
+		}
+	}
+	p.idle = kept
+	count := len(kept)
+	p.mu.Unlock()
+
+	for i := 0; i < count; i++ {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			// Every slot is busy, so the idle connections are about to be used anyway
+			return
+		}
+
+		// Take the least recently used connection not yet checked in this round
+		p.mu.Lock()
+		index := -1
+		for j, pc := range p.idle {
+			if pc.checkedAt.Before(now) {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			p.mu.Unlock()
+			<-p.slots
+			return
+		}
+		pc := p.idle[index]
+		p.idle = append(p.idle[:index], p.idle[index+1:]...)
+		p.mu.Unlock()
+
+		healthy := ping(pc.conn)
+		pc.checkedAt = time.Now()
+
+		p.mu.Lock()
+		if healthy && !p.closed {
+			// Put it back at the bottom without refreshing lastUsed, so idle timeouts still apply
+			p.idle = append([]*pooledConn{pc}, p.idle...)
+		} else {
+			p.logger.Debug("Idle LDAP connection failed health check, closing it")
+			p.discardLocked(pc)
+		}
+		p.mu.Unlock()
+		<-p.slots
+	}
+}
+
+// fill opens connections until MinSize are open, stopping at the first failure
+func (p *pool) fill() {
+	for {
+		p.mu.Lock()
+		need := !p.closed && len(p.conns) < p.config.MinSize && time.Now().After(p.nextDial)
+		p.mu.Unlock()
+		if !need {
+			return
+		}
+
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			return
+		}
+		conn, err := p.open()
+		if err != nil {
+			<-p.slots
+			return
+		}
+		p.put(conn)
+	}
+}
+
+// stats returns the current pool counters
+func (p *pool) stats() poolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := poolStats{
+		Open:    len(p.conns),
+		Idle:    len(p.idle),
+		InUse:   len(p.slots),
+		Healthy: p.lastErr == nil && p.everHealthy,
+	}
+	if p.lastErr != nil {
+		s.LastError = p.lastErr.Error()
+	}
+	return s
+}
+
+// close stops the maintainer and closes every idle connection. Checked-out
+// connections are closed when they are returned.
+func (p *pool) close() int {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0
+	}
+	p.closed = true
+	close(p.done)
+	count := len(p.idle)
+	for _, pc := range p.idle {
+		p.discardLocked(pc)
+	}
+	p.idle = nil
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return count
+}
+
+// ping checks that a connection still answers, using a cheap root DSE read
+func ping(conn *ldap.Conn) bool {
+	searchRequest := ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		5,
+		false,
+		"(objectClass=*)",
+		[]string{"1.1"},
+		nil,
+	)
+
+	_, err := conn.Search(searchRequest)
+	return err == nil
+}
@@ -0,0 +1,136 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/registration"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// registrationsContainer is the container below StateDN() holding self-service registrations
+const registrationsContainer = "registrations"
+
+// RegistrationStore keeps registrations in the directory, so the review
+// queue survives restarts and is the same on every replica. Pending
+// registrations never expire; reviewed ones are kept for
+// registration.ReviewedRetention. It satisfies registration.Store.
+type RegistrationStore struct {
+	m *Manager
+}
+
+// Registrations returns the LDAP-backed registration store
+func (m *Manager) Registrations() *RegistrationStore {
+	return &RegistrationStore{m: m}
+}
+
+// storedRegistration adds the password hash, which models.Registration
+// keeps out of JSON, to the stored document
+type storedRegistration struct {
+	*models.Registration
+	PasswordHash string `json:"passwordHash,omitempty"`
+}
+
+func encodeRegistration(reg *models.Registration) (*stateEntry, error) {
+	data, err := encodeState(storedRegistration{Registration: reg, PasswordHash: reg.PasswordHash})
+	if err != nil {
+		return nil, err
+	}
+	e := &stateEntry{key: reg.ID, data: data}
+	if reg.ReviewedAt != nil {
+		e.expiresAt = reg.ReviewedAt.Add(registration.ReviewedRetention)
+	}
+	return e, nil
+}
+
+func decodeRegistration(e *stateEntry) (*models.Registration, error) {
+	stored := storedRegistration{Registration: &models.Registration{}}
+	if err := e.decode(&stored); err != nil {
+		return nil, err
+	}
+	stored.Registration.PasswordHash = stored.PasswordHash
+	return stored.Registration, nil
+}
+
+// Create stores a new registration
+func (s *RegistrationStore) Create(ctx context.Context, reg *models.Registration) error {
+	e, err := encodeRegistration(reg)
+	if err != nil {
+		return err
+	}
+	return s.m.addState(ctx, registrationsContainer, e)
+}
+
+// Get returns a registration
+func (s *RegistrationStore) Get(ctx context.Context, id string) (*models.Registration, error) {
+	e, err := s.m.readState(ctx, registrationsContainer, id)
+	if errors.Is(err, errStateNotFound) {
+		return nil, registration.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeRegistration(e)
+}
+
+// List returns registrations with the given status, oldest first;
+// an empty status returns all of them
+func (s *RegistrationStore) List(ctx context.Context, status string) ([]*models.Registration, error) {
+	entries, err := s.m.searchState(ctx, registrationsContainer, "")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.Registration, 0, len(entries))
+	for _, e := range entries {
+		reg, err := decodeRegistration(e)
+		if err != nil {
+			return nil, err
+		}
+		if status == "" || reg.Status == status {
+			result = append(result, reg)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// Update replaces a registration whose stored status is still from. The
+// stored document is swapped in one modify that deletes the exact value
+// read, so a concurrent review makes it fail.
+func (s *RegistrationStore) Update(ctx context.Context, reg *models.Registration, from string) error {
+	current, err := s.m.readState(ctx, registrationsContainer, reg.ID)
+	if errors.Is(err, errStateNotFound) {
+		return registration.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	existing, err := decodeRegistration(current)
+	if err != nil {
+		return err
+	}
+	if existing.Status != from {
+		return registration.ErrConflict
+	}
+
+	e, err := encodeRegistration(reg)
+	if err != nil {
+		return err
+	}
+	err = s.m.replaceState(ctx, registrationsContainer, e, current.data)
+	var ldapErr *ldap.Error
+	if errors.As(err, &ldapErr) {
+		switch ldapErr.ResultCode {
+		case ldap.LDAPResultNoSuchAttribute:
+			return registration.ErrConflict
+		case ldap.LDAPResultNoSuchObject:
+			return registration.ErrNotFound
+		}
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ldap "github.com/go-ldap/ldap/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// RenameUser changes the uid of a user. The entry is renamed and the group
+// memberships and department managers naming the old DN are rewritten; if
+// any step fails, the steps already done are undone.
+func (m *Manager) RenameUser(ctx context.Context, oldUID, newUID string) (*models.User, error) {
+	if oldUID == newUID {
+		return nil, fmt.Errorf("new uid is the same as the old one")
+	}
+
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	if err := m.requireUser(conn, oldUID); err != nil {
+		return nil, err
+	}
+	if _, err := m.readDeletedUser(conn, newUID); err == nil {
+		return nil, fmt.Errorf("uid %s belongs to a deleted user; restore or purge it first", newUID)
+	}
+	oldDN, newDN := m.config.UserDN(oldUID), m.config.UserDN(newUID)
+
+	// References name the old DN, so they are looked up before the rename
+	groups, err := m.searchGroups(conn, fmt.Sprintf("(&%s%s)", groupFilter, m.memberFilter(oldUID)), groupAttributes)
+	if err != nil {
+		return nil, err
+	}
+	managed, err := m.searchDepartmentsByFilter(conn, fmt.Sprintf("(manager=%s)", ldap.EscapeFilter(oldDN)))
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":    oldUID,
+		"newUid": newUID,
+	}).Info("Renaming user")
+
+	if err := conn.ModifyDN(ldap.NewModifyDNRequest(oldDN, "uid="+ldap.EscapeDN(newUID), true, "")); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return nil, fmt.Errorf("user already exists: %s", newUID)
+		}
+		m.logger.WithError(err).Error("Failed to rename user")
+		return nil, fmt.Errorf("failed to rename user: %w", err)
+	}
+
+	changes := newChangeSet()
+	for _, group := range groups {
+		modifyRequest := ldap.NewModifyRequest(group.DN, nil)
+		undoRequest := ldap.NewModifyRequest(group.DN, nil)
+		if containsDN(group.GetAttributeValues("member"), oldDN) {
+			modifyRequest.Delete("member", []string{oldDN})
+			modifyRequest.Add("member", []string{newDN})
+			undoRequest.Delete("member", []string{newDN})
+			undoRequest.Add("member", []string{oldDN})
+		}
+		if containsFold(group.GetAttributeValues("memberUid"), oldUID) {
+			modifyRequest.Delete("memberUid", []string{oldUID})
+			modifyRequest.Add("memberUid", []string{newUID})
+			undoRequest.Delete("memberUid", []string{newUID})
+			undoRequest.Add("memberUid", []string{oldUID})
+		}
+		if err := changes.modify(conn, modifyRequest, undoRequest, "renamed member "+oldUID); err != nil {
+			m.undoRename(conn, changes, newDN, oldUID)
+			return nil, fmt.Errorf("failed to rename member of group %s: %w", group.GetAttributeValue("cn"), err)
+		}
+	}
+	for _, dept := range managed {
+		modifyRequest := ldap.NewModifyRequest(dept.DN, nil)
+		modifyRequest.Replace("manager", []string{newDN})
+		undoRequest := ldap.NewModifyRequest(dept.DN, nil)
+		undoRequest.Replace("manager", []string{oldDN})
+		if err := changes.modify(conn, modifyRequest, undoRequest, "renamed manager "+oldUID); err != nil {
+			m.undoRename(conn, changes, newDN, oldUID)
+			return nil, fmt.Errorf("failed to rename manager of department %s: %w", dept.GetAttributeValue("ou"), err)
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":      oldUID,
+		"newUid":   newUID,
+		"modified": len(changes.summary.Modified),
+	}).Info("User renamed successfully")
+	return m.GetUser(withProvider(ctx), newUID)
+}
+
+// undoRename rolls back the reference updates of RenameUser and renames the entry back
+func (m *Manager) undoRename(conn *ldap.Conn, changes *changeSet, newDN, oldUID string) {
+	m.rollback(conn, changes)
+	if err := conn.ModifyDN(ldap.NewModifyDNRequest(newDN, "uid="+ldap.EscapeDN(oldUID), true, "")); err != nil {
+		m.logger.WithError(err).WithField("dn", newDN).Error("Failed to roll back rename")
+	}
+}
+
+// MoveUser moves a user to another department. The repositories of the old
+// department are replaced by those of the new one, and the user leaves the
+// group of the old department for that of the new one (see DEPARTMENT_GROUPS).
+// If any step fails, the steps already done are undone.
+func (m *Manager) MoveUser(ctx context.Context, uid, department string) (*models.User, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	entry, err := m.readUserEntry(conn, m.config.UserDN(uid), uid)
+	if err != nil {
+		return nil, err
+	}
+	newDept, err := m.readDepartment(conn, department)
+	if err != nil {
+		return nil, err
+	}
+	oldDepartment := entry.GetAttributeValue("departmentNumber")
+	var oldRepos []string
+	if oldDepartment != "" {
+		// The old department may have been deleted since
+		if oldDept, err := m.readDepartment(conn, oldDepartment); err == nil {
+			oldRepos = oldDept.GetAttributeValues("githubRepository")
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":  uid,
+		"from": oldDepartment,
+		"to":   department,
+	}).Info("Moving user")
+
+	changes := newChangeSet()
+	modifyRequest := ldap.NewModifyRequest(entry.DN, nil)
+	modifyRequest.Replace("departmentNumber", []string{department})
+	modifyRequest.Replace("githubRepository", departmentRepositories(entry.GetAttributeValues("githubRepository"), oldRepos, newDept.GetAttributeValues("githubRepository")))
+	undoRequest := ldap.NewModifyRequest(entry.DN, nil)
+	undoRequest.Replace("departmentNumber", entry.GetAttributeValues("departmentNumber"))
+	undoRequest.Replace("githubRepository", entry.GetAttributeValues("githubRepository"))
+	if err := changes.modify(conn, modifyRequest, undoRequest, "moved to department "+department); err != nil {
+		m.logger.WithError(err).Error("Failed to move user")
+		return nil, fmt.Errorf("failed to move user: %w", err)
+	}
+
+	oldGroup, newGroup := m.config.DepartmentGroups[oldDepartment], m.config.DepartmentGroups[department]
+	if oldGroup != newGroup {
+		if oldGroup != "" {
+			group, err := m.readGroup(conn, oldGroup)
+			if err != nil {
+				m.rollback(conn, changes)
+				return nil, err
+			}
+			modifyRequest, undoRequest := m.memberRemoval(group, uid)
+			if err := changes.modify(conn, modifyRequest, undoRequest, "removed member "+uid); err != nil {
+				m.rollback(conn, changes)
+				return nil, fmt.Errorf("failed to remove user from group %s: %w", oldGroup, err)
+			}
+		}
+		if newGroup != "" {
+			group, err := m.readGroup(conn, newGroup)
+			if err != nil {
+				m.rollback(conn, changes)
+				return nil, err
+			}
+			modifyRequest, undoRequest := m.memberAddition(group, uid)
+			if err := changes.modify(conn, modifyRequest, undoRequest, "added member "+uid); err != nil {
+				m.rollback(conn, changes)
+				return nil, fmt.Errorf("failed to add user to group %s: %w", newGroup, err)
+			}
+		}
+	}
+
+	m.logger.WithFields(logrus.Fields{
+		"uid":        uid,
+		"department": department,
+	}).Info("User moved successfully")
+	return m.GetUser(withProvider(ctx), uid)
+}
+
+// departmentRepositories returns the repositories of a user who leaves a
+// department with oldRepos for one with newRepos. Repositories the user was
+// given directly are kept, unless the old department also had them.
+func departmentRepositories(repos, oldRepos, newRepos []string) []string {
+	result := make([]string, 0, len(repos)+len(newRepos))
+	for _, repo := range repos {
+		if !containsFold(oldRepos, repo) {
+			result = append(result, repo)
+		}
+	}
+	for _, repo := range newRepos {
+		if !containsFold(result, repo) {
+			result = append(result, repo)
+		}
+	}
+	return result
+}
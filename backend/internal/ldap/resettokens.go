@@ -0,0 +1,97 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/reset"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// resetsContainer is the container below StateDN() holding password reset tokens
+const resetsContainer = "resets"
+
+// ResetTokenStore keeps password reset tokens in the directory, so a link
+// works on every replica and survives restarts. It satisfies reset.Store.
+type ResetTokenStore struct {
+	m *Manager
+}
+
+// ResetTokens returns the LDAP-backed reset token store
+func (m *Manager) ResetTokens() *ResetTokenStore {
+	return &ResetTokenStore{m: m}
+}
+
+// Put stores token, invalidating the previous tokens of the same user
+func (s *ResetTokenStore) Put(ctx context.Context, token *reset.Token) error {
+	previous, err := s.m.searchState(ctx, resetsContainer, fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(token.UID)))
+	if err != nil {
+		return err
+	}
+	for _, e := range previous {
+		if _, err := s.m.deleteState(ctx, resetsContainer, e.key); err != nil {
+			return err
+		}
+	}
+
+	data, err := encodeState(token)
+	if err != nil {
+		return err
+	}
+	return s.m.addState(ctx, resetsContainer, &stateEntry{key: token.Hash, uid: token.UID, expiresAt: token.ExpiresAt, data: data})
+}
+
+// Consume removes and returns the live token with the given hash. Of
+// concurrent requests with the same token only the one whose delete
+// succeeds gets it.
+func (s *ResetTokenStore) Consume(ctx context.Context, hash string) (*reset.Token, error) {
+	e, err := s.m.readState(ctx, resetsContainer, hash)
+	if errors.Is(err, errStateNotFound) {
+		return nil, reset.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.m.deleteState(ctx, resetsContainer, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, reset.ErrInvalidToken
+	}
+	var token reset.Token
+	if err := e.decode(&token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Restore puts back a consumed token without touching the other tokens of the user
+func (s *ResetTokenStore) Restore(ctx context.Context, token *reset.Token) error {
+	data, err := encodeState(token)
+	if err != nil {
+		return err
+	}
+	return s.m.addState(ctx, resetsContainer, &stateEntry{key: token.Hash, uid: token.UID, expiresAt: token.ExpiresAt, data: data})
+}
+
+// LastIssued returns when the live token of uid was created, if there is one
+func (s *ResetTokenStore) LastIssued(ctx context.Context, uid string) (time.Time, bool) {
+	entries, err := s.m.searchState(ctx, resetsContainer, fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid)))
+	if err != nil {
+		s.m.logger.WithError(err).WithField("uid", uid).Warn("Failed to look up reset tokens")
+		return time.Time{}, false
+	}
+
+	var last time.Time
+	for _, e := range entries {
+		var token reset.Token
+		if err := e.decode(&token); err == nil && token.CreatedAt.After(last) {
+			last = token.CreatedAt
+		}
+	}
+	return last, !last.IsZero()
+}
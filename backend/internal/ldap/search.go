@@ -0,0 +1,562 @@
+package ldap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// userAttributes are the attributes read for every user entry
+var userAttributes = []string{"uid", "cn", "sn", "givenName", "mail", "departmentNumber", "uidNumber", "gidNumber", "homeDirectory", "githubRepository", "shadowExpire"}
+
+// sortOrderingRules are the user attributes that may be sorted on, with the
+// ordering rule the server sorts them by. uid and cn have no ORDERING rule in
+// the standard schema, so the rule has to be named in the sort request.
+var sortOrderingRules = map[string]string{
+	"uid":       "caseIgnoreOrderingMatch",
+	"cn":        "caseIgnoreOrderingMatch",
+	"sn":        "caseIgnoreOrderingMatch",
+	"givenName": "caseIgnoreOrderingMatch",
+	"mail":      "caseIgnoreOrderingMatch",
+	"uidNumber": "integerOrderingMatch",
+}
+
+// sortControl is a critical RFC 2891 server side sort request, so a server
+// that cannot sort fails the search instead of returning unsorted pages.
+// go-ldap's own control is never critical and always sends an orderingRule,
+// even an empty one.
+type sortControl struct {
+	keys []sortKey
+}
+
+// sortKey is one key of a sortControl, in order of precedence
+type sortKey struct {
+	attribute    string
+	orderingRule string
+	reverse      bool
+}
+
+func (c *sortControl) GetControlType() string {
+	return ldap.ControlTypeServerSideSorting
+}
+
+func (c *sortControl) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.GetControlType(), "Control Type"))
+	packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "Criticality"))
+
+	keys := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SortKeyList")
+	for _, k := range c.keys {
+		key := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "SortKey")
+		key.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k.attribute, "attributeType"))
+		if k.orderingRule != "" {
+			key.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, k.orderingRule, "orderingRule"))
+		}
+		if k.reverse {
+			key.AppendChild(ber.NewBoolean(ber.ClassContext, ber.TypePrimitive, 1, true, "reverseOrder"))
+		}
+		keys.AppendChild(key)
+	}
+
+	value := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, nil, "Control Value")
+	value.AppendChild(keys)
+	packet.AppendChild(value)
+	return packet
+}
+
+func (c *sortControl) String() string {
+	attributes := make([]string, 0, len(c.keys))
+	for _, k := range c.keys {
+		if k.reverse {
+			attributes = append(attributes, "-"+k.attribute)
+		} else {
+			attributes = append(attributes, k.attribute)
+		}
+	}
+	return fmt.Sprintf("Control Type: Server Side Sorting (%q)  Keys: %s", c.GetControlType(), strings.Join(attributes, ","))
+}
+
+// userSortControl returns the sort control for a users search, or nil when
+// server side sorting is disabled. An explicit sort requires it to be enabled.
+func (m *Manager) userSortControl(sort *models.UserSort) (ldap.Control, error) {
+	if !m.config.LDAPServerSideSort {
+		if sort != nil {
+			return nil, fmt.Errorf("sorting requires LDAP_SERVER_SIDE_SORT to be enabled")
+		}
+		return nil, nil
+	}
+
+	attribute, reverse, err := m.userSortKey(sort)
+	if err != nil {
+		return nil, err
+	}
+	return &sortControl{keys: []sortKey{{attribute: attribute, orderingRule: sortOrderingRules[attribute], reverse: reverse}}}, nil
+}
+
+// userSortKey returns the attribute and direction of a users sort
+func (m *Manager) userSortKey(sort *models.UserSort) (string, bool, error) {
+	attribute, reverse := m.config.LDAPUserSortAttribute, false
+	if sort != nil {
+		attribute, reverse = sort.Attribute, sort.Descending
+	}
+	if _, ok := sortOrderingRules[attribute]; !ok {
+		return "", false, fmt.Errorf("cannot sort users by %q", attribute)
+	}
+	return attribute, reverse, nil
+}
+
+// searchPaged runs req with RFC 2696 paged results, calling fn for every
+// entry until fn returns false. Stopping early releases the server's paging state.
+func (m *Manager) searchPaged(conn *ldap.Conn, req *ldap.SearchRequest, pageSize uint32, fn func(*ldap.Entry) bool) error {
+	paging := ldap.NewControlPaging(pageSize)
+	req.Controls = append(req.Controls, paging)
+
+	for {
+		result, err := conn.Search(req)
+		if err != nil {
+			return fmt.Errorf("search failed: %w", err)
+		}
+
+		for _, entry := range result.Entries {
+			if !fn(entry) {
+				return m.abandonPaging(conn, req, paging, result.Controls)
+			}
+		}
+
+		cookie := pagingCookie(result.Controls)
+		if len(cookie) == 0 {
+			return nil
+		}
+		paging.SetCookie(cookie)
+	}
+}
+
+// abandonPaging tells the server a paged search will not be continued
+func (m *Manager) abandonPaging(conn *ldap.Conn, req *ldap.SearchRequest, paging *ldap.ControlPaging, controls []ldap.Control) error {
+	cookie := pagingCookie(controls)
+	if len(cookie) == 0 {
+		return nil
+	}
+	paging.PagingSize = 0
+	paging.SetCookie(cookie)
+	if _, err := conn.Search(req); err != nil {
+		m.logger.WithError(err).Debug("Failed to abandon paged search")
+	}
+	return nil
+}
+
+func pagingCookie(controls []ldap.Control) []byte {
+	if control, ok := ldap.FindControl(controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+		return control.Cookie
+	}
+	return nil
+}
+
+// countEntries counts the entries matched by search without reading their
+// attributes. It stops after limit entries and reports the count as estimated.
+func (m *Manager) countEntries(conn *ldap.Conn, search *ldap.SearchRequest, limit int) (int, bool, error) {
+	req := ldap.NewSearchRequest(
+		search.BaseDN,
+		search.Scope,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		search.Filter,
+		[]string{"1.1"},
+		nil,
+	)
+
+	count, estimated := 0, false
+	err := m.searchPaged(conn, req, uint32(m.config.LDAPPageSize), func(*ldap.Entry) bool {
+		if limit > 0 && count >= limit {
+			estimated = true
+			return false
+		}
+		count++
+		return true
+	})
+	return count, estimated, err
+}
+
+// userFilter builds the LDAP filter for a users search. departments, when
+// given, replaces the department of filter.
+func userFilter(filter *models.SearchFilter, departments []string) string {
+	filterStr := "(objectClass=inetOrgPerson)"
+	if filter != nil {
+		filters := []string{"(objectClass=inetOrgPerson)"}
+		if len(departments) > 0 {
+			terms := make([]string, 0, len(departments))
+			for _, department := range departments {
+				terms = append(terms, fmt.Sprintf("(departmentNumber=%s)", ldap.EscapeFilter(department)))
+			}
+			filters = append(filters, fmt.Sprintf("(|%s)", strings.Join(terms, "")))
+		} else if filter.Department != "" {
+			filters = append(filters, fmt.Sprintf("(departmentNumber=%s)", ldap.EscapeFilter(filter.Department)))
+		}
+		if filter.Mail != "" {
+			filters = append(filters,
+				fmt.Sprintf("(mail=*%s*)", ldap.EscapeFilter(filter.Mail)),
+			)
+		}
+		if filter.CN != "" {
+			filters = append(filters, fmt.Sprintf("(cn=*%s*)", ldap.EscapeFilter(filter.CN)))
+		}
+		// Deleted users are selected by the search base, see userSearchBase
+		switch filter.Status {
+		case models.UserStatusActive:
+			filters = append(filters, fmt.Sprintf("(|(!(shadowExpire=*))(!%s))", expiredFilter(time.Now())))
+		case models.UserStatusDisabled:
+			filters = append(filters, expiredFilter(time.Now()))
+		}
+		if len(filters) > 1 {
+			filterStr = fmt.Sprintf("(&%s)", strings.Join(filters, ""))
+		}
+	}
+	return filterStr
+}
+
+// userWindow is a slice of a users search result
+type userWindow struct {
+	Users          []*models.User
+	Total          int
+	TotalEstimated bool
+	HasMore        bool
+}
+
+// listUserWindow returns up to limit users starting at offset. Entries are
+// streamed page by page, so memory stays bounded by the window rather than
+// the directory size.
+func (m *Manager) listUserWindow(ctx context.Context, filter *models.SearchFilter, sort *models.UserSort, offset, limit int) (*userWindow, error) {
+	sortCtrl, err := m.userSortControl(sort)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	filterStr, err := m.userSearchFilter(conn, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var controls []ldap.Control
+	if sortCtrl != nil {
+		controls = append(controls, sortCtrl)
+	}
+	req := ldap.NewSearchRequest(
+		m.userSearchBase(filter),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		filterStr,
+		userAttributes,
+		controls,
+	)
+
+	window := &userWindow{Users: make([]*models.User, 0, limit)}
+	window.Total, window.TotalEstimated, window.HasMore, err = m.searchWindow(conn, req, offset, limit, func(entry *ldap.Entry) {
+		window.Users = append(window.Users, m.entryToUser(entry))
+	})
+	if err != nil {
+		// Nothing has been deleted yet
+		if isNoSuchObject(err) {
+			return window, nil
+		}
+		return nil, err
+	}
+	return window, nil
+}
+
+// searchWindow calls fn for entries offset to offset+limit of a paged search
+// and returns the total number of matches and whether more entries follow.
+// The total is exact when the search reached the end, and counted
+// separately otherwise.
+func (m *Manager) searchWindow(conn *ldap.Conn, req *ldap.SearchRequest, offset, limit int, fn func(*ldap.Entry)) (int, bool, bool, error) {
+	// Read one entry past the window to learn whether there is a next page
+	want := offset + limit + 1
+	pageSize := m.config.LDAPPageSize
+	if want < pageSize {
+		pageSize = want
+	}
+
+	seen, hasMore := 0, false
+	err := m.searchPaged(conn, req, uint32(pageSize), func(entry *ldap.Entry) bool {
+		seen++
+		if seen > offset+limit {
+			hasMore = true
+			return false
+		}
+		if seen > offset {
+			fn(entry)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, false, false, err
+	}
+	if !hasMore {
+		return seen, false, false, nil
+	}
+
+	total, estimated, err := m.countEntries(conn, req, m.config.LDAPCountLimit)
+	if err != nil {
+		return 0, false, false, fmt.Errorf("failed to count entries: %w", err)
+	}
+	return total, estimated, true, nil
+}
+
+// ListUsersConnection returns the first users after the cursor after.
+// Cursors hold the sort key and uid of a user, and the next page is selected
+// by a filter on them, so deep pages cost no more than the first one. uid
+// breaks ties in the sort. Without server side sorting users are ordered by
+// uid, picked from the matches on the client.
+func (m *Manager) ListUsersConnection(ctx context.Context, filter *models.SearchFilter, sort *models.UserSort, first int, after string) (*models.UserConnection, error) {
+	if !m.config.LDAPServerSideSort && sort != nil {
+		return nil, fmt.Errorf("sorting requires LDAP_SERVER_SIDE_SORT to be enabled")
+	}
+	attribute, reverse := "uid", false
+	if m.config.LDAPServerSideSort {
+		var err error
+		if attribute, reverse, err = m.userSortKey(sort); err != nil {
+			return nil, err
+		}
+	}
+
+	var cursor *userCursor
+	if after != "" {
+		var err error
+		if cursor, err = decodeCursor(after); err != nil {
+			return nil, err
+		}
+		if cursor.Attribute != attribute || cursor.Descending != reverse {
+			return nil, fmt.Errorf("invalid cursor: it belongs to another sort order")
+		}
+	}
+
+	conn, err := m.getReadConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	filterStr, err := m.userSearchFilter(conn, filter)
+	if err != nil {
+		return nil, err
+	}
+	pageFilter := filterStr
+	if cursor != nil {
+		pageFilter = fmt.Sprintf("(&%s%s)", filterStr, cursor.filter())
+	}
+
+	var controls []ldap.Control
+	if m.config.LDAPServerSideSort {
+		keys := []sortKey{{attribute: attribute, orderingRule: sortOrderingRules[attribute], reverse: reverse}}
+		if attribute != "uid" {
+			keys = append(keys, sortKey{attribute: "uid", orderingRule: sortOrderingRules["uid"], reverse: reverse})
+		}
+		controls = append(controls, &sortControl{keys: keys})
+	}
+	req := ldap.NewSearchRequest(
+		m.userSearchBase(filter),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		pageFilter,
+		userAttributes,
+		controls,
+	)
+
+	// Read one entry past the page to learn whether there is a next one
+	var entries []*ldap.Entry
+	if m.config.LDAPServerSideSort {
+		pageSize := m.config.LDAPPageSize
+		if first+1 < pageSize {
+			pageSize = first + 1
+		}
+		err = m.searchPaged(conn, req, uint32(pageSize), func(entry *ldap.Entry) bool {
+			entries = append(entries, entry)
+			return len(entries) <= first
+		})
+	} else {
+		err = m.searchPaged(conn, req, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+			entries = insertByUID(entries, entry, first+1)
+			return true
+		})
+	}
+	if err != nil && !isNoSuchObject(err) {
+		return nil, err
+	}
+
+	hasMore := len(entries) > first
+	if hasMore {
+		entries = entries[:first]
+	}
+
+	result := &models.UserConnection{
+		Edges: make([]*models.UserEdge, 0, len(entries)),
+		PageInfo: &models.PageInfo{
+			HasNextPage:     hasMore,
+			HasPreviousPage: cursor != nil,
+		},
+	}
+	// The page alone tells the total only when it is the whole result
+	if cursor == nil && !hasMore {
+		result.Total = len(entries)
+	} else {
+		countReq := *req
+		countReq.Filter = filterStr
+		result.Total, result.TotalEstimated, err = m.countEntries(conn, &countReq, m.config.LDAPCountLimit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count entries: %w", err)
+		}
+	}
+
+	for _, entry := range entries {
+		result.Edges = append(result.Edges, &models.UserEdge{
+			Cursor: encodeCursor(&userCursor{
+				Attribute:  attribute,
+				Descending: reverse,
+				Value:      sortValue(entry, attribute),
+				UID:        entry.GetAttributeValue("uid"),
+			}),
+			Node: m.entryToUser(entry),
+		})
+	}
+	if len(result.Edges) > 0 {
+		result.PageInfo.StartCursor = result.Edges[0].Cursor
+		result.PageInfo.EndCursor = result.Edges[len(result.Edges)-1].Cursor
+	}
+	return result, nil
+}
+
+// insertByUID adds entry to entries, which are ordered by uid, keeping the
+// limit lowest
+func insertByUID(entries []*ldap.Entry, entry *ldap.Entry, limit int) []*ldap.Entry {
+	uid := strings.ToLower(entry.GetAttributeValue("uid"))
+	i := len(entries)
+	for i > 0 && strings.ToLower(entries[i-1].GetAttributeValue("uid")) > uid {
+		i--
+	}
+	if i >= limit {
+		return entries
+	}
+	entries = append(entries[:i], append([]*ldap.Entry{entry}, entries[i:]...)...)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// sortValue returns the value a server side sort orders entry by: the lowest
+// value of attribute, or "" when it has none
+func sortValue(entry *ldap.Entry, attribute string) string {
+	lowest := ""
+	for i, value := range entry.GetAttributeValues(attribute) {
+		if i == 0 || compareSortValues(attribute, value, lowest) < 0 {
+			lowest = value
+		}
+	}
+	return lowest
+}
+
+// compareSortValues orders two values by the ordering rule of attribute
+func compareSortValues(attribute, a, b string) int {
+	if sortOrderingRules[attribute] == "integerOrderingMatch" {
+		x, errA := strconv.Atoi(a)
+		y, errB := strconv.Atoi(b)
+		if errA == nil && errB == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// Cursors are opaque to clients; they encode the last user of a page
+const cursorPrefix = "user:"
+
+// userCursor is the position after a user in a sorted users connection.
+// Value is empty for users without the sort attribute, which sort last.
+type userCursor struct {
+	Attribute  string `json:"a"`
+	Descending bool   `json:"d,omitempty"`
+	Value      string `json:"v,omitempty"`
+	UID        string `json:"u"`
+}
+
+// filter matches the users that sort after the cursor. An extensible match
+// with an ordering rule asserts "less than" (RFC 4517); uid and most other
+// sort attributes have no ORDERING rule, so <= and >= cannot be used.
+func (c *userCursor) filter() string {
+	uidAfter := orderingFilter("uid", c.UID, c.Descending)
+	if c.Attribute == "uid" {
+		return uidAfter
+	}
+
+	present := fmt.Sprintf("(%s=*)", c.Attribute)
+	if c.Value == "" {
+		// Users without the attribute come last, or first when descending
+		if c.Descending {
+			return fmt.Sprintf("(|%s(&(!%s)%s))", present, present, uidAfter)
+		}
+		return fmt.Sprintf("(&(!%s)%s)", present, uidAfter)
+	}
+
+	equal := fmt.Sprintf("(%s=%s)", c.Attribute, ldap.EscapeFilter(c.Value))
+	after := fmt.Sprintf("%s(&%s%s)", orderingFilter(c.Attribute, c.Value, c.Descending), equal, uidAfter)
+	if !c.Descending {
+		// Users without the attribute follow every value. Say so rather than rely
+		// on how a server evaluates the negations above for them.
+		after += fmt.Sprintf("(!%s)", present)
+	}
+	return "(|" + after + ")"
+}
+
+// orderingFilter matches values of attribute strictly after value, or
+// strictly before it when descending
+func orderingFilter(attribute, value string, descending bool) string {
+	less := fmt.Sprintf("(%s:%s:=%s)", attribute, sortOrderingRules[attribute], ldap.EscapeFilter(value))
+	if descending {
+		return less
+	}
+	return fmt.Sprintf("(&(!%s)(!(%s=%s)))", less, attribute, ldap.EscapeFilter(value))
+}
+
+func encodeCursor(cursor *userCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(append([]byte(cursorPrefix), data...))
+}
+
+func decodeCursor(cursor string) (*userCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(raw), cursorPrefix) {
+		var c userCursor
+		err := json.Unmarshal(raw[len(cursorPrefix):], &c)
+		if _, known := sortOrderingRules[c.Attribute]; err == nil && known && c.UID != "" {
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid cursor")
+}
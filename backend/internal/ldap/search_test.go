@@ -0,0 +1,56 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	for _, c := range []*userCursor{
+		{Attribute: "uid", UID: "alice"},
+		{Attribute: "mail", Descending: true, Value: "bob@devplatform.local", UID: "bob"},
+		{Attribute: "uidNumber", UID: "carol"},
+	} {
+		got, err := decodeCursor(encodeCursor(c))
+		if err != nil || !reflect.DeepEqual(got, c) {
+			t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v, %v", c, got, err)
+		}
+	}
+
+	for _, cursor := range []string{
+		"",
+		"not base64!",
+		encodeCursor(&userCursor{Attribute: "uid"}),
+		encodeCursor(&userCursor{Attribute: "userPassword", UID: "alice"}),
+	} {
+		if c, err := decodeCursor(cursor); err == nil {
+			t.Errorf("decodeCursor(%q) = %+v, want an error", cursor, c)
+		}
+	}
+}
+
+func TestCursorFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor userCursor
+		want   string
+	}{
+		{"uid", userCursor{Attribute: "uid", UID: "bob"},
+			"(&(!(uid:caseIgnoreOrderingMatch:=bob))(!(uid=bob)))"},
+		{"uid descending", userCursor{Attribute: "uid", Descending: true, UID: "bob"},
+			"(uid:caseIgnoreOrderingMatch:=bob)"},
+		{"value", userCursor{Attribute: "mail", Value: "b*@x", UID: "bob"},
+			`(|(&(!(mail:caseIgnoreOrderingMatch:=b\2a@x))(!(mail=b\2a@x)))(&(mail=b\2a@x)(&(!(uid:caseIgnoreOrderingMatch:=bob))(!(uid=bob))))(!(mail=*)))`},
+		{"value descending", userCursor{Attribute: "mail", Descending: true, Value: "b@x", UID: "bob"},
+			"(|(mail:caseIgnoreOrderingMatch:=b@x)(&(mail=b@x)(uid:caseIgnoreOrderingMatch:=bob)))"},
+		{"without the attribute", userCursor{Attribute: "mail", UID: "bob"},
+			"(&(!(mail=*))(&(!(uid:caseIgnoreOrderingMatch:=bob))(!(uid=bob))))"},
+		{"without the attribute descending", userCursor{Attribute: "mail", Descending: true, UID: "bob"},
+			"(|(mail=*)(&(!(mail=*))(uid:caseIgnoreOrderingMatch:=bob)))"},
+	}
+	for _, tt := range tests {
+		if got := tt.cursor.filter(); got != tt.want {
+			t.Errorf("%s: filter = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
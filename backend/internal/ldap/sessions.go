@@ -0,0 +1,121 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/session"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// Containers below StateDN() holding sessions and revoked access token IDs
+const (
+	sessionsContainer    = "sessions"
+	revocationsContainer = "revocations"
+)
+
+// SessionStore keeps sessions and revoked access token IDs in the directory,
+// so every replica sees logins and revocations made on the others. It
+// satisfies session.Store.
+type SessionStore struct {
+	m *Manager
+}
+
+// Sessions returns the LDAP-backed session store
+func (m *Manager) Sessions() *SessionStore {
+	return &SessionStore{m: m}
+}
+
+// Create stores a new session
+func (s *SessionStore) Create(ctx context.Context, sess *session.Session) error {
+	data, err := encodeState(sess)
+	if err != nil {
+		return err
+	}
+	return s.m.addState(ctx, sessionsContainer, &stateEntry{key: sess.ID, uid: sess.UID, expiresAt: sess.ExpiresAt, data: data})
+}
+
+// Get returns a live session
+func (s *SessionStore) Get(ctx context.Context, id string) (*session.Session, error) {
+	e, err := s.m.readState(ctx, sessionsContainer, id)
+	if errors.Is(err, errStateNotFound) {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sess session.Session
+	if err := e.decode(&sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+// Update replaces an existing session while its stored document is still
+// the encoding of from
+func (s *SessionStore) Update(ctx context.Context, sess *session.Session, from *session.Session) error {
+	data, err := encodeState(sess)
+	if err != nil {
+		return err
+	}
+	previous, err := encodeState(from)
+	if err != nil {
+		return err
+	}
+	err = s.m.replaceState(ctx, sessionsContainer, &stateEntry{key: sess.ID, expiresAt: sess.ExpiresAt, data: data}, previous)
+	switch {
+	case isNoSuchObject(err):
+		return session.ErrNotFound
+	case isNoSuchAttribute(err):
+		return session.ErrConflict
+	}
+	return err
+}
+
+// Delete removes a session
+func (s *SessionStore) Delete(ctx context.Context, id string) error {
+	_, err := s.m.deleteState(ctx, sessionsContainer, id)
+	return err
+}
+
+// ListByUser returns the live sessions of uid, most recently used first
+func (s *SessionStore) ListByUser(ctx context.Context, uid string) ([]*session.Session, error) {
+	entries, err := s.m.searchState(ctx, sessionsContainer, fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid)))
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*session.Session, 0, len(entries))
+	for _, e := range entries {
+		var sess session.Session
+		if err := e.decode(&sess); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &sess)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+// Revoke marks a token ID as revoked until the given time
+func (s *SessionStore) Revoke(ctx context.Context, jti string, until time.Time) error {
+	err := s.m.addState(ctx, revocationsContainer, &stateEntry{key: jti, expiresAt: until})
+	if isEntryAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// IsRevoked reports whether a token ID has been revoked
+func (s *SessionStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	_, err := s.m.readState(ctx, revocationsContainer, jti)
+	if errors.Is(err, errStateNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
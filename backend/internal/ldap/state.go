@@ -0,0 +1,319 @@
+package ldap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+const (
+	stateObjectClass = "devplatformStateEntry"
+	stateExpiresAttr = "devplatformExpiresAt"
+	stateDataAttr    = "devplatformStateData"
+)
+
+// errStateNotFound is returned for missing and expired state entries
+var errStateNotFound = errors.New("state entry not found")
+
+// stateEntry is one entry below a container of StateDN(), keyed by its cn.
+// Entries without an expiry are kept until deleted.
+type stateEntry struct {
+	key       string
+	uid       string
+	expiresAt time.Time
+	data      string
+}
+
+// expired reports whether the entry should be treated as gone
+func (e *stateEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// decode unmarshals the JSON document of the entry into value
+func (e *stateEntry) decode(value interface{}) error {
+	if err := json.Unmarshal([]byte(e.data), value); err != nil {
+		return fmt.Errorf("failed to decode state entry %s: %w", e.key, err)
+	}
+	return nil
+}
+
+// stateContainerDN returns the DN of a container below StateDN()
+func (m *Manager) stateContainerDN(container string) string {
+	return "ou=" + ldap.EscapeDN(container) + "," + m.config.StateDN()
+}
+
+// stateDN returns the DN of the entry stored under key in container
+func (m *Manager) stateDN(container, key string) string {
+	return "cn=" + ldap.EscapeDN(key) + "," + m.stateContainerDN(container)
+}
+
+// encodeState returns value as the JSON document of a state entry
+func encodeState(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode state entry: %w", err)
+	}
+	return string(data), nil
+}
+
+// addState adds an entry to container, creating the containers on first use.
+// It fails with LDAPResultEntryAlreadyExists if key is taken.
+func (m *Manager) addState(ctx context.Context, container string, e *stateEntry) error {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	addRequest := ldap.NewAddRequest(m.stateDN(container, e.key), nil)
+	addRequest.Attribute("objectClass", []string{stateObjectClass})
+	addRequest.Attribute("cn", []string{e.key})
+	if e.uid != "" {
+		addRequest.Attribute("uid", []string{e.uid})
+	}
+	if !e.expiresAt.IsZero() {
+		addRequest.Attribute(stateExpiresAttr, []string{e.expiresAt.UTC().Format(generalizedTime)})
+	}
+	if e.data != "" {
+		addRequest.Attribute(stateDataAttr, []string{e.data})
+	}
+
+	err = conn.Add(addRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		// The entry is new, so a missing object is one of the containers
+		for _, parent := range []struct{ dn, ou string }{
+			{m.config.StateDN(), "state"},
+			{m.stateContainerDN(container), container},
+		} {
+			containerRequest := ldap.NewAddRequest(parent.dn, nil)
+			containerRequest.Attribute("objectClass", []string{"organizationalUnit"})
+			containerRequest.Attribute("ou", []string{parent.ou})
+			if err := conn.Add(containerRequest); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+				return fmt.Errorf("failed to create %s: %w", parent.dn, err)
+			}
+		}
+		err = conn.Add(addRequest)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add state entry %s: %w", e.key, err)
+	}
+	return nil
+}
+
+// readState returns the live entry stored under key. Reads go to the
+// provider, since state is read back right after it is written.
+func (m *Manager) readState(ctx context.Context, container, key string) (*stateEntry, error) {
+	e, err := m.lookupState(ctx, container, key)
+	if err != nil {
+		return nil, err
+	}
+	if e.expired(time.Now()) {
+		return nil, errStateNotFound
+	}
+	return e, nil
+}
+
+// lookupState returns the entry stored under key, expired or not
+func (m *Manager) lookupState(ctx context.Context, container, key string) (*stateEntry, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.stateDN(container, key),
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass="+stateObjectClass+")",
+		stateAttributes,
+		nil,
+	)
+	result, err := conn.Search(searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, errStateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state entry %s: %w", key, err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, errStateNotFound
+	}
+
+	return m.stateEntryFrom(result.Entries[0]), nil
+}
+
+// searchState returns the live entries of container matching filter
+func (m *Manager) searchState(ctx context.Context, container, filter string) ([]*stateEntry, error) {
+	conn, err := m.getConnection(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.stateContainerDN(container),
+		ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&(objectClass=%s)%s)", stateObjectClass, filter),
+		stateAttributes,
+		nil,
+	)
+
+	now := time.Now()
+	var entries []*stateEntry
+	err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		if e := m.stateEntryFrom(entry); !e.expired(now) {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	if isNoSuchObject(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// replaceState swaps the JSON document and expiry of an entry. Unless from
+// is empty the change only applies while the stored document still equals
+// from, so concurrent writers cannot both succeed.
+func (m *Manager) replaceState(ctx context.Context, container string, e *stateEntry, from string) error {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	modifyRequest := ldap.NewModifyRequest(m.stateDN(container, e.key), nil)
+	if from != "" {
+		modifyRequest.Delete(stateDataAttr, []string{from})
+		modifyRequest.Add(stateDataAttr, []string{e.data})
+	} else {
+		modifyRequest.Replace(stateDataAttr, []string{e.data})
+	}
+	// Replacing with no values removes the expiry
+	expiresAt := []string{}
+	if !e.expiresAt.IsZero() {
+		expiresAt = []string{e.expiresAt.UTC().Format(generalizedTime)}
+	}
+	modifyRequest.Replace(stateExpiresAttr, expiresAt)
+	if err := conn.Modify(modifyRequest); err != nil {
+		return fmt.Errorf("failed to update state entry %s: %w", e.key, err)
+	}
+	return nil
+}
+
+// deleteState removes the entry stored under key. It reports whether the
+// entry existed, which tells concurrent consumers of an entry who got it.
+func (m *Manager) deleteState(ctx context.Context, container, key string) (bool, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	err = conn.Del(ldap.NewDelRequest(m.stateDN(container, key), nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete state entry %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// PurgeExpiredState removes expired entries below StateDN() and returns how
+// many were removed. Readers already ignore them; this keeps the tree small.
+func (m *Manager) PurgeExpiredState(ctx context.Context) (int, error) {
+	conn, err := m.getWriteConnection(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer m.returnConnection(conn)
+
+	searchRequest := ldap.NewSearchRequest(
+		m.config.StateDN(),
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		fmt.Sprintf("(&(objectClass=%s)(%s<=%s))", stateObjectClass, stateExpiresAttr, time.Now().UTC().Format(generalizedTime)),
+		[]string{"1.1"},
+		nil,
+	)
+	var expired []string
+	err = m.searchPaged(conn, searchRequest, uint32(m.config.LDAPPageSize), func(entry *ldap.Entry) bool {
+		expired = append(expired, entry.DN)
+		return true
+	})
+	if err != nil {
+		if isNoSuchObject(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	purged := 0
+	for _, dn := range expired {
+		if err := conn.Del(ldap.NewDelRequest(dn, nil)); err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			m.logger.WithError(err).WithField("dn", dn).Error("Failed to purge expired state entry")
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartStatePurge runs PurgeExpiredState every interval until the manager is closed
+func (m *Manager) StartStatePurge(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := m.PurgeExpiredState(context.Background()); err != nil {
+					m.logger.WithError(err).Error("Failed to purge expired state")
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+var stateAttributes = []string{"cn", "uid", stateExpiresAttr, stateDataAttr}
+
+func (m *Manager) stateEntryFrom(entry *ldap.Entry) *stateEntry {
+	e := &stateEntry{
+		key:  entry.GetAttributeValue("cn"),
+		uid:  entry.GetAttributeValue("uid"),
+		data: entry.GetAttributeValue(stateDataAttr),
+	}
+	if value := entry.GetAttributeValue(stateExpiresAttr); value != "" {
+		expiresAt, err := time.Parse(generalizedTime, value)
+		if err != nil {
+			// Readers ignore entries with an unreadable expiry
+			m.logger.WithField("dn", entry.DN).Warn("Unparseable state expiry, treating entry as expired")
+			expiresAt = time.Unix(0, 0)
+		}
+		e.expiresAt = expiresAt
+	}
+	return e
+}
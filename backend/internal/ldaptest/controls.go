@@ -0,0 +1,188 @@
+package ldaptest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// control is a request control as sent by the client
+type control struct {
+	oid      string
+	critical bool
+	value    []byte
+}
+
+// decodeControls reads the controls of a request envelope
+func decodeControls(envelope *ber.Packet) []*control {
+	if len(envelope.Children) < 3 {
+		return nil
+	}
+	var controls []*control
+	for _, child := range envelope.Children[2].Children {
+		if len(child.Children) == 0 {
+			continue
+		}
+		c := &control{oid: child.Children[0].Data.String()}
+		for _, field := range child.Children[1:] {
+			switch field.Tag {
+			case ber.TagBoolean:
+				c.critical, _ = field.Value.(bool)
+			case ber.TagOctetString:
+				c.value = field.Data.Bytes()
+			}
+		}
+		controls = append(controls, c)
+	}
+	return controls
+}
+
+// checkCritical fails for critical controls the operation does not support
+func checkCritical(controls []*control, supported ...string) error {
+	for _, c := range controls {
+		if !c.critical {
+			continue
+		}
+		known := false
+		for _, oid := range supported {
+			known = known || c.oid == oid
+		}
+		if !known {
+			return ldap.NewError(ldap.LDAPResultUnavailableCriticalExtension, fmt.Errorf("critical extension %s is unavailable", c.oid))
+		}
+	}
+	return nil
+}
+
+func findControl(controls []*control, oid string) *control {
+	for _, c := range controls {
+		if c.oid == oid {
+			return c
+		}
+	}
+	return nil
+}
+
+// pagingRequest is an RFC 2696 paged results request. The cookie is the
+// offset of the next page, so pages reflect changes made between them.
+type pagingRequest struct {
+	size   int
+	offset int
+}
+
+func decodePaging(c *control) (*pagingRequest, error) {
+	invalid := ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("paged results control value is invalid"))
+	packet, err := ber.DecodePacketErr(c.value)
+	if err != nil || len(packet.Children) != 2 {
+		return nil, invalid
+	}
+	size, ok := packet.Children[0].Value.(int64)
+	if !ok || size < 0 {
+		return nil, invalid
+	}
+
+	p := &pagingRequest{size: int(size)}
+	if cookie := packet.Children[1].Data.String(); cookie != "" {
+		offset, err := strconv.Atoi(cookie)
+		if err != nil || offset < 0 {
+			return nil, ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("paged results cookie is invalid"))
+		}
+		p.offset = offset
+	}
+	return p, nil
+}
+
+// pagingResponse encodes the paged results control of a search result.
+// An empty cookie ends the search.
+func pagingResponse(cookie string) *ber.Packet {
+	paging := ldap.NewControlPaging(0)
+	paging.SetCookie([]byte(cookie))
+	return paging.Encode()
+}
+
+// sortKey is one key of an RFC 2891 server side sort request
+type sortKey struct {
+	attribute    string
+	orderingRule string
+	reverse      bool
+}
+
+// orderingRules are the ordering rules the server sorts by
+var orderingRules = map[string]bool{
+	"":                        true,
+	"caseIgnoreOrderingMatch": true,
+	"caseExactOrderingMatch":  true,
+	"integerOrderingMatch":    true,
+}
+
+func decodeSort(c *control) ([]*sortKey, error) {
+	invalid := ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("sort control value is invalid"))
+	packet, err := ber.DecodePacketErr(c.value)
+	if err != nil || len(packet.Children) == 0 {
+		return nil, invalid
+	}
+
+	var keys []*sortKey
+	for _, child := range packet.Children {
+		if len(child.Children) == 0 {
+			return nil, invalid
+		}
+		key := &sortKey{attribute: child.Children[0].Data.String()}
+		for _, field := range child.Children[1:] {
+			switch field.Tag {
+			case 0:
+				key.orderingRule = field.Data.String()
+			case 1:
+				key.reverse = len(field.Data.Bytes()) > 0 && field.Data.Bytes()[0] != 0
+			}
+		}
+		if !orderingRules[key.orderingRule] {
+			return nil, ldap.NewError(ldap.LDAPResultInappropriateMatching, fmt.Errorf("unknown ordering rule %s", key.orderingRule))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sortEntries orders entries by keys. Entries without a value for a key sort
+// after all others, as RFC 2891 asks.
+func (s *Schema) sortEntries(entries []*entry, keys []*sortKey) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		for _, key := range keys {
+			a, b := s.sortValue(entries[i], key), s.sortValue(entries[j], key)
+			var c int
+			switch {
+			case a == nil && b == nil:
+				continue
+			case a == nil:
+				c = 1
+			case b == nil:
+				c = -1
+			default:
+				c = s.compare(key.attribute, key.orderingRule, *a, *b)
+			}
+			if key.reverse {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+// sortValue returns the smallest value of the key attribute, or nil
+func (s *Schema) sortValue(e *entry, key *sortKey) *string {
+	var lowest *string
+	for _, value := range e.values(key.attribute) {
+		value := value
+		if lowest == nil || s.compare(key.attribute, key.orderingRule, value, *lowest) < 0 {
+			lowest = &value
+		}
+	}
+	return lowest
+}
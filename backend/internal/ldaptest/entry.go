@@ -0,0 +1,100 @@
+package ldaptest
+
+import (
+	"strings"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// attribute is one attribute of an entry. Names are kept in the spelling of
+// the schema, because clients look attributes up case-sensitively.
+type attribute struct {
+	name   string
+	values []string
+}
+
+// entry is one directory entry. seq is its creation order, which is also the
+// order searches return entries in; renames keep it.
+type entry struct {
+	seq   int
+	dn    *ldap.DN
+	attrs []*attribute
+}
+
+func (e *entry) get(name string) *attribute {
+	for _, attr := range e.attrs {
+		if strings.EqualFold(attr.name, name) {
+			return attr
+		}
+	}
+	return nil
+}
+
+func (e *entry) values(name string) []string {
+	if attr := e.get(name); attr != nil {
+		return attr.values
+	}
+	return nil
+}
+
+// set replaces the values of an attribute, removing it when values is empty
+func (e *entry) set(name string, values []string) {
+	for i, attr := range e.attrs {
+		if strings.EqualFold(attr.name, name) {
+			if len(values) == 0 {
+				e.attrs = append(e.attrs[:i], e.attrs[i+1:]...)
+			} else {
+				attr.values = values
+			}
+			return
+		}
+	}
+	if len(values) > 0 {
+		e.attrs = append(e.attrs, &attribute{name: name, values: values})
+	}
+}
+
+func (e *entry) clone() *entry {
+	c := &entry{seq: e.seq, dn: e.dn, attrs: make([]*attribute, 0, len(e.attrs))}
+	for _, attr := range e.attrs {
+		c.attrs = append(c.attrs, &attribute{name: attr.name, values: append([]string{}, attr.values...)})
+	}
+	return c
+}
+
+// normalizeDN returns a key under which equal DNs compare equal
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	return dnKey(parsed)
+}
+
+func dnKey(dn *ldap.DN) string {
+	rdns := make([]string, 0, len(dn.RDNs))
+	for _, rdn := range dn.RDNs {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, attr := range rdn.Attributes {
+			attrs = append(attrs, strings.ToLower(attr.Type)+"="+strings.ToLower(ldap.EscapeDN(strings.TrimSpace(attr.Value))))
+		}
+		rdns = append(rdns, strings.Join(attrs, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
+
+// parentDN returns the DN one level up, which is empty for top-level entries
+func parentDN(dn *ldap.DN) *ldap.DN {
+	if len(dn.RDNs) == 0 {
+		return dn
+	}
+	return &ldap.DN{RDNs: dn.RDNs[1:]}
+}
+
+// isBelow reports whether key names an entry strictly below base
+func isBelow(key, base string) bool {
+	if base == "" {
+		return key != ""
+	}
+	return strings.HasSuffix(key, ","+base)
+}
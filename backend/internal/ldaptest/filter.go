@@ -0,0 +1,186 @@
+package ldaptest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// match evaluates an RFC 4511 filter against an entry. Undefined results,
+// e.g. for unknown attributes, count as false.
+func (s *Schema) match(f *ber.Packet, e *entry) (bool, error) {
+	if f.ClassType != ber.ClassContext {
+		return false, ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid filter"))
+	}
+
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, child := range f.Children {
+			ok, err := s.match(child, e)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case ldap.FilterOr:
+		for _, child := range f.Children {
+			ok, err := s.match(child, e)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case ldap.FilterNot:
+		if len(f.Children) != 1 {
+			return false, ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid not filter"))
+		}
+		ok, err := s.match(f.Children[0], e)
+		return !ok, err
+	case ldap.FilterPresent:
+		return len(e.values(f.Data.String())) > 0, nil
+	case ldap.FilterEqualityMatch, ldap.FilterApproxMatch:
+		attr, value, err := assertion(f)
+		if err != nil {
+			return false, err
+		}
+		if strings.EqualFold(attr, "objectClass") {
+			return s.hasObjectClass(e, value), nil
+		}
+		return s.contains(e.values(attr), attr, value), nil
+	case ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual:
+		attr, value, err := assertion(f)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range e.values(attr) {
+			c := s.compare(attr, "", v, value)
+			if (f.Tag == ldap.FilterGreaterOrEqual && c >= 0) || (f.Tag == ldap.FilterLessOrEqual && c <= 0) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ldap.FilterSubstrings:
+		if len(f.Children) != 2 {
+			return false, ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid substrings filter"))
+		}
+		attr := f.Children[0].Data.String()
+		for _, v := range e.values(attr) {
+			if s.matchSubstrings(attr, v, f.Children[1].Children) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ldap.FilterExtensibleMatch:
+		var rule, attr, value string
+		for _, child := range f.Children {
+			switch child.Tag {
+			case ldap.MatchingRuleAssertionMatchingRule:
+				rule = child.Data.String()
+			case ldap.MatchingRuleAssertionType:
+				attr = child.Data.String()
+			case ldap.MatchingRuleAssertionMatchValue:
+				value = child.Data.String()
+			}
+		}
+		// An ordering rule asserts that a value sorts before the assertion
+		// (RFC 4517). Like OpenLDAP, other rules the server does not know
+		// match nothing.
+		if !orderingRules[rule] || attr == "" {
+			return false, nil
+		}
+		for _, v := range e.values(attr) {
+			if s.compare(attr, rule, v, value) < 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("unknown filter choice %d", f.Tag))
+}
+
+func assertion(f *ber.Packet) (string, string, error) {
+	if len(f.Children) != 2 {
+		return "", "", ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid attribute value assertion"))
+	}
+	return f.Children[0].Data.String(), f.Children[1].Data.String(), nil
+}
+
+// hasObjectClass reports whether an entry is of class, directly or through a subclass
+func (s *Schema) hasObjectClass(e *entry, class string) bool {
+	for _, value := range e.values("objectClass") {
+		for _, name := range s.superclasses(value) {
+			if strings.EqualFold(name, class) {
+				return true
+			}
+		}
+		if strings.EqualFold(value, class) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) matchSubstrings(attr, value string, parts []*ber.Packet) bool {
+	fold := func(v string) string { return v }
+	if at, ok := s.AttributeType(attr); !ok || at.Equality == MatchCaseIgnore || at.Equality == MatchDN {
+		fold = strings.ToLower
+	}
+
+	value = fold(value)
+	for _, part := range parts {
+		sub := fold(part.Data.String())
+		switch part.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, sub) {
+				return false
+			}
+			value = value[len(sub):]
+		case ldap.FilterSubstringsAny:
+			i := strings.Index(value, sub)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(sub):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, sub) {
+				return false
+			}
+			value = ""
+		}
+	}
+	return true
+}
+
+// compare orders two values of attr. The ordering rule defaults to the one
+// matching the equality rule of the attribute.
+func (s *Schema) compare(attr, rule, a, b string) int {
+	numeric := rule == "integerOrderingMatch"
+	exact := rule == "caseExactOrderingMatch"
+	if rule == "" {
+		if at, ok := s.AttributeType(attr); ok {
+			numeric = at.Equality == MatchInteger
+			exact = at.Equality == MatchCaseExact || at.Equality == MatchOctet
+		}
+	}
+
+	if numeric {
+		x, errA := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+		y, errB := strconv.ParseInt(strings.TrimSpace(b), 10, 64)
+		if errA == nil && errB == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if !exact {
+		a, b = strings.ToLower(a), strings.ToLower(b)
+	}
+	return strings.Compare(a, b)
+}
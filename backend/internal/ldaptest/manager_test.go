@@ -0,0 +1,1566 @@
+package ldaptest_test
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/ldap"
+	"github.com/devplatform/ldap-manager/internal/ldaptest"
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/password"
+	"github.com/devplatform/ldap-manager/internal/registration"
+	"github.com/devplatform/ldap-manager/internal/reset"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	baseDN       = "dc=devplatform,dc=local"
+	rootDN       = "cn=admin," + baseDN
+	rootPassword = "admin-secret"
+)
+
+// Passwords of the fixture users
+const (
+	alicePassword = "Alice-Pass-123"
+	bobPassword   = "Bob-Pass-123"
+	carolPassword = "Carol-Pass-123"
+	newPassword   = "Fresh-Start-456"
+)
+
+// env is one directory under test. For the LDAP backend m is the Manager
+// talking to its own test server srv; both are nil for the in-memory one.
+type env struct {
+	srv *ldaptest.Server
+	m   *ldap.Manager
+	dir directory.Directory
+	cfg *config.Config
+}
+
+// backends are the directory implementations TestDirectory runs against
+var backends = []struct {
+	name string
+	env  func(t *testing.T) *env
+}{
+	{directory.BackendLDAP, newEnv},
+	{directory.BackendMemory, newMemoryEnv},
+}
+
+// newEnv starts a server with the fixture below and a Manager connected to it:
+//
+//	departments  Engineering (manager alice) > Platform, Sales
+//	users        alice (Engineering), bob (Platform), carol (Sales)
+//	groups       admins {bob}, developers {alice}, sales {}
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	return startEnv(t, nil, seed)
+}
+
+// startEnv starts a server with schema, or the default one when nil, filled
+// by seed, or holding only the base entry when seed is nil, and a Manager
+// connected to it
+func startEnv(t *testing.T, schema *ldaptest.Schema, seed func(*testing.T, *ldaptest.Server)) *env {
+	t.Helper()
+
+	srv, err := ldaptest.NewServer(ldaptest.Config{BaseDN: baseDN, RootDN: rootDN, RootPassword: rootPassword, Schema: schema})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	if seed != nil {
+		seed(t, srv)
+	}
+
+	t.Setenv("LDAP_URL", srv.URL())
+	cfg := loadConfig(t)
+	m, err := ldap.NewManager(cfg, quietLogger())
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	return &env{srv: srv, m: m, dir: m, cfg: cfg}
+}
+
+// newMemoryEnv returns an in-memory directory holding the fixture of newEnv,
+// created through the Directory methods. Users get their gidNumbers from the
+// same counter as groups, so those differ from the LDAP fixture.
+func newMemoryEnv(t *testing.T) *env {
+	t.Helper()
+
+	t.Setenv("STARTING_GID", "10100")
+	cfg := loadConfig(t)
+	dir, err := directory.NewMemoryDirectory(cfg, quietLogger())
+	if err != nil {
+		t.Fatalf("NewMemoryDirectory: %v", err)
+	}
+	t.Cleanup(func() { dir.Close() })
+
+	hasher, err := password.NewHasher("SSHA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, cn := range []string{"admins", "developers", "sales"} {
+		result(dir.CreateGroup(ctx, cn, "")).must(t)
+	}
+	result(dir.CreateDepartment(ctx, &models.CreateDepartmentInput{
+		OU: "Engineering", Description: "Builds things", Repositories: []string{"devplatform/api"},
+	})).must(t)
+	result(dir.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Platform", Parent: "Engineering"})).must(t)
+	result(dir.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Sales", Repositories: []string{"devplatform/crm"}})).must(t)
+
+	// Hashed up front like the LDAP fixture, since the passwords break the policy
+	user := func(uid, given, sn, department, plain string, repos ...string) {
+		hashed, err := hasher.Hash(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result(dir.CreateUser(ctx, &models.CreateUserInput{
+			UID: uid, CN: given + " " + sn, SN: sn, GivenName: given, Mail: uid + "@devplatform.local",
+			Department: department, PasswordHash: hashed, Repositories: repos,
+		})).must(t)
+	}
+	user("alice", "Alice", "Archer", "Engineering", alicePassword, "devplatform/api", "devplatform/tools")
+	user("bob", "Bob", "Baker", "Platform", bobPassword)
+	user("carol", "Carol", "Cooper", "Sales", carolPassword)
+	result(dir.UpdateDepartment(ctx, &models.UpdateDepartmentInput{OU: "Engineering", Manager: ptr("alice")})).must(t)
+	check(t, dir.AddUserToGroup(ctx, "bob", "admins"))
+	check(t, dir.AddUserToGroup(ctx, "alice", "developers"))
+
+	return &env{dir: dir, cfg: cfg}
+}
+
+// loadConfig returns the configuration of the test directories
+func loadConfig(t *testing.T) *config.Config {
+	t.Helper()
+	t.Setenv("LDAP_BASE_DN", baseDN)
+	t.Setenv("LDAP_BIND_DN", rootDN)
+	t.Setenv("LDAP_BIND_PASSWORD", rootPassword)
+	// Small pages so every listing spans several of them
+	t.Setenv("LDAP_PAGE_SIZE", "2")
+	t.Setenv("LDAP_SERVER_SIDE_SORT", "true")
+	t.Setenv("DEPARTMENT_GROUPS", "Engineering:developers,Sales:sales")
+	return config.Load()
+}
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func seed(t *testing.T, srv *ldaptest.Server) {
+	t.Helper()
+
+	hasher, err := password.NewHasher("SSHA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := func(plain string) string {
+		hashed, err := hasher.Hash(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hashed
+	}
+
+	add := func(dn string, attrs map[string][]string) {
+		if err := srv.Add(dn+","+baseDN, attrs); err != nil {
+			t.Fatalf("seeding %s: %v", dn, err)
+		}
+	}
+	for _, ou := range []string{"users", "groups", "departments"} {
+		add("ou="+ou, map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {ou}})
+	}
+
+	department := func(dn, ou string, attrs map[string][]string) {
+		attrs["objectClass"] = []string{"organizationalUnit", "extensibleObject"}
+		attrs["ou"] = []string{ou}
+		add(dn, attrs)
+	}
+	department("ou=Engineering,ou=departments", "Engineering", map[string][]string{
+		"description":      {"Builds things"},
+		"manager":          {"uid=alice,ou=users," + baseDN},
+		"githubRepository": {"devplatform/api"},
+	})
+	department("ou=Platform,ou=Engineering,ou=departments", "Platform", map[string][]string{})
+	department("ou=Sales,ou=departments", "Sales", map[string][]string{"githubRepository": {"devplatform/crm"}})
+
+	user := func(uid, given, sn, department string, number int, plain string, repos ...string) {
+		attrs := map[string][]string{
+			"objectClass":      {"inetOrgPerson", "posixAccount", "shadowAccount", "extensibleObject"},
+			"uid":              {uid},
+			"cn":               {given + " " + sn},
+			"sn":               {sn},
+			"givenName":        {given},
+			"mail":             {uid + "@devplatform.local"},
+			"departmentNumber": {department},
+			"uidNumber":        {strconv.Itoa(number)},
+			"gidNumber":        {strconv.Itoa(number)},
+			"homeDirectory":    {"/home/" + uid},
+			"userPassword":     {hash(plain)},
+		}
+		if len(repos) > 0 {
+			attrs["githubRepository"] = repos
+		}
+		add("uid="+uid+",ou=users", attrs)
+	}
+	user("alice", "Alice", "Archer", "Engineering", 10000, alicePassword, "devplatform/api", "devplatform/tools")
+	user("bob", "Bob", "Baker", "Platform", 10001, bobPassword)
+	user("carol", "Carol", "Cooper", "Sales", 10002, carolPassword)
+
+	group := func(cn string, number int, members ...string) {
+		attrs := map[string][]string{
+			"objectClass": {"groupOfMembers", "posixGroup"},
+			"cn":          {cn},
+			"gidNumber":   {strconv.Itoa(number)},
+		}
+		for _, uid := range members {
+			attrs["member"] = append(attrs["member"], "uid="+uid+",ou=users,"+baseDN)
+			attrs["memberUid"] = append(attrs["memberUid"], uid)
+		}
+		add("cn="+cn+",ou=groups", attrs)
+	}
+	group("admins", 10100, "bob")
+	group("developers", 10101, "alice")
+	group("sales", 10102)
+}
+
+// seedExpiredDeletedUser adds a user soft-deleted long ago, past any retention period
+func seedExpiredDeletedUser(t *testing.T, srv *ldaptest.Server) {
+	t.Helper()
+	if _, ok := srv.Entry("ou=deleted," + baseDN); !ok {
+		if err := srv.Add("ou=deleted,"+baseDN, map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"deleted"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := srv.Add("uid=olivia,ou=deleted,"+baseDN, map[string][]string{
+		"objectClass":   {"inetOrgPerson", "posixAccount", "shadowAccount"},
+		"uid":           {"olivia"},
+		"cn":            {"Olivia Old"},
+		"sn":            {"Old"},
+		"uidNumber":     {"9000"},
+		"gidNumber":     {"9000"},
+		"homeDirectory": {"/home/olivia"},
+		"shadowExpire":  {"1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ptr(s string) *string { return &s }
+
+func uids(users []*models.User) []string {
+	out := make([]string, 0, len(users))
+	for _, user := range users {
+		out = append(out, user.UID)
+	}
+	return out
+}
+
+func cns(groups []*models.Group) []string {
+	out := make([]string, 0, len(groups))
+	for _, group := range groups {
+		out = append(out, group.CN)
+	}
+	return out
+}
+
+func ous(departments []*models.Department) []string {
+	out := make([]string, 0, len(departments))
+	for _, dept := range departments {
+		out = append(out, dept.OU)
+	}
+	return out
+}
+
+func changedDNs(summary *models.DeletionSummary) []string {
+	out := make([]string, 0, len(summary.Modified))
+	for _, change := range summary.Modified {
+		out = append(out, change.DN)
+	}
+	return out
+}
+
+// outcome holds the two results of a method, so a test can require success
+type outcome[T any] struct {
+	value T
+	err   error
+}
+
+func result[T any](value T, err error) outcome[T] {
+	return outcome[T]{value, err}
+}
+
+// must returns the value, failing the test if the method returned an error
+func (o outcome[T]) must(t *testing.T) T {
+	t.Helper()
+	if o.err != nil {
+		t.Fatalf("unexpected error: %v", o.err)
+	}
+	return o.value
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func fails(t *testing.T, err error, contains string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected an error containing %q", contains)
+	}
+	if !strings.Contains(err.Error(), contains) {
+		t.Fatalf("expected an error containing %q, got %v", contains, err)
+	}
+}
+
+func equal[T any](t *testing.T, what string, got, want T) {
+	t.Helper()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s = %v, want %v", what, got, want)
+	}
+}
+
+// TestDirectory runs the cases that hold for every directory.Directory
+// against both the LDAP backend and the in-memory one
+func TestDirectory(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, e *env)
+	}{
+		// Users
+		{"GetUser", func(t *testing.T, e *env) {
+			user := result(e.dir.GetUser(ctx, "alice")).must(t)
+			equal(t, "cn", user.CN, "Alice Archer")
+			equal(t, "mail", user.Mail, "alice@devplatform.local")
+			equal(t, "department", user.Department, "Engineering")
+			equal(t, "uidNumber", user.UIDNumber, 10000)
+			equal(t, "homeDirectory", user.HomeDir, "/home/alice")
+			equal(t, "repositories", user.Repositories, []string{"devplatform/api", "devplatform/tools"})
+
+			_, err := e.dir.GetUser(ctx, "nobody")
+			fails(t, err, "user not found")
+		}},
+		{"ListUsers", func(t *testing.T, e *env) {
+			equal(t, "all", uids(result(e.dir.ListUsers(ctx, nil)).must(t)), []string{"alice", "bob", "carol"})
+			equal(t, "department", uids(result(e.dir.ListUsers(ctx, &models.SearchFilter{Department: "Engineering"})).must(t)), []string{"alice"})
+			equal(t, "subtree", uids(result(e.dir.ListUsers(ctx, &models.SearchFilter{Department: "Engineering", IncludeDescendants: true})).must(t)), []string{"alice", "bob"})
+			equal(t, "cn", uids(result(e.dir.ListUsers(ctx, &models.SearchFilter{CN: "coop"})).must(t)), []string{"carol"})
+			equal(t, "mail", uids(result(e.dir.ListUsers(ctx, &models.SearchFilter{Mail: "bob@"})).must(t)), []string{"bob"})
+			equal(t, "deleted", uids(result(e.dir.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusDeleted})).must(t)), []string{})
+		}},
+		{"ListUsersPaginated", func(t *testing.T, e *env) {
+			sort := &models.UserSort{Attribute: "uid", Descending: true}
+			page := result(e.dir.ListUsersPaginated(ctx, nil, sort, 1, 2)).must(t)
+			equal(t, "page 1", uids(page.Items), []string{"carol", "bob"})
+			equal(t, "total", page.Total, 3)
+			equal(t, "hasNextPage", page.HasNextPage, true)
+
+			page = result(e.dir.ListUsersPaginated(ctx, nil, sort, 2, 2)).must(t)
+			equal(t, "page 2", uids(page.Items), []string{"alice"})
+			equal(t, "hasNextPage", page.HasNextPage, false)
+
+			page = result(e.dir.ListUsersPaginated(ctx, nil, &models.UserSort{Attribute: "uidNumber", Descending: true}, 1, 1)).must(t)
+			equal(t, "by uidNumber", uids(page.Items), []string{"carol"})
+
+			_, err := e.dir.ListUsersPaginated(ctx, nil, &models.UserSort{Attribute: "homeDirectory"}, 1, 2)
+			fails(t, err, "cannot sort users")
+		}},
+		{"ListUsersConnection", func(t *testing.T, e *env) {
+			conn := result(e.dir.ListUsersConnection(ctx, nil, nil, 2, "")).must(t)
+			var got []*models.User
+			for _, edge := range conn.Edges {
+				got = append(got, edge.Node)
+			}
+			equal(t, "first", uids(got), []string{"alice", "bob"})
+			equal(t, "hasNextPage", conn.PageInfo.HasNextPage, true)
+			equal(t, "total", conn.Total, 3)
+
+			conn = result(e.dir.ListUsersConnection(ctx, nil, nil, 2, conn.PageInfo.EndCursor)).must(t)
+			equal(t, "after", len(conn.Edges), 1)
+			equal(t, "after", conn.Edges[0].Node.UID, "carol")
+			equal(t, "hasNextPage", conn.PageInfo.HasNextPage, false)
+			equal(t, "hasPreviousPage", conn.PageInfo.HasPreviousPage, true)
+
+			_, err := e.dir.ListUsersConnection(ctx, nil, nil, 2, "bogus")
+			fails(t, err, "invalid cursor")
+		}},
+		{"ListUsersConnectionKeyset", func(t *testing.T, e *env) {
+			page := func(sort *models.UserSort, after string) *models.UserConnection {
+				t.Helper()
+				return result(e.dir.ListUsersConnection(ctx, nil, sort, 1, after)).must(t)
+			}
+			walk := func(sort *models.UserSort) []string {
+				t.Helper()
+				var got []string
+				for conn := page(sort, ""); ; conn = page(sort, conn.PageInfo.EndCursor) {
+					for _, edge := range conn.Edges {
+						got = append(got, edge.Node.UID)
+					}
+					if !conn.PageInfo.HasNextPage {
+						return got
+					}
+				}
+			}
+
+			// dave shares bob's surname, uid breaks the tie
+			result(e.dir.CreateUser(ctx, &models.CreateUserInput{
+				UID: "dave", CN: "Dave Baker", SN: "Baker", GivenName: "Dave",
+				Mail: "dave@devplatform.local", Department: "Sales", Password: "Quiet-Harbor-417",
+			})).must(t)
+			equal(t, "by sn", walk(&models.UserSort{Attribute: "sn"}), []string{"alice", "bob", "dave", "carol"})
+			equal(t, "by sn descending", walk(&models.UserSort{Attribute: "sn", Descending: true}), []string{"carol", "dave", "bob", "alice"})
+			equal(t, "by uidNumber", walk(&models.UserSort{Attribute: "uidNumber"}), []string{"alice", "bob", "carol", "dave"})
+
+			// Users added before the cursor do not shift the next page
+			conn := result(e.dir.ListUsersConnection(ctx, nil, nil, 2, "")).must(t)
+			result(e.dir.CreateUser(ctx, &models.CreateUserInput{
+				UID: "aaron", CN: "Aaron Abbot", SN: "Abbot", GivenName: "Aaron",
+				Mail: "aaron@devplatform.local", Department: "Sales", Password: "Quiet-Harbor-417",
+			})).must(t)
+			next := result(e.dir.ListUsersConnection(ctx, nil, nil, 2, conn.PageInfo.EndCursor)).must(t)
+			equal(t, "next page", next.Edges[0].Node.UID, "carol")
+			equal(t, "total", next.Total, 5)
+
+			_, err := e.dir.ListUsersConnection(ctx, nil, &models.UserSort{Attribute: "cn"}, 2, conn.PageInfo.EndCursor)
+			fails(t, err, "another sort order")
+		}},
+		{"FindUserByMail", func(t *testing.T, e *env) {
+			equal(t, "uid", result(e.dir.FindUserByMail(ctx, "bob@devplatform.local")).must(t).UID, "bob")
+
+			_, err := e.dir.FindUserByMail(ctx, "nobody@devplatform.local")
+			fails(t, err, "no unique user")
+
+			// A shared address does not identify anyone
+			_, err = e.dir.CreateUser(ctx, &models.CreateUserInput{
+				UID: "bobby", CN: "Bobby Baker", SN: "Baker", GivenName: "Bobby",
+				Mail: "bob@devplatform.local", Password: "Quiet-Harbor-417",
+			})
+			check(t, err)
+			_, err = e.dir.FindUserByMail(ctx, "bob@devplatform.local")
+			fails(t, err, "no unique user")
+		}},
+		{"UpdateUser", func(t *testing.T, e *env) {
+			user := result(e.dir.UpdateUser(ctx, &models.UpdateUserInput{
+				UID:          "alice",
+				CN:           ptr("Alice Able"),
+				Mail:         ptr("alice.able@devplatform.local"),
+				Password:     ptr(newPassword),
+				Repositories: []string{"devplatform/web"},
+			})).must(t)
+			equal(t, "cn", user.CN, "Alice Able")
+			equal(t, "mail", user.Mail, "alice.able@devplatform.local")
+			equal(t, "sn", user.SN, "Archer")
+			equal(t, "repositories", user.Repositories, []string{"devplatform/web"})
+			result(e.dir.Authenticate(ctx, "alice", newPassword)).must(t)
+
+			_, err := e.dir.UpdateUser(ctx, &models.UpdateUserInput{UID: "nobody", CN: ptr("Nobody")})
+			fails(t, err, "No Such Object")
+		}},
+		{"Authenticate", func(t *testing.T, e *env) {
+			equal(t, "uid", result(e.dir.Authenticate(ctx, "alice", alicePassword)).must(t).UID, "alice")
+
+			_, err := e.dir.Authenticate(ctx, "alice", bobPassword)
+			fails(t, err, "authentication failed")
+			_, err = e.dir.Authenticate(ctx, "nobody", alicePassword)
+			fails(t, err, "user not found")
+
+			result(e.dir.DisableUser(ctx, "alice")).must(t)
+			_, err = e.dir.Authenticate(ctx, "alice", alicePassword)
+			fails(t, err, "authentication failed")
+		}},
+		{"PreparePassword", func(t *testing.T, e *env) {
+			subject := password.Subject{UID: "dave", CN: "Dave Doe", SN: "Doe", GivenName: "Dave"}
+			hashed := result(e.dir.PreparePassword("Quiet-Harbor-417", subject)).must(t)
+			if !password.Verify("Quiet-Harbor-417", hashed) {
+				t.Fatalf("hash %q does not verify", hashed)
+			}
+
+			_, err := e.dir.PreparePassword("dave-doe-2024!", subject)
+			if err == nil {
+				t.Fatal("expected a password containing the uid to be rejected")
+			}
+		}},
+		{"SetPassword", func(t *testing.T, e *env) {
+			check(t, e.dir.SetPassword(ctx, "bob", newPassword))
+			result(e.dir.Authenticate(ctx, "bob", newPassword)).must(t)
+			_, err := e.dir.Authenticate(ctx, "bob", bobPassword)
+			fails(t, err, "authentication failed")
+
+			fails(t, e.dir.SetPassword(ctx, "nobody", newPassword), "user not found")
+		}},
+		{"RenameUser", func(t *testing.T, e *env) {
+			user := result(e.dir.RenameUser(ctx, "alice", "alicia")).must(t)
+			equal(t, "uid", user.UID, "alicia")
+			equal(t, "dn", user.DN, e.cfg.UserDN("alicia"))
+			equal(t, "uidNumber", user.UIDNumber, 10000)
+
+			equal(t, "members", result(e.dir.GetGroup(ctx, "developers")).must(t).Members, []string{"alicia"})
+			equal(t, "manager", result(e.dir.GetDepartment(ctx, "Engineering")).must(t).Manager, "alicia")
+			result(e.dir.Authenticate(ctx, "alicia", alicePassword)).must(t)
+			_, err := e.dir.GetUser(ctx, "alice")
+			fails(t, err, "user not found")
+
+			_, err = e.dir.RenameUser(ctx, "alicia", "bob")
+			fails(t, err, "user already exists")
+		}},
+		{"MoveUser", func(t *testing.T, e *env) {
+			user := result(e.dir.MoveUser(ctx, "alice", "Sales")).must(t)
+			equal(t, "department", user.Department, "Sales")
+			// Engineering's repository goes, Sales' comes, the personal one stays
+			equal(t, "repositories", user.Repositories, []string{"devplatform/tools", "devplatform/crm"})
+			equal(t, "developers", result(e.dir.GetGroup(ctx, "developers")).must(t).Members, []string{})
+			equal(t, "sales", result(e.dir.GetGroup(ctx, "sales")).must(t).Members, []string{"alice"})
+
+			_, err := e.dir.MoveUser(ctx, "alice", "Marketing")
+			fails(t, err, "department not found")
+		}},
+
+		// Account lifecycle
+		{"DisableUser", func(t *testing.T, e *env) {
+			equal(t, "status", result(e.dir.DisableUser(ctx, "bob")).must(t).Status, models.UserStatusDisabled)
+			equal(t, "disabled", uids(result(e.dir.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusDisabled})).must(t)), []string{"bob"})
+			equal(t, "active", uids(result(e.dir.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusActive})).must(t)), []string{"alice", "carol"})
+
+			_, err := e.dir.DisableUser(ctx, "nobody")
+			fails(t, err, "user not found")
+		}},
+		{"EnableUser", func(t *testing.T, e *env) {
+			result(e.dir.DisableUser(ctx, "bob")).must(t)
+			equal(t, "status", result(e.dir.EnableUser(ctx, "bob")).must(t).Status, models.UserStatusActive)
+			result(e.dir.Authenticate(ctx, "bob", bobPassword)).must(t)
+			// Enabling an active user changes nothing
+			equal(t, "status", result(e.dir.EnableUser(ctx, "bob")).must(t).Status, models.UserStatusActive)
+		}},
+		{"SoftDeleteUser", func(t *testing.T, e *env) {
+			summary := result(e.dir.SoftDeleteUser(ctx, "alice")).must(t)
+			equal(t, "modified", changedDNs(summary), []string{
+				e.cfg.GroupDN("developers"),
+				e.cfg.DepartmentDN("Engineering"),
+				e.cfg.UserDN("alice"),
+				e.cfg.UserDN("alice"),
+			})
+
+			_, err := e.dir.GetUser(ctx, "alice")
+			fails(t, err, "user not found")
+			deleted := result(e.dir.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusDeleted})).must(t)
+			equal(t, "deleted", uids(deleted), []string{"alice"})
+			equal(t, "status", deleted[0].Status, models.UserStatusDeleted)
+			if deleted[0].DeletedAt == nil {
+				t.Fatal("deletedAt is not set")
+			}
+
+			_, err = e.dir.CreateUser(ctx, &models.CreateUserInput{
+				UID: "alice", CN: "A", SN: "A", GivenName: "A", Mail: "a@devplatform.local", Password: "Other-Pass-123",
+			})
+			fails(t, err, "belongs to a deleted user")
+		}},
+		{"RestoreUser", func(t *testing.T, e *env) {
+			result(e.dir.SoftDeleteUser(ctx, "carol")).must(t)
+			user := result(e.dir.RestoreUser(ctx, "carol")).must(t)
+			equal(t, "dn", user.DN, e.cfg.UserDN("carol"))
+			equal(t, "status", user.Status, models.UserStatusDisabled)
+			equal(t, "uidNumber", user.UIDNumber, 10002)
+
+			_, err := e.dir.RestoreUser(ctx, "carol")
+			fails(t, err, "deleted user not found")
+		}},
+		{"RestoreUserReferences", func(t *testing.T, e *env) {
+			result(e.dir.SoftDeleteUser(ctx, "alice")).must(t)
+			result(e.dir.RestoreUser(ctx, "alice")).must(t)
+			equal(t, "members", result(e.dir.GetGroup(ctx, "developers")).must(t).Members, []string{"alice"})
+			equal(t, "manager", result(e.dir.GetDepartment(ctx, "Engineering")).must(t).Manager, "alice")
+
+			// A department that got another manager in the meantime keeps it
+			result(e.dir.SoftDeleteUser(ctx, "alice")).must(t)
+			result(e.dir.UpdateDepartment(ctx, &models.UpdateDepartmentInput{OU: "Engineering", Manager: ptr("bob")})).must(t)
+			result(e.dir.RestoreUser(ctx, "alice")).must(t)
+			equal(t, "members again", result(e.dir.GetGroup(ctx, "developers")).must(t).Members, []string{"alice"})
+			equal(t, "other manager", result(e.dir.GetDepartment(ctx, "Engineering")).must(t).Manager, "bob")
+		}},
+
+		// Departments
+		{"GetDepartment", func(t *testing.T, e *env) {
+			dept := result(e.dir.GetDepartment(ctx, "Engineering")).must(t)
+			equal(t, "description", dept.Description, "Builds things")
+			equal(t, "manager", dept.Manager, "alice")
+			equal(t, "members", dept.Members, []string{"alice"})
+			equal(t, "repositories", dept.Repositories, []string{"devplatform/api"})
+
+			dept = result(e.dir.GetDepartment(ctx, "platform")).must(t)
+			equal(t, "path", dept.Path, []string{"Engineering", "Platform"})
+
+			_, err := e.dir.GetDepartment(ctx, "Marketing")
+			fails(t, err, "department not found")
+		}},
+		{"ListDepartments", func(t *testing.T, e *env) {
+			departments := result(e.dir.ListDepartments(ctx)).must(t)
+			equal(t, "departments", ous(departments), []string{"Engineering", "Platform", "Sales"})
+			equal(t, "members", departments[2].Members, []string{"carol"})
+		}},
+		{"UpdateDepartment", func(t *testing.T, e *env) {
+			dept := result(e.dir.UpdateDepartment(ctx, &models.UpdateDepartmentInput{
+				OU: "Sales", Description: ptr("Sells things"), Manager: ptr("carol"),
+			})).must(t)
+			equal(t, "description", dept.Description, "Sells things")
+			equal(t, "manager", dept.Manager, "carol")
+
+			dept = result(e.dir.UpdateDepartment(ctx, &models.UpdateDepartmentInput{OU: "Sales", Description: ptr(""), Manager: ptr("")})).must(t)
+			equal(t, "description", dept.Description, "")
+			equal(t, "manager", dept.Manager, "")
+
+			_, err := e.dir.UpdateDepartment(ctx, &models.UpdateDepartmentInput{OU: "Sales", Manager: ptr("nobody")})
+			fails(t, err, "invalid manager")
+		}},
+		{"DeleteDepartment", func(t *testing.T, e *env) {
+			_, err := e.dir.DeleteDepartment(ctx, "Sales", "")
+			fails(t, err, "still has 1 members")
+			_, err = e.dir.DeleteDepartment(ctx, "Engineering", "Sales")
+			fails(t, err, "has sub-departments")
+			_, err = e.dir.DeleteDepartment(ctx, "Sales", "Sales")
+			fails(t, err, "to itself")
+
+			summary := result(e.dir.DeleteDepartment(ctx, "Sales", "Engineering")).must(t)
+			equal(t, "deleted", summary.Deleted, []string{e.cfg.DepartmentDN("Sales")})
+			equal(t, "modified", changedDNs(summary), []string{e.cfg.UserDN("carol")})
+			equal(t, "department", result(e.dir.GetUser(ctx, "carol")).must(t).Department, "Engineering")
+
+			_, err = e.dir.GetDepartment(ctx, "Sales")
+			fails(t, err, "department not found")
+		}},
+		{"MoveDepartment", func(t *testing.T, e *env) {
+			_, err := e.dir.MoveDepartment(ctx, "Engineering", "Platform")
+			fails(t, err, "below itself")
+
+			// The whole subtree moves
+			dept := result(e.dir.MoveDepartment(ctx, "Engineering", "Sales")).must(t)
+			equal(t, "path", dept.Path, []string{"Sales", "Engineering"})
+			platform := result(e.dir.GetDepartment(ctx, "Platform")).must(t)
+			equal(t, "dn", platform.DN, "ou=Platform,ou=Engineering,"+e.cfg.DepartmentDN("Sales"))
+			equal(t, "members", platform.Members, []string{"bob"})
+
+			dept = result(e.dir.MoveDepartment(ctx, "Platform", "")).must(t)
+			equal(t, "path", dept.Path, []string{"Platform"})
+			equal(t, "dn", dept.DN, e.cfg.DepartmentDN("Platform"))
+		}},
+		{"AssignRepositoryToDepartment", func(t *testing.T, e *env) {
+			check(t, e.dir.AssignRepositoryToDepartment(ctx, "Sales", []string{"devplatform/crm", "devplatform/billing"}))
+			equal(t, "repositories", result(e.dir.GetDepartment(ctx, "Sales")).must(t).Repositories, []string{"devplatform/crm", "devplatform/billing"})
+
+			fails(t, e.dir.AssignRepositoryToDepartment(ctx, "Marketing", []string{"devplatform/ads"}), "department not found")
+		}},
+		{"GetUsersByDepartment", func(t *testing.T, e *env) {
+			equal(t, "direct", uids(result(e.dir.GetUsersByDepartment(ctx, "Engineering", false)).must(t)), []string{"alice"})
+			equal(t, "descendants", uids(result(e.dir.GetUsersByDepartment(ctx, "Engineering", true)).must(t)), []string{"alice", "bob"})
+			equal(t, "empty", uids(result(e.dir.GetUsersByDepartment(ctx, "Platform", false)).must(t)), []string{"bob"})
+		}},
+		{"GetDepartmentChildren", func(t *testing.T, e *env) {
+			result(e.dir.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Data", Parent: "Engineering"})).must(t)
+			equal(t, "children", ous(result(e.dir.GetDepartmentChildren(ctx, "Engineering")).must(t)), []string{"Data", "Platform"})
+			equal(t, "leaf", ous(result(e.dir.GetDepartmentChildren(ctx, "Sales")).must(t)), []string{})
+		}},
+		{"GetDepartmentAncestors", func(t *testing.T, e *env) {
+			result(e.dir.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "SRE", Parent: "Platform"})).must(t)
+			equal(t, "ancestors", ous(result(e.dir.GetDepartmentAncestors(ctx, "SRE")).must(t)), []string{"Engineering", "Platform"})
+			equal(t, "top level", ous(result(e.dir.GetDepartmentAncestors(ctx, "Sales")).must(t)), []string{})
+		}},
+		{"GetDepartmentTree", func(t *testing.T, e *env) {
+			result(e.dir.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Accounting"})).must(t)
+			tree := result(e.dir.GetDepartmentTree(ctx)).must(t)
+			equal(t, "tree", ous(tree), []string{"Accounting", "Engineering", "Platform", "Sales"})
+			equal(t, "members", tree[2].Members, []string{"bob"})
+		}},
+		// Groups
+		{"GetGroup", func(t *testing.T, e *env) {
+			group := result(e.dir.GetGroup(ctx, "developers")).must(t)
+			equal(t, "members", group.Members, []string{"alice"})
+			equal(t, "gidNumber", group.GIDNumber, 10101)
+
+			_, err := e.dir.GetGroup(ctx, "nobody")
+			fails(t, err, "group not found")
+		}},
+		{"ListGroups", func(t *testing.T, e *env) {
+			page := result(e.dir.ListGroups(ctx, nil, 1, 2)).must(t)
+			equal(t, "page 1", cns(page.Items), []string{"admins", "developers"})
+			equal(t, "total", page.Total, 3)
+			equal(t, "hasNextPage", page.HasNextPage, true)
+
+			page = result(e.dir.ListGroups(ctx, nil, 2, 2)).must(t)
+			equal(t, "page 2", cns(page.Items), []string{"sales"})
+
+			equal(t, "member", cns(result(e.dir.ListGroups(ctx, &models.GroupFilter{Member: "alice"}, 1, 10)).must(t).Items), []string{"developers"})
+			equal(t, "cn", cns(result(e.dir.ListGroups(ctx, &models.GroupFilter{CN: "dev"}, 1, 10)).must(t).Items), []string{"developers"})
+		}},
+		{"UpdateGroup", func(t *testing.T, e *env) {
+			equal(t, "description", result(e.dir.UpdateGroup(ctx, &models.UpdateGroupInput{CN: "sales", Description: ptr("Sales team")})).must(t).Description, "Sales team")
+			equal(t, "cleared", result(e.dir.UpdateGroup(ctx, &models.UpdateGroupInput{CN: "sales", Description: ptr("")})).must(t).Description, "")
+
+			_, err := e.dir.UpdateGroup(ctx, &models.UpdateGroupInput{CN: "nobody", Description: ptr("x")})
+			fails(t, err, "group not found")
+		}},
+		{"DeleteGroup", func(t *testing.T, e *env) {
+			check(t, e.dir.AddGroupToGroup(ctx, "sales", "developers"))
+			check(t, e.dir.DeleteGroup(ctx, "sales"))
+			_, err := e.dir.GetGroup(ctx, "sales")
+			fails(t, err, "group not found")
+			// The parent no longer lists the deleted group
+			parent := result(e.dir.GetGroup(ctx, "developers")).must(t)
+			equal(t, "memberGroups", parent.MemberGroups, []string{})
+			equal(t, "members", parent.Members, []string{"alice"})
+
+			fails(t, e.dir.DeleteGroup(ctx, "admins"), "grants a role")
+			fails(t, e.dir.DeleteGroup(ctx, "sales"), "No Such Object")
+		}},
+		{"SetGroupMembers", func(t *testing.T, e *env) {
+			check(t, e.dir.AddGroupToGroup(ctx, "sales", "developers"))
+			group := result(e.dir.SetGroupMembers(ctx, "developers", []string{"bob", "carol", "bob"})).must(t)
+			equal(t, "members", group.Members, []string{"bob", "carol"})
+			// Nested groups stay
+			equal(t, "memberGroups", group.MemberGroups, []string{"sales"})
+
+			_, err := e.dir.SetGroupMembers(ctx, "developers", []string{"nobody"})
+			fails(t, err, "user not found")
+		}},
+		{"GetUserGroups", func(t *testing.T, e *env) {
+			check(t, e.dir.AddUserToGroup(ctx, "bob", "sales"))
+			equal(t, "bob", cns(result(e.dir.GetUserGroups(ctx, "bob")).must(t)), []string{"admins", "sales"})
+			equal(t, "nobody", cns(result(e.dir.GetUserGroups(ctx, "nobody")).must(t)), []string{})
+		}},
+		{"AddGroupToGroup", func(t *testing.T, e *env) {
+			check(t, e.dir.AddGroupToGroup(ctx, "developers", "admins"))
+			equal(t, "memberGroups", result(e.dir.GetGroup(ctx, "admins")).must(t).MemberGroups, []string{"developers"})
+
+			fails(t, e.dir.AddGroupToGroup(ctx, "admins", "developers"), "would create a cycle")
+			fails(t, e.dir.AddGroupToGroup(ctx, "admins", "admins"), "member of itself")
+			fails(t, e.dir.AddGroupToGroup(ctx, "nobody", "admins"), "group not found")
+		}},
+		{"RemoveGroupFromGroup", func(t *testing.T, e *env) {
+			check(t, e.dir.AddGroupToGroup(ctx, "developers", "admins"))
+			check(t, e.dir.RemoveGroupFromGroup(ctx, "developers", "admins"))
+			equal(t, "memberGroups", result(e.dir.GetGroup(ctx, "admins")).must(t).MemberGroups, []string{})
+
+			fails(t, e.dir.RemoveGroupFromGroup(ctx, "developers", "admins"), "is not a member")
+		}},
+		{"EffectiveGroups", func(t *testing.T, e *env) {
+			check(t, e.dir.AddGroupToGroup(ctx, "developers", "admins"))
+			equal(t, "alice", cns(result(e.dir.EffectiveGroups(ctx, "alice")).must(t)), []string{"developers", "admins"})
+			equal(t, "bob", cns(result(e.dir.EffectiveGroups(ctx, "bob")).must(t)), []string{"admins"})
+		}},
+		{"EffectiveMembers", func(t *testing.T, e *env) {
+			check(t, e.dir.AddGroupToGroup(ctx, "developers", "admins"))
+			check(t, e.dir.AddGroupToGroup(ctx, "sales", "developers"))
+			check(t, e.dir.AddUserToGroup(ctx, "carol", "sales"))
+			equal(t, "admins", result(e.dir.EffectiveMembers(ctx, "admins")).must(t), []string{"bob", "alice", "carol"})
+
+			_, err := e.dir.EffectiveMembers(ctx, "nobody")
+			fails(t, err, "group not found")
+		}},
+
+		// Operations
+		{"IDAllocation", func(t *testing.T, e *env) {
+			alloc := result(e.dir.IDAllocation(ctx)).must(t)
+			equal(t, "next uid", alloc.UID.Next, 10003)
+			equal(t, "allocated uids", alloc.UID.Allocated, 3)
+			next := alloc.GID.Next
+
+			group := result(e.dir.CreateGroup(ctx, "ops", "")).must(t)
+			equal(t, "gidNumber", group.GIDNumber, next)
+			alloc = result(e.dir.IDAllocation(ctx)).must(t)
+			equal(t, "next uid", alloc.UID.Next, 10003)
+			equal(t, "next gid", alloc.GID.Next, next+1)
+		}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tt.run(t, backend.env(t))
+				})
+			}
+		})
+	}
+}
+
+// TestManager runs the cases specific to the LDAP backend
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, e *env)
+	}{
+		// Users
+		{"CreateUser", func(t *testing.T, e *env) {
+			user := result(e.m.CreateUser(ctx, &models.CreateUserInput{
+				UID: "dave", CN: "Dave Doe", SN: "Doe", GivenName: "Dave",
+				Mail: "dave@devplatform.local", Department: "Sales", Password: "Quiet-Harbor-417",
+				Repositories: []string{"devplatform/crm"},
+			})).must(t)
+			equal(t, "uid", user.UID, "dave")
+			equal(t, "dn", user.DN, e.cfg.UserDN("dave"))
+			// The allocator starts above the highest numbers in use
+			equal(t, "uidNumber", user.UIDNumber, 10003)
+			equal(t, "gidNumber", user.GIDNumber, 10103)
+			equal(t, "repositories", user.Repositories, []string{"devplatform/crm"})
+			equal(t, "status", user.Status, models.UserStatusActive)
+
+			attrs, _ := e.srv.Entry(user.DN)
+			if stored := attrs["userPassword"]; len(stored) != 1 || !strings.HasPrefix(stored[0], "{SSHA}") {
+				t.Fatalf("userPassword = %v, want an SSHA hash", stored)
+			}
+
+			_, err := e.m.CreateUser(ctx, &models.CreateUserInput{
+				UID: "alice", CN: "A", SN: "A", GivenName: "A", Mail: "a@devplatform.local", Password: "Other-Pass-123",
+			})
+			fails(t, err, "Entry Already Exists")
+		}},
+		{"ListUsersConnectionWithoutAttribute", func(t *testing.T, e *env) {
+			// Entries added outside this service may lack a sort attribute
+			err := e.srv.Add(e.cfg.UserDN("erin"), map[string][]string{
+				"objectClass":   {"inetOrgPerson", "posixAccount", "shadowAccount"},
+				"uid":           {"erin"},
+				"cn":            {"Erin Evans"},
+				"sn":            {"Evans"},
+				"uidNumber":     {"10003"},
+				"gidNumber":     {"10003"},
+				"homeDirectory": {"/home/erin"},
+			})
+			check(t, err)
+
+			walk := func(sort *models.UserSort) []string {
+				t.Helper()
+				var got []string
+				after := ""
+				for {
+					conn := result(e.m.ListUsersConnection(ctx, nil, sort, 1, after)).must(t)
+					for _, edge := range conn.Edges {
+						got = append(got, edge.Node.UID)
+					}
+					if !conn.PageInfo.HasNextPage {
+						return got
+					}
+					after = conn.PageInfo.EndCursor
+				}
+			}
+			// Users without the attribute sort last, after the cursor of any value
+			equal(t, "by mail", walk(&models.UserSort{Attribute: "mail"}), []string{"alice", "bob", "carol", "erin"})
+			equal(t, "by mail descending", walk(&models.UserSort{Attribute: "mail", Descending: true}), []string{"erin", "carol", "bob", "alice"})
+		}},
+		{"ShadowExpire", func(t *testing.T, e *env) {
+			today := time.Now().Unix() / (24 * 60 * 60)
+			// Expiry dates set by other tools: never, next week and last week
+			for uid, expire := range map[string]int64{"dave": -1, "erin": today + 7, "frank": today - 7} {
+				err := e.srv.Add(e.cfg.UserDN(uid), map[string][]string{
+					"objectClass":   {"inetOrgPerson", "posixAccount", "shadowAccount"},
+					"uid":           {uid},
+					"cn":            {uid},
+					"sn":            {uid},
+					"uidNumber":     {"20000"},
+					"gidNumber":     {"20000"},
+					"homeDirectory": {"/home/" + uid},
+					"shadowExpire":  {strconv.FormatInt(expire, 10)},
+				})
+				check(t, err)
+			}
+			result(e.m.DisableUser(ctx, "bob")).must(t)
+
+			equal(t, "never", result(e.m.GetUser(ctx, "dave")).must(t).Status, models.UserStatusActive)
+			equal(t, "future", result(e.m.GetUser(ctx, "erin")).must(t).Status, models.UserStatusActive)
+			equal(t, "past", result(e.m.GetUser(ctx, "frank")).must(t).Status, models.UserStatusDisabled)
+			equal(t, "active", uids(result(e.m.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusActive})).must(t)), []string{"alice", "carol", "dave", "erin"})
+			equal(t, "disabled", uids(result(e.m.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusDisabled})).must(t)), []string{"bob", "frank"})
+
+			// Enabling keeps an expiry still to come and clears one that has passed
+			result(e.m.EnableUser(ctx, "erin")).must(t)
+			attrs, _ := e.srv.Entry(e.cfg.UserDN("erin"))
+			equal(t, "kept", attrs["shadowExpire"], []string{strconv.FormatInt(today+7, 10)})
+			equal(t, "enabled", result(e.m.EnableUser(ctx, "frank")).must(t).Status, models.UserStatusActive)
+			attrs, _ = e.srv.Entry(e.cfg.UserDN("frank"))
+			equal(t, "cleared", len(attrs["shadowExpire"]), 0)
+		}},
+		{"CreateUserSkipsIDsInUse", func(t *testing.T, e *env) {
+			input := func(uid string) *models.CreateUserInput {
+				return &models.CreateUserInput{
+					UID: uid, CN: uid, SN: uid, GivenName: uid,
+					Mail: uid + "@devplatform.local", Department: "Sales", Password: "Quiet-Harbor-417",
+				}
+			}
+			equal(t, "first", result(e.m.CreateUser(ctx, input("dave"))).must(t).UIDNumber, 10003)
+
+			// Numbers taken behind the allocator's back, found over several pages
+			for i, number := range []int{10004, 10005, 10006, 10040} {
+				uid := "ext" + strconv.Itoa(i)
+				err := e.srv.Add(e.cfg.UserDN(uid), map[string][]string{
+					"objectClass":   {"inetOrgPerson", "posixAccount"},
+					"uid":           {uid},
+					"cn":            {uid},
+					"sn":            {uid},
+					"uidNumber":     {strconv.Itoa(number)},
+					"gidNumber":     {"20000"},
+					"homeDirectory": {"/home/" + uid},
+				})
+				check(t, err)
+			}
+			equal(t, "past the highest", result(e.m.CreateUser(ctx, input("erin"))).must(t).UIDNumber, 10041)
+			equal(t, "next", result(e.m.IDAllocation(ctx)).must(t).UID.Next, 10042)
+		}},
+		{"CreateUserWeakPassword", func(t *testing.T, e *env) {
+			_, err := e.m.CreateUser(ctx, &models.CreateUserInput{
+				UID: "dave", CN: "Dave Doe", SN: "Doe", GivenName: "Dave", Mail: "dave@devplatform.local", Password: "short",
+			})
+			if err == nil {
+				t.Fatal("expected the password policy to reject the password")
+			}
+			if _, ok := e.srv.Entry(e.cfg.UserDN("dave")); ok {
+				t.Fatal("user was created despite the rejected password")
+			}
+		}},
+		{"DeleteUser", func(t *testing.T, e *env) {
+			summary := result(e.m.DeleteUser(ctx, "alice")).must(t)
+			equal(t, "deleted", summary.Deleted, []string{e.cfg.UserDN("alice")})
+			equal(t, "modified", changedDNs(summary), []string{
+				e.cfg.GroupDN("developers"),
+				e.cfg.DepartmentDN("Engineering"),
+			})
+
+			equal(t, "members", result(e.m.GetGroup(ctx, "developers")).must(t).Members, []string{})
+			equal(t, "manager", result(e.m.GetDepartment(ctx, "Engineering")).must(t).Manager, "")
+			if _, ok := e.srv.Entry(e.cfg.UserDN("alice")); ok {
+				t.Fatal("user entry still exists")
+			}
+
+			_, err := e.m.DeleteUser(ctx, "alice")
+			fails(t, err, "user not found")
+		}},
+		// Account lifecycle
+		{"RestoreUserKeepsReferences", func(t *testing.T, e *env) {
+			// The references are kept on the deleted entry until it is restored
+			result(e.m.SoftDeleteUser(ctx, "alice")).must(t)
+			attrs, _ := e.srv.Entry("uid=alice,ou=deleted," + baseDN)
+			equal(t, "kept references", attrs["devplatformRemovedReference"], []string{e.cfg.GroupDN("developers"), e.cfg.DepartmentDN("Engineering")})
+			result(e.m.RestoreUser(ctx, "alice")).must(t)
+			attrs, _ = e.srv.Entry(e.cfg.UserDN("alice"))
+			equal(t, "cleared references", attrs["devplatformRemovedReference"], []string(nil))
+
+			// Before migration 5 users are still deleted and restored, without their references
+			schema := ldaptest.DefaultSchema()
+			schema.RemoveAttributeType("devplatformRemovedReference")
+			old := startEnv(t, schema, seed)
+			result(old.m.SoftDeleteUser(ctx, "alice")).must(t)
+			result(old.m.RestoreUser(ctx, "alice")).must(t)
+			equal(t, "old members", result(old.m.GetGroup(ctx, "developers")).must(t).Members, []string{})
+		}},
+		{"PurgeDeletedUsers", func(t *testing.T, e *env) {
+			equal(t, "nothing deleted", result(e.m.PurgeDeletedUsers(ctx)).must(t), 0)
+
+			seedExpiredDeletedUser(t, e.srv)
+			result(e.m.SoftDeleteUser(ctx, "carol")).must(t)
+			// Only the user past its retention period goes
+			equal(t, "purged", result(e.m.PurgeDeletedUsers(ctx)).must(t), 1)
+			equal(t, "kept", uids(result(e.m.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusDeleted})).must(t)), []string{"carol"})
+		}},
+		{"StartPurge", func(t *testing.T, e *env) {
+			seedExpiredDeletedUser(t, e.srv)
+			e.m.StartPurge(10 * time.Millisecond)
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if _, ok := e.srv.Entry("uid=olivia,ou=deleted," + baseDN); !ok {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("expired user was not purged")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}},
+
+		// Departments
+		{"CreateDepartment", func(t *testing.T, e *env) {
+			dept := result(e.m.CreateDepartment(ctx, &models.CreateDepartmentInput{
+				OU: "QA", Parent: "Engineering", Description: "Quality", Manager: "bob",
+				Repositories: []string{"devplatform/e2e"},
+			})).must(t)
+			equal(t, "dn", dept.DN, "ou=QA,"+e.cfg.DepartmentDN("Engineering"))
+			equal(t, "path", dept.Path, []string{"Engineering", "QA"})
+			equal(t, "parent", dept.Parent, "Engineering")
+			equal(t, "manager", dept.Manager, "bob")
+			equal(t, "repositories", dept.Repositories, []string{"devplatform/e2e"})
+			attrs, _ := e.srv.Entry(dept.DN)
+			equal(t, "objectClass", attrs["objectClass"], []string{"organizationalUnit", "devplatformDepartment"})
+
+			top := result(e.m.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Legal"})).must(t)
+			equal(t, "dn", top.DN, e.cfg.DepartmentDN("Legal"))
+
+			_, err := e.m.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "qa"})
+			fails(t, err, "already exists")
+			_, err = e.m.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Ops", Manager: "nobody"})
+			fails(t, err, "invalid manager")
+			_, err = e.m.CreateDepartment(ctx, &models.CreateDepartmentInput{OU: "Ops", Parent: "Nowhere"})
+			fails(t, err, "department not found")
+		}},
+
+		// Groups
+		{"CreateGroup", func(t *testing.T, e *env) {
+			group := result(e.m.CreateGroup(ctx, "ops", "Operations")).must(t)
+			equal(t, "dn", group.DN, e.cfg.GroupDN("ops"))
+			equal(t, "gidNumber", group.GIDNumber, 10103)
+			equal(t, "description", group.Description, "Operations")
+			equal(t, "members", group.Members, []string{})
+
+			attrs, _ := e.srv.Entry(group.DN)
+			equal(t, "objectClass", attrs["objectClass"], []string{"groupOfMembers", "posixGroup"})
+
+			_, err := e.m.CreateGroup(ctx, "ops", "")
+			fails(t, err, "Entry Already Exists")
+		}},
+		{"CreateGroupNISSchema", func(t *testing.T, e *env) {
+			// OpenLDAP's default nis schema: no groupOfMembers, a structural posixGroup
+			schema := ldaptest.DefaultSchema()
+			schema.RemoveObjectClass("groupOfMembers")
+			schema.AddObjectClass(&ldaptest.ObjectClass{Name: "posixGroup", Sup: "top", Must: []string{"cn", "gidNumber"},
+				May: []string{"userPassword", "memberUid", "description"}})
+			nis := startEnv(t, schema, func(t *testing.T, srv *ldaptest.Server) {
+				for _, ou := range []string{"users", "groups", "departments"} {
+					check(t, srv.Add("ou="+ou+","+baseDN, map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {ou}}))
+				}
+			})
+
+			result(nis.m.CreateGroup(ctx, "ops", "Operations")).must(t)
+			result(nis.m.CreateGroup(ctx, "staff", "")).must(t)
+			attrs, _ := nis.srv.Entry(nis.cfg.GroupDN("ops"))
+			equal(t, "objectClass", attrs["objectClass"], []string{"posixGroup", "extensibleObject"})
+
+			check(t, nis.m.AddGroupToGroup(ctx, "ops", "staff"))
+			equal(t, "members", result(nis.m.GetGroup(ctx, "staff")).must(t).MemberGroups, []string{"ops"})
+		}},
+		{"AddUserToGroup", func(t *testing.T, e *env) {
+			check(t, e.m.AddUserToGroup(ctx, "carol", "developers"))
+			// Adding again changes nothing
+			check(t, e.m.AddUserToGroup(ctx, "carol", "developers"))
+			equal(t, "members", result(e.m.GetGroup(ctx, "developers")).must(t).Members, []string{"alice", "carol"})
+
+			attrs, _ := e.srv.Entry(e.cfg.GroupDN("developers"))
+			equal(t, "memberUid", attrs["memberUid"], []string{"alice", "carol"})
+
+			fails(t, e.m.AddUserToGroup(ctx, "nobody", "developers"), "user not found")
+			fails(t, e.m.AddUserToGroup(ctx, "carol", "nobody"), "group not found")
+		}},
+		{"RemoveUserFromGroup", func(t *testing.T, e *env) {
+			check(t, e.m.RemoveUserFromGroup(ctx, "alice", "developers"))
+			equal(t, "members", result(e.m.GetGroup(ctx, "developers")).must(t).Members, []string{})
+
+			attrs, _ := e.srv.Entry(e.cfg.GroupDN("developers"))
+			equal(t, "memberUid", attrs["memberUid"], []string(nil))
+
+			fails(t, e.m.RemoveUserFromGroup(ctx, "alice", "developers"), "is not a member")
+		}},
+
+		// Account locks
+		{"AccountLocks", func(t *testing.T, e *env) {
+			locks := e.m.AccountLocks()
+			lockedAt := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+			check(t, locks.Lock(ctx, &models.AccountLock{UID: "alice", LockedAt: lockedAt}))
+			check(t, locks.Lock(ctx, &models.AccountLock{UID: "bob", LockedAt: lockedAt.Add(time.Hour), Permanent: true}))
+
+			lock := result(locks.Get(ctx, "alice")).must(t)
+			if lock == nil || !lock.LockedAt.Equal(lockedAt) || lock.Permanent {
+				t.Fatalf("lock = %+v, want a lock from %s", lock, lockedAt)
+			}
+			// The directory refuses binds of locked accounts itself
+			_, err := e.m.Authenticate(ctx, "alice", alicePassword)
+			fails(t, err, "authentication failed")
+
+			list := result(locks.List(ctx)).must(t)
+			equal(t, "locked", len(list), 2)
+			// Permanent locks carry no time and sort last
+			equal(t, "permanent", list[1].Permanent, true)
+			equal(t, "permanent uid", list[1].UID, "bob")
+
+			check(t, locks.Unlock(ctx, "alice"))
+			if lock := result(locks.Get(ctx, "alice")).must(t); lock != nil {
+				t.Fatalf("lock = %+v after unlock", lock)
+			}
+			result(e.m.Authenticate(ctx, "alice", alicePassword)).must(t)
+			// Unlocking twice or a missing user is not an error
+			check(t, locks.Unlock(ctx, "alice"))
+			check(t, locks.Unlock(ctx, "nobody"))
+		}},
+		{"LoginCounters", func(t *testing.T, e *env) {
+			counters := e.m.LoginCounters()
+			if c := result(counters.Get(ctx, "uid:alice")).must(t); c != nil {
+				t.Fatalf("counter = %+v before any failure", c)
+			}
+			increment := func(c *lockout.Counter) {
+				c.Failures++
+				c.ExpiresAt = time.Now().Add(time.Minute)
+			}
+			result(counters.Update(ctx, "uid:alice", increment)).must(t)
+			equal(t, "failures", result(counters.Update(ctx, "uid:alice", increment)).must(t).Failures, 2)
+			equal(t, "stored", result(counters.Get(ctx, "uid:alice")).must(t).Failures, 2)
+
+			// An expired counter starts over, even before it is purged
+			result(counters.Update(ctx, "uid:alice", func(c *lockout.Counter) { c.ExpiresAt = time.Now().Add(-time.Second) })).must(t)
+			if c := result(counters.Get(ctx, "uid:alice")).must(t); c != nil {
+				t.Fatalf("counter = %+v after expiry", c)
+			}
+			equal(t, "restarted", result(counters.Update(ctx, "uid:alice", increment)).must(t).Failures, 1)
+			check(t, counters.Delete(ctx, "uid:alice"))
+			check(t, counters.Delete(ctx, "uid:alice"))
+
+			// Two replicas share the limit, whatever case the uid is typed in
+			cfg := *e.cfg
+			cfg.LoginMaxFailures, cfg.LoginBackoffAfter, cfg.LoginIPBackoffAfter = 3, 0, 0
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+			first := lockout.NewGuard(&cfg, e.m.AccountLocks(), counters, logger)
+			second := lockout.NewGuard(&cfg, e.m.AccountLocks(), counters, logger)
+			first.Failure(ctx, "alice", "10.0.0.1")
+			second.Failure(ctx, "Alice", "10.0.0.2")
+			check(t, second.Check(ctx, "alice", ""))
+			first.Failure(ctx, "ALICE", "10.0.0.1")
+			fails(t, second.Check(ctx, "alice", ""), "account is locked")
+		}},
+		{"Sessions", func(t *testing.T, e *env) {
+			sessions := e.m.Sessions()
+			now := time.Now().Truncate(time.Second)
+			first := &session.Session{ID: "s1", UID: "alice", AccessJTI: "j1", AccessExp: now.Add(time.Minute),
+				ClientIP: "10.0.0.1", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+			second := &session.Session{ID: "s2", UID: "alice", CreatedAt: now, LastUsedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Hour)}
+			expired := &session.Session{ID: "s3", UID: "alice", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(-time.Second)}
+			// The first write creates the containers
+			for _, sess := range []*session.Session{first, second, expired} {
+				check(t, sessions.Create(ctx, sess))
+			}
+			check(t, sessions.Create(ctx, &session.Session{ID: "s4", UID: "bob", ExpiresAt: now.Add(time.Hour)}))
+
+			got := result(sessions.Get(ctx, "s1")).must(t)
+			equal(t, "client", got.ClientIP, "10.0.0.1")
+			equal(t, "expires", got.ExpiresAt.Equal(first.ExpiresAt), true)
+			_, err := sessions.Get(ctx, "s3")
+			equal(t, "expired", err, session.ErrNotFound)
+
+			read := *got
+			got.LastUsedAt = now.Add(time.Minute)
+			got.AccessJTI = "j2"
+			check(t, sessions.Update(ctx, got, &read))
+			// A second writer holding the same copy lost the race
+			equal(t, "stale update", sessions.Update(ctx, got, &read), session.ErrConflict)
+			list := result(sessions.ListByUser(ctx, "alice")).must(t)
+			equal(t, "listed", []string{list[0].ID, list[1].ID}, []string{"s1", "s2"})
+			equal(t, "listed count", len(list), 2)
+			equal(t, "updated", list[0].AccessJTI, "j2")
+			equal(t, "update missing", sessions.Update(ctx, &session.Session{ID: "nope"}, &session.Session{ID: "nope"}), session.ErrNotFound)
+
+			check(t, sessions.Delete(ctx, "s1"))
+			check(t, sessions.Delete(ctx, "s1"))
+			_, err = sessions.Get(ctx, "s1")
+			equal(t, "deleted", err, session.ErrNotFound)
+
+			check(t, sessions.Revoke(ctx, "j1", now.Add(time.Minute)))
+			check(t, sessions.Revoke(ctx, "j1", now.Add(time.Minute)))
+			check(t, sessions.Revoke(ctx, "old", now.Add(-time.Second)))
+			equal(t, "revoked", result(sessions.IsRevoked(ctx, "j1")).must(t), true)
+			equal(t, "not revoked", result(sessions.IsRevoked(ctx, "j2")).must(t), false)
+			equal(t, "revocation expired", result(sessions.IsRevoked(ctx, "old")).must(t), false)
+
+			equal(t, "purged", result(e.m.PurgeExpiredState(ctx)).must(t), 2)
+			if _, ok := e.srv.Entry("cn=s3,ou=sessions," + e.cfg.StateDN()); ok {
+				t.Fatal("expired session left after purge")
+			}
+		}},
+		{"ResetTokens", func(t *testing.T, e *env) {
+			resets := e.m.ResetTokens()
+			now := time.Now().Truncate(time.Second)
+			_, ok := resets.LastIssued(ctx, "alice")
+			equal(t, "issued before any", ok, false)
+
+			check(t, resets.Put(ctx, &reset.Token{Hash: "h1", UID: "alice", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}))
+			// A new token replaces the previous one of the user
+			check(t, resets.Put(ctx, &reset.Token{Hash: "h2", UID: "alice", CreatedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Hour)}))
+			check(t, resets.Put(ctx, &reset.Token{Hash: "h3", UID: "bob", CreatedAt: now, ExpiresAt: now.Add(-time.Second)}))
+
+			last, ok := resets.LastIssued(ctx, "alice")
+			equal(t, "last issued", ok && last.Equal(now.Add(time.Second)), true)
+			_, ok = resets.LastIssued(ctx, "bob")
+			equal(t, "expired issued", ok, false)
+
+			_, err := resets.Consume(ctx, "h1")
+			equal(t, "replaced", err, reset.ErrInvalidToken)
+			equal(t, "consumed", result(resets.Consume(ctx, "h2")).must(t).UID, "alice")
+			_, err = resets.Consume(ctx, "h2")
+			equal(t, "consumed twice", err, reset.ErrInvalidToken)
+			_, err = resets.Consume(ctx, "h3")
+			equal(t, "expired", err, reset.ErrInvalidToken)
+
+			// Restoring a consumed token keeps a token issued in the meantime
+			check(t, resets.Put(ctx, &reset.Token{Hash: "h4", UID: "alice", CreatedAt: now.Add(2 * time.Second), ExpiresAt: now.Add(time.Hour)}))
+			check(t, resets.Restore(ctx, &reset.Token{Hash: "h2", UID: "alice", CreatedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Hour)}))
+			last, _ = resets.LastIssued(ctx, "alice")
+			equal(t, "last issued after restore", last.Equal(now.Add(2*time.Second)), true)
+			equal(t, "restored", result(resets.Consume(ctx, "h2")).must(t).UID, "alice")
+			equal(t, "kept", result(resets.Consume(ctx, "h4")).must(t).UID, "alice")
+		}},
+		{"Registrations", func(t *testing.T, e *env) {
+			registrations := e.m.Registrations()
+			now := time.Now().Truncate(time.Second)
+			check(t, registrations.Create(ctx, &models.Registration{ID: "r2", Username: "erin", Status: registration.StatusPending,
+				CreatedAt: now.Add(time.Second), PasswordHash: "{SSHA}erin"}))
+			check(t, registrations.Create(ctx, &models.Registration{ID: "r1", Username: "dave", Status: registration.StatusPending,
+				CreatedAt: now, PasswordHash: "{SSHA}dave"}))
+
+			reg := result(registrations.Get(ctx, "r1")).must(t)
+			equal(t, "password hash", reg.PasswordHash, "{SSHA}dave")
+			_, err := registrations.Get(ctx, "nope")
+			equal(t, "missing", err, registration.ErrNotFound)
+
+			// Reviewing claims the request; a second review of the same state fails
+			reviewed := now.Add(time.Minute)
+			reg.Status, reg.ReviewedAt, reg.ReviewedBy = registration.StatusApproved, &reviewed, "alice"
+			check(t, registrations.Update(ctx, reg, registration.StatusPending))
+			stale := result(registrations.Get(ctx, "r2")).must(t)
+			stale.Status = registration.StatusRejected
+			check(t, registrations.Update(ctx, stale, registration.StatusPending))
+			equal(t, "conflict", registrations.Update(ctx, reg, registration.StatusPending), registration.ErrConflict)
+			equal(t, "update missing", registrations.Update(ctx, &models.Registration{ID: "nope"}, registration.StatusPending), registration.ErrNotFound)
+
+			var names []string
+			for _, reg := range result(registrations.List(ctx, "")).must(t) {
+				names = append(names, reg.Username+" "+reg.Status)
+			}
+			equal(t, "all", names, []string{"dave APPROVED", "erin REJECTED"})
+			equal(t, "pending", len(result(registrations.List(ctx, registration.StatusPending)).must(t)), 0)
+
+			// Reviewed registrations expire after the retention period, pending ones never
+			attrs, _ := e.srv.Entry("cn=r1,ou=registrations," + e.cfg.StateDN())
+			equal(t, "expiry", attrs["devplatformExpiresAt"], []string{reviewed.Add(registration.ReviewedRetention).UTC().Format("20060102150405Z")})
+			reg.Status, reg.ReviewedAt, reg.ReviewedBy = registration.StatusPending, nil, ""
+			check(t, registrations.Update(ctx, reg, registration.StatusApproved))
+			attrs, _ = e.srv.Entry("cn=r1,ou=registrations," + e.cfg.StateDN())
+			equal(t, "released expiry", len(attrs["devplatformExpiresAt"]), 0)
+		}},
+		{"AuthCodes", func(t *testing.T, e *env) {
+			codes := e.m.AuthCodes()
+			now := time.Now().Truncate(time.Second)
+			code := &models.AuthCode{ClientID: "grafana", RedirectURI: "https://grafana/cb", UID: "alice",
+				Scopes: []string{"openid", "email"}, CodeChallenge: "challenge", AuthTime: now, ExpiresAt: now.Add(time.Minute)}
+			check(t, codes.Put(ctx, "h1", code))
+			check(t, codes.Put(ctx, "h2", &models.AuthCode{UID: "bob", ExpiresAt: now.Add(-time.Second)}))
+
+			got := result(codes.Take(ctx, "h1")).must(t)
+			if got == nil {
+				t.Fatal("stored code not found")
+			}
+			equal(t, "scopes", got.Scopes, code.Scopes)
+			equal(t, "auth time", got.AuthTime.Equal(now), true)
+			// Codes work once, and expired or unknown codes not at all
+			for _, hash := range []string{"h1", "h2", "h3"} {
+				if got := result(codes.Take(ctx, hash)).must(t); got != nil {
+					t.Fatalf("Take(%s) = %+v, want nil", hash, got)
+				}
+			}
+		}},
+		// Operations
+		{"Migrate", func(t *testing.T, e *env) {
+			statuses := func(results []*ldap.MigrationResult) []string {
+				out := make([]string, 0, len(results))
+				for _, result := range results {
+					out = append(out, result.Status)
+				}
+				return out
+			}
+
+			// The base OUs are seeded and the schema is loaded, but the
+			// departments still carry extensibleObject
+			results := result(e.m.Migrate(ctx, true)).must(t)
+			equal(t, "dry run", statuses(results), []string{ldap.MigrationPending, ldap.MigrationPending, ldap.MigrationPending, ldap.MigrationPending, ldap.MigrationPending, ldap.MigrationPending})
+			equal(t, "changes", [][]string{results[0].Changes, results[1].Changes, results[3].Changes, results[4].Changes, results[5].Changes}, [][]string{nil, nil, nil, nil, nil})
+			equal(t, "departments", results[2].Changes, []string{
+				"add objectClass devplatformDepartment to ou=Engineering,ou=departments," + baseDN,
+				"add objectClass devplatformDepartment to ou=Platform,ou=Engineering,ou=departments," + baseDN,
+				"add objectClass devplatformDepartment to ou=Sales,ou=departments," + baseDN,
+			})
+			if _, ok := e.srv.Entry(e.cfg.MigrationsDN()); ok {
+				t.Fatal("dry run recorded migrations")
+			}
+			attrs, _ := e.srv.Entry(e.cfg.DepartmentDN("Sales"))
+			equal(t, "dry run classes", attrs["objectClass"], []string{"organizationalUnit", "extensibleObject"})
+
+			equal(t, "first run", statuses(result(e.m.Migrate(ctx, false)).must(t)), []string{ldap.MigrationApplied, ldap.MigrationApplied, ldap.MigrationApplied, ldap.MigrationApplied, ldap.MigrationApplied, ldap.MigrationApplied})
+			attrs, _ = e.srv.Entry(e.cfg.MigrationsDN())
+			equal(t, "recorded", attrs["description"], []string{"1 directory schema", "2 base organizational units", "3 department object class", "4 state schema", "5 deleted user references", "6 password history"})
+			attrs, _ = e.srv.Entry(e.cfg.DepartmentDN("Sales"))
+			equal(t, "classes", attrs["objectClass"], []string{"organizationalUnit", "devplatformDepartment"})
+			equal(t, "repositories", result(e.m.GetDepartment(ctx, "Sales")).must(t).Repositories, []string{"devplatform/crm"})
+			equal(t, "second run", statuses(result(e.m.Migrate(ctx, false)).must(t)), []string{ldap.MigrationCurrent, ldap.MigrationCurrent, ldap.MigrationCurrent, ldap.MigrationCurrent, ldap.MigrationCurrent, ldap.MigrationCurrent})
+
+			// Without cn=config access the schema cannot be installed, and the
+			// departments are left alone until it is
+			schema := ldaptest.DefaultSchema()
+			schema.RemoveObjectClass("devplatformDepartment")
+			bare := startEnv(t, schema, seed)
+			results = result(bare.m.Migrate(ctx, false)).must(t)
+			equal(t, "without schema", statuses(results), []string{ldap.MigrationSkipped, ldap.MigrationApplied, ldap.MigrationSkipped, ldap.MigrationSkipped, ldap.MigrationSkipped, ldap.MigrationSkipped})
+			if !strings.Contains(results[0].Reason, "LDAP_CONFIG_BIND_DN") {
+				t.Fatalf("reason = %q, want a hint at LDAP_CONFIG_BIND_DN", results[0].Reason)
+			}
+			attrs, _ = bare.srv.Entry(bare.cfg.MigrationsDN())
+			equal(t, "recorded without schema", attrs["description"], []string{"2 base organizational units"})
+
+			// A directory migrated before passwordHistory was added still needs it
+			schema = ldaptest.DefaultSchema()
+			schema.RemoveAttributeType("passwordHistory")
+			older := startEnv(t, schema, seed)
+			results = result(older.m.Migrate(ctx, true)).must(t)
+			equal(t, "without passwordHistory", results[5].Status, ldap.MigrationSkipped)
+		}},
+		{"ExportLDIF", func(t *testing.T, e *env) {
+			var out strings.Builder
+			check(t, e.m.ExportLDIF(ctx, models.LDIFScopeGroups, &out))
+			if !strings.HasPrefix(out.String(), "version: 1\n") {
+				t.Fatalf("export does not start with a version line:\n%s", out.String())
+			}
+			equal(t, "groups", strings.Count(out.String(), "\ndn: "), 4)
+			if !strings.Contains(out.String(), "\ndn: cn=developers,ou=groups,"+baseDN+"\n") {
+				t.Fatalf("developers missing from export:\n%s", out.String())
+			}
+
+			// Deleted users belong to the users scope
+			result(e.m.SoftDeleteUser(ctx, "carol")).must(t)
+			out.Reset()
+			check(t, e.m.ExportLDIF(ctx, models.LDIFScopeUsers, &out))
+			equal(t, "users", strings.Count(out.String(), "\ndn: "), 5)
+
+			fails(t, e.m.ExportLDIF(ctx, "everything", &out), "invalid LDIF scope")
+
+			// An export of everything restores into an empty directory
+			out.Reset()
+			check(t, e.m.ExportLDIF(ctx, models.LDIFScopeAll, &out))
+			restored := startEnv(t, nil, nil)
+			report := result(restored.m.ImportLDIF(ctx, strings.NewReader(out.String()), false)).must(t)
+			equal(t, "failed", report.Failed, 0)
+			equal(t, "applied", report.Applied, 13)
+
+			equal(t, "users", uids(result(restored.m.ListUsers(ctx, nil)).must(t)), []string{"alice", "bob"})
+			equal(t, "deleted", uids(result(restored.m.ListUsers(ctx, &models.SearchFilter{Status: models.UserStatusDeleted})).must(t)), []string{"carol"})
+			equal(t, "platform", result(restored.m.GetDepartment(ctx, "Platform")).must(t).Path, []string{"Engineering", "Platform"})
+			equal(t, "developers", result(restored.m.GetGroup(ctx, "developers")).must(t).Members, []string{"alice"})
+			result(restored.m.Authenticate(ctx, "alice", alicePassword)).must(t)
+		}},
+		{"ImportLDIF", func(t *testing.T, e *env) {
+			input := strings.Join([]string{
+				"version: 1",
+				"",
+				"# A new user",
+				"dn: uid=dave,ou=users," + baseDN,
+				"changetype: add",
+				"objectClass: inetOrgPerson",
+				"objectClass: posixAccount",
+				"uid: dave",
+				"cn: Dave Doe",
+				"sn: Doe",
+				"uidNumber: 10042",
+				"gidNumber: 10042",
+				"homeDirectory: /home/dave",
+				"description:: RMO2cmcgYXVzIEvDtmxu",
+				"",
+				"dn: uid=carol,ou=users," + baseDN,
+				"changetype: modify",
+				"replace: mail",
+				"mail: carol.cooper@devplatf",
+				" orm.local",
+				"-",
+				"",
+				"dn: cn=sales,ou=groups," + baseDN,
+				"changetype: delete",
+				"",
+				"dn: cn=printer,ou=devices," + baseDN,
+				"changetype: add",
+				"objectClass: device",
+				"cn: printer",
+				"",
+				"dn: uid=bob,ou=users," + baseDN,
+				"changetype: modrdn",
+				"newrdn: uid=robert",
+				"deleteoldrdn: 1",
+				"",
+				"dn: cn=sales,ou=groups," + baseDN,
+				"changetype: modify",
+				"add: description",
+				"description: Gone by now",
+				"-",
+				"",
+			}, "\n")
+			statuses := func(report *models.LDIFImportReport) []string {
+				out := make([]string, 0, len(report.Records))
+				for _, record := range report.Records {
+					out = append(out, record.Status)
+				}
+				return out
+			}
+
+			report := result(e.m.ImportLDIF(ctx, strings.NewReader(input), true)).must(t)
+			equal(t, "dry run", statuses(report), []string{
+				models.LDIFRecordValid, models.LDIFRecordValid, models.LDIFRecordValid,
+				models.LDIFRecordFailed, models.LDIFRecordFailed, models.LDIFRecordFailed,
+			})
+			equal(t, "lines", report.Records[1].Line, 16)
+			equal(t, "layout", report.Records[3].Error, "cn=printer,ou=devices,"+baseDN+" is not a user, group or department entry of "+baseDN)
+			equal(t, "modrdn", report.Records[4].Error, "changetype modrdn is not supported")
+			equal(t, "deleted before", report.Records[5].Error, "entry does not exist")
+			if _, ok := e.srv.Entry(e.cfg.UserDN("dave")); ok {
+				t.Fatal("dry run added an entry")
+			}
+
+			report = result(e.m.ImportLDIF(ctx, strings.NewReader(input), false)).must(t)
+			equal(t, "import", statuses(report), []string{
+				models.LDIFRecordApplied, models.LDIFRecordApplied, models.LDIFRecordApplied,
+				models.LDIFRecordFailed, models.LDIFRecordFailed, models.LDIFRecordFailed,
+			})
+			equal(t, "counts", []int{report.Applied, report.Failed}, []int{3, 3})
+
+			attrs, _ := e.srv.Entry(e.cfg.UserDN("dave"))
+			equal(t, "base64 value", attrs["description"], []string{"Dörg aus Köln"})
+			equal(t, "folded value", result(e.m.GetUser(ctx, "carol")).must(t).Mail, "carol.cooper@devplatform.local")
+			_, err := e.m.GetGroup(ctx, "sales")
+			fails(t, err, "group not found")
+		}},
+		{"ImportLDIFPasswords", func(t *testing.T, e *env) {
+			input := strings.Join([]string{
+				"dn: uid=dave,ou=users," + baseDN,
+				"objectClass: inetOrgPerson",
+				"objectClass: posixAccount",
+				"uid: dave",
+				"cn: Dave Doe",
+				"sn: Doe",
+				"homeDirectory: /home/dave",
+				"userPassword: " + newPassword,
+				"",
+				"dn: uid=erin,ou=users," + baseDN,
+				"objectClass: inetOrgPerson",
+				"uid: erin",
+				"cn: Erin Evans",
+				"sn: Evans",
+				"userPassword: short",
+				"",
+				"dn: uid=carol,ou=users," + baseDN,
+				"changetype: modify",
+				"replace: userPassword",
+				"userPassword: " + newPassword,
+				"-",
+				"",
+			}, "\n")
+
+			report := result(e.m.ImportLDIF(ctx, strings.NewReader(input), true)).must(t)
+			equal(t, "dry run counts", []int{report.Applied, report.Failed}, []int{2, 1})
+			if report.Records[1].Error == "" {
+				t.Fatal("dry run accepted a password breaking the policy")
+			}
+
+			carolBefore, _ := e.srv.Entry(e.cfg.UserDN("carol"))
+			report = result(e.m.ImportLDIF(ctx, strings.NewReader(input), false)).must(t)
+			equal(t, "counts", []int{report.Applied, report.Failed}, []int{2, 1})
+
+			dave, _ := e.srv.Entry(e.cfg.UserDN("dave"))
+			if stored := dave["userPassword"]; len(stored) != 1 || !strings.HasPrefix(stored[0], "{SSHA}") || !password.Verify(newPassword, stored[0]) {
+				t.Fatalf("userPassword = %v, want an SSHA hash of the imported password", stored)
+			}
+			if len(dave["uidNumber"]) != 1 || len(dave["gidNumber"]) != 1 {
+				t.Fatalf("uidNumber = %v, gidNumber = %v, want allocated numbers", dave["uidNumber"], dave["gidNumber"])
+			}
+			next := result(e.m.IDAllocation(ctx)).must(t)
+			equal(t, "allocated uidNumber", dave["uidNumber"][0], strconv.Itoa(next.UID.Next-1))
+
+			carol, _ := e.srv.Entry(e.cfg.UserDN("carol"))
+			if stored := carol["userPassword"]; len(stored) != 1 || !password.Verify(newPassword, stored[0]) || stored[0] == newPassword || reflect.DeepEqual(stored, carolBefore["userPassword"]) {
+				t.Fatalf("userPassword = %v, want a fresh hash of the imported password", stored)
+			}
+		}},
+		{"AuthenticateAgainstProvider", func(t *testing.T, e *env) {
+			// A consumer that has not replicated the changes below
+			consumer, err := ldaptest.NewServer(ldaptest.Config{BaseDN: baseDN, RootDN: rootDN, RootPassword: rootPassword})
+			if err != nil {
+				t.Fatalf("NewServer: %v", err)
+			}
+			t.Cleanup(func() { consumer.Close() })
+			seed(t, consumer)
+
+			t.Setenv("LDAP_ENDPOINTS", "provider="+e.srv.URL()+",consumer="+consumer.URL())
+			m, err := ldap.NewManager(loadConfig(t), quietLogger())
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			t.Cleanup(func() { m.Close() })
+
+			result(m.DisableUser(ctx, "alice")).must(t)
+			check(t, m.SetPassword(ctx, "bob", newPassword))
+
+			_, err = m.Authenticate(ctx, "alice", alicePassword)
+			fails(t, err, "authentication failed")
+			_, err = m.Authenticate(ctx, "bob", bobPassword)
+			fails(t, err, "authentication failed")
+			result(m.Authenticate(ctx, "bob", newPassword)).must(t)
+
+			// Consumers still answer binds while no provider is up
+			e.srv.Close()
+			m, err = ldap.NewManager(loadConfig(t), quietLogger())
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			t.Cleanup(func() { m.Close() })
+			result(m.Authenticate(ctx, "carol", carolPassword)).must(t)
+		}},
+		{"HealthCheck", func(t *testing.T, e *env) {
+			check(t, e.m.HealthCheck(ctx))
+
+			e.srv.Close()
+			fails(t, e.m.HealthCheck(ctx), "health check failed")
+		}},
+		{"CheckEndpoints", func(t *testing.T, e *env) {
+			endpoints := e.m.CheckEndpoints(ctx)
+			equal(t, "endpoints", len(endpoints), 1)
+			equal(t, "url", endpoints[0].URL, e.srv.URL())
+			equal(t, "role", endpoints[0].Role, config.RoleProvider)
+			equal(t, "healthy", endpoints[0].Healthy, true)
+		}},
+		{"GetStats", func(t *testing.T, e *env) {
+			result(e.m.GetUser(ctx, "alice")).must(t)
+			stats := e.m.GetStats()
+			if stats.TotalRequests < 1 {
+				t.Fatalf("totalRequests = %d, want at least 1", stats.TotalRequests)
+			}
+			equal(t, "healthy", stats.Healthy, true)
+			equal(t, "pool size", stats.PoolSize, e.cfg.LDAPPoolSize)
+			equal(t, "endpoints", len(stats.Endpoints), 1)
+		}},
+		{"Close", func(t *testing.T, e *env) {
+			check(t, e.m.Close())
+			_, err := e.m.GetUser(ctx, "alice")
+			fails(t, err, "connection pool is closed")
+			check(t, e.m.Close())
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newEnv(t))
+		})
+	}
+}
@@ -0,0 +1,635 @@
+package ldaptest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/password"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// bind authenticates a session. Anonymous binds always succeed; entries
+// bind with any of their userPassword values unless they carry a ppolicy lock.
+// A failed bind leaves the session anonymous.
+func (s *Server) bind(sess *session, op *ber.Packet) error {
+	sess.bound, sess.root = "", false
+	if len(op.Children) != 3 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid bind request"))
+	}
+	if version, _ := op.Children[0].Value.(int64); version != 3 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("requested protocol version not allowed"))
+	}
+	auth := op.Children[2]
+	if auth.ClassType != ber.ClassContext || auth.Tag != 0 {
+		return ldap.NewError(ldap.LDAPResultAuthMethodNotSupported, fmt.Errorf("only simple binds are supported"))
+	}
+
+	name, secret := op.Children[1].Data.String(), auth.Data.String()
+	invalid := ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+	switch {
+	case name == "" && secret == "":
+		return nil
+	case secret == "":
+		return ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("unauthenticated bind (DN with no password) disallowed"))
+	case normalizeDN(name) == s.rootKey:
+		if secret != s.config.RootPassword {
+			return invalid
+		}
+		sess.bound, sess.root = s.rootKey, true
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[normalizeDN(name)]
+	if !ok || len(e.values("pwdAccountLockedTime")) > 0 {
+		return invalid
+	}
+	for _, stored := range e.values("userPassword") {
+		if password.Verify(secret, stored) {
+			sess.bound = dnKey(e.dn)
+			return nil
+		}
+	}
+	return invalid
+}
+
+// searchRequest is a decoded search request
+type searchRequest struct {
+	base       string
+	scope      int
+	sizeLimit  int
+	typesOnly  bool
+	filter     *ber.Packet
+	attributes []string
+}
+
+func decodeSearch(op *ber.Packet) (*searchRequest, error) {
+	if len(op.Children) != 8 {
+		return nil, ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid search request"))
+	}
+	scope, _ := op.Children[1].Value.(int64)
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	typesOnly, _ := op.Children[5].Value.(bool)
+	req := &searchRequest{
+		base:      op.Children[0].Data.String(),
+		scope:     int(scope),
+		sizeLimit: int(sizeLimit),
+		typesOnly: typesOnly,
+		filter:    op.Children[6],
+	}
+	for _, attr := range op.Children[7].Children {
+		req.attributes = append(req.attributes, attr.Data.String())
+	}
+	return req, nil
+}
+
+// search answers a search request with its entries and the final result
+func (s *Server) search(sess *session, id int64, op *ber.Packet, controls []*control) bool {
+	done := func(err error, responseControls ...*ber.Packet) bool {
+		return s.send(sess, envelope(id, result(ldap.ApplicationSearchResultDone, err), responseControls...))
+	}
+
+	req, err := decodeSearch(op)
+	if err != nil {
+		return done(err)
+	}
+	if err := checkCritical(controls, ldap.ControlTypePaging, ldap.ControlTypeServerSideSorting); err != nil {
+		return done(err)
+	}
+	var paging *pagingRequest
+	if c := findControl(controls, ldap.ControlTypePaging); c != nil {
+		if paging, err = decodePaging(c); err != nil {
+			return done(err)
+		}
+	}
+	var sortKeys []*sortKey
+	if c := findControl(controls, ldap.ControlTypeServerSideSorting); c != nil {
+		if sortKeys, err = decodeSort(c); err != nil {
+			return done(err)
+		}
+	}
+
+	entries, err := s.find(req)
+	if err != nil {
+		return done(err)
+	}
+	if sortKeys != nil {
+		s.schema.sortEntries(entries, sortKeys)
+	}
+
+	var responseControls []*ber.Packet
+	if paging != nil {
+		// A page size of 0 abandons the paged search
+		if paging.size == 0 || paging.offset >= len(entries) {
+			entries = nil
+		} else {
+			entries = entries[paging.offset:]
+		}
+		cookie := ""
+		if paging.size > 0 && len(entries) > paging.size {
+			entries = entries[:paging.size]
+			cookie = strconv.Itoa(paging.offset + paging.size)
+		}
+		responseControls = append(responseControls, pagingResponse(cookie))
+	}
+
+	var limitErr error
+	if req.sizeLimit > 0 && len(entries) > req.sizeLimit {
+		entries = entries[:req.sizeLimit]
+		limitErr = ldap.NewError(ldap.LDAPResultSizeLimitExceeded, fmt.Errorf("size limit exceeded"))
+	}
+
+	for _, e := range entries {
+		if !s.send(sess, envelope(id, s.encodeEntry(sess, e, req))) {
+			return false
+		}
+	}
+	return done(limitErr, responseControls...)
+}
+
+// find returns the entries in scope of req that match its filter, in creation order
+func (s *Server) find(req *searchRequest) ([]*entry, error) {
+	if req.base == "" && req.scope == ldap.ScopeBaseObject {
+		root := s.rootDSE()
+		ok, err := s.schema.match(req.filter, root)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []*entry{root}, nil
+	}
+	if req.scope == ldap.ScopeBaseObject && strings.EqualFold(req.base, SubschemaDN) {
+		subschema := s.subschema()
+		ok, err := s.schema.match(req.filter, subschema)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []*entry{subschema}, nil
+	}
+
+	base, err := ldap.ParseDN(req.base)
+	if err != nil {
+		return nil, ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid DN"))
+	}
+	baseKey := dnKey(base)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[baseKey]; !ok && baseKey != "" {
+		return nil, s.noSuchObject(base)
+	}
+
+	var entries []*entry
+	for key, e := range s.entries {
+		inScope := false
+		switch req.scope {
+		case ldap.ScopeBaseObject:
+			inScope = key == baseKey
+		case ldap.ScopeSingleLevel:
+			inScope = dnKey(parentDN(e.dn)) == baseKey
+		case ldap.ScopeWholeSubtree:
+			inScope = key == baseKey || isBelow(key, baseKey)
+		default:
+			return nil, ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid scope"))
+		}
+		if !inScope {
+			continue
+		}
+		ok, err := s.schema.match(req.filter, e)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			entries = append(entries, e.clone())
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	return entries, nil
+}
+
+// rootDSE describes the server to clients
+func (s *Server) rootDSE() *entry {
+	e := &entry{dn: &ldap.DN{}}
+	e.set("objectClass", []string{"top"})
+	e.set("namingContexts", []string{s.config.BaseDN})
+	e.set("supportedLDAPVersion", []string{"3"})
+	e.set("supportedControl", []string{ldap.ControlTypePaging, ldap.ControlTypeServerSideSorting})
+	e.set("subschemaSubentry", []string{SubschemaDN})
+	return e
+}
+
+// subschema publishes the schema the way clients discover it, as RFC 4512
+// definitions. Only the names and the kinds of object classes are meaningful.
+func (s *Server) subschema() *entry {
+	dn, _ := ldap.ParseDN(SubschemaDN)
+	e := &entry{dn: dn}
+	e.set("objectClass", []string{"top", "subschema"})
+	e.set("cn", []string{"Subschema"})
+
+	var attributeTypes, objectClasses []string
+	for i, name := range s.schema.attributeNames() {
+		attributeTypes = append(attributeTypes, fmt.Sprintf("( 1.3.6.1.4.1.99999.1.%d NAME '%s' )", i+1, name))
+	}
+	for i, name := range s.schema.classNames() {
+		kind := "STRUCTURAL"
+		if oc, _ := s.schema.ObjectClass(name); oc.Auxiliary {
+			kind = "AUXILIARY"
+		}
+		objectClasses = append(objectClasses, fmt.Sprintf("( 1.3.6.1.4.1.99999.2.%d NAME '%s' %s )", i+1, name, kind))
+	}
+	e.set("attributeTypes", attributeTypes)
+	e.set("objectClasses", objectClasses)
+	return e
+}
+
+// encodeEntry encodes the attributes of e selected by req. userPassword is
+// only disclosed to root, and operational attributes only when asked for by name.
+func (s *Server) encodeEntry(sess *session, e *entry, req *searchRequest) *ber.Packet {
+	all, operational := len(req.attributes) == 0, false
+	named := map[string]bool{}
+	for _, attr := range req.attributes {
+		switch attr {
+		case "*":
+			all = true
+		case "+":
+			operational = true
+		default:
+			named[strings.ToLower(attr)] = true
+		}
+	}
+
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn.String(), "Object Name"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, attr := range e.attrs {
+		isOperational := false
+		if at, ok := s.schema.AttributeType(attr.name); ok {
+			isOperational = at.Operational
+		}
+		if !named[strings.ToLower(attr.name)] && !(isOperational && operational) && !(!isOperational && all) {
+			continue
+		}
+		if strings.EqualFold(attr.name, "userPassword") && !sess.root {
+			continue
+		}
+
+		partial := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		partial.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr.name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		if !req.typesOnly {
+			for _, value := range attr.values {
+				values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+			}
+		}
+		partial.AppendChild(values)
+		attrs.AppendChild(partial)
+	}
+	packet.AppendChild(attrs)
+	return packet
+}
+
+// add handles an add request
+func (s *Server) add(op *ber.Packet) error {
+	if len(op.Children) != 2 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid add request"))
+	}
+	dn, err := ldap.ParseDN(op.Children[0].Data.String())
+	if err != nil || len(dn.RDNs) == 0 {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid DN"))
+	}
+
+	e := &entry{dn: dn}
+	for _, attr := range op.Children[1].Children {
+		if len(attr.Children) != 2 {
+			return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid attribute"))
+		}
+		values := make([]string, 0, len(attr.Children[1].Children))
+		for _, value := range attr.Children[1].Children {
+			values = append(values, value.Data.String())
+		}
+		if err := s.addAttribute(e, attr.Children[0].Data.String(), values); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addEntry(e)
+}
+
+// addAttribute adds the values of an attribute to a new entry
+func (s *Server) addAttribute(e *entry, name string, values []string) error {
+	at, ok := s.schema.AttributeType(name)
+	if !ok {
+		return ldap.NewError(ldap.LDAPResultUndefinedAttributeType, fmt.Errorf("%s: attribute type undefined", name))
+	}
+	if len(values) == 0 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("%s: no values for attribute type", at.Name))
+	}
+	if e.get(at.Name) != nil {
+		return ldap.NewError(ldap.LDAPResultAttributeOrValueExists, fmt.Errorf("%s: attribute provided more than once", at.Name))
+	}
+	merged, err := s.addValues(at.Name, nil, values)
+	if err != nil {
+		return err
+	}
+	e.set(at.Name, merged)
+	return nil
+}
+
+// addValues returns existing with values appended, failing on duplicates
+func (s *Server) addValues(attr string, existing, values []string) ([]string, error) {
+	merged := append([]string{}, existing...)
+	for _, value := range values {
+		if s.schema.contains(merged, attr, value) {
+			return nil, ldap.NewError(ldap.LDAPResultAttributeOrValueExists, fmt.Errorf("%s: value #0 provided more than once", attr))
+		}
+		merged = append(merged, value)
+	}
+	return merged, nil
+}
+
+// addEntry checks and stores a new entry. Must be called with s.mu held.
+func (s *Server) addEntry(e *entry) error {
+	key := dnKey(e.dn)
+	if _, ok := s.entries[key]; ok {
+		return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, fmt.Errorf("already exists"))
+	}
+	if key != s.baseKey {
+		if !isBelow(key, s.baseKey) {
+			return ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no global superior knowledge"))
+		}
+		if _, ok := s.entries[dnKey(parentDN(e.dn))]; !ok {
+			return s.noSuchObject(e.dn)
+		}
+	}
+	if err := s.schema.check(e); err != nil {
+		return err
+	}
+
+	s.seq++
+	e.seq = s.seq
+	s.entries[key] = e
+	return nil
+}
+
+// modify handles a modify request. Changes apply in order and the entry is
+// only stored when all of them succeed and it still passes the schema check.
+func (s *Server) modify(op *ber.Packet) error {
+	if len(op.Children) != 2 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid modify request"))
+	}
+	dn, err := ldap.ParseDN(op.Children[0].Data.String())
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid DN"))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.entries[dnKey(dn)]
+	if !ok {
+		return s.noSuchObject(dn)
+	}
+	e := current.clone()
+
+	for _, change := range op.Children[1].Children {
+		if len(change.Children) != 2 || len(change.Children[1].Children) != 2 {
+			return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid change"))
+		}
+		operation, _ := change.Children[0].Value.(int64)
+		name := change.Children[1].Children[0].Data.String()
+		var values []string
+		for _, value := range change.Children[1].Children[1].Children {
+			values = append(values, value.Data.String())
+		}
+		if err := s.applyChange(e, operation, name, values); err != nil {
+			return err
+		}
+	}
+
+	for _, rdn := range e.dn.RDNs[0].Attributes {
+		if !s.schema.contains(e.values(rdn.Type), rdn.Type, rdn.Value) {
+			return ldap.NewError(ldap.LDAPResultNotAllowedOnRDN, fmt.Errorf("cannot remove the naming attribute %s", rdn.Type))
+		}
+	}
+	if err := s.schema.check(e); err != nil {
+		return err
+	}
+	s.entries[dnKey(dn)] = e
+	return nil
+}
+
+func (s *Server) applyChange(e *entry, operation int64, name string, values []string) error {
+	at, ok := s.schema.AttributeType(name)
+	if !ok {
+		return ldap.NewError(ldap.LDAPResultUndefinedAttributeType, fmt.Errorf("%s: attribute type undefined", name))
+	}
+	existing := e.values(at.Name)
+
+	switch operation {
+	case ldap.AddAttribute:
+		if len(values) == 0 {
+			return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("modify/add: %s: no values given", at.Name))
+		}
+		merged, err := s.addValues(at.Name, existing, values)
+		if err != nil {
+			return err
+		}
+		e.set(at.Name, merged)
+	case ldap.DeleteAttribute:
+		if len(existing) == 0 {
+			return ldap.NewError(ldap.LDAPResultNoSuchAttribute, fmt.Errorf("modify/delete: %s: no such attribute", at.Name))
+		}
+		if len(values) == 0 {
+			e.set(at.Name, nil)
+			return nil
+		}
+		kept := append([]string{}, existing...)
+		for _, value := range values {
+			found := false
+			for i, v := range kept {
+				if s.schema.equal(at.Name, v, value) {
+					kept = append(kept[:i], kept[i+1:]...)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return ldap.NewError(ldap.LDAPResultNoSuchAttribute, fmt.Errorf("modify/delete: %s: no such value", at.Name))
+			}
+		}
+		e.set(at.Name, kept)
+	case ldap.ReplaceAttribute:
+		merged, err := s.addValues(at.Name, nil, values)
+		if err != nil {
+			return err
+		}
+		e.set(at.Name, merged)
+	case ldap.IncrementAttribute:
+		if len(existing) == 0 {
+			return ldap.NewError(ldap.LDAPResultNoSuchAttribute, fmt.Errorf("modify/increment: %s: no such attribute", at.Name))
+		}
+		if at.Equality != MatchInteger || len(values) != 1 {
+			return ldap.NewError(ldap.LDAPResultConstraintViolation, fmt.Errorf("modify/increment: %s: invalid", at.Name))
+		}
+		delta, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, fmt.Errorf("modify/increment: %s: invalid syntax", at.Name))
+		}
+		incremented := make([]string, 0, len(existing))
+		for _, v := range existing {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return ldap.NewError(ldap.LDAPResultConstraintViolation, fmt.Errorf("modify/increment: %s: invalid value", at.Name))
+			}
+			incremented = append(incremented, strconv.FormatInt(n+delta, 10))
+		}
+		e.set(at.Name, incremented)
+	default:
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("unknown modify operation %d", operation))
+	}
+	return nil
+}
+
+// del handles a delete request. Only leaf entries can be deleted.
+func (s *Server) del(op *ber.Packet) error {
+	dn, err := ldap.ParseDN(op.Data.String())
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid DN"))
+	}
+	key := dnKey(dn)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[key]; !ok {
+		return s.noSuchObject(dn)
+	}
+	for other := range s.entries {
+		if isBelow(other, key) {
+			return ldap.NewError(ldap.LDAPResultNotAllowedOnNonLeaf, fmt.Errorf("subordinate objects must be deleted first"))
+		}
+	}
+	delete(s.entries, key)
+	return nil
+}
+
+// modifyDN handles a ModifyDN request, moving the whole subtree of the entry
+func (s *Server) modifyDN(op *ber.Packet) error {
+	if len(op.Children) < 3 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid modify DN request"))
+	}
+	dn, err := ldap.ParseDN(op.Children[0].Data.String())
+	if err != nil || len(dn.RDNs) == 0 {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid DN"))
+	}
+	newRDN, err := ldap.ParseDN(op.Children[1].Data.String())
+	if err != nil || len(newRDN.RDNs) != 1 {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid new RDN"))
+	}
+	deleteOld, _ := op.Children[2].Value.(bool)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	oldKey := dnKey(dn)
+	current, ok := s.entries[oldKey]
+	if !ok {
+		return s.noSuchObject(dn)
+	}
+
+	parent := parentDN(current.dn)
+	if len(op.Children) > 3 {
+		if parent, err = ldap.ParseDN(op.Children[3].Data.String()); err != nil {
+			return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid new superior"))
+		}
+		parentKey := dnKey(parent)
+		if parentKey == oldKey || isBelow(parentKey, oldKey) {
+			return ldap.NewError(ldap.LDAPResultUnwillingToPerform, fmt.Errorf("new superior is below the entry"))
+		}
+		if _, ok := s.entries[parentKey]; !ok {
+			return s.noSuchObject(parent)
+		}
+	}
+	target := &ldap.DN{RDNs: append([]*ldap.RelativeDN{newRDN.RDNs[0]}, parent.RDNs...)}
+	newKey := dnKey(target)
+	if _, ok := s.entries[newKey]; ok && newKey != oldKey {
+		return ldap.NewError(ldap.LDAPResultEntryAlreadyExists, fmt.Errorf("already exists"))
+	}
+
+	e := current.clone()
+	e.dn = target
+	if deleteOld {
+		for _, rdn := range current.dn.RDNs[0].Attributes {
+			kept := make([]string, 0)
+			for _, v := range e.values(rdn.Type) {
+				if !s.schema.equal(rdn.Type, v, rdn.Value) {
+					kept = append(kept, v)
+				}
+			}
+			if attr := e.get(rdn.Type); attr != nil {
+				e.set(attr.name, kept)
+			}
+		}
+	}
+	for _, rdn := range newRDN.RDNs[0].Attributes {
+		at, ok := s.schema.AttributeType(rdn.Type)
+		if !ok {
+			return ldap.NewError(ldap.LDAPResultUndefinedAttributeType, fmt.Errorf("%s: attribute type undefined", rdn.Type))
+		}
+		if !s.schema.contains(e.values(at.Name), at.Name, rdn.Value) {
+			e.set(at.Name, append(append([]string{}, e.values(at.Name)...), rdn.Value))
+		}
+	}
+	if err := s.schema.check(e); err != nil {
+		return err
+	}
+
+	// Re-key the entry and everything below it
+	delete(s.entries, oldKey)
+	s.entries[newKey] = e
+	for key, child := range s.entries {
+		if !isBelow(key, oldKey) {
+			continue
+		}
+		depth := len(child.dn.RDNs) - len(current.dn.RDNs)
+		moved := child.clone()
+		moved.dn = &ldap.DN{RDNs: append(append([]*ldap.RelativeDN{}, child.dn.RDNs[:depth]...), target.RDNs...)}
+		delete(s.entries, key)
+		s.entries[dnKey(moved.dn)] = moved
+	}
+	return nil
+}
+
+// compare handles a compare request
+func (s *Server) compare(op *ber.Packet) error {
+	if len(op.Children) != 2 || len(op.Children[1].Children) != 2 {
+		return ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("invalid compare request"))
+	}
+	dn, err := ldap.ParseDN(op.Children[0].Data.String())
+	if err != nil {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid DN"))
+	}
+	attr, value := op.Children[1].Children[0].Data.String(), op.Children[1].Children[1].Data.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[dnKey(dn)]
+	if !ok {
+		return s.noSuchObject(dn)
+	}
+	if _, ok := s.schema.AttributeType(attr); !ok {
+		return ldap.NewError(ldap.LDAPResultUndefinedAttributeType, fmt.Errorf("%s: attribute type undefined", attr))
+	}
+	if s.schema.contains(e.values(attr), attr, value) {
+		return ldap.NewError(ldap.LDAPResultCompareTrue, nil)
+	}
+	return ldap.NewError(ldap.LDAPResultCompareFalse, nil)
+}
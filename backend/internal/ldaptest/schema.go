@@ -0,0 +1,342 @@
+package ldaptest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// Equality matching rules, which decide how values of an attribute compare
+const (
+	MatchCaseIgnore = "caseIgnoreMatch"
+	MatchCaseExact  = "caseExactMatch"
+	MatchInteger    = "integerMatch"
+	MatchDN         = "distinguishedNameMatch"
+	MatchOctet      = "octetStringMatch"
+)
+
+// AttributeType describes one attribute of the schema
+type AttributeType struct {
+	Name     string
+	Equality string
+	Single   bool
+	// Operational attributes are allowed on every entry, as ppolicy's are
+	Operational bool
+}
+
+// ObjectClass describes one object class of the schema
+type ObjectClass struct {
+	Name string
+	Sup  string
+	Must []string
+	May  []string
+	// Auxiliary classes may be added to any entry; the other classes of an
+	// entry must form a single chain of superclasses
+	Auxiliary bool
+}
+
+// Schema holds the attribute types and object classes entries are checked
+// against. Names are matched case-insensitively.
+type Schema struct {
+	attributes map[string]*AttributeType
+	classes    map[string]*ObjectClass
+}
+
+// NewSchema returns an empty schema
+func NewSchema() *Schema {
+	return &Schema{
+		attributes: make(map[string]*AttributeType),
+		classes:    make(map[string]*ObjectClass),
+	}
+}
+
+// AddAttributeType adds or replaces an attribute type
+func (s *Schema) AddAttributeType(at *AttributeType) {
+	if at.Equality == "" {
+		at.Equality = MatchCaseIgnore
+	}
+	s.attributes[strings.ToLower(at.Name)] = at
+}
+
+// AddObjectClass adds or replaces an object class
+func (s *Schema) AddObjectClass(oc *ObjectClass) {
+	s.classes[strings.ToLower(oc.Name)] = oc
+}
+
+// SubschemaDN is the entry the schema is published at
+const SubschemaDN = "cn=Subschema"
+
+// RemoveObjectClass removes an object class, for servers that lack it
+func (s *Schema) RemoveObjectClass(name string) {
+	delete(s.classes, strings.ToLower(name))
+}
+
+// RemoveAttributeType removes an attribute type, for servers that lack it
+func (s *Schema) RemoveAttributeType(name string) {
+	delete(s.attributes, strings.ToLower(name))
+}
+
+// AttributeType returns the attribute type called name
+func (s *Schema) AttributeType(name string) (*AttributeType, bool) {
+	at, ok := s.attributes[strings.ToLower(name)]
+	return at, ok
+}
+
+// ObjectClass returns the object class called name
+func (s *Schema) ObjectClass(name string) (*ObjectClass, bool) {
+	oc, ok := s.classes[strings.ToLower(name)]
+	return oc, ok
+}
+
+// DefaultSchema returns the standard classes used by the service (core,
+// cosine, inetorgperson, nis, RFC 2307bis groupOfMembers) plus the schema
+// the migrations install (githubRepository, devplatformDepartment,
+// devplatformStateEntry, devplatformRemovedReference, passwordHistory) and
+// ppolicy's pwdAccountLockedTime.
+func DefaultSchema() *Schema {
+	s := NewSchema()
+
+	for _, name := range []string{
+		"o", "ou", "cn", "sn", "givenName", "description", "mail", "departmentNumber",
+		"title", "telephoneNumber", "mobile", "l", "st", "street", "postalCode",
+		"businessCategory", "employeeType", "initials", "labeledURI", "gecos",
+	} {
+		s.AddAttributeType(&AttributeType{Name: name})
+	}
+	for _, at := range []*AttributeType{
+		{Name: "objectClass"},
+		{Name: "dc", Single: true},
+		{Name: "uid"},
+		{Name: "displayName", Single: true},
+		{Name: "employeeNumber", Single: true},
+		{Name: "preferredLanguage", Single: true},
+		{Name: "userPassword", Equality: MatchOctet},
+		{Name: "passwordHistory", Equality: MatchOctet},
+		{Name: "githubRepository", Equality: MatchCaseExact},
+		// Generalized times in one format order like strings
+		{Name: "devplatformExpiresAt", Equality: MatchCaseExact, Single: true},
+		{Name: "devplatformStateData", Equality: MatchCaseExact, Single: true},
+		{Name: "devplatformRemovedReference", Equality: MatchDN},
+		{Name: "memberUid", Equality: MatchCaseExact},
+		{Name: "homeDirectory", Equality: MatchCaseExact, Single: true},
+		{Name: "loginShell", Equality: MatchCaseExact, Single: true},
+		{Name: "uidNumber", Equality: MatchInteger, Single: true},
+		{Name: "gidNumber", Equality: MatchInteger, Single: true},
+		{Name: "shadowLastChange", Equality: MatchInteger, Single: true},
+		{Name: "shadowMin", Equality: MatchInteger, Single: true},
+		{Name: "shadowMax", Equality: MatchInteger, Single: true},
+		{Name: "shadowWarning", Equality: MatchInteger, Single: true},
+		{Name: "shadowInactive", Equality: MatchInteger, Single: true},
+		{Name: "shadowExpire", Equality: MatchInteger, Single: true},
+		{Name: "shadowFlag", Equality: MatchInteger, Single: true},
+		{Name: "member", Equality: MatchDN},
+		{Name: "manager", Equality: MatchDN},
+		{Name: "owner", Equality: MatchDN},
+		{Name: "seeAlso", Equality: MatchDN},
+		{Name: "roleOccupant", Equality: MatchDN},
+		{Name: "pwdAccountLockedTime", Equality: MatchCaseExact, Single: true, Operational: true},
+	} {
+		s.AddAttributeType(at)
+	}
+
+	for _, oc := range []*ObjectClass{
+		{Name: "top", Must: []string{"objectClass"}},
+		{Name: "extensibleObject", Sup: "top", Auxiliary: true},
+		{Name: "dcObject", Sup: "top", Auxiliary: true, Must: []string{"dc"}},
+		{Name: "organization", Sup: "top", Must: []string{"o"},
+			May: []string{"description", "telephoneNumber", "l", "st", "street", "postalCode", "businessCategory", "seeAlso"}},
+		{Name: "organizationalUnit", Sup: "top", Must: []string{"ou"},
+			May: []string{"description", "telephoneNumber", "l", "st", "street", "postalCode", "businessCategory", "seeAlso", "userPassword"}},
+		{Name: "organizationalRole", Sup: "top", Must: []string{"cn"},
+			May: []string{"description", "roleOccupant", "telephoneNumber", "ou", "l", "st", "street", "seeAlso"}},
+		{Name: "applicationProcess", Sup: "top", Must: []string{"cn"},
+			May: []string{"description", "l", "ou", "seeAlso"}},
+		{Name: "person", Sup: "top", Must: []string{"sn", "cn"},
+			May: []string{"userPassword", "telephoneNumber", "seeAlso", "description"}},
+		{Name: "organizationalPerson", Sup: "person",
+			May: []string{"title", "ou", "l", "st", "street", "postalCode"}},
+		{Name: "inetOrgPerson", Sup: "organizationalPerson",
+			May: []string{"businessCategory", "departmentNumber", "displayName", "employeeNumber", "employeeType",
+				"givenName", "initials", "labeledURI", "mail", "manager", "mobile", "o", "preferredLanguage", "uid"}},
+		{Name: "posixAccount", Sup: "top", Auxiliary: true, Must: []string{"cn", "uid", "uidNumber", "gidNumber", "homeDirectory"},
+			May: []string{"userPassword", "loginShell", "gecos", "description"}},
+		{Name: "shadowAccount", Sup: "top", Auxiliary: true, Must: []string{"uid"},
+			May: []string{"userPassword", "shadowLastChange", "shadowMin", "shadowMax", "shadowWarning",
+				"shadowInactive", "shadowExpire", "shadowFlag", "description"}},
+		{Name: "posixGroup", Sup: "top", Auxiliary: true, Must: []string{"cn", "gidNumber"},
+			May: []string{"userPassword", "memberUid", "description"}},
+		{Name: "groupOfNames", Sup: "top", Must: []string{"member", "cn"},
+			May: []string{"businessCategory", "seeAlso", "owner", "ou", "o", "description"}},
+		{Name: "groupOfMembers", Sup: "top", Must: []string{"cn"},
+			May: []string{"businessCategory", "seeAlso", "owner", "ou", "o", "description", "member"}},
+		{Name: "devplatformDepartment", Sup: "top", Auxiliary: true, May: []string{"manager", "githubRepository"}},
+		{Name: "devplatformStateEntry", Sup: "top", Must: []string{"cn"},
+			May: []string{"uid", "devplatformExpiresAt", "devplatformStateData"}},
+	} {
+		s.AddObjectClass(oc)
+	}
+	return s
+}
+
+// attributeNames returns the names of all attribute types, sorted
+func (s *Schema) attributeNames() []string {
+	names := make([]string, 0, len(s.attributes))
+	for _, at := range s.attributes {
+		names = append(names, at.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// classNames returns the names of all object classes, sorted
+func (s *Schema) classNames() []string {
+	names := make([]string, 0, len(s.classes))
+	for _, oc := range s.classes {
+		names = append(names, oc.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// superclasses returns class and every class it inherits from
+func (s *Schema) superclasses(class string) []string {
+	var names []string
+	for class != "" && len(names) < 16 {
+		oc, ok := s.ObjectClass(class)
+		if !ok {
+			break
+		}
+		names = append(names, oc.Name)
+		class = oc.Sup
+	}
+	return names
+}
+
+// inChain reports whether class is one of names
+func inChain(names []string, class string) bool {
+	for _, name := range names {
+		if strings.EqualFold(name, class) {
+			return true
+		}
+	}
+	return false
+}
+
+// check validates an entry against the schema, returning the LDAP error a
+// directory server would answer with
+func (s *Schema) check(e *entry) error {
+	classes := e.values("objectClass")
+	if len(classes) == 0 {
+		return ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("no objectClass attribute"))
+	}
+
+	must := map[string]bool{}
+	may := map[string]bool{}
+	extensible := false
+	var structural []string
+	for _, class := range classes {
+		oc, ok := s.ObjectClass(class)
+		if !ok {
+			return ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("objectClass: value #0 invalid per syntax (%s)", class))
+		}
+		if !oc.Auxiliary && !strings.EqualFold(oc.Name, "top") {
+			structural = append(structural, oc.Name)
+		}
+		for _, name := range s.superclasses(class) {
+			oc, _ := s.ObjectClass(name)
+			if strings.EqualFold(oc.Name, "extensibleObject") {
+				extensible = true
+			}
+			for _, attr := range oc.Must {
+				must[strings.ToLower(attr)] = true
+			}
+			for _, attr := range oc.May {
+				may[strings.ToLower(attr)] = true
+			}
+		}
+	}
+
+	// Structural classes must all lie on one chain, as OpenLDAP demands
+	for _, a := range structural {
+		for _, b := range structural {
+			if !inChain(s.superclasses(a), b) && !inChain(s.superclasses(b), a) {
+				return ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("invalid structural object class chain (%s/%s)", a, b))
+			}
+		}
+	}
+
+	for attr := range must {
+		if len(e.values(attr)) == 0 {
+			return ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("object class requires attribute '%s'", attr))
+		}
+	}
+
+	for _, attr := range e.attrs {
+		at, ok := s.AttributeType(attr.name)
+		if !ok {
+			return ldap.NewError(ldap.LDAPResultUndefinedAttributeType, fmt.Errorf("%s: attribute type undefined", attr.name))
+		}
+		name := strings.ToLower(at.Name)
+		if !must[name] && !may[name] && !extensible && !at.Operational {
+			return ldap.NewError(ldap.LDAPResultObjectClassViolation, fmt.Errorf("attribute '%s' not allowed", at.Name))
+		}
+		if at.Single && len(attr.values) > 1 {
+			return ldap.NewError(ldap.LDAPResultConstraintViolation, fmt.Errorf("attribute '%s' cannot have multiple values", at.Name))
+		}
+		if at.Equality == MatchInteger {
+			for i, value := range attr.values {
+				if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+					return ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, fmt.Errorf("%s: value #%d invalid per syntax", at.Name, i))
+				}
+			}
+		}
+		if at.Equality == MatchDN {
+			for i, value := range attr.values {
+				if _, err := ldap.ParseDN(value); err != nil {
+					return ldap.NewError(ldap.LDAPResultInvalidAttributeSyntax, fmt.Errorf("%s: value #%d invalid per syntax", at.Name, i))
+				}
+			}
+		}
+	}
+
+	// The RDN values must be present in the entry itself
+	for _, rdn := range e.dn.RDNs[0].Attributes {
+		if !s.contains(e.values(rdn.Type), rdn.Type, rdn.Value) {
+			return ldap.NewError(ldap.LDAPResultNamingViolation, fmt.Errorf("value of naming attribute '%s' is not present in entry", rdn.Type))
+		}
+	}
+	return nil
+}
+
+// equal compares two values of attr with its equality rule
+func (s *Schema) equal(attr, a, b string) bool {
+	rule := MatchCaseIgnore
+	if at, ok := s.AttributeType(attr); ok {
+		rule = at.Equality
+	}
+	switch rule {
+	case MatchCaseExact, MatchOctet:
+		return a == b
+	case MatchInteger:
+		x, errA := strconv.ParseInt(strings.TrimSpace(a), 10, 64)
+		y, errB := strconv.ParseInt(strings.TrimSpace(b), 10, 64)
+		return errA == nil && errB == nil && x == y
+	case MatchDN:
+		return normalizeDN(a) == normalizeDN(b)
+	default:
+		return strings.EqualFold(strings.Join(strings.Fields(a), " "), strings.Join(strings.Fields(b), " "))
+	}
+}
+
+// contains reports whether values holds value under the equality rule of attr
+func (s *Schema) contains(values []string, attr, value string) bool {
+	for _, v := range values {
+		if s.equal(attr, v, value) {
+			return true
+		}
+	}
+	return false
+}
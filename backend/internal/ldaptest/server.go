@@ -0,0 +1,337 @@
+// Package ldaptest runs an in-process LDAPv3 server for integration tests.
+//
+// The server speaks the real wire protocol on a random local port, so code
+// using go-ldap can be tested without Docker or an OpenLDAP install. It
+// supports simple bind, search with the full RFC 4515 filter language, add,
+// modify, delete and ModifyDN, the paged results (RFC 2696) and server side
+// sort (RFC 2891) controls, and checks entries against a Schema, which it
+// publishes at cn=Subschema. Entries
+// live in memory and are lost on Close.
+package ldaptest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldap "github.com/go-ldap/ldap/v3"
+)
+
+// Config configures a test server
+type Config struct {
+	// BaseDN is the naming context; its entry is created on start
+	BaseDN string
+	// RootDN binds with RootPassword and may write. Defaults to cn=admin,<BaseDN>.
+	RootDN       string
+	RootPassword string
+	// Schema entries are checked against. Defaults to DefaultSchema().
+	Schema *Schema
+}
+
+// Server is a running test server
+type Server struct {
+	config   Config
+	schema   *Schema
+	listener net.Listener
+	rootKey  string
+	baseKey  string
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	seq     int
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a server listening on a random port of 127.0.0.1
+func NewServer(cfg Config) (*Server, error) {
+	base, err := ldap.ParseDN(cfg.BaseDN)
+	if err != nil || len(base.RDNs) == 0 {
+		return nil, fmt.Errorf("invalid base DN %q", cfg.BaseDN)
+	}
+	if cfg.RootDN == "" {
+		cfg.RootDN = "cn=admin," + cfg.BaseDN
+	}
+	if cfg.Schema == nil {
+		cfg.Schema = DefaultSchema()
+	}
+
+	s := &Server{
+		config:  cfg,
+		schema:  cfg.Schema,
+		rootKey: normalizeDN(cfg.RootDN),
+		baseKey: dnKey(base),
+		entries: make(map[string]*entry),
+		conns:   make(map[net.Conn]bool),
+	}
+	if err := s.addEntry(s.baseEntry(base)); err != nil {
+		return nil, fmt.Errorf("failed to create base entry: %w", err)
+	}
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// baseEntry returns the entry of the naming context
+func (s *Server) baseEntry(base *ldap.DN) *entry {
+	rdn := base.RDNs[0].Attributes[0]
+	e := &entry{dn: base}
+	switch strings.ToLower(rdn.Type) {
+	case "dc":
+		e.set("objectClass", []string{"top", "dcObject", "organization"})
+		e.set("dc", []string{rdn.Value})
+		e.set("o", []string{rdn.Value})
+	case "o":
+		e.set("objectClass", []string{"top", "organization"})
+		e.set("o", []string{rdn.Value})
+	default:
+		e.set("objectClass", []string{"top", "organizationalUnit", "extensibleObject"})
+		e.set(rdn.Type, []string{rdn.Value})
+	}
+	return e
+}
+
+// URL returns the ldap:// URL of the server
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Close stops the server and drops all connections
+func (s *Server) Close() error {
+	s.connsMu.Lock()
+	if s.closed {
+		s.connsMu.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.connsMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// Add creates an entry directly, bypassing access control but not the schema
+func (s *Server) Add(dn string, attrs map[string][]string) error {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ldap.NewError(ldap.LDAPResultInvalidDNSyntax, fmt.Errorf("invalid DN %q", dn))
+	}
+
+	// objectClass first, the rest in a stable order
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if strings.EqualFold(names[i], "objectClass") != strings.EqualFold(names[j], "objectClass") {
+			return strings.EqualFold(names[i], "objectClass")
+		}
+		return names[i] < names[j]
+	})
+
+	e := &entry{dn: parsed}
+	for _, name := range names {
+		if err := s.addAttribute(e, name, attrs[name]); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addEntry(e)
+}
+
+// Entry returns the attributes of an entry, or false if it does not exist
+func (s *Server) Entry(dn string) (map[string][]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[normalizeDN(dn)]
+	if !ok {
+		return nil, false
+	}
+	attrs := make(map[string][]string, len(e.attrs))
+	for _, attr := range e.attrs {
+		attrs[attr.name] = append([]string{}, attr.values...)
+	}
+	return attrs, true
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.connsMu.Lock()
+		if s.closed {
+			s.connsMu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.connsMu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+// session is the state of one client connection
+type session struct {
+	conn net.Conn
+	// bound is the normalized DN the client is bound as, "" when anonymous
+	bound string
+	root  bool
+}
+
+// serve answers the requests of one connection in order until it is closed
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.connsMu.Lock()
+		delete(s.conns, conn)
+		s.connsMu.Unlock()
+		conn.Close()
+	}()
+
+	sess := &session{conn: conn}
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		id, ok := packet.Children[0].Value.(int64)
+		if !ok {
+			return
+		}
+		if !s.handle(sess, id, packet) {
+			return
+		}
+	}
+}
+
+// handle answers one request, returning false when the connection must be closed
+func (s *Server) handle(sess *session, id int64, packet *ber.Packet) bool {
+	op := packet.Children[1]
+	controls := decodeControls(packet)
+
+	if op.ClassType != ber.ClassApplication {
+		return false
+	}
+	switch op.Tag {
+	case ldap.ApplicationUnbindRequest:
+		return false
+	case ldap.ApplicationAbandonRequest:
+		// Requests are answered in order, so there is never anything to abandon
+		return true
+	case ldap.ApplicationBindRequest:
+		return s.reply(sess, id, ldap.ApplicationBindResponse, s.bind(sess, op))
+	case ldap.ApplicationSearchRequest:
+		return s.search(sess, id, op, controls)
+	case ldap.ApplicationAddRequest:
+		return s.reply(sess, id, ldap.ApplicationAddResponse, s.writeOp(sess, controls, func() error { return s.add(op) }))
+	case ldap.ApplicationModifyRequest:
+		return s.reply(sess, id, ldap.ApplicationModifyResponse, s.writeOp(sess, controls, func() error { return s.modify(op) }))
+	case ldap.ApplicationDelRequest:
+		return s.reply(sess, id, ldap.ApplicationDelResponse, s.writeOp(sess, controls, func() error { return s.del(op) }))
+	case ldap.ApplicationModifyDNRequest:
+		return s.reply(sess, id, ldap.ApplicationModifyDNResponse, s.writeOp(sess, controls, func() error { return s.modifyDN(op) }))
+	case ldap.ApplicationCompareRequest:
+		return s.reply(sess, id, ldap.ApplicationCompareResponse, s.compare(op))
+	case ldap.ApplicationExtendedRequest:
+		return s.reply(sess, id, ldap.ApplicationExtendedResponse, ldap.NewError(ldap.LDAPResultProtocolError, fmt.Errorf("unsupported extended operation")))
+	}
+	return false
+}
+
+// writeOp runs a write for root only
+func (s *Server) writeOp(sess *session, controls []*control, op func() error) error {
+	if err := checkCritical(controls); err != nil {
+		return err
+	}
+	if !sess.root {
+		return ldap.NewError(ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("no write access to parent"))
+	}
+	return op()
+}
+
+// reply sends the result of an operation
+func (s *Server) reply(sess *session, id int64, tag ber.Tag, err error) bool {
+	return s.send(sess, envelope(id, result(tag, err)))
+}
+
+func (s *Server) send(sess *session, packet *ber.Packet) bool {
+	_, err := sess.conn.Write(packet.Bytes())
+	return err == nil
+}
+
+func envelope(id int64, op *ber.Packet, controls ...*ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	packet.AppendChild(op)
+	if len(controls) > 0 {
+		wrapper := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, c := range controls {
+			wrapper.AppendChild(c)
+		}
+		packet.AppendChild(wrapper)
+	}
+	return packet
+}
+
+// result encodes an LDAPResult, taking the code, matched DN and message from err
+func result(tag ber.Tag, err error) *ber.Packet {
+	code, matched, message := uint16(ldap.LDAPResultSuccess), "", ""
+	if err != nil {
+		var ldapErr *ldap.Error
+		if errors.As(err, &ldapErr) {
+			code, matched = ldapErr.ResultCode, ldapErr.MatchedDN
+			if ldapErr.Err != nil {
+				message = ldapErr.Err.Error()
+			}
+		} else {
+			code, message = ldap.LDAPResultOther, err.Error()
+		}
+	}
+
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, matched, "Matched DN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	return packet
+}
+
+// noSuchObject returns the error for a missing entry, naming the closest
+// existing ancestor as matched DN. Must be called with s.mu held.
+func (s *Server) noSuchObject(dn *ldap.DN) error {
+	matched := ""
+	for parent := parentDN(dn); len(parent.RDNs) > 0; parent = parentDN(parent) {
+		if e, ok := s.entries[dnKey(parent)]; ok {
+			matched = e.dn.String()
+			break
+		}
+	}
+	return &ldap.Error{ResultCode: ldap.LDAPResultNoSuchObject, MatchedDN: matched, Err: fmt.Errorf("no such object")}
+}
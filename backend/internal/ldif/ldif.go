@@ -0,0 +1,420 @@
+// Package ldif reads and writes the LDAP Data Interchange Format of RFC 2849.
+//
+// Content records are read as adds, so exports can be imported as they are.
+// Change records may add, modify or delete entries; modrdn, controls and
+// values given by URL are rejected.
+package ldif
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Change types of records
+const (
+	ChangeAdd    = "add"
+	ChangeDelete = "delete"
+	ChangeModify = "modify"
+)
+
+// Operations of a modify record
+const (
+	ModAdd     = "add"
+	ModDelete  = "delete"
+	ModReplace = "replace"
+)
+
+// maxLineLength is where Writer folds lines, as RFC 2849 recommends
+const maxLineLength = 76
+
+// Attribute is an attribute with its values, in the order they were given
+type Attribute struct {
+	Name   string
+	Values []string
+}
+
+// Modification is one change of a modify record. A delete without values
+// removes the attribute, a replace without values clears it.
+type Modification struct {
+	Op        string
+	Attribute Attribute
+}
+
+// Record is one entry or change record
+type Record struct {
+	// Line is the line number the record starts on
+	Line       int
+	DN         string
+	ChangeType string
+	// Attributes of an add
+	Attributes []Attribute
+	// Modifications of a modify
+	Modifications []Modification
+}
+
+// ParseError is an invalid record. Reader skips to the next record after it,
+// so reading can go on.
+type ParseError struct {
+	Line int
+	// DN of the record, if it got that far
+	DN  string
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// line is one unfolded line and the line number it started on
+type line struct {
+	number int
+	text   string
+}
+
+// Reader reads records from an LDIF stream
+type Reader struct {
+	scanner *bufio.Scanner
+	number  int
+	// pending is a line read ahead while unfolding
+	pending *line
+	started bool
+}
+
+// NewReader returns a Reader reading from r
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &Reader{scanner: scanner}
+}
+
+// Next returns the next record, or io.EOF after the last one. A *ParseError
+// concerns only its record; other errors end the stream.
+func (r *Reader) Next() (*Record, error) {
+	lines, err := r.readRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	// An optional version line precedes the first record
+	if !r.started {
+		r.started = true
+		if name, value, _, ok := splitLine(lines[0].text); ok && strings.EqualFold(name, "version") {
+			if value != "1" {
+				return nil, &ParseError{Line: lines[0].number, Msg: fmt.Sprintf("unsupported LDIF version %q", value)}
+			}
+			lines = lines[1:]
+			if len(lines) == 0 {
+				return r.Next()
+			}
+		}
+	}
+	return parseRecord(lines)
+}
+
+// readRecord returns the unfolded lines up to the next blank line, without comments
+func (r *Reader) readRecord() ([]line, error) {
+	var lines []line
+	for {
+		l, err := r.readLine()
+		if err == io.EOF && len(lines) > 0 {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l.text == "" {
+			if len(lines) > 0 {
+				return lines, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(l.text, "#") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+}
+
+// readLine returns the next logical line, joining continuation lines
+func (r *Reader) readLine() (line, error) {
+	var l line
+	if r.pending != nil {
+		l, r.pending = *r.pending, nil
+	} else {
+		next, err := r.physicalLine()
+		if err != nil {
+			return line{}, err
+		}
+		l = next
+	}
+	if l.text == "" {
+		return l, nil
+	}
+
+	for {
+		next, err := r.physicalLine()
+		if err == io.EOF {
+			return l, nil
+		}
+		if err != nil {
+			return line{}, err
+		}
+		if !strings.HasPrefix(next.text, " ") {
+			r.pending = &next
+			return l, nil
+		}
+		l.text += next.text[1:]
+	}
+}
+
+func (r *Reader) physicalLine() (line, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return line{}, err
+		}
+		return line{}, io.EOF
+	}
+	r.number++
+	return line{number: r.number, text: strings.TrimSuffix(r.scanner.Text(), "\r")}, nil
+}
+
+// splitLine splits "name: value", "name:: base64" and "name:< url" lines.
+// The returned kind is ":", "::" or ":<".
+func splitLine(text string) (name, value, kind string, ok bool) {
+	i := strings.IndexByte(text, ':')
+	if i <= 0 {
+		return "", "", "", false
+	}
+	name, rest := text[:i], text[i+1:]
+	kind = ":"
+	switch {
+	case strings.HasPrefix(rest, ":"):
+		kind, rest = "::", rest[1:]
+	case strings.HasPrefix(rest, "<"):
+		kind, rest = ":<", rest[1:]
+	}
+	return name, strings.TrimLeft(rest, " "), kind, true
+}
+
+// decodeLine returns the attribute name and decoded value of a line
+func decodeLine(l line) (string, string, error) {
+	name, value, kind, ok := splitLine(l.text)
+	if !ok {
+		return "", "", fmt.Errorf("expected \"attribute: value\", got %q", l.text)
+	}
+	switch kind {
+	case "::":
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 value of %s", name)
+		}
+		return name, string(decoded), nil
+	case ":<":
+		return "", "", fmt.Errorf("URL values are not supported (%s)", name)
+	}
+	return name, value, nil
+}
+
+func parseRecord(lines []line) (*Record, error) {
+	record := &Record{Line: lines[0].number}
+	fail := func(l line, format string, args ...interface{}) (*Record, error) {
+		return nil, &ParseError{Line: l.number, DN: record.DN, Msg: fmt.Sprintf(format, args...)}
+	}
+
+	name, dn, err := decodeLine(lines[0])
+	if err != nil {
+		return fail(lines[0], "%v", err)
+	}
+	if !strings.EqualFold(name, "dn") {
+		return fail(lines[0], "record must start with dn, got %s", name)
+	}
+	record.DN = dn
+	lines = lines[1:]
+
+	record.ChangeType = ChangeAdd
+	if len(lines) > 0 {
+		name, value, err := decodeLine(lines[0])
+		if err != nil {
+			return fail(lines[0], "%v", err)
+		}
+		switch {
+		case strings.EqualFold(name, "control"):
+			return fail(lines[0], "controls are not supported")
+		case strings.EqualFold(name, "changetype"):
+			record.ChangeType = strings.ToLower(value)
+			lines = lines[1:]
+		}
+	}
+
+	switch record.ChangeType {
+	case ChangeAdd:
+		if len(lines) == 0 {
+			return fail(recordStart(record), "add of %s has no attributes", record.DN)
+		}
+		for _, l := range lines {
+			name, value, err := decodeLine(l)
+			if err != nil {
+				return fail(l, "%v", err)
+			}
+			record.Attributes = appendValue(record.Attributes, name, value)
+		}
+	case ChangeDelete:
+		if len(lines) > 0 {
+			return fail(lines[0], "delete takes no attributes")
+		}
+	case ChangeModify:
+		mods, err := parseModifications(lines)
+		if err != nil {
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				parseErr.DN = record.DN
+				if parseErr.Line == 0 {
+					parseErr.Line = record.Line
+				}
+			}
+			return nil, err
+		}
+		record.Modifications = mods
+	default:
+		return fail(recordStart(record), "changetype %s is not supported", record.ChangeType)
+	}
+	return record, nil
+}
+
+// recordStart is the position of a record for errors about the record as a whole
+func recordStart(record *Record) line {
+	return line{number: record.Line}
+}
+
+// parseModifications reads the mod-specs of a modify record, each ended by "-"
+func parseModifications(lines []line) ([]Modification, error) {
+	var mods []Modification
+	for len(lines) > 0 {
+		op, attr, err := decodeLine(lines[0])
+		if err != nil {
+			return nil, &ParseError{Line: lines[0].number, Msg: err.Error()}
+		}
+		op = strings.ToLower(op)
+		if op != ModAdd && op != ModDelete && op != ModReplace {
+			return nil, &ParseError{Line: lines[0].number, Msg: fmt.Sprintf("expected add, delete or replace, got %s", op)}
+		}
+
+		mod := Modification{Op: op, Attribute: Attribute{Name: attr}}
+		start := lines[0]
+		lines = lines[1:]
+		ended := false
+		for len(lines) > 0 {
+			l := lines[0]
+			lines = lines[1:]
+			if l.text == "-" {
+				ended = true
+				break
+			}
+			name, value, err := decodeLine(l)
+			if err != nil {
+				return nil, &ParseError{Line: l.number, Msg: err.Error()}
+			}
+			if !strings.EqualFold(name, attr) {
+				return nil, &ParseError{Line: l.number, Msg: fmt.Sprintf("value of %s in the %s of %s", name, op, attr)}
+			}
+			mod.Attribute.Values = append(mod.Attribute.Values, value)
+		}
+		if !ended {
+			return nil, &ParseError{Line: start.number, Msg: fmt.Sprintf("%s of %s is not ended by \"-\"", op, attr)}
+		}
+		if op == ModAdd && len(mod.Attribute.Values) == 0 {
+			return nil, &ParseError{Line: start.number, Msg: fmt.Sprintf("add of %s has no values", attr)}
+		}
+		mods = append(mods, mod)
+	}
+	if len(mods) == 0 {
+		return nil, &ParseError{Msg: "modify has no changes"}
+	}
+	return mods, nil
+}
+
+func appendValue(attrs []Attribute, name, value string) []Attribute {
+	for i := range attrs {
+		if strings.EqualFold(attrs[i].Name, name) {
+			attrs[i].Values = append(attrs[i].Values, value)
+			return attrs
+		}
+	}
+	return append(attrs, Attribute{Name: name, Values: []string{value}})
+}
+
+// Writer writes entries as LDIF content records
+type Writer struct {
+	w       *bufio.Writer
+	started bool
+}
+
+// NewWriter returns a Writer writing to w. Call Flush when done.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteEntry writes one entry
+func (w *Writer) WriteEntry(dn string, attrs []Attribute) error {
+	if !w.started {
+		w.started = true
+		w.w.WriteString("version: 1\n")
+	}
+	w.w.WriteString("\n")
+	w.writeLine("dn", dn)
+	for _, attr := range attrs {
+		for _, value := range attr.Values {
+			w.writeLine(attr.Name, value)
+		}
+	}
+	// Hand full buffers on, so large exports stream
+	if w.w.Buffered() >= 32*1024 {
+		return w.w.Flush()
+	}
+	return nil
+}
+
+// Flush writes any buffered data
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeLine(name, value string) {
+	text := name + ": " + value
+	if !isSafe(value) {
+		text = name + ":: " + base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	for len(text) > maxLineLength {
+		w.w.WriteString(text[:maxLineLength])
+		w.w.WriteString("\n ")
+		text = text[maxLineLength:]
+	}
+	w.w.WriteString(text)
+	w.w.WriteString("\n")
+}
+
+// isSafe reports whether value is a SAFE-STRING of RFC 2849. Values ending
+// in a space are base64 encoded too, so they survive editors.
+func isSafe(value string) bool {
+	if value == "" {
+		return true
+	}
+	switch value[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	if value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == 0 || c == '\n' || c == '\r' || c > 127 {
+			return false
+		}
+	}
+	return true
+}
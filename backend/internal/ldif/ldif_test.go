@@ -0,0 +1,209 @@
+package ldif_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/ldif"
+)
+
+// readAll returns the records of input and the errors of the invalid ones
+func readAll(t *testing.T, input string) ([]*ldif.Record, []*ldif.ParseError) {
+	t.Helper()
+
+	var records []*ldif.Record
+	var parseErrs []*ldif.ParseError
+	r := ldif.NewReader(strings.NewReader(input))
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records, parseErrs
+		}
+		var parseErr *ldif.ParseError
+		if errors.As(err, &parseErr) {
+			parseErrs = append(parseErrs, parseErr)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []*ldif.Record
+	}{
+		{"empty", "", nil},
+		{"version only", "version: 1\n", nil},
+		{
+			"content record",
+			"version: 1\n\ndn: uid=alice,ou=people,dc=example\nobjectClass: top\nobjectClass: inetOrgPerson\ncn: Alice\n",
+			[]*ldif.Record{{Line: 3, DN: "uid=alice,ou=people,dc=example", ChangeType: ldif.ChangeAdd, Attributes: []ldif.Attribute{
+				{Name: "objectClass", Values: []string{"top", "inetOrgPerson"}},
+				{Name: "cn", Values: []string{"Alice"}},
+			}}},
+		},
+		{
+			"folded lines",
+			"dn: uid=alice,ou=peo\n ple,dc=example\ndescription: a long\n  value\n",
+			[]*ldif.Record{{Line: 1, DN: "uid=alice,ou=people,dc=example", ChangeType: ldif.ChangeAdd, Attributes: []ldif.Attribute{
+				{Name: "description", Values: []string{"a long value"}},
+			}}},
+		},
+		{
+			"base64 values",
+			"dn:: dWlkPWrDtnJnLGRjPWV4YW1wbGU=\ncn:: IErDtnJnIA==\n",
+			[]*ldif.Record{{Line: 1, DN: "uid=jörg,dc=example", ChangeType: ldif.ChangeAdd, Attributes: []ldif.Attribute{
+				{Name: "cn", Values: []string{" Jörg "}},
+			}}},
+		},
+		{
+			"comments and CRLF",
+			"# export of\n  the directory\r\ndn: dc=example\r\n# inside a record\r\ndc: example\r\n\r\n\r\n",
+			[]*ldif.Record{{Line: 3, DN: "dc=example", ChangeType: ldif.ChangeAdd, Attributes: []ldif.Attribute{
+				{Name: "dc", Values: []string{"example"}},
+			}}},
+		},
+		{
+			"change records",
+			"dn: uid=bob,dc=example\nchangetype: delete\n\n" +
+				"dn: uid=carol,dc=example\nchangetype: Modify\nreplace: mail\nmail: carol@example\nmail: c@example\n-\ndelete: description\n-\nadd: cn\ncn: Carol\n-\n",
+			[]*ldif.Record{
+				{Line: 1, DN: "uid=bob,dc=example", ChangeType: ldif.ChangeDelete},
+				{Line: 4, DN: "uid=carol,dc=example", ChangeType: ldif.ChangeModify, Modifications: []ldif.Modification{
+					{Op: ldif.ModReplace, Attribute: ldif.Attribute{Name: "mail", Values: []string{"carol@example", "c@example"}}},
+					{Op: ldif.ModDelete, Attribute: ldif.Attribute{Name: "description"}},
+					{Op: ldif.ModAdd, Attribute: ldif.Attribute{Name: "cn", Values: []string{"Carol"}}},
+				}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, parseErrs := readAll(t, tt.input)
+			if len(parseErrs) > 0 {
+				t.Fatalf("parse errors: %v", parseErrs)
+			}
+			if !reflect.DeepEqual(records, tt.want) {
+				t.Fatalf("records:\n%s\nwant:\n%s", dump(records), dump(tt.want))
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+		dn    string
+		msg   string
+	}{
+		{"version", "version: 2\n\ndn: dc=example\ndc: example\n", 1, "", "unsupported LDIF version"},
+		{"no dn", "# first\ncn: Alice\n", 2, "", "must start with dn"},
+		{"not a line", "dn: dc=example\njust text\n", 2, "dc=example", "expected \"attribute: value\""},
+		{"bad base64", "dn: dc=example\ndescription:: !!!\n", 2, "dc=example", "invalid base64"},
+		{"URL value", "dn: dc=example\njpegPhoto:< file:///etc/passwd\n", 2, "dc=example", "URL values"},
+		{"control", "dn: dc=example\ncontrol: 1.2.840.113556.1.4.805\nchangetype: delete\n", 2, "dc=example", "controls"},
+		{"add without attributes", "\n\ndn: dc=example\n", 3, "dc=example", "has no attributes"},
+		{"modrdn", "dn: dc=example\nchangetype: modrdn\nnewrdn: dc=other\n", 1, "dc=example", "modrdn is not supported"},
+		{"delete with attributes", "dn: dc=example\nchangetype: delete\ndc: example\n", 3, "dc=example", "takes no attributes"},
+		{"modify without changes", "dn: dc=example\nchangetype: modify\n", 1, "dc=example", "has no changes"},
+		{"unknown operation", "dn: dc=example\nchangetype: modify\nincrement: uidNumber\n-\n", 3, "dc=example", "expected add, delete or replace"},
+		{"mod-spec without -", "dn: dc=example\nchangetype: modify\nreplace: mail\nmail: a@example\n", 3, "dc=example", "not ended by \"-\""},
+		{"value of another attribute", "dn: dc=example\nchangetype: modify\nreplace: mail\ncn: x\n-\n", 4, "dc=example", "value of cn in the replace of mail"},
+		{"add without values", "dn: dc=example\nchangetype: modify\nadd: mail\n-\n", 3, "dc=example", "add of mail has no values"},
+		{"line after folding", "dn: dc=exam\n ple\ndesc\n ription\n", 3, "dc=example", "expected \"attribute: value\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, parseErrs := readAll(t, tt.input)
+			if len(parseErrs) != 1 {
+				t.Fatalf("parse errors = %v, want one", parseErrs)
+			}
+			e := parseErrs[0]
+			if e.Line != tt.line || e.DN != tt.dn || !strings.Contains(e.Msg, tt.msg) {
+				t.Fatalf("error = %+v, want line %d, dn %q and %q", e, tt.line, tt.dn, tt.msg)
+			}
+		})
+	}
+}
+
+func TestReaderRecovers(t *testing.T) {
+	input := "dn: uid=alice,dc=example\ncn: Alice\n\n" +
+		"dn: uid=bob,dc=example\nchangetype: modify\nreplace: mail\n\n" +
+		"dn: uid=carol,dc=example\ncn: Carol\n"
+	records, parseErrs := readAll(t, input)
+	if len(parseErrs) != 1 || parseErrs[0].Line != 6 || parseErrs[0].Error() != `line 6: replace of mail is not ended by "-"` {
+		t.Fatalf("parse errors = %v, want the modify of bob", parseErrs)
+	}
+	if len(records) != 2 || records[0].DN != "uid=alice,dc=example" || records[1].DN != "uid=carol,dc=example" || records[1].Line != 8 {
+		t.Fatalf("records:\n%s\nwant alice and carol", dump(records))
+	}
+}
+
+func TestWriter(t *testing.T) {
+	long := strings.Repeat("0123456789", 20)
+	entries := []ldif.Record{
+		{DN: "uid=alice,dc=example", Attributes: []ldif.Attribute{
+			{Name: "objectClass", Values: []string{"top", "inetOrgPerson"}},
+			{Name: "description", Values: []string{long}},
+			{Name: "cn", Values: []string{"Jörg"}},
+			{Name: "sn", Values: []string{" padded ", ":colon", "<angle", "line\nbreak", ""}},
+		}},
+		{DN: "uid=bob,dc=example", Attributes: []ldif.Attribute{{Name: "cn", Values: []string{"Bob"}}}},
+	}
+
+	var buf bytes.Buffer
+	w := ldif.NewWriter(&buf)
+	for _, entry := range entries {
+		if err := w.WriteEntry(entry.DN, entry.Attributes); err != nil {
+			t.Fatalf("WriteEntry: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "version: 1\n\ndn: uid=alice,dc=example\n") {
+		t.Fatalf("output starts with %q, want the version line and alice", out[:40])
+	}
+	for _, l := range strings.Split(out, "\n") {
+		if len(l) > 77 {
+			t.Fatalf("line %q is not folded", l)
+		}
+	}
+	for _, want := range []string{"cn:: SsO2cmc=\n", "sn:: IHBhZGRlZCA=\n", "sn: \n", "\ndescription: 0123"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	records, parseErrs := readAll(t, out)
+	if len(parseErrs) > 0 || len(records) != len(entries) {
+		t.Fatalf("read back %d records, errors %v", len(records), parseErrs)
+	}
+	for i, record := range records {
+		if record.DN != entries[i].DN || !reflect.DeepEqual(record.Attributes, entries[i].Attributes) {
+			t.Fatalf("read back:\n%s\nwant:\n%s", dump(records[i:i+1]), dump([]*ldif.Record{&entries[i]}))
+		}
+	}
+}
+
+func dump(records []*ldif.Record) string {
+	var b strings.Builder
+	for _, r := range records {
+		fmt.Fprintf(&b, "%+v\n", *r)
+	}
+	return b.String()
+}
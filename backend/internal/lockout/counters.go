@@ -0,0 +1,84 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Counter counts the recent failures of one uid or client IP
+type Counter struct {
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"lastFailure"`
+	BlockedUntil time.Time `json:"blockedUntil"`
+	// ExpiresAt is when the counter is forgotten
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// CounterStore keeps failure counters. With more than one replica it must be
+// shared, or every replica allows the full number of attempts.
+type CounterStore interface {
+	// Get returns the live counter of key, or nil
+	Get(ctx context.Context, key string) (*Counter, error)
+	// Update applies fn to the live counter of key, or to a zero counter, and
+	// stores the result. fn may run again if the counter changed concurrently.
+	Update(ctx context.Context, key string, fn func(*Counter)) (*Counter, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryCounterStore keeps failure counters in process memory
+type MemoryCounterStore struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewMemoryCounterStore creates an empty counter store
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{counters: make(map[string]*Counter)}
+}
+
+// Get returns a copy of the live counter of key, or nil
+func (s *MemoryCounterStore) Get(ctx context.Context, key string) (*Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || !time.Now().Before(c.ExpiresAt) {
+		return nil, nil
+	}
+	copied := *c
+	return &copied, nil
+}
+
+// Update applies fn to the counter of key, dropping expired counters so the
+// map stays bounded
+func (s *MemoryCounterStore) Update(ctx context.Context, key string, fn func(*Counter)) (*Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, c := range s.counters {
+		if !now.Before(c.ExpiresAt) {
+			delete(s.counters, k)
+		}
+	}
+
+	c := &Counter{}
+	if existing, ok := s.counters[key]; ok {
+		copied := *existing
+		c = &copied
+	}
+	fn(c)
+	s.counters[key] = c
+	copied := *c
+	return &copied, nil
+}
+
+// Delete forgets the counter of key
+func (s *MemoryCounterStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
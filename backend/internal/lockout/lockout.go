@@ -0,0 +1,288 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	loginFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ldap_manager_login_failures_total",
+			Help: "Total number of rejected login attempts",
+		},
+		[]string{"reason"},
+	)
+
+	accountLockouts = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "ldap_manager_account_lockouts_total",
+			Help: "Total number of accounts locked after repeated login failures",
+		},
+	)
+)
+
+// Failure reasons reported in ldap_manager_login_failures_total
+const (
+	ReasonInvalidCredentials = "invalid_credentials"
+	ReasonLocked             = "locked"
+	ReasonThrottled          = "throttled"
+)
+
+// Error is returned when a login attempt is refused before checking the password
+type Error struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Extensions exposes the error code and retry delay in the GraphQL error response
+func (e *Error) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"code": e.Code}
+	if e.RetryAfter > 0 {
+		ext["retryAfter"] = int(math.Ceil(e.RetryAfter.Seconds()))
+	}
+	return ext
+}
+
+// Guard throttles login attempts per uid and per client IP with exponential
+// backoff, and locks accounts after too many consecutive failures.
+type Guard struct {
+	config   *config.Config
+	locks    Store
+	counters CounterStore
+	logger   *logrus.Logger
+}
+
+// NewGuard creates a guard persisting locks in store and failure counters in counters
+func NewGuard(cfg *config.Config, store Store, counters CounterStore, logger *logrus.Logger) *Guard {
+	return &Guard{
+		config:   cfg,
+		locks:    store,
+		counters: counters,
+		logger:   logger,
+	}
+}
+
+// Check refuses an attempt for a locked account or while a backoff is pending.
+// It is called before the password is verified so refused attempts never reach LDAP.
+func (g *Guard) Check(ctx context.Context, uid, ip string) error {
+	lock, err := g.activeLock(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if lock != nil {
+		loginFailures.WithLabelValues(ReasonLocked).Inc()
+		e := &Error{Code: "ACCOUNT_LOCKED", Message: "account is locked"}
+		if lock.ExpiresAt != nil {
+			e.RetryAfter = time.Until(*lock.ExpiresAt)
+		}
+		return e
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys(uid, ip) {
+		c, err := g.counters.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to read login failures: %w", err)
+		}
+		if c != nil && c.BlockedUntil.After(now) {
+			if d := c.BlockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		loginFailures.WithLabelValues(ReasonThrottled).Inc()
+		return &Error{
+			Code:       "TOO_MANY_ATTEMPTS",
+			Message:    "too many failed login attempts, try again later",
+			RetryAfter: wait,
+		}
+	}
+	return nil
+}
+
+// Failure records a failed attempt and locks the account once the limit is reached
+func (g *Guard) Failure(ctx context.Context, uid, ip string) {
+	loginFailures.WithLabelValues(ReasonInvalidCredentials).Inc()
+
+	now := time.Now()
+	uidFailures := g.record(ctx, uidKey(uid), now, g.config.LoginBackoffAfter)
+	if ip != "" {
+		g.record(ctx, ipKey(ip), now, g.config.LoginIPBackoffAfter)
+	}
+	if g.config.LoginMaxFailures <= 0 || uidFailures < g.config.LoginMaxFailures {
+		return
+	}
+	g.forget(ctx, uid)
+
+	lock := &models.AccountLock{UID: uid, LockedAt: now}
+	if err := g.locks.Lock(ctx, lock); err != nil {
+		g.logger.WithError(err).WithField("uid", uid).Error("Failed to lock account")
+		return
+	}
+	accountLockouts.Inc()
+	g.logger.WithFields(logrus.Fields{
+		"uid":      uid,
+		"ip":       ip,
+		"failures": uidFailures,
+	}).Warn("Account locked after repeated login failures")
+}
+
+// Throttle counts an anonymous request of kind, such as a registration, from
+// ip and refuses it while the client's backoff is pending. The backoff
+// starts once a client made after requests within LoginFailureWindow.
+func (g *Guard) Throttle(ctx context.Context, kind, ip string, after int) error {
+	if ip == "" || after <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	key := kind + ":" + ipKey(ip)
+	c, err := g.counters.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read request counter: %w", err)
+	}
+	if c != nil && c.BlockedUntil.After(now) {
+		return &Error{
+			Code:       "TOO_MANY_ATTEMPTS",
+			Message:    "too many requests, try again later",
+			RetryAfter: c.BlockedUntil.Sub(now),
+		}
+	}
+	g.record(ctx, key, now, after)
+	return nil
+}
+
+// Success clears the failure counter of uid. The IP counter is left to
+// decay so that one valid account cannot reset it.
+func (g *Guard) Success(ctx context.Context, uid string) {
+	g.forget(ctx, uid)
+}
+
+// forget drops the failure counter of uid
+func (g *Guard) forget(ctx context.Context, uid string) {
+	if err := g.counters.Delete(ctx, uidKey(uid)); err != nil {
+		g.logger.WithError(err).WithField("uid", uid).Warn("Failed to clear login failures")
+	}
+}
+
+// Unlock removes an account lock and its failure counter
+func (g *Guard) Unlock(ctx context.Context, uid string) error {
+	if err := g.locks.Unlock(ctx, uid); err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+	g.forget(ctx, uid)
+	return nil
+}
+
+// Locked returns the currently locked accounts
+func (g *Guard) Locked(ctx context.Context) ([]*models.AccountLock, error) {
+	locks, err := g.locks.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list account locks: %w", err)
+	}
+
+	active := make([]*models.AccountLock, 0, len(locks))
+	for _, lock := range locks {
+		if g.withExpiry(lock) {
+			active = append(active, lock)
+		}
+	}
+	return active, nil
+}
+
+// activeLock returns the lock of uid, releasing it if it has expired
+func (g *Guard) activeLock(ctx context.Context, uid string) (*models.AccountLock, error) {
+	lock, err := g.locks.Get(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account lock: %w", err)
+	}
+	if lock == nil {
+		return nil, nil
+	}
+	if !g.withExpiry(lock) {
+		if err := g.locks.Unlock(ctx, uid); err != nil {
+			g.logger.WithError(err).WithField("uid", uid).Warn("Failed to release expired account lock")
+		}
+		return nil, nil
+	}
+	return lock, nil
+}
+
+// withExpiry fills in the lock expiry from the configured duration and
+// reports whether the lock is still in effect
+func (g *Guard) withExpiry(lock *models.AccountLock) bool {
+	if lock.Permanent || g.config.LoginLockoutDuration <= 0 {
+		lock.ExpiresAt = nil
+		return true
+	}
+	expires := lock.LockedAt.Add(g.config.LoginLockoutDuration)
+	lock.ExpiresAt = &expires
+	return time.Now().Before(expires)
+}
+
+// record counts a failure for key and returns the failure count, or 0 if
+// the counter could not be stored
+func (g *Guard) record(ctx context.Context, key string, now time.Time, backoffAfter int) int {
+	c, err := g.counters.Update(ctx, key, func(c *Counter) {
+		c.Failures++
+		c.LastFailure = now
+		c.ExpiresAt = now.Add(g.config.LoginFailureWindow)
+		if backoffAfter > 0 && c.Failures >= backoffAfter {
+			c.BlockedUntil = now.Add(g.backoff(c.Failures - backoffAfter))
+			if c.BlockedUntil.After(c.ExpiresAt) {
+				c.ExpiresAt = c.BlockedUntil
+			}
+		}
+	})
+	if err != nil {
+		g.logger.WithError(err).WithField("key", key).Error("Failed to record login failure")
+		return 0
+	}
+	return c.Failures
+}
+
+// backoff returns base * 2^n, capped at the configured maximum
+func (g *Guard) backoff(n int) time.Duration {
+	delay := g.config.LoginBackoffBase
+	for i := 0; i < n && delay < g.config.LoginBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > g.config.LoginBackoffMax {
+		delay = g.config.LoginBackoffMax
+	}
+	return delay
+}
+
+// keys returns the counter keys for an attempt; the IP is unknown outside HTTP requests
+func keys(uid, ip string) []string {
+	if ip == "" {
+		return []string{uidKey(uid)}
+	}
+	return []string{uidKey(uid), ipKey(ip)}
+}
+
+// uidKey lowercases uid, as uid matching in the directory ignores case
+func uidKey(uid string) string {
+	return "uid:" + strings.ToLower(uid)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,147 @@
+package lockout_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	"github.com/sirupsen/logrus"
+)
+
+var ctx = context.Background()
+
+func newGuard(cfg *config.Config) *lockout.Guard {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return lockout.NewGuard(cfg, lockout.NewMemoryStore(), lockout.NewMemoryCounterStore(), logger)
+}
+
+// refused returns err as a refused attempt, failing the test if it is not one
+func refused(t *testing.T, err error) *lockout.Error {
+	t.Helper()
+	var e *lockout.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("error = %v, want a refused attempt", err)
+	}
+	return e
+}
+
+func TestBackoff(t *testing.T) {
+	g := newGuard(&config.Config{
+		LoginFailureWindow:  time.Minute,
+		LoginBackoffAfter:   2,
+		LoginIPBackoffAfter: 3,
+		LoginBackoffBase:    time.Second,
+		LoginBackoffMax:     3 * time.Second,
+	})
+
+	g.Failure(ctx, "alice", "10.0.0.1")
+	if err := g.Check(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Fatalf("Check after one failure: %v", err)
+	}
+	// uids are counted regardless of case
+	g.Failure(ctx, "Alice", "10.0.0.1")
+	e := refused(t, g.Check(ctx, "alice", "10.0.0.2"))
+	if e.Code != "TOO_MANY_ATTEMPTS" || e.RetryAfter <= 0 || e.RetryAfter > time.Second {
+		t.Fatalf("error = %+v, want a backoff of up to the base delay", e)
+	}
+	if got := e.Extensions()["retryAfter"]; got != 1 {
+		t.Fatalf("retryAfter = %v, want 1", got)
+	}
+
+	// The delay doubles up to the maximum
+	g.Failure(ctx, "alice", "10.0.0.1")
+	g.Failure(ctx, "alice", "10.0.0.1")
+	if e := refused(t, g.Check(ctx, "alice", "")); e.RetryAfter <= 2*time.Second || e.RetryAfter > 3*time.Second {
+		t.Fatalf("RetryAfter = %v, want the maximum of 3s", e.RetryAfter)
+	}
+
+	// A success clears the uid counter but not the one of the client
+	g.Success(ctx, "alice")
+	if err := g.Check(ctx, "alice", "10.0.0.2"); err != nil {
+		t.Fatalf("Check after success: %v", err)
+	}
+	refused(t, g.Check(ctx, "bob", "10.0.0.1"))
+}
+
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name      string
+		duration  time.Duration
+		permanent bool
+	}{
+		{"temporary", time.Hour, false},
+		{"until unlocked", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newGuard(&config.Config{
+				LoginMaxFailures:     3,
+				LoginLockoutDuration: tt.duration,
+				LoginFailureWindow:   time.Minute,
+			})
+			for i := 0; i < 3; i++ {
+				if err := g.Check(ctx, "alice", ""); err != nil {
+					t.Fatalf("Check before the limit: %v", err)
+				}
+				g.Failure(ctx, "alice", "")
+			}
+
+			e := refused(t, g.Check(ctx, "alice", ""))
+			if e.Code != "ACCOUNT_LOCKED" || (e.RetryAfter > 0) == tt.permanent {
+				t.Fatalf("error = %+v, want a lock with retry delay %v", e, !tt.permanent)
+			}
+			locks, err := g.Locked(ctx)
+			if err != nil || len(locks) != 1 || locks[0].UID != "alice" || (locks[0].ExpiresAt == nil) != tt.permanent {
+				t.Fatalf("Locked = %+v, %v, want alice", locks, err)
+			}
+
+			if err := g.Unlock(ctx, "alice"); err != nil {
+				t.Fatalf("Unlock: %v", err)
+			}
+			if err := g.Check(ctx, "alice", ""); err != nil {
+				t.Fatalf("Check after unlock: %v", err)
+			}
+		})
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	g := newGuard(&config.Config{LoginMaxFailures: 1, LoginLockoutDuration: 10 * time.Millisecond, LoginFailureWindow: time.Minute})
+	g.Failure(ctx, "alice", "")
+	refused(t, g.Check(ctx, "alice", ""))
+
+	time.Sleep(20 * time.Millisecond)
+	if err := g.Check(ctx, "alice", ""); err != nil {
+		t.Fatalf("Check after the lock expired: %v", err)
+	}
+	if locks, _ := g.Locked(ctx); len(locks) != 0 {
+		t.Fatalf("Locked = %+v, want the expired lock released", locks)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	g := newGuard(&config.Config{LoginFailureWindow: time.Minute, LoginBackoffBase: time.Second, LoginBackoffMax: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		if err := g.Throttle(ctx, "registration", "10.0.0.1", 2); err != nil {
+			t.Fatalf("Throttle of request %d: %v", i+1, err)
+		}
+	}
+	refused(t, g.Throttle(ctx, "registration", "10.0.0.1", 2))
+
+	// Other kinds and clients have their own counters
+	if err := g.Throttle(ctx, "reset", "10.0.0.1", 2); err != nil {
+		t.Fatalf("Throttle of another kind: %v", err)
+	}
+	if err := g.Throttle(ctx, "registration", "10.0.0.2", 2); err != nil {
+		t.Fatalf("Throttle of another client: %v", err)
+	}
+	if err := g.Throttle(ctx, "registration", "", 2); err != nil {
+		t.Fatalf("Throttle without a client IP: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package lockout
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// Store persists account locks; failure counters are kept in a CounterStore.
+// Implementations match uids case-insensitively, like the directory, and
+// return a nil lock from Get when the account is not locked.
+type Store interface {
+	Lock(ctx context.Context, lock *models.AccountLock) error
+	Unlock(ctx context.Context, uid string) error
+	Get(ctx context.Context, uid string) (*models.AccountLock, error)
+	List(ctx context.Context) ([]*models.AccountLock, error)
+}
+
+// MemoryStore keeps locks in process memory. Locks are lost on restart
+// and are not shared between replicas.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	locks map[string]*models.AccountLock
+}
+
+// NewMemoryStore creates an empty lock store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{locks: make(map[string]*models.AccountLock)}
+}
+
+// Lock records a lock for lock.UID
+func (s *MemoryStore) Lock(ctx context.Context, lock *models.AccountLock) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *lock
+	s.locks[strings.ToLower(lock.UID)] = &copied
+	return nil
+}
+
+// Unlock removes the lock of uid, if any
+func (s *MemoryStore) Unlock(ctx context.Context, uid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.locks, strings.ToLower(uid))
+	return nil
+}
+
+// Get returns the lock of uid, or nil
+func (s *MemoryStore) Get(ctx context.Context, uid string) (*models.AccountLock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lock, ok := s.locks[strings.ToLower(uid)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *lock
+	return &copied, nil
+}
+
+// List returns all locks, most recent first
+func (s *MemoryStore) List(ctx context.Context) ([]*models.AccountLock, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	locks := make([]*models.AccountLock, 0, len(s.locks))
+	for _, lock := range s.locks {
+		copied := *lock
+		locks = append(locks, &copied)
+	}
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].LockedAt.After(locks[j].LockedAt)
+	})
+	return locks, nil
+}
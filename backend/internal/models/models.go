@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // User represents an LDAP user with all attributes
 type User struct {
 	UID          string   `json:"uid"`
@@ -13,11 +15,26 @@ type User struct {
 	HomeDir      string   `json:"homeDirectory"`
 	Repositories []string `json:"repositories"`
 	DN           string   `json:"dn"`
+	Status       string   `json:"status"`
+	// DeletedAt is the day a soft-deleted user was deleted
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
+// User statuses. Disabled users cannot log in; deleted users wait in the
+// deleted container until restored or purged.
+const (
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusDeleted  = "deleted"
+)
+
 // Department represents an organizational unit in LDAP
 type Department struct {
-	OU           string   `json:"ou"`
+	OU string `json:"ou"`
+	// Parent is the ou of the enclosing department, empty for top-level departments
+	Parent string `json:"parent,omitempty"`
+	// Path lists the OUs from the top-level department down to this one
+	Path         []string `json:"path"`
 	Description  string   `json:"description"`
 	Manager      string   `json:"manager,omitempty"`
 	Members      []string `json:"members"`
@@ -27,10 +44,55 @@ type Department struct {
 
 // Group represents an LDAP group
 type Group struct {
-	CN        string   `json:"cn"`
-	GIDNumber int      `json:"gidNumber"`
-	Members   []string `json:"members"`
-	DN        string   `json:"dn"`
+	CN          string   `json:"cn"`
+	GIDNumber   int      `json:"gidNumber"`
+	Description string   `json:"description"`
+	Members     []string `json:"members"`
+	// MemberGroups are the CNs of groups nested directly in this group
+	MemberGroups []string `json:"memberGroups"`
+	DN           string   `json:"dn"`
+}
+
+// GroupPage is one page of a groups listing
+type GroupPage struct {
+	Items          []*Group
+	Total          int
+	TotalEstimated bool
+	Page           int
+	Limit          int
+	HasNextPage    bool
+}
+
+// GroupFilter contains optional filters for group searches
+type GroupFilter struct {
+	CN     string `json:"cn,omitempty"`
+	Member string `json:"member,omitempty"`
+}
+
+// DeletionSummary lists every entry a delete removed or changed
+type DeletionSummary struct {
+	Deleted  []string       `json:"deleted"`
+	Modified []*EntryChange `json:"modified"`
+}
+
+// EntryChange describes a change made to one entry
+type EntryChange struct {
+	DN     string `json:"dn"`
+	Change string `json:"change"`
+}
+
+// UpdateDepartmentInput contains fields for updating a department. Nil fields
+// are left unchanged, empty strings clear the attribute.
+type UpdateDepartmentInput struct {
+	OU          string  `json:"ou"`
+	Description *string `json:"description,omitempty"`
+	Manager     *string `json:"manager,omitempty"`
+}
+
+// UpdateGroupInput contains fields for updating a group
+type UpdateGroupInput struct {
+	CN          string  `json:"cn"`
+	Description *string `json:"description,omitempty"`
 }
 
 
@@ -40,11 +102,40 @@ type Pagination struct {
 }
 
 type UserPage struct {
-	Items       []*User
-	Total       int
-	Page        int
-	Limit       int
-	HasNextPage bool
+	Items          []*User
+	Total          int
+	TotalEstimated bool
+	Page           int
+	Limit          int
+	HasNextPage    bool
+}
+
+// UserConnection is a cursor-paginated list of users
+type UserConnection struct {
+	Edges          []*UserEdge
+	PageInfo       *PageInfo
+	Total          int
+	TotalEstimated bool
+}
+
+// UserEdge is a user with its position in a UserConnection
+type UserEdge struct {
+	Cursor string
+	Node   *User
+}
+
+// PageInfo describes the position of a page in a cursor-paginated list
+type PageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     string
+	EndCursor       string
+}
+
+// UserSort orders a users search by an LDAP attribute
+type UserSort struct {
+	Attribute  string
+	Descending bool
 }
 
 // CreateUserInput contains fields for creating a new user
@@ -57,6 +148,9 @@ type CreateUserInput struct {
 	Department   string   `json:"department"`
 	Password     string   `json:"password"`
 	Repositories []string `json:"repositories"`
+	// PasswordHash, when set, is stored as is instead of hashing Password.
+	// Used for passwords that were checked against the policy earlier.
+	PasswordHash string `json:"-"`
 }
 
 // UpdateUserInput contains fields for updating a user
@@ -74,6 +168,7 @@ type UpdateUserInput struct {
 // CreateDepartmentInput contains fields for creating a department
 type CreateDepartmentInput struct {
 	OU           string   `json:"ou"`
+	Parent       string   `json:"parent,omitempty"`
 	Description  string   `json:"description"`
 	Manager      string   `json:"manager,omitempty"`
 	Repositories []string `json:"repositories,omitempty"`
@@ -82,22 +177,175 @@ type CreateDepartmentInput struct {
 // SearchFilter contains optional filters for user searches
 type SearchFilter struct {
 	Department string `json:"department,omitempty"`
-	Mail       string `json:"mail,omitempty"`
-	CN         string `json:"cn,omitempty"`
+	// IncludeDescendants widens Department to the departments below it
+	IncludeDescendants bool   `json:"includeDescendants,omitempty"`
+	Mail               string `json:"mail,omitempty"`
+	CN                 string `json:"cn,omitempty"`
+	// Status limits the search to users with this status. Deleted users are
+	// only listed when asked for.
+	Status string `json:"status,omitempty"`
 }
 
 // AuthPayload is returned after successful authentication
 type AuthPayload struct {
-	Token string `json:"token"`
-	User  *User  `json:"user"`
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	User         *User     `json:"user"`
+}
+
+// Session describes an active login of the current user
+type Session struct {
+	ID         string    `json:"id"`
+	ClientIP   string    `json:"clientIp"`
+	UserAgent  string    `json:"userAgent"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// Registration is a self-service sign-up waiting for review
+type Registration struct {
+	ID           string     `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	FirstName    string     `json:"firstName"`
+	LastName     string     `json:"lastName"`
+	UserType     string     `json:"userType"`
+	Department   string     `json:"department"`
+	Status       string     `json:"status"`
+	Reason       string     `json:"reason,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ReviewedAt   *time.Time `json:"reviewedAt"`
+	ReviewedBy   string     `json:"reviewedBy,omitempty"`
+	PasswordHash string     `json:"-"`
+}
+
+// AccountLock describes an account locked after repeated login failures.
+// ExpiresAt is nil for locks that last until an admin unlocks the account.
+type AccountLock struct {
+	UID       string     `json:"uid"`
+	LockedAt  time.Time  `json:"lockedAt"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Permanent bool       `json:"-"`
+}
+
+// AuthCode is an OIDC authorization code issued at login, waiting to be
+// redeemed at the token endpoint
+type AuthCode struct {
+	ClientID      string    `json:"clientId"`
+	RedirectURI   string    `json:"redirectUri"`
+	UID           string    `json:"uid"`
+	Scopes        []string  `json:"scopes"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"codeChallenge"`
+	AuthTime      time.Time `json:"authTime"`
+	ExpiresAt     time.Time `json:"expiresAt"`
 }
 
 // Stats contains connection pool statistics
 type Stats struct {
-	PoolSize      int `json:"poolSize"`
-	Available     int `json:"available"`
-	InUse         int `json:"inUse"`
-	TotalRequests int `json:"totalRequests"`
+	PoolSize      int    `json:"poolSize"`
+	Open          int    `json:"open"`
+	Available     int    `json:"available"`
+	InUse         int    `json:"inUse"`
+	TotalRequests int    `json:"totalRequests"`
+	Healthy       bool   `json:"healthy"`
+	LastError     string `json:"lastError,omitempty"`
+
+	Endpoints []*EndpointStats `json:"endpoints"`
+}
+
+// EndpointStats describes the health and connections of one LDAP endpoint
+type EndpointStats struct {
+	URL       string `json:"url"`
+	Role      string `json:"role"`
+	Healthy   bool   `json:"healthy"`
+	LastError string `json:"lastError,omitempty"`
+	PoolSize  int    `json:"poolSize"`
+	Open      int    `json:"open"`
+	Available int    `json:"available"`
+	InUse     int    `json:"inUse"`
+}
+
+// IDRange describes allocation progress for uidNumber or gidNumber
+type IDRange struct {
+	Start     int `json:"start"`
+	Next      int `json:"next"`
+	Allocated int `json:"allocated"`
+}
+
+// IDAllocation reports the UID and GID ranges handed out so far
+type IDAllocation struct {
+	UID *IDRange `json:"uid"`
+	GID *IDRange `json:"gid"`
+}
+
+// Parts of the directory an LDIF export covers
+const (
+	LDIFScopeUsers       = "users"
+	LDIFScopeGroups      = "groups"
+	LDIFScopeDepartments = "departments"
+	LDIFScopeAll         = "all"
+)
+
+// Outcomes of an LDIF import record. Dry runs report valid instead of applied.
+const (
+	LDIFRecordApplied = "applied"
+	LDIFRecordValid   = "valid"
+	LDIFRecordFailed  = "failed"
+)
+
+// LDIFRecordResult is the outcome of one record of an LDIF import
+type LDIFRecordResult struct {
+	Line       int    `json:"line"`
+	DN         string `json:"dn"`
+	ChangeType string `json:"changeType"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// LDIFImportReport lists the outcome of every record of an LDIF import
+type LDIFImportReport struct {
+	DryRun bool `json:"dryRun"`
+	// Applied counts the records applied, or in a dry run those that would be
+	Applied int                 `json:"applied"`
+	Failed  int                 `json:"failed"`
+	Records []*LDIFRecordResult `json:"records"`
+}
+
+// Outcomes of a row of a user import. Dry runs report valid for rows that
+// would be created.
+const (
+	UserImportSuccess = "success"
+	UserImportValid   = "valid"
+	UserImportSkipped = "skipped"
+	UserImportError   = "error"
+)
+
+// UserImportRow is the outcome of one CSV row of a user import
+type UserImportRow struct {
+	// Line is the CSV line of the row; the header is line 1
+	Line   int    `json:"line"`
+	UID    string `json:"uid"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	// InitialPassword is the generated password of a created user. It is
+	// not stored anywhere else, so this report is the only copy.
+	InitialPassword string `json:"initialPassword,omitempty"`
+}
+
+// UserImportReport lists the outcome of every row of a user import
+type UserImportReport struct {
+	DryRun bool `json:"dryRun"`
+	// Created counts the users created; dry runs create none
+	Created int `json:"created"`
+	// Valid counts the rows a dry run would create users for
+	Valid   int              `json:"valid"`
+	Skipped int              `json:"skipped"`
+	Failed  int              `json:"failed"`
+	Rows    []*UserImportRow `json:"rows"`
 }
 
 // HealthStatus represents the health status of the service
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a notification for a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users, e.g. password reset links
+type Notifier interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// WriterNotifier appends messages to a writer instead of delivering them.
+// It is meant for local development, where the file or stdout is read by hand.
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterNotifier creates a notifier writing to w
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+// NewFileNotifier creates a notifier appending to path; "-" writes to stdout
+func NewFileNotifier(path string) (*WriterNotifier, error) {
+	if path == "" || path == "-" {
+		return NewWriterNotifier(os.Stdout), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open notification file: %w", err)
+	}
+	return NewWriterNotifier(f), nil
+}
+
+// Send writes msg in a mail-like format
+func (n *WriterNotifier) Send(ctx context.Context, msg *Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "To: %s\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\n\n", msg.Subject)
+	b.WriteString(msg.Body)
+	b.WriteString("\n\n")
+
+	if _, err := io.WriteString(n.w, b.String()); err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/sirupsen/logrus"
+)
+
+// authRequest is a validated authorization request
+type authRequest struct {
+	Client              *Client
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sign in</title>
+<style>
+body { font-family: sans-serif; background: #f4f5f7; }
+form { max-width: 320px; margin: 10vh auto; padding: 24px; background: #fff; border-radius: 6px; }
+input { display: block; width: 100%; box-sizing: border-box; margin: 8px 0 16px; padding: 8px; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<form method="POST" action="{{.Action}}">
+<h2>Sign in to {{.ClientName}}</h2>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<label>Username<input name="username" value="{{.Username}}" autocomplete="username" autofocus required></label>
+<label>Password<input name="password" type="password" autocomplete="current-password" required></label>
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<input type="hidden" name="client_id" value="{{.Request.Client.ID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// handleAuthorize shows the login form on GET and completes the login on POST
+func (p *Provider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Errors before the client and redirect URI are known must not redirect
+	client, ok := p.clients[r.Form.Get("client_id")]
+	if !ok {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if !client.AllowsRedirect(redirectURI) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	req := &authRequest{
+		Client:              client,
+		RedirectURI:         redirectURI,
+		Scope:               strings.Join(parseScopes(r.Form.Get("scope")), " "),
+		State:               r.Form.Get("state"),
+		Nonce:               r.Form.Get("nonce"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		p.redirectError(w, r, req, "unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	if !hasScope(strings.Fields(req.Scope), "openid") {
+		p.redirectError(w, r, req, "invalid_scope", "the openid scope is required")
+		return
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		p.redirectError(w, r, req, "invalid_request", "PKCE with code_challenge_method S256 is required")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		p.renderLogin(w, req, http.StatusOK, "", "")
+		return
+	}
+
+	username := strings.TrimSpace(r.PostForm.Get("username"))
+	if !validCSRF(r) {
+		p.logger.WithFields(logrus.Fields{
+			"uid":       username,
+			"client_id": client.ID,
+		}).Warn("OIDC login refused, CSRF token missing or wrong")
+		p.renderLogin(w, req, http.StatusForbidden, username, "Your sign-in form expired, please try again")
+		return
+	}
+	ip := session.ClientFromContext(r.Context()).IP
+	if err := p.guard.Check(r.Context(), username, ip); err != nil {
+		p.logger.WithError(err).WithFields(logrus.Fields{
+			"uid":       username,
+			"client_id": client.ID,
+		}).Warn("OIDC login refused")
+		message := "Too many failed attempts, please try again later"
+		if lockErr, ok := err.(*lockout.Error); ok {
+			if lockErr.Code == "ACCOUNT_LOCKED" {
+				message = "This account is locked"
+			}
+			if lockErr.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockErr.RetryAfter.Seconds()))))
+			}
+		}
+		p.renderLogin(w, req, http.StatusUnauthorized, username, message)
+		return
+	}
+
+	user, err := p.dir.Authenticate(r.Context(), username, r.PostForm.Get("password"))
+	if err != nil {
+		p.guard.Failure(r.Context(), username, ip)
+		p.logger.WithFields(logrus.Fields{
+			"uid":       username,
+			"client_id": client.ID,
+		}).Warn("OIDC login failed")
+		p.renderLogin(w, req, http.StatusUnauthorized, username, "Invalid username or password")
+		return
+	}
+	p.guard.Success(r.Context(), username)
+
+	now := time.Now()
+	code, err := p.issueCode(r.Context(), &models.AuthCode{
+		ClientID:      client.ID,
+		RedirectURI:   req.RedirectURI,
+		UID:           user.UID,
+		Scopes:        strings.Fields(req.Scope),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(p.config.OIDCCodeExpiration),
+	})
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to issue authorization code")
+		p.redirectError(w, r, req, "server_error", "failed to issue authorization code")
+		return
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"uid":       user.UID,
+		"client_id": client.ID,
+	}).Info("OIDC login succeeded")
+
+	p.redirect(w, r, req, url.Values{"code": {code}})
+}
+
+func (p *Provider) renderLogin(w http.ResponseWriter, req *authRequest, status int, username, message string) {
+	name := req.Client.Name
+	if name == "" {
+		name = req.Client.ID
+	}
+
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to generate CSRF token")
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     AuthorizePath,
+		Secure:   strings.HasPrefix(p.issuer(), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	loginTemplate.Execute(w, map[string]interface{}{
+		"Action":     AuthorizePath,
+		"ClientName": name,
+		"Request":    req,
+		"Username":   username,
+		"Error":      message,
+		"CSRFToken":  csrfToken,
+	})
+}
+
+// csrfCookie carries the CSRF token of the login form. A cross-site POST
+// can send the cookie but cannot read it to fill in the form field.
+const csrfCookie = "oidc_csrf"
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validCSRF reports whether the form's CSRF token matches its cookie
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) == 1
+}
+
+// redirectError reports an RFC 6749 section 4.1.2.1 error to the client
+func (p *Provider) redirectError(w http.ResponseWriter, r *http.Request, req *authRequest, code, description string) {
+	p.redirect(w, r, req, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+}
+
+func (p *Provider) redirect(w http.ResponseWriter, r *http.Request, req *authRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	query.Set("iss", p.issuer())
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
@@ -0,0 +1,67 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Client is a registered relying party, such as Gitea or Grafana
+type Client struct {
+	ID           string   `json:"client_id"`
+	Secret       string   `json:"client_secret,omitempty"`
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// Public reports whether the client cannot keep a secret and relies on PKCE alone
+func (c *Client) Public() bool {
+	return c.Secret == ""
+}
+
+// AllowsRedirect reports whether uri exactly matches a registered redirect URI
+func (c *Client) AllowsRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate checks the presented client secret
+func (c *Client) Authenticate(secret string) bool {
+	if c.Public() {
+		return secret == ""
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) == 1
+}
+
+// LoadClients reads the client registry, a JSON array of clients
+func LoadClients(path string) (map[string]*Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OIDC clients: %w", err)
+	}
+
+	var list []*Client
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse OIDC clients: %w", err)
+	}
+
+	clients := make(map[string]*Client, len(list))
+	for _, client := range list {
+		if client.ID == "" {
+			return nil, fmt.Errorf("OIDC client without client_id")
+		}
+		if len(client.RedirectURIs) == 0 {
+			return nil, fmt.Errorf("OIDC client %s has no redirect_uris", client.ID)
+		}
+		if _, ok := clients[client.ID]; ok {
+			return nil, fmt.Errorf("duplicate OIDC client %s", client.ID)
+		}
+		clients[client.ID] = client
+	}
+	return clients, nil
+}
@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// CodeStore holds issued authorization codes until they are redeemed, keyed
+// by the hash of the code. Take removes the code so it works only once and
+// returns nil for unknown, used or expired codes. A shared store is required
+// when the service runs with more than one replica, since the token request
+// may reach another replica than the login.
+type CodeStore interface {
+	Put(ctx context.Context, hash string, code *models.AuthCode) error
+	Take(ctx context.Context, hash string) (*models.AuthCode, error)
+}
+
+// MemoryCodeStore keeps authorization codes in process memory
+type MemoryCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]*models.AuthCode
+}
+
+// NewMemoryCodeStore creates an empty code store
+func NewMemoryCodeStore() *MemoryCodeStore {
+	return &MemoryCodeStore{codes: make(map[string]*models.AuthCode)}
+}
+
+// Put stores a code, dropping expired ones
+func (s *MemoryCodeStore) Put(ctx context.Context, hash string, code *models.AuthCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, c := range s.codes {
+		if now.After(c.ExpiresAt) {
+			delete(s.codes, key)
+		}
+	}
+	copied := *code
+	s.codes[hash] = &copied
+	return nil
+}
+
+// Take returns and removes a live code
+func (s *MemoryCodeStore) Take(ctx context.Context, hash string) (*models.AuthCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.codes[hash]
+	delete(s.codes, hash)
+	if !ok || time.Now().After(code.ExpiresAt) {
+		return nil, nil
+	}
+	return code, nil
+}
+
+// issueCode stores code data and returns the opaque code. Only its hash is
+// stored, so the store does not hold usable codes.
+func (p *Provider) issueCode(ctx context.Context, code *models.AuthCode) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+
+	if err := p.codes.Put(ctx, hashCode(value), code); err != nil {
+		return "", fmt.Errorf("failed to store code: %w", err)
+	}
+	return value, nil
+}
+
+// redeemCode returns and removes a code; each code can be used once
+func (p *Provider) redeemCode(ctx context.Context, value string) (*models.AuthCode, error) {
+	if value == "" {
+		return nil, nil
+	}
+	return p.codes.Take(ctx, hashCode(value))
+}
+
+func hashCode(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyPKCE checks an RFC 7636 S256 code_verifier against the stored challenge
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,189 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/token"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// Endpoint paths, relative to the issuer
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/.well-known/jwks.json"
+	AuthorizePath = "/oauth2/authorize"
+	TokenPath     = "/oauth2/token"
+	UserInfoPath  = "/oauth2/userinfo"
+)
+
+// Supported scopes; "openid" is mandatory
+var supportedScopes = []string{"openid", "profile", "email", "groups"}
+
+// Provider is an OpenID Connect identity provider backed by the directory.
+// Users authenticate through Directory.Authenticate and tokens are signed with
+// the service's asymmetric keys, published at the JWKS endpoint.
+type Provider struct {
+	config  *config.Config
+	dir     directory.Directory
+	keys    *token.KeySet
+	guard   *lockout.Guard
+	clients map[string]*Client
+	codes   CodeStore
+	logger  *logrus.Logger
+}
+
+// accessClaims are the claims of access tokens issued to relying parties.
+// They carry no session ID, so the GraphQL API rejects them.
+type accessClaims struct {
+	Scope    string `json:"scope"`
+	ClientID string `json:"client_id"`
+	TokenUse string `json:"token_use"`
+	jwt.RegisteredClaims
+}
+
+// NewProvider creates the provider, loading registered clients from OIDC_CLIENTS_FILE
+func NewProvider(cfg *config.Config, dir directory.Directory, keys *token.KeySet, guard *lockout.Guard, codes CodeStore, logger *logrus.Logger) (*Provider, error) {
+	if cfg.OIDCIssuer == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER is required when OIDC is enabled")
+	}
+	if keys.Algorithm() == token.AlgHS256 {
+		return nil, fmt.Errorf("OIDC requires an asymmetric JWT_SIGNING_ALG, not %s", token.AlgHS256)
+	}
+
+	clients, err := LoadClients(cfg.OIDCClientsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.WithFields(logrus.Fields{
+		"issuer":  cfg.OIDCIssuer,
+		"clients": len(clients),
+	}).Info("OIDC provider initialized")
+
+	return &Provider{
+		config:  cfg,
+		dir:     dir,
+		keys:    keys,
+		guard:   guard,
+		clients: clients,
+		codes:   codes,
+		logger:  logger,
+	}, nil
+}
+
+// Register mounts the provider endpoints. The JWKS endpoint is served by the key set.
+func (p *Provider) Register(mux *http.ServeMux) {
+	mux.HandleFunc(DiscoveryPath, p.handleDiscovery)
+	mux.HandleFunc(AuthorizePath, p.handleAuthorize)
+	mux.HandleFunc(TokenPath, p.handleToken)
+	mux.HandleFunc(UserInfoPath, p.handleUserInfo)
+}
+
+func (p *Provider) issuer() string {
+	return strings.TrimSuffix(p.config.OIDCIssuer, "/")
+}
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := p.issuer()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + AuthorizePath,
+		"token_endpoint":                        issuer + TokenPath,
+		"userinfo_endpoint":                     issuer + UserInfoPath,
+		"jwks_uri":                              issuer + JWKSPath,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{p.keys.Algorithm()},
+		"scopes_supported":                      supportedScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "given_name", "family_name", "preferred_username",
+			"email", "groups", "department",
+		},
+	})
+}
+
+// userClaims returns the identity claims for the granted scopes
+func (p *Provider) userClaims(ctx context.Context, user *models.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": user.UID,
+	}
+
+	if hasScope(scopes, "profile") {
+		claims["name"] = user.CN
+		claims["given_name"] = user.GivenName
+		claims["family_name"] = user.SN
+		claims["preferred_username"] = user.UID
+		claims["department"] = user.Department
+	}
+	if hasScope(scopes, "email") {
+		claims["email"] = user.Mail
+		claims["email_verified"] = user.Mail != ""
+	}
+	if hasScope(scopes, "groups") {
+		groups, err := p.dir.EffectiveGroups(ctx, user.UID)
+		if err != nil {
+			p.logger.WithError(err).WithField("uid", user.UID).Warn("Failed to load groups for OIDC claims")
+		}
+		names := make([]string, 0, len(groups))
+		for _, group := range groups {
+			names = append(names, group.CN)
+		}
+		claims["groups"] = names
+		claims["department"] = user.Department
+	}
+
+	return claims
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// parseScopes keeps the supported scopes of a space separated scope string
+func parseScopes(raw string) []string {
+	var scopes []string
+	for _, s := range strings.Fields(raw) {
+		if hasScope(supportedScopes, s) && !hasScope(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func (p *Provider) tokenLifetime() time.Duration {
+	return p.config.OIDCTokenExpiration
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeOAuthError writes an RFC 6749 section 5.2 error response
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
@@ -0,0 +1,370 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/lockout"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/oidc"
+	"github.com/devplatform/ldap-manager/internal/password"
+	"github.com/devplatform/ldap-manager/internal/token"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	issuer        = "https://sso.devplatform.local"
+	redirectURI   = "https://gitea.devplatform.local/user/oauth2/sso/callback"
+	alicePassword = "Alice-Pass-123"
+	// verifier is a 43 character PKCE code_verifier
+	verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadClients(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `[{"client_id": "gitea", "redirect_uris": ["https://gitea/cb"]}, {"client_id": "grafana", "client_secret": "s", "redirect_uris": ["https://grafana/cb"]}]`, ""},
+		{"malformed", `{"client_id": "gitea"}`, "failed to parse"},
+		{"without client_id", `[{"redirect_uris": ["https://gitea/cb"]}]`, "without client_id"},
+		{"without redirect_uris", `[{"client_id": "gitea"}]`, "has no redirect_uris"},
+		{"duplicate", `[{"client_id": "gitea", "redirect_uris": ["a"]}, {"client_id": "gitea", "redirect_uris": ["b"]}]`, "duplicate OIDC client gitea"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clients.json")
+			writeFile(t, path, tt.content)
+			clients, err := oidc.LoadClients(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadClients error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || len(clients) != 2 {
+				t.Fatalf("LoadClients = %v, %v, want 2 clients", clients, err)
+			}
+			if !clients["gitea"].Public() || clients["grafana"].Public() {
+				t.Fatal("only the client without a secret should be public")
+			}
+		})
+	}
+
+	if _, err := oidc.LoadClients(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("LoadClients accepted a missing file")
+	}
+}
+
+func TestClient(t *testing.T) {
+	public := &oidc.Client{ID: "gitea", RedirectURIs: []string{redirectURI}}
+	confidential := &oidc.Client{ID: "grafana", Secret: "grafana-secret", RedirectURIs: []string{redirectURI}}
+
+	if !public.AllowsRedirect(redirectURI) || public.AllowsRedirect(redirectURI+"/") || public.AllowsRedirect("") {
+		t.Fatal("AllowsRedirect must match registered URIs exactly")
+	}
+	if !public.Authenticate("") || public.Authenticate("guess") {
+		t.Fatal("a public client authenticates with an empty secret only")
+	}
+	if !confidential.Authenticate("grafana-secret") || confidential.Authenticate("") || confidential.Authenticate("other") {
+		t.Fatal("a confidential client authenticates with its secret only")
+	}
+}
+
+// newProvider serves a provider for the public client gitea and the
+// confidential client grafana, over an in-memory directory holding alice
+func newProvider(t *testing.T) *http.ServeMux {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("LDAP_BASE_DN", "dc=devplatform,dc=local")
+	t.Setenv("LDAP_BIND_DN", "cn=admin,dc=devplatform,dc=local")
+	t.Setenv("LDAP_BIND_PASSWORD", "admin-secret")
+	cfg := config.Load()
+	cfg.OIDCIssuer = issuer
+	cfg.OIDCClientsFile = filepath.Join(dir, "clients.json")
+	cfg.JWTSigningAlg = token.AlgES256
+	cfg.JWTKeysDir = filepath.Join(dir, "keys")
+	writeFile(t, cfg.OIDCClientsFile, `[
+		{"client_id": "gitea", "redirect_uris": ["`+redirectURI+`"]},
+		{"client_id": "grafana", "client_secret": "grafana-secret", "redirect_uris": ["`+redirectURI+`"]}
+	]`)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(cfg.JWTKeysDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(cfg.JWTKeysDir, "signing.pem")
+	writeFile(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})))
+	published := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(keyFile, published, published); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := token.NewKeySet(cfg, quietLogger())
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	t.Cleanup(keys.Close)
+
+	users, err := directory.NewMemoryDirectory(cfg, quietLogger())
+	if err != nil {
+		t.Fatalf("NewMemoryDirectory: %v", err)
+	}
+	t.Cleanup(func() { users.Close() })
+	hasher, err := password.NewHasher("SSHA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := hasher.Hash(alicePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.CreateUser(context.Background(), &models.CreateUserInput{
+		UID: "alice", CN: "Alice Archer", SN: "Archer", GivenName: "Alice", Mail: "alice@devplatform.local", PasswordHash: hashed,
+	}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	guard := lockout.NewGuard(cfg, lockout.NewMemoryStore(), lockout.NewMemoryCounterStore(), quietLogger())
+	provider, err := oidc.NewProvider(cfg, users, keys, guard, oidc.NewMemoryCodeStore(), quietLogger())
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	mux := http.NewServeMux()
+	provider.Register(mux)
+	return mux
+}
+
+func serve(mux *http.ServeMux, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func postForm(path string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// authorizeParams returns the parameters of an authorization request of gitea
+func authorizeParams() url.Values {
+	sum := sha256.Sum256([]byte(verifier))
+	return url.Values{
+		"client_id":             {"gitea"},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// login completes the login form of an authorization request and returns
+// the redirect it answers with
+func login(t *testing.T, mux *http.ServeMux, params url.Values, pass string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := serve(mux, httptest.NewRequest(http.MethodGet, oidc.AuthorizePath+"?"+params.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET authorize = %d: %s", rec.Code, rec.Body)
+	}
+	var csrf *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "oidc_csrf" {
+			csrf = cookie
+		}
+	}
+	if csrf == nil {
+		t.Fatal("the login form set no CSRF cookie")
+	}
+
+	form := url.Values{"username": {"alice"}, "password": {pass}, "csrf_token": {csrf.Value}}
+	for key, values := range params {
+		form[key] = values
+	}
+	req := postForm(oidc.AuthorizePath, form)
+	req.AddCookie(csrf)
+	return serve(mux, req)
+}
+
+// redirectQuery returns the query of the client redirect of rec
+func redirectQuery(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+	if rec.Code != http.StatusFound {
+		t.Fatalf("status = %d, want a redirect: %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), redirectURI+"?") {
+		t.Fatalf("Location = %q, want the client redirect URI", rec.Header().Get("Location"))
+	}
+	return location.Query()
+}
+
+func exchange(mux *http.ServeMux, code, codeVerifier string) (int, map[string]interface{}) {
+	rec := serve(mux, postForm(oidc.TokenPath, url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"gitea"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}))
+	var body map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec.Code, body
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	mux := newProvider(t)
+
+	query := redirectQuery(t, login(t, mux, authorizeParams(), alicePassword))
+	if query.Get("state") != "xyz" || query.Get("iss") != issuer || query.Get("code") == "" {
+		t.Fatalf("redirect query = %v, want a code with state and iss", query)
+	}
+	code := query.Get("code")
+
+	status, body := exchange(mux, code, verifier)
+	if status != http.StatusOK || body["token_type"] != "Bearer" || body["scope"] != "openid profile email" {
+		t.Fatalf("token response = %d %v", status, body)
+	}
+	idToken, _ := body["id_token"].(string)
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, claims); err != nil {
+		t.Fatalf("id_token: %v", err)
+	}
+	if claims["sub"] != "alice" || claims["aud"] != "gitea" || claims["iss"] != issuer || claims["nonce"] != "n-0S6" || claims["email"] != "alice@devplatform.local" {
+		t.Fatalf("id_token claims = %v", claims)
+	}
+
+	// Codes are single use
+	if status, body := exchange(mux, code, verifier); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("second redemption = %d %v, want invalid_grant", status, body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, oidc.UserInfoPath, nil)
+	req.Header.Set("Authorization", "Bearer "+body["access_token"].(string))
+	rec := serve(mux, req)
+	var userinfo map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &userinfo); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("userinfo = %d: %s", rec.Code, rec.Body)
+	}
+	if userinfo["sub"] != "alice" || userinfo["preferred_username"] != "alice" || userinfo["groups"] != nil {
+		t.Fatalf("userinfo = %v, want the profile and email claims of alice", userinfo)
+	}
+
+	// The ID token is not an access token
+	req.Header.Set("Authorization", "Bearer "+idToken)
+	if rec := serve(mux, req); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("userinfo with the id_token = %d, want 401", rec.Code)
+	}
+}
+
+func TestAuthorizationCodeWrongVerifier(t *testing.T) {
+	mux := newProvider(t)
+
+	code := redirectQuery(t, login(t, mux, authorizeParams(), alicePassword)).Get("code")
+	other := strings.Repeat("a", 43)
+	if status, body := exchange(mux, code, other); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("token response = %d %v, want invalid_grant", status, body)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	mux := newProvider(t)
+
+	without := func(key string) url.Values {
+		params := authorizeParams()
+		params.Del(key)
+		return params
+	}
+	with := func(key, value string) url.Values {
+		params := authorizeParams()
+		params.Set(key, value)
+		return params
+	}
+
+	// Without a known client and redirect URI there is nobody to redirect to
+	for name, params := range map[string]url.Values{
+		"unknown client":        with("client_id", "intruder"),
+		"unregistered redirect": with("redirect_uri", "https://evil.example/callback"),
+		"without client_id":     without("client_id"),
+		"without redirect_uri":  without("redirect_uri"),
+	} {
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, oidc.AuthorizePath+"?"+params.Encode(), nil))
+		if rec.Code != http.StatusBadRequest || rec.Header().Get("Location") != "" {
+			t.Fatalf("%s = %d %q, want 400 without a redirect", name, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+
+	tests := []struct {
+		name   string
+		params url.Values
+		want   string
+	}{
+		{"token response type", with("response_type", "token"), "unsupported_response_type"},
+		{"without openid", with("scope", "profile"), "invalid_scope"},
+		{"without PKCE", without("code_challenge"), "invalid_request"},
+		{"plain PKCE", with("code_challenge_method", "plain"), "invalid_request"},
+	}
+	for _, tt := range tests {
+		rec := serve(mux, httptest.NewRequest(http.MethodGet, oidc.AuthorizePath+"?"+tt.params.Encode(), nil))
+		if query := redirectQuery(t, rec); query.Get("error") != tt.want || query.Get("state") != "xyz" {
+			t.Fatalf("%s redirected with %v, want error %s", tt.name, query, tt.want)
+		}
+	}
+
+	// A POST without the CSRF cookie is refused before the password is checked
+	form := authorizeParams()
+	form.Set("username", "alice")
+	form.Set("password", alicePassword)
+	form.Set("csrf_token", "forged")
+	if rec := serve(mux, postForm(oidc.AuthorizePath, form)); rec.Code != http.StatusForbidden {
+		t.Fatalf("POST without the CSRF cookie = %d, want 403", rec.Code)
+	}
+
+	if rec := login(t, mux, authorizeParams(), "wrong"); rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Invalid username or password") {
+		t.Fatalf("wrong password = %d, want 401 with the login form", rec.Code)
+	}
+}
@@ -0,0 +1,164 @@
+package oidc
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/directory"
+	"github.com/devplatform/ldap-manager/internal/models"
+	"github.com/devplatform/ldap-manager/internal/session"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const accessTokenUse = "oidc_access"
+
+// handleToken exchanges an authorization code for an ID token and access token
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	client, found := p.clients[clientID]
+	if !found || !client.Authenticate(secret) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oidc"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	code, err := p.redeemCode(r.Context(), r.PostForm.Get("code"))
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to redeem authorization code")
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to redeem authorization code")
+		return
+	}
+	if code == nil || code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+	if !verifyPKCE(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	user, err := p.dir.GetUser(directory.WithProvider(r.Context()), code.UID)
+	if err != nil {
+		p.logger.WithError(err).WithField("uid", code.UID).Warn("OIDC user no longer exists")
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "user not found")
+		return
+	}
+	if user.Status != models.UserStatusActive {
+		p.logger.WithField("uid", code.UID).Warn("OIDC user is disabled")
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "user is disabled")
+		return
+	}
+
+	now := time.Now()
+	exp := now.Add(p.tokenLifetime())
+	jti, err := session.NewID()
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to generate token ID")
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+
+	accessToken, err := p.keys.Sign(&accessClaims{
+		Scope:    strings.Join(code.Scopes, " "),
+		ClientID: client.ID,
+		TokenUse: accessTokenUse,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    p.issuer(),
+			Subject:   user.UID,
+			Audience:  jwt.ClaimStrings{p.issuer() + UserInfoPath},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+	})
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to sign OIDC access token")
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+
+	idClaims := jwt.MapClaims(p.userClaims(r.Context(), user, code.Scopes))
+	idClaims["iss"] = p.issuer()
+	idClaims["aud"] = client.ID
+	idClaims["iat"] = now.Unix()
+	idClaims["exp"] = exp.Unix()
+	idClaims["auth_time"] = code.AuthTime.Unix()
+	if code.Nonce != "" {
+		idClaims["nonce"] = code.Nonce
+	}
+
+	idToken, err := p.keys.Sign(idClaims)
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to sign OIDC ID token")
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+
+	p.logger.WithFields(logrus.Fields{
+		"uid":       user.UID,
+		"client_id": client.ID,
+	}).Info("OIDC tokens issued")
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(p.tokenLifetime().Seconds()),
+		"id_token":     idToken,
+		"scope":        strings.Join(code.Scopes, " "),
+	})
+}
+
+// handleUserInfo returns the claims of the access token's subject
+func (p *Provider) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenString == "" || tokenString == r.Header.Get("Authorization") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "bearer token required")
+		return
+	}
+
+	claims := &accessClaims{}
+	if _, err := p.keys.Parse(tokenString, claims); err != nil || claims.TokenUse != accessTokenUse || claims.Issuer != p.issuer() {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc", error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "invalid or expired access token")
+		return
+	}
+
+	user, err := p.dir.GetUser(directory.WithProvider(r.Context()), claims.Subject)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc", error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "user not found")
+		return
+	}
+	if user.Status != models.UserStatusActive {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="oidc", error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "user is disabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p.userClaims(r.Context(), user, strings.Fields(claims.Scope)))
+}
@@ -0,0 +1,143 @@
+package password
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"strings"
+)
+
+// SHA-512 based crypt(3) ("$6$") as specified by Ulrich Drepper in
+// https://www.akkadia.org/drepper/SHA-crypt.txt
+
+const (
+	sha512CryptDefaultRounds = 5000
+	sha512CryptMinRounds     = 1000
+	sha512CryptMaxRounds     = 999999999
+	sha512CryptMaxSalt       = 16
+)
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptSalt maps random bytes onto the crypt salt alphabet
+func cryptSalt(random []byte) []byte {
+	salt := make([]byte, 0, sha512CryptMaxSalt)
+	for _, b := range random {
+		if len(salt) == sha512CryptMaxSalt {
+			break
+		}
+		salt = append(salt, cryptAlphabet[int(b)%len(cryptAlphabet)])
+	}
+	return salt
+}
+
+// sha512Crypt computes the full "$6$..." string. explicitRounds controls
+// whether a "rounds=" field is emitted, which changes nothing but the output.
+func sha512Crypt(key, salt []byte, rounds int, explicitRounds bool) string {
+	if len(salt) > sha512CryptMaxSalt {
+		salt = salt[:sha512CryptMaxSalt]
+	}
+	if rounds < sha512CryptMinRounds {
+		rounds = sha512CryptMinRounds
+	}
+	if rounds > sha512CryptMaxRounds {
+		rounds = sha512CryptMaxRounds
+	}
+
+	alt := sha512.New()
+	alt.Write(key)
+	alt.Write(salt)
+	alt.Write(key)
+	altSum := alt.Sum(nil)
+
+	a := sha512.New()
+	a.Write(key)
+	a.Write(salt)
+	n := len(key)
+	for ; n > sha512.Size; n -= sha512.Size {
+		a.Write(altSum)
+	}
+	a.Write(altSum[:n])
+	for n = len(key); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(altSum)
+		} else {
+			a.Write(key)
+		}
+	}
+	sum := a.Sum(nil)
+
+	dp := sha512.New()
+	for i := 0; i < len(key); i++ {
+		dp.Write(key)
+	}
+	p := repeatTo(dp.Sum(nil), len(key))
+
+	ds := sha512.New()
+	for i := 0; i < 16+int(sum[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeatTo(ds.Sum(nil), len(salt))
+
+	for i := 0; i < rounds; i++ {
+		c := sha512.New()
+		if i&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(sum)
+		}
+		if i%3 != 0 {
+			c.Write(s)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i&1 != 0 {
+			c.Write(sum)
+		} else {
+			c.Write(p)
+		}
+		sum = c.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString("$6$")
+	if explicitRounds {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.Write(salt)
+	out.WriteByte('$')
+
+	order := [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+	for _, o := range order {
+		encode24(&out, sum[o[0]], sum[o[1]], sum[o[2]], 4)
+	}
+	encode24(&out, 0, 0, sum[63], 2)
+
+	return out.String()
+}
+
+func repeatTo(block []byte, length int) []byte {
+	out := make([]byte, 0, length)
+	for len(out) < length {
+		remaining := length - len(out)
+		if remaining > len(block) {
+			remaining = len(block)
+		}
+		out = append(out, block[:remaining]...)
+	}
+	return out
+}
+
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for i := 0; i < n; i++ {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
@@ -0,0 +1,53 @@
+package password
+
+import "testing"
+
+// The SHA-512 test vectors of the specification
+func TestSHA512Crypt(t *testing.T) {
+	tests := []struct {
+		key      string
+		salt     string
+		rounds   int
+		explicit bool
+		want     string
+	}{
+		{"Hello world!", "saltstring", 5000, false,
+			"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "saltstringsaltstring", 10000, true,
+			"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+		{"This is just a test", "toolongsaltstring", 5000, true,
+			"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+		{"a very much longer text to encrypt.  This one even stretches over morethan one line.", "anotherlongsaltstring", 1400, true,
+			"$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1"},
+		{"we have a short salt string but not a short password", "short", 77777, true,
+			"$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0"},
+		{"a short string", "asaltof16chars..", 123456, true,
+			"$6$rounds=123456$asaltof16chars..$BtCwjqMJGx5hrJhZywWvt0RLE8uZ4oPwcelCjmw2kSYu.Ec6ycULevoBK25fs2xXgMNrCzIMVcgEJAstJeonj1"},
+		{"the minimum number is still observed", "roundstoolow", 10, true,
+			"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX."},
+	}
+	for _, tt := range tests {
+		if got := sha512Crypt([]byte(tt.key), []byte(tt.salt), tt.rounds, tt.explicit); got != tt.want {
+			t.Errorf("sha512Crypt(%q, %q, %d) = %s, want %s", tt.key, tt.salt, tt.rounds, got, tt.want)
+		}
+		if !Verify(tt.key, "{CRYPT}"+tt.want) {
+			t.Errorf("Verify(%q) rejected %s", tt.key, tt.want)
+		}
+	}
+}
+
+func TestCryptSalt(t *testing.T) {
+	random := make([]byte, 32)
+	for i := range random {
+		random[i] = byte(i * 9)
+	}
+	salt := cryptSalt(random)
+	if len(salt) != sha512CryptMaxSalt {
+		t.Fatalf("salt %q has %d characters, want %d", salt, len(salt), sha512CryptMaxSalt)
+	}
+	for _, c := range salt {
+		if c == '$' || c == ':' || c == '\n' {
+			t.Fatalf("salt %q contains %q", salt, c)
+		}
+	}
+}
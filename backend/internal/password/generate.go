@@ -0,0 +1,56 @@
+package password
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// Characters of generated passwords, without look-alikes such as 0/O and 1/l/I
+const (
+	generateLower   = "abcdefghijkmnopqrstuvwxyz"
+	generateUpper   = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	generateDigits  = "23456789"
+	generateSymbols = "!#%+-=?@_"
+)
+
+// Generate returns a random password of length characters with at least one
+// character of every class. Callers still check it against the policy.
+func Generate(length int) (string, error) {
+	classes := []string{generateLower, generateUpper, generateDigits, generateSymbols}
+	if length < len(classes) {
+		length = len(classes)
+	}
+	all := generateLower + generateUpper + generateDigits + generateSymbols
+
+	chars := make([]byte, length)
+	for i := range chars {
+		set := all
+		if i < len(classes) {
+			set = classes[i]
+		}
+		c, err := randomIndex(len(set))
+		if err != nil {
+			return "", err
+		}
+		chars[i] = set[c]
+	}
+
+	// Move the guaranteed class characters away from the front
+	for i := len(chars) - 1; i > 0; i-- {
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return "", err
+		}
+		chars[i], chars[j] = chars[j], chars[i]
+	}
+	return string(chars), nil
+}
+
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate password: %w", err)
+	}
+	return int(i.Int64()), nil
+}
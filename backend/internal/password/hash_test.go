@@ -0,0 +1,89 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/password"
+)
+
+func TestHashVerify(t *testing.T) {
+	tests := []struct {
+		scheme string
+		prefix string
+	}{
+		{"ssha", "{SSHA}"},
+		{password.SchemeSSHA512, "{SSHA512}"},
+		{password.SchemeCryptSHA512, "{CRYPT}$6$"},
+		{password.SchemeArgon2, "{ARGON2}$argon2id$v=19$m=65536,t=3,p=1$"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			h, err := password.NewHasher(tt.scheme)
+			if err != nil {
+				t.Fatalf("NewHasher: %v", err)
+			}
+			hashed, err := h.Hash("Correct-Horse-1")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if !strings.HasPrefix(hashed, tt.prefix) || !password.IsHashed(hashed) {
+				t.Fatalf("Hash = %s, want a %s value", hashed, tt.prefix)
+			}
+			if !password.Verify("Correct-Horse-1", hashed) {
+				t.Fatal("Verify rejected the password")
+			}
+			if password.Verify("correct-horse-1", hashed) {
+				t.Fatal("Verify accepted another password")
+			}
+
+			// Salts are fresh for every hash
+			again, _ := h.Hash("Correct-Horse-1")
+			if again == hashed {
+				t.Fatal("two hashes of the same password are equal")
+			}
+		})
+	}
+
+	if _, err := password.NewHasher("MD5"); err == nil {
+		t.Fatal("NewHasher accepted MD5")
+	}
+}
+
+func TestVerifyStored(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored string
+		want   bool
+	}{
+		{"cleartext", "secret", true},
+		{"other cleartext", "Secret", false},
+		{"unknown scheme", "{MD5}Xr4ilOzQ4PCOq3aQ0qbuaQ==", false},
+		{"truncated digest", "{SSHA}c2VjcmV0", false},
+		{"not base64", "{SSHA512}!!", false},
+		{"MD5 crypt", "{CRYPT}$1$salt$hash", false},
+		{"bad rounds", "{CRYPT}$6$rounds=many$salt$hash", false},
+		{"argon2i", "{ARGON2}$argon2i$v=19$m=65536,t=3,p=1$c2FsdA$aGFzaA", false},
+	}
+	for _, tt := range tests {
+		if got := password.Verify("secret", tt.stored); got != tt.want {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIsHashed(t *testing.T) {
+	for stored, want := range map[string]bool{
+		"{SSHA}abc":        true,
+		"{CRYPT}$6$s$h":    true,
+		"{x-custom.1}abc":  true,
+		"{}abc":            false,
+		"{not a scheme}":   false,
+		"{SSHA":            false,
+		"Hunter2{SSHA}abc": false,
+	} {
+		if got := password.IsHashed(stored); got != want {
+			t.Errorf("IsHashed(%q) = %v, want %v", stored, got, want)
+		}
+	}
+}
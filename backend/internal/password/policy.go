@@ -0,0 +1,179 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Rule names reported in policy violations
+const (
+	RuleMinLength    = "MIN_LENGTH"
+	RuleCharClasses  = "CHARACTER_CLASSES"
+	RuleBannedWord   = "BANNED_WORD"
+	RulePersonalInfo = "PERSONAL_INFO"
+	RuleHistory      = "HISTORY"
+)
+
+// minPersonalTokenLength ignores very short name fragments such as initials
+const minPersonalTokenLength = 3
+
+// Policy describes what a new password must satisfy
+type Policy struct {
+	MinLength   int
+	MinClasses  int
+	BannedWords []string
+	HistorySize int
+}
+
+// Subject carries the account attributes a password must not contain
+type Subject struct {
+	UID       string
+	CN        string
+	SN        string
+	GivenName string
+	Mail      string
+}
+
+// Violation is a single failed policy rule
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PolicyError is returned when a password fails one or more rules
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "password policy violation: " + strings.Join(messages, "; ")
+}
+
+// Extensions exposes the failed rules in the GraphQL error response
+func (e *PolicyError) Extensions() map[string]interface{} {
+	rules := make([]string, 0, len(e.Violations))
+	violations := make([]map[string]interface{}, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		rules = append(rules, v.Rule)
+		violations = append(violations, map[string]interface{}{
+			"rule":    v.Rule,
+			"message": v.Message,
+		})
+	}
+	return map[string]interface{}{
+		"code":       "PASSWORD_POLICY_VIOLATION",
+		"rules":      rules,
+		"violations": violations,
+	}
+}
+
+// Validate checks plain against the policy. history holds previous
+// userPassword values (hashed or not) that may not be reused.
+func (p *Policy) Validate(plain string, subject Subject, history []string) error {
+	var violations []Violation
+
+	if len([]rune(plain)) < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if classes := characterClasses(plain); classes < p.MinClasses {
+		violations = append(violations, Violation{
+			Rule:    RuleCharClasses,
+			Message: fmt.Sprintf("must contain at least %d of: lowercase, uppercase, digits, symbols", p.MinClasses),
+		})
+	}
+
+	lower := strings.ToLower(plain)
+	for _, word := range p.BannedWords {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" && strings.Contains(lower, word) {
+			violations = append(violations, Violation{
+				Rule:    RuleBannedWord,
+				Message: fmt.Sprintf("must not contain %q", word),
+			})
+			break
+		}
+	}
+
+	for _, token := range subject.tokens() {
+		if strings.Contains(lower, token) {
+			violations = append(violations, Violation{
+				Rule:    RulePersonalInfo,
+				Message: "must not contain your user name or parts of your name",
+			})
+			break
+		}
+	}
+
+	if p.HistorySize > 0 {
+		for _, previous := range history {
+			if Verify(plain, previous) {
+				violations = append(violations, Violation{
+					Rule:    RuleHistory,
+					Message: fmt.Sprintf("must differ from your last %d passwords", p.HistorySize),
+				})
+				break
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// tokens returns the lowercased identity fragments checked against the password
+func (s Subject) tokens() []string {
+	var tokens []string
+	add := func(value string) {
+		for _, part := range strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(part)) >= minPersonalTokenLength {
+				tokens = append(tokens, part)
+			}
+		}
+	}
+
+	add(s.UID)
+	add(s.CN)
+	add(s.SN)
+	add(s.GivenName)
+	if at := strings.Index(s.Mail, "@"); at > 0 {
+		add(s.Mail[:at])
+	}
+	return tokens
+}
+
+func characterClasses(plain string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range plain {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
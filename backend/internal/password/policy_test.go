@@ -0,0 +1,83 @@
+package password_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/devplatform/ldap-manager/internal/password"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	policy := &password.Policy{MinLength: 10, MinClasses: 3, BannedWords: []string{" Password ", ""}, HistorySize: 2}
+	subject := password.Subject{UID: "alice", CN: "Alice Archer", SN: "Archer", GivenName: "Alice", Mail: "a.w@devplatform.local"}
+
+	h, err := password.NewHasher(password.SchemeSSHA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := h.Hash("Old-Secret-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := []string{previous, "Older-Secret-41"}
+
+	tests := []struct {
+		name  string
+		plain string
+		want  []string
+	}{
+		{"valid", "Tidal-Wave-93", nil},
+		{"initials of the mail are too short to count", "Tidal-Wave-93-a.w", nil},
+		{"too short", "Ti-9a", []string{password.RuleMinLength}},
+		{"multibyte characters count once", "Über-Straße-1", nil},
+		{"too few classes", "tidalwave93", []string{password.RuleCharClasses}},
+		{"banned word", "My-Password-1", []string{password.RuleBannedWord}},
+		{"uid", "Hello-ALICE-123", []string{password.RulePersonalInfo}},
+		{"surname", "archer-Tidal-9", []string{password.RulePersonalInfo}},
+		{"hashed history", "Old-Secret-42", []string{password.RuleHistory}},
+		{"cleartext history", "Older-Secret-41", []string{password.RuleHistory}},
+		{"several rules", "alice", []string{password.RuleMinLength, password.RuleCharClasses, password.RulePersonalInfo}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.plain, subject, history)
+			var rules []string
+			var policyErr *password.PolicyError
+			if errors.As(err, &policyErr) {
+				for _, v := range policyErr.Violations {
+					rules = append(rules, v.Rule)
+				}
+				if got := policyErr.Extensions()["rules"]; !reflect.DeepEqual(got, rules) {
+					t.Fatalf("extension rules = %v, want %v", got, rules)
+				}
+			} else if err != nil {
+				t.Fatalf("Validate = %v, want a policy error", err)
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Fatalf("violated rules = %v, want %v", rules, tt.want)
+			}
+		})
+	}
+
+	// History is only checked when the policy keeps one
+	if err := (&password.Policy{}).Validate("Old-Secret-42", subject, history); err != nil {
+		t.Fatalf("Validate without history = %v", err)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	policy := &password.Policy{MinLength: 16, MinClasses: 4}
+	for i := 0; i < 20; i++ {
+		plain, err := password.Generate(16)
+		if err != nil {
+			t.Fatalf("Generate: %v", err)
+		}
+		if err := policy.Validate(plain, password.Subject{}, nil); err != nil {
+			t.Fatalf("Generate = %q: %v", plain, err)
+		}
+	}
+	if plain, _ := password.Generate(1); len(plain) != 4 {
+		t.Fatalf("Generate(1) = %q, want one character of every class", plain)
+	}
+}
@@ -0,0 +1,88 @@
+package registration
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// MemoryStore keeps registrations in process memory. Pending requests are
+// lost on restart and are not shared between replicas.
+type MemoryStore struct {
+	mu            sync.Mutex
+	registrations map[string]*models.Registration
+}
+
+// NewMemoryStore creates an empty registration store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{registrations: make(map[string]*models.Registration)}
+}
+
+// Create stores a new registration
+func (s *MemoryStore) Create(ctx context.Context, reg *models.Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range s.registrations {
+		if existing.ReviewedAt != nil && now.Sub(*existing.ReviewedAt) > ReviewedRetention {
+			delete(s.registrations, id)
+		}
+	}
+
+	copied := *reg
+	s.registrations[reg.ID] = &copied
+	return nil
+}
+
+// Get returns a copy of a registration
+func (s *MemoryStore) Get(ctx context.Context, id string) (*models.Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.registrations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *reg
+	return &copied, nil
+}
+
+// List returns registrations with the given status, oldest first;
+// an empty status returns all of them
+func (s *MemoryStore) List(ctx context.Context, status string) ([]*models.Registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]*models.Registration, 0, len(s.registrations))
+	for _, reg := range s.registrations {
+		if status == "" || reg.Status == status {
+			copied := *reg
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// Update replaces a registration whose stored status is still from
+func (s *MemoryStore) Update(ctx context.Context, reg *models.Registration, from string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.registrations[reg.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if existing.Status != from {
+		return ErrConflict
+	}
+	copied := *reg
+	s.registrations[reg.ID] = &copied
+	return nil
+}
@@ -0,0 +1,36 @@
+package registration
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/models"
+)
+
+// Registration states
+const (
+	StatusPending  = "PENDING"
+	StatusApproved = "APPROVED"
+	StatusRejected = "REJECTED"
+)
+
+// ReviewedRetention is how long decided registrations are kept for reference
+const ReviewedRetention = 30 * 24 * time.Hour
+
+var (
+	// ErrNotFound is returned for unknown registration IDs
+	ErrNotFound = errors.New("registration not found")
+	// ErrConflict is returned when a registration changed state concurrently
+	ErrConflict = errors.New("registration was already reviewed")
+)
+
+// Store holds registrations until they are reviewed. Update only succeeds
+// while the stored status still equals from, so two reviewers cannot both
+// act on the same request.
+type Store interface {
+	Create(ctx context.Context, reg *models.Registration) error
+	Get(ctx context.Context, id string) (*models.Registration, error)
+	List(ctx context.Context, status string) ([]*models.Registration, error)
+	Update(ctx context.Context, reg *models.Registration, from string) error
+}
@@ -0,0 +1,86 @@
+package reset
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps reset tokens in process memory. Tokens are lost on
+// restart and are not shared between replicas.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]*Token
+}
+
+// NewMemoryStore creates an empty token store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tokens: make(map[string]*Token)}
+}
+
+// Put stores token, invalidating the previous tokens of the same user
+func (s *MemoryStore) Put(ctx context.Context, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now())
+	for hash, previous := range s.tokens {
+		if previous.UID == token.UID {
+			delete(s.tokens, hash)
+		}
+	}
+	copied := *token
+	s.tokens[token.Hash] = &copied
+	return nil
+}
+
+// Consume removes and returns the live token with the given hash
+func (s *MemoryStore) Consume(ctx context.Context, hash string) (*Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	delete(s.tokens, hash)
+
+	if time.Now().After(token.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+	return token, nil
+}
+
+// Restore puts back a consumed token without touching the other tokens of the user
+func (s *MemoryStore) Restore(ctx context.Context, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *token
+	s.tokens[token.Hash] = &copied
+	return nil
+}
+
+// LastIssued returns when the live token of uid was created, if there is one
+func (s *MemoryStore) LastIssued(ctx context.Context, uid string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var last time.Time
+	for _, token := range s.tokens {
+		if token.UID == uid && !now.After(token.ExpiresAt) && token.CreatedAt.After(last) {
+			last = token.CreatedAt
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// prune drops expired tokens. Must be called with s.mu held.
+func (s *MemoryStore) prune(now time.Time) {
+	for hash, token := range s.tokens {
+		if now.After(token.ExpiresAt) {
+			delete(s.tokens, hash)
+		}
+	}
+}
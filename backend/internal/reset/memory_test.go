@@ -0,0 +1,59 @@
+package reset_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/reset"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := reset.NewMemoryStore()
+	now := time.Now()
+	token := func(hash, uid string, created time.Duration) *reset.Token {
+		return &reset.Token{Hash: hash, UID: uid, CreatedAt: now.Add(created), ExpiresAt: now.Add(time.Hour)}
+	}
+	consume := func(hash, wantUID string) {
+		t.Helper()
+		got, err := s.Consume(ctx, hash)
+		if wantUID == "" {
+			if err != reset.ErrInvalidToken {
+				t.Fatalf("Consume(%s) = %+v, %v, want an invalid token", hash, got, err)
+			}
+			return
+		}
+		if err != nil || got.UID != wantUID {
+			t.Fatalf("Consume(%s) = %+v, %v, want the token of %s", hash, got, err, wantUID)
+		}
+	}
+
+	if _, ok := s.LastIssued(ctx, "alice"); ok {
+		t.Fatal("LastIssued reported a token before any was issued")
+	}
+	s.Put(ctx, token("h1", "alice", 0))
+	s.Put(ctx, token("h2", "alice", time.Second))
+	s.Put(ctx, token("b1", "bob", 0))
+	expired := token("b2", "bob", 0)
+	expired.ExpiresAt = now.Add(-time.Second)
+	s.Restore(ctx, expired)
+
+	if last, ok := s.LastIssued(ctx, "alice"); !ok || !last.Equal(now.Add(time.Second)) {
+		t.Fatalf("LastIssued = %v, %v, want the second token", last, ok)
+	}
+	consume("h1", "")
+	consume("b2", "")
+	consume("h2", "alice")
+	consume("h2", "")
+
+	// A restored token works again and leaves newer tokens alone
+	s.Put(ctx, token("h3", "alice", 2*time.Second))
+	s.Restore(ctx, token("h2", "alice", time.Second))
+	if last, _ := s.LastIssued(ctx, "alice"); !last.Equal(now.Add(2 * time.Second)) {
+		t.Fatalf("LastIssued = %v, want the newest token", last)
+	}
+	consume("h2", "alice")
+	consume("h3", "alice")
+	consume("b1", "bob")
+}
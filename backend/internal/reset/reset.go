@@ -0,0 +1,58 @@
+package reset
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidToken is returned for unknown, used or expired reset tokens
+var ErrInvalidToken = errors.New("invalid or expired reset token")
+
+// Token is an issued password reset token. Only the hash of the secret is kept.
+type Token struct {
+	Hash      string
+	UID       string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Store holds outstanding reset tokens. Issuing a token for a user replaces
+// any earlier one, and Consume removes the token so it works only once.
+// Restore puts back a consumed token whose reset failed, leaving any token
+// issued in the meantime in place.
+type Store interface {
+	Put(ctx context.Context, token *Token) error
+	Consume(ctx context.Context, hash string) (*Token, error)
+	Restore(ctx context.Context, token *Token) error
+	LastIssued(ctx context.Context, uid string) (time.Time, bool)
+}
+
+// NewToken generates a reset token for uid valid for ttl. It returns the
+// secret to send to the user and the token to store.
+func NewToken(uid string, ttl time.Duration) (string, *Token, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	return secret, &Token{
+		Hash:      HashSecret(secret),
+		UID:       uid,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// HashSecret returns the lookup key for a token secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on
+// restart and are not shared between replicas.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	revoked  map[string]time.Time
+	done     chan struct{}
+}
+
+// NewMemoryStore creates a store that prunes expired entries every interval
+func NewMemoryStore(interval time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		sessions: make(map[string]*Session),
+		revoked:  make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+	go s.janitor(interval)
+	return s
+}
+
+// Create stores a new session
+func (s *MemoryStore) Create(ctx context.Context, sess *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *sess
+	s.sessions[sess.ID] = &copied
+	return nil
+}
+
+// Get returns a copy of a live session
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sess, ok := s.sessions[id]
+	if !ok || time.Now().After(sess.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	copied := *sess
+	return &copied, nil
+}
+
+// Update replaces an existing session while it still equals from
+func (s *MemoryStore) Update(ctx context.Context, sess *Session, from *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[sess.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if !stored.equal(from) {
+		return ErrConflict
+	}
+	copied := *sess
+	s.sessions[sess.ID] = &copied
+	return nil
+}
+
+// Delete removes a session
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// ListByUser returns the live sessions of uid, most recently used first
+func (s *MemoryStore) ListByUser(ctx context.Context, uid string) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	sessions := make([]*Session, 0)
+	for _, sess := range s.sessions {
+		if sess.UID == uid && now.Before(sess.ExpiresAt) {
+			copied := *sess
+			sessions = append(sessions, &copied)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+	return sessions, nil
+}
+
+// Revoke marks a token ID as revoked until the given time
+func (s *MemoryStore) Revoke(ctx context.Context, jti string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[jti] = until
+	return nil
+}
+
+// IsRevoked reports whether a token ID has been revoked
+func (s *MemoryStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
+
+// Close stops the background janitor
+func (s *MemoryStore) Close() {
+	close(s.done)
+}
+
+func (s *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.prune(time.Now())
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, sess := range s.sessions {
+		if now.After(sess.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+	for jti, until := range s.revoked {
+		if now.After(until) {
+			delete(s.revoked, jti)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(time.Minute)
+	defer s.Close()
+
+	if err := s.Create(ctx, &Session{ID: "s1", UID: "alice", RefreshHash: "h1", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	read, err := s.Get(ctx, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two refreshes of the same token: only the first may rotate it
+	first, second := *read, *read
+	first.RefreshHash, second.RefreshHash = "h2", "h3"
+	if err := s.Update(ctx, &first, read); err != nil {
+		t.Fatalf("first update: %v", err)
+	}
+	if err := s.Update(ctx, &second, read); err != ErrConflict {
+		t.Fatalf("second update = %v, want ErrConflict", err)
+	}
+	if got, _ := s.Get(ctx, "s1"); got.RefreshHash != "h2" {
+		t.Fatalf("refresh hash = %s, want h2", got.RefreshHash)
+	}
+
+	if err := s.Update(ctx, &Session{ID: "nope"}, &Session{ID: "nope"}); err != ErrNotFound {
+		t.Fatalf("update missing = %v, want ErrNotFound", err)
+	}
+}
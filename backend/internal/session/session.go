@@ -0,0 +1,119 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a session does not exist or has expired
+var ErrNotFound = errors.New("session not found")
+
+// ErrConflict is returned when a session changed since it was read
+var ErrConflict = errors.New("session changed concurrently")
+
+// Session is a login of one user from one client. It owns a rotating
+// refresh token and the access token issued with it.
+type Session struct {
+	ID          string
+	UID         string
+	RefreshHash string
+	AccessJTI   string
+	AccessExp   time.Time
+	ClientIP    string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+}
+
+// equal reports whether two copies of a session hold the same values
+func (s *Session) equal(o *Session) bool {
+	return s.ID == o.ID && s.UID == o.UID && s.RefreshHash == o.RefreshHash &&
+		s.AccessJTI == o.AccessJTI && s.AccessExp.Equal(o.AccessExp) &&
+		s.ClientIP == o.ClientIP && s.UserAgent == o.UserAgent &&
+		s.CreatedAt.Equal(o.CreatedAt) && s.LastUsedAt.Equal(o.LastUsedAt) && s.ExpiresAt.Equal(o.ExpiresAt)
+}
+
+// Store persists sessions and revoked access token IDs. Implementations
+// must be safe for concurrent use; a shared store is required when the
+// service runs with more than one replica.
+type Store interface {
+	Create(ctx context.Context, s *Session) error
+	Get(ctx context.Context, id string) (*Session, error)
+	// Update replaces a session only while it still equals from, the copy
+	// read before, and fails with ErrConflict otherwise. A refresh token
+	// presented twice at once can so only be rotated once.
+	Update(ctx context.Context, s *Session, from *Session) error
+	Delete(ctx context.Context, id string) error
+	ListByUser(ctx context.Context, uid string) ([]*Session, error)
+
+	// Revoke marks an access token ID as unusable until it would have expired anyway
+	Revoke(ctx context.Context, jti string, until time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// ClientInfo describes where a request came from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx carrying the client information
+func WithClient(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client information stored in ctx
+func ClientFromContext(ctx context.Context) ClientInfo {
+	client, _ := ctx.Value(clientKey{}).(ClientInfo)
+	return client
+}
+
+// NewID returns a random URL-safe identifier
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewRefreshToken returns a refresh token for sessionID and the hash to store.
+// The token is "<session id>.<secret>" so it can be looked up without a scan.
+func NewRefreshToken(sessionID string) (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return sessionID + "." + secret, hashSecret(secret), nil
+}
+
+// ParseRefreshToken splits a refresh token into its session ID and secret
+func ParseRefreshToken(token string) (sessionID string, secret string, err error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("malformed refresh token")
+	}
+	return parts[0], parts[1], nil
+}
+
+// MatchesRefresh reports whether secret belongs to the session's current refresh token
+func (s *Session) MatchesRefresh(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(s.RefreshHash)) == 1
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
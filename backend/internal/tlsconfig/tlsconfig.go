@@ -0,0 +1,145 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+)
+
+// Client certificate modes for the HTTP API
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// ParseVersion converts "1.0".."1.3" to a crypto/tls version constant
+func ParseVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version: %s", version)
+}
+
+// Server builds the TLS configuration for the HTTP API, or returns nil when
+// no server certificate is configured and the API should speak plain HTTP
+func Server(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	minVersion, err := ParseVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	if cfg.TLSClientCAFile == "" {
+		return tlsCfg, nil
+	}
+
+	pool, err := loadCertPool(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg.ClientCAs = pool
+
+	switch cfg.TLSClientAuth {
+	case ClientAuthNone:
+	case ClientAuthOptional:
+		// Lets probes and browser clients in without a certificate
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported TLS_CLIENT_AUTH mode: %s", cfg.TLSClientAuth)
+	}
+
+	return tlsCfg, nil
+}
+
+// LDAP builds the TLS configuration for connections to the directory at
+// rawURL. It returns nil when the URL is ldap:// and StartTLS is disabled.
+func LDAP(cfg *config.Config, rawURL string) (*tls.Config, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL %q: %w", rawURL, err)
+	}
+
+	secure := u.Scheme == "ldaps"
+	if secure && cfg.LDAPStartTLS {
+		return nil, fmt.Errorf("LDAP_START_TLS cannot be used with an ldaps:// URL")
+	}
+	if !secure && !cfg.LDAPStartTLS {
+		return nil, nil
+	}
+
+	minVersion, err := ParseVersion(cfg.LDAPTLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg := &tls.Config{
+		ServerName:         u.Hostname(),
+		MinVersion:         minVersion,
+		InsecureSkipVerify: cfg.LDAPTLSInsecureSkipVerify,
+	}
+	if cfg.LDAPTLSServerName != "" {
+		tlsCfg.ServerName = cfg.LDAPTLSServerName
+	}
+
+	if cfg.LDAPCACertFile != "" {
+		pool, err := loadCertPool(cfg.LDAPCACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if cfg.LDAPClientCertFile != "" || cfg.LDAPClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.LDAPClientCertFile, cfg.LDAPClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load LDAP client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
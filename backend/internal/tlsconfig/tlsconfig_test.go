@@ -0,0 +1,166 @@
+package tlsconfig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/tlsconfig"
+)
+
+// writeCertificate writes a self-signed certificate and its key to dir and
+// returns their paths
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap-manager"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+	}{
+		{"1.0", tls.VersionTLS10},
+		{"1.1", tls.VersionTLS11},
+		{"", tls.VersionTLS12},
+		{" 1.2 ", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+	}
+	for _, tt := range tests {
+		got, err := tlsconfig.ParseVersion(tt.version)
+		if err != nil || got != tt.want {
+			t.Fatalf("ParseVersion(%q) = %x, %v, want %x", tt.version, got, err, tt.want)
+		}
+	}
+	if _, err := tlsconfig.ParseVersion("1.4"); err == nil {
+		t.Fatal("ParseVersion accepted 1.4")
+	}
+}
+
+func TestServer(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir())
+
+	tests := []struct {
+		name       string
+		cfg        config.Config
+		wantNil    bool
+		wantAuth   tls.ClientAuthType
+		wantErr    string
+		wantClient bool
+	}{
+		{name: "plain HTTP", wantNil: true},
+		{name: "client CA without certificate", cfg: config.Config{TLSClientCAFile: certFile}, wantErr: "requires TLS_CERT_FILE"},
+		{name: "certificate", cfg: config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile}, wantAuth: tls.NoClientCert},
+		{name: "optional client certificates", cfg: config.Config{
+			TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: certFile, TLSClientAuth: tlsconfig.ClientAuthOptional,
+		}, wantAuth: tls.VerifyClientCertIfGiven, wantClient: true},
+		{name: "required client certificates", cfg: config.Config{
+			TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: certFile, TLSClientAuth: tlsconfig.ClientAuthRequire,
+		}, wantAuth: tls.RequireAndVerifyClientCert, wantClient: true},
+		{name: "unknown client auth mode", cfg: config.Config{
+			TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: certFile, TLSClientAuth: "sometimes",
+		}, wantErr: "unsupported TLS_CLIENT_AUTH"},
+		{name: "missing key", cfg: config.Config{TLSCertFile: certFile, TLSKeyFile: certFile + ".missing"}, wantErr: "failed to load server certificate"},
+		{name: "CA bundle without certificates", cfg: config.Config{
+			TLSCertFile: certFile, TLSKeyFile: keyFile, TLSClientCAFile: keyFile, TLSClientAuth: tlsconfig.ClientAuthRequire,
+		}, wantErr: "no certificates found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			got, err := tlsconfig.Server(&cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Server error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Server: %v", err)
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Fatalf("Server = %+v, want nil", got)
+				}
+				return
+			}
+			if got.ClientAuth != tt.wantAuth || (got.ClientCAs != nil) != tt.wantClient || got.MinVersion != tls.VersionTLS12 {
+				t.Fatalf("ClientAuth = %v, ClientCAs = %v, MinVersion = %x", got.ClientAuth, got.ClientCAs != nil, got.MinVersion)
+			}
+		})
+	}
+}
+
+func TestLDAP(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir())
+
+	if got, err := tlsconfig.LDAP(&config.Config{}, "ldap://ldap.local:389"); got != nil || err != nil {
+		t.Fatalf("plain ldap:// = %+v, %v, want no TLS", got, err)
+	}
+	if _, err := tlsconfig.LDAP(&config.Config{LDAPStartTLS: true}, "ldaps://ldap.local"); err == nil || !strings.Contains(err.Error(), "LDAP_START_TLS") {
+		t.Fatalf("StartTLS over ldaps:// error = %v", err)
+	}
+
+	got, err := tlsconfig.LDAP(&config.Config{LDAPStartTLS: true}, "ldap://ldap.local:389")
+	if err != nil || got.ServerName != "ldap.local" || got.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("StartTLS = %+v, %v, want ServerName ldap.local", got, err)
+	}
+
+	got, err = tlsconfig.LDAP(&config.Config{
+		LDAPTLSServerName:  "ldap.internal",
+		LDAPTLSMinVersion:  "1.3",
+		LDAPCACertFile:     certFile,
+		LDAPClientCertFile: certFile,
+		LDAPClientKeyFile:  keyFile,
+	}, "ldaps://10.0.0.5:636")
+	if err != nil {
+		t.Fatalf("LDAP: %v", err)
+	}
+	if got.ServerName != "ldap.internal" || got.MinVersion != tls.VersionTLS13 || got.RootCAs == nil || len(got.Certificates) != 1 {
+		t.Fatalf("ldaps:// = %+v, want the configured name, version, CA and client certificate", got)
+	}
+
+	if _, err := tlsconfig.LDAP(&config.Config{LDAPClientCertFile: certFile}, "ldaps://ldap.local"); err == nil {
+		t.Fatal("LDAP accepted a client certificate without its key")
+	}
+}
@@ -0,0 +1,360 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// Key is an asymmetric signing key identified by its kid
+type Key struct {
+	ID        string
+	Algorithm string
+	ActiveAt  time.Time
+	private   crypto.Signer
+}
+
+// Public returns the verification half of the key
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeySet signs and verifies tokens. With HS256 it uses the shared secret;
+// otherwise it loads PEM private keys from a directory, signs with the newest
+// key that has been published for at least the activation delay, and accepts
+// any loaded key by kid. Reloading the directory on a schedule lets operators
+// rotate keys by adding a new file and later removing the old one.
+type KeySet struct {
+	mu              sync.RWMutex
+	algorithm       string
+	secret          []byte
+	dir             string
+	activationDelay time.Duration
+	keys            map[string]*Key
+	ephemeral       bool
+	logger          *logrus.Logger
+	done            chan struct{}
+}
+
+// NewKeySet creates the key set described by the configuration
+func NewKeySet(cfg *config.Config, logger *logrus.Logger) (*KeySet, error) {
+	ks := &KeySet{
+		algorithm:       cfg.JWTSigningAlg,
+		secret:          []byte(cfg.JWTSecret),
+		dir:             cfg.JWTKeysDir,
+		activationDelay: cfg.JWTKeyActivationDelay,
+		keys:            make(map[string]*Key),
+		logger:          logger,
+		done:            make(chan struct{}),
+	}
+
+	switch ks.algorithm {
+	case AlgHS256:
+		if len(ks.secret) == 0 {
+			return nil, fmt.Errorf("JWT_SECRET is required for %s", AlgHS256)
+		}
+		return ks, nil
+	case AlgRS256, AlgES256, AlgEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm: %s", ks.algorithm)
+	}
+
+	if ks.dir == "" {
+		if !cfg.IsDevelopment() {
+			return nil, fmt.Errorf("JWT_KEYS_DIR is required for %s", ks.algorithm)
+		}
+		key, err := generateKey(ks.algorithm)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[key.ID] = key
+		ks.ephemeral = true
+		logger.WithField("kid", key.ID).Warn("No JWT_KEYS_DIR configured, using an ephemeral signing key")
+		return ks, nil
+	}
+
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Algorithm returns the configured signing algorithm
+func (ks *KeySet) Algorithm() string {
+	return ks.algorithm
+}
+
+// Reload rereads the key directory, keeping the activation time of known keys
+func (ks *KeySet) Reload() error {
+	if ks.ephemeral || ks.algorithm == AlgHS256 {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	loaded := make(map[string]*Key, len(paths))
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			ks.logger.WithError(err).WithField("path", path).Warn("Skipping unreadable JWT key")
+			continue
+		}
+		loaded[key.ID] = key
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	hasSigner := false
+	for id, key := range loaded {
+		if known, ok := ks.keys[id]; ok && known.ActiveAt.Before(key.ActiveAt) {
+			key.ActiveAt = known.ActiveAt
+		}
+		if key.Algorithm == ks.algorithm {
+			hasSigner = true
+		}
+	}
+	if !hasSigner {
+		return fmt.Errorf("no %s key found in %s", ks.algorithm, ks.dir)
+	}
+
+	for id := range loaded {
+		if _, ok := ks.keys[id]; !ok {
+			ks.logger.WithField("kid", id).Info("JWT key added")
+		}
+	}
+	for id := range ks.keys {
+		if _, ok := loaded[id]; !ok {
+			ks.logger.WithField("kid", id).Info("JWT key retired")
+		}
+	}
+
+	ks.keys = loaded
+	return nil
+}
+
+// StartRotation reloads the key directory every interval until Close is called
+func (ks *KeySet) StartRotation(interval time.Duration) {
+	if ks.ephemeral || ks.algorithm == AlgHS256 || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := ks.Reload(); err != nil {
+					ks.logger.WithError(err).Error("Failed to reload JWT keys, keeping current keys")
+				}
+			case <-ks.done:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops scheduled rotation
+func (ks *KeySet) Close() {
+	close(ks.done)
+}
+
+// signingKey picks the newest key past its activation delay, falling back to
+// the oldest key so a fresh deployment can sign immediately
+func (ks *KeySet) signingKey() (*Key, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	candidates := make([]*Key, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		if key.Algorithm == ks.algorithm {
+			candidates = append(candidates, key)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no signing key available")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].ActiveAt.Equal(candidates[j].ActiveAt) {
+			return candidates[i].ID < candidates[j].ID
+		}
+		return candidates[i].ActiveAt.After(candidates[j].ActiveAt)
+	})
+
+	cutoff := time.Now().Add(-ks.activationDelay)
+	for _, key := range candidates {
+		if !key.ActiveAt.After(cutoff) {
+			return key, nil
+		}
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+// Sign serializes and signs claims, stamping the kid header for asymmetric keys
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.algorithm == AlgHS256 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.secret)
+	}
+
+	key, err := ks.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Parse verifies tokenString and decodes it into claims
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, ks.keyFunc)
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	if ks.algorithm == AlgHS256 {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return ks.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public(), nil
+}
+
+// loadKey parses a PEM private key file. The key's activation time is the
+// file's modification time.
+func loadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	key, err := newKey(parsed)
+	if err != nil {
+		return nil, err
+	}
+	key.ActiveAt = info.ModTime()
+	return key, nil
+}
+
+func newKey(private interface{}) (*Key, error) {
+	var alg string
+	var signer crypto.Signer
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA keys must be at least 2048 bits")
+		}
+		alg, signer = AlgRS256, k
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("only P-256 EC keys are supported")
+		}
+		alg, signer = AlgES256, k
+	case ed25519.PrivateKey:
+		alg, signer = AlgEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+
+	kid, err := thumbprint(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: kid, Algorithm: alg, private: signer}, nil
+}
+
+func generateKey(alg string) (*Key, error) {
+	var private interface{}
+	var err error
+
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("cannot generate key for %s", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	key, err := newKey(private)
+	if err != nil {
+		return nil, err
+	}
+	key.ActiveAt = time.Now()
+	return key, nil
+}
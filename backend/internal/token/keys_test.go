@@ -0,0 +1,163 @@
+package token_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devplatform/ldap-manager/internal/config"
+	"github.com/devplatform/ldap-manager/internal/token"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// writeKey writes a PEM private key to dir/name, dated published
+func writeKey(t *testing.T, dir, name string, private interface{}, published time.Time) {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, published, published); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// kid returns the key ID a token was signed with
+func kid(t *testing.T, signed string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := parsed.Header["kid"].(string)
+	return id
+}
+
+func TestHS256(t *testing.T) {
+	if _, err := token.NewKeySet(&config.Config{JWTSigningAlg: token.AlgHS256}, quietLogger()); err == nil {
+		t.Fatal("NewKeySet accepted HS256 without a secret")
+	}
+
+	ks, err := token.NewKeySet(&config.Config{JWTSigningAlg: token.AlgHS256, JWTSecret: "secret"}, quietLogger())
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	signed, err := ks.Sign(&jwt.RegisteredClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	var claims jwt.RegisteredClaims
+	if _, err := ks.Parse(signed, &claims); err != nil || claims.Subject != "alice" {
+		t.Fatalf("Parse = %+v, %v, want subject alice", claims, err)
+	}
+
+	other, _ := token.NewKeySet(&config.Config{JWTSigningAlg: token.AlgHS256, JWTSecret: "other"}, quietLogger())
+	if _, err := other.Parse(signed, &jwt.RegisteredClaims{}); err == nil {
+		t.Fatal("a token signed with another secret was accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeKey(t, dir, "old.pem", newECKey(t), now.Add(-2*time.Hour))
+	writeKey(t, dir, "new.pem", newECKey(t), now)
+	// Keys of other algorithms are published but never sign
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "ed.pem", edKey, now.Add(-3*time.Hour))
+	if err := os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{JWTSigningAlg: token.AlgES256, JWTKeysDir: dir, JWTKeyActivationDelay: 10 * time.Minute}
+	ks, err := token.NewKeySet(cfg, quietLogger())
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	defer ks.Close()
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 3 {
+		t.Fatalf("JWKS has %d keys, want the 3 readable ones", len(jwks.Keys))
+	}
+	kty := map[string]string{}
+	for _, jwk := range jwks.Keys {
+		kty[jwk.Kid] = jwk.Kty + " " + jwk.Alg
+	}
+
+	// The new key is published but not yet past the activation delay
+	signed, err := ks.Sign(&jwt.RegisteredClaims{Subject: "alice"})
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	oldKid := kid(t, signed)
+	if kty[oldKid] != "EC ES256" {
+		t.Fatalf("signed with %q (%s), want an ES256 key", oldKid, kty[oldKid])
+	}
+
+	writeKey(t, dir, "new.pem", newECKey(t), now.Add(-time.Hour))
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(os.Remove(filepath.Join(dir, "old.pem")))
+	check(ks.Reload())
+	rotated, err := ks.Sign(&jwt.RegisteredClaims{Subject: "alice"})
+	check(err)
+	if newKid := kid(t, rotated); newKid == oldKid || newKid == "" {
+		t.Fatalf("signed with %q after rotation, want the new key", newKid)
+	}
+
+	// Tokens of retired keys no longer verify
+	if _, err := ks.Parse(signed, &jwt.RegisteredClaims{}); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("Parse of a retired key's token = %v, want unknown signing key", err)
+	}
+	var claims jwt.RegisteredClaims
+	if _, err := ks.Parse(rotated, &claims); err != nil || claims.Subject != "alice" {
+		t.Fatalf("Parse = %+v, %v, want subject alice", claims, err)
+	}
+
+	// A directory without a key of the algorithm keeps the loaded keys
+	check(os.Remove(filepath.Join(dir, "new.pem")))
+	if err := ks.Reload(); err == nil {
+		t.Fatal("Reload accepted a directory without an ES256 key")
+	}
+	if _, err := ks.Parse(rotated, &jwt.RegisteredClaims{}); err != nil {
+		t.Fatalf("Parse after a failed reload: %v", err)
+	}
+}
@@ -11,8 +11,24 @@ export METRICS_PORT=9090
 export ENVIRONMENT=development
 export LOG_LEVEL=debug
 export LDAP_POOL_SIZE=5
+# "./run-local.sh migrate [--dry-run]" installs the schema through cn=config and creates the base OUs
+export LDAP_CONFIG_BIND_DN=cn=admin,cn=config
+export LDAP_CONFIG_BIND_PASSWORD=config123
 export STARTING_UID=10000
 export STARTING_GID=10000
+export ROLE_GROUPS=admins:admin,auditors:auditor
+export PASSWORD_SCHEME=SSHA
+# DIRECTORY_BACKEND=memory runs without OpenLDAP, with an admin user using the password below
+export DIRECTORY_BACKEND=${DIRECTORY_BACKEND:-ldap}
+export DIRECTORY_MEMORY_ADMIN_PASSWORD=${DIRECTORY_MEMORY_ADMIN_PASSWORD:-admin123}
+# OIDC needs asymmetric keys; development generates an ephemeral one
+export JWT_SIGNING_ALG=ES256
+export OIDC_ENABLED=true
+export OIDC_ISSUER=http://localhost:8080
+export OIDC_CLIENTS_FILE=./oidc-clients.example.json
+# Password reset links are printed to stdout
+export NOTIFIER=file
+export NOTIFIER_FILE=-
 
 echo "Starting LDAP Manager Service..."
 echo ""
@@ -21,7 +37,9 @@ echo "  - GraphQL API: http://localhost:8080/graphql"
 echo "  - Health Check: http://localhost:8080/health"
 echo "  - Readiness: http://localhost:8080/ready"
 echo "  - Metrics: http://localhost:9090/metrics"
+echo "  - OIDC discovery: http://localhost:8080/.well-known/openid-configuration"
+echo "    (test with: go run ./cmd/oidc-testclient)"
 echo ""
 
 cd "$(dirname "$0")"
-go run cmd/server/main.go
+go run ./cmd/server "$@"
@@ -43,8 +43,21 @@ export type LoginMutationVariables = {
   password: string;
 };
 
+// Sign-ups are queued for review; no account or token exists until approval
+export type Registration = {
+  id: string;
+  username: string;
+  email: string;
+  firstName: string;
+  lastName: string;
+  userType: string;
+  department: string | null;
+  status: "PENDING" | "APPROVED" | "REJECTED";
+  createdAt: string;
+};
+
 export type RegisterMutation = {
-  register: AuthPayload;
+  register: Registration;
 };
 
 export type RegisterMutationVariables = {
@@ -54,4 +67,5 @@ export type RegisterMutationVariables = {
   firstName: string;
   lastName: string;
   userType: string;
+  department?: string;
 };
@@ -2,5 +2,7 @@ import type { User } from "./user"
 
 export type AuthPayload = {
   token: string
+  refreshToken: string
+  expiresAt: string
   user: User
 }
\ No newline at end of file
@@ -0,0 +1,10 @@
+export interface EntryChange {
+  dn: string;
+  change: string;
+}
+
+// Entries removed or changed by a delete mutation
+export interface DeletionSummary {
+  deleted: string[];
+  modified: EntryChange[];
+}
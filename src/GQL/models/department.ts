@@ -1,15 +1,27 @@
+import type { User } from "./user";
+
 export interface Department {
   ou: string;
   description?: string;
-  manager?: string;
+  manager?: Pick<User, "uid" | "cn" | "mail"> | null;
   members: string[];
   repositories: string[];
   dn: string;
+  // OUs from the top-level department down to this one
+  path: string[];
 }
 
 export interface CreateDepartmentInput {
   ou: string;
+  parent?: string;
   description?: string;
   manager?: string;
   repositories?: string[];
 }
+
+// Omitted fields are left unchanged; an empty string clears the field
+export interface UpdateDepartmentInput {
+  ou: string;
+  description?: string;
+  manager?: string;
+}
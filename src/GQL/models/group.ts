@@ -1,6 +1,23 @@
 export interface Group {
   cn: string;
   gidNumber: number;
+  description?: string;
   members: string[];
+  memberGroups?: string[];
+  effectiveMembers?: string[];
   dn: string;
 }
+
+export interface GroupPage {
+  items: Group[];
+  total: number;
+  totalEstimated?: boolean;
+  page: number;
+  limit: number;
+  hasNextPage: boolean;
+}
+
+export interface GroupFilter {
+  cn?: string;
+  member?: string;
+}
@@ -10,8 +10,13 @@ export interface User {
   homeDirectory: string;
   repositories: string[];
   dn: string;
+  status: UserStatus;
+  // Set while the user is soft-deleted
+  deletedAt?: string | null;
 }
 
+export type UserStatus = "ACTIVE" | "DISABLED" | "DELETED";
+
 export interface CreateUserInput {
   uid: string;
   cn: string;
@@ -37,6 +42,7 @@ export interface UpdateUserInput {
 export interface UserPage {
   items: User[];
   total: number;
+  totalEstimated?: boolean;
   page: number;
   limit: number;
   hasNextPage: boolean;
@@ -47,9 +53,31 @@ export interface UserFilter {
   cn?: string;
   mail?: string;
   uid?: string;
+  status?: UserStatus;
 }
 
 export interface PaginationInput {
   page: number;
   limit: number;
 }
+
+export type UserImportStatus = "success" | "valid" | "skipped" | "error";
+
+export interface UserImportRow {
+  line: number;
+  uid: string;
+  status: UserImportStatus;
+  reason?: string;
+  // Generated password of a created user, only ever returned here
+  initialPassword?: string;
+}
+
+export interface UserImportReport {
+  dryRun: boolean;
+  created: number;
+  // Rows a dry run would create users for
+  valid: number;
+  skipped: number;
+  failed: number;
+  rows: UserImportRow[];
+}
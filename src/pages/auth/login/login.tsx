@@ -2,6 +2,7 @@ import { useState } from 'react';
 import "./login.css"
 import { useNavigate } from 'react-router-dom';
 import { login } from '../../../services/userService';
+import { storeTokens } from '../../../services/graphqlRequest';
 import hideIcon from '../../../assets/eye-password-hide.svg'
 import showIcon from '../../../assets/eye-password-show.svg'
 
@@ -22,8 +23,8 @@ const Login = () => {
     try {
       const result = await login({ uid, password });
 
-      // Store the token
-      localStorage.setItem('authToken', result.login.token);
+      // Store the tokens; the access token is renewed with the refresh token
+      storeTokens(result.login);
 
       // Store user info
       localStorage.setItem('user', JSON.stringify(result.login.user));
@@ -1,11 +1,12 @@
 import { graphqlRequest } from "./graphqlRequest";
-import type { Department, CreateDepartmentInput } from "../GQL/models/department";
+import type { Department, CreateDepartmentInput, UpdateDepartmentInput } from "../GQL/models/department";
+import type { DeletionSummary } from "../GQL/models/deletionSummary";
 
 export async function getDepartment(ou: string): Promise<Department> {
   const query = `
     query ($ou: String!) {
       department(ou: $ou) {
-        ou description manager members repositories dn
+        ou description manager { uid cn mail } members repositories dn path
       }
     }
   `;
@@ -16,29 +17,65 @@ export async function listDepartments(): Promise<Department[]> {
   const query = `
     query {
       departments {
-        ou description manager members repositories dn
+        ou description manager { uid cn mail } members repositories dn path
       }
     }
   `;
   return graphqlRequest<{ departments: Department[] }>(query).then(res => res.departments);
 }
 
+export async function getDepartmentTree(): Promise<Department[]> {
+  const query = `
+    query {
+      departmentTree {
+        ou description manager { uid cn mail } members repositories dn path
+      }
+    }
+  `;
+  return graphqlRequest<{ departmentTree: Department[] }>(query).then(res => res.departmentTree);
+}
+
 export async function createDepartment(input: CreateDepartmentInput): Promise<Department> {
   const mutation = `
     mutation ($input: CreateDepartmentInput!) {
       createDepartment(input: $input) {
-        ou description manager members repositories dn
+        ou description manager { uid cn mail } members repositories dn path
       }
     }
   `;
   return graphqlRequest<{ createDepartment: Department }, { input: CreateDepartmentInput }>(mutation, { input }).then(res => res.createDepartment);
 }
 
-export async function deleteDepartment(ou: string): Promise<boolean> {
+export async function updateDepartment(input: UpdateDepartmentInput): Promise<Department> {
+  const mutation = `
+    mutation ($ou: String!, $description: String, $manager: String) {
+      updateDepartment(ou: $ou, description: $description, manager: $manager) {
+        ou description manager { uid cn mail } members repositories dn path
+      }
+    }
+  `;
+  return graphqlRequest<{ updateDepartment: Department }, UpdateDepartmentInput>(mutation, input).then(res => res.updateDepartment);
+}
+
+export async function deleteDepartment(ou: string, reassignTo?: string): Promise<DeletionSummary> {
+  const mutation = `
+    mutation ($ou: String!, $reassignTo: String) {
+      deleteDepartment(ou: $ou, reassignTo: $reassignTo) {
+        deleted
+        modified { dn change }
+      }
+    }
+  `;
+  return graphqlRequest<{ deleteDepartment: DeletionSummary }, { ou: string; reassignTo?: string }>(mutation, { ou, reassignTo }).then(res => res.deleteDepartment);
+}
+
+export async function moveDepartment(ou: string, parent?: string): Promise<Department> {
   const mutation = `
-    mutation ($ou: String!) {
-      deleteDepartment(ou: $ou)
+    mutation ($ou: String!, $parent: String) {
+      moveDepartment(ou: $ou, parent: $parent) {
+        ou description manager { uid cn mail } members repositories dn path
+      }
     }
   `;
-  return graphqlRequest<{ deleteDepartment: boolean }, { ou: string }>(mutation, { ou }).then(res => res.deleteDepartment);
+  return graphqlRequest<{ moveDepartment: Department }, { ou: string; parent?: string }>(mutation, { ou, parent }).then(res => res.moveDepartment);
 }
@@ -1,8 +1,32 @@
 const GRAPHQL_ENDPOINT = import.meta.env.VITE_GRAPHQL_ENDPOINT!;
 
-export async function graphqlRequest<T, V = Record<string, any>>(query: string, variables?: V): Promise<T> {
-  const token = localStorage.getItem('authToken');
+// The access token is short-lived; the refresh token rotates on every use
+const TOKEN_KEY = "authToken";
+const REFRESH_TOKEN_KEY = "refreshToken";
+const EXPIRES_AT_KEY = "tokenExpiresAt";
+
+// Tokens are refreshed this long before they expire
+const REFRESH_MARGIN_MS = 30_000;
+
+export type StoredTokens = {
+  token: string;
+  refreshToken?: string;
+  expiresAt?: string;
+};
 
+export function storeTokens({ token, refreshToken, expiresAt }: StoredTokens): void {
+  localStorage.setItem(TOKEN_KEY, token);
+  if (refreshToken) localStorage.setItem(REFRESH_TOKEN_KEY, refreshToken);
+  if (expiresAt) localStorage.setItem(EXPIRES_AT_KEY, expiresAt);
+}
+
+export function clearTokens(): void {
+  localStorage.removeItem(TOKEN_KEY);
+  localStorage.removeItem(REFRESH_TOKEN_KEY);
+  localStorage.removeItem(EXPIRES_AT_KEY);
+}
+
+async function post(query: string, variables: unknown, token: string | null): Promise<{ res: Response; json: any }> {
   const headers: Record<string, string> = {
     "Content-Type": "application/json",
   };
@@ -13,8 +37,67 @@ export async function graphqlRequest<T, V = Record<string, any>>(query: string,
     headers,
     body: JSON.stringify({ query, variables }),
   });
+  const json = await res.json().catch(() => ({ errors: [{ message: res.statusText }] }));
+  return { res, json };
+}
+
+function isUnauthenticated(res: Response, json: any): boolean {
+  return res.status === 401 || (json.errors ?? []).some((err: any) => err.extensions?.code === "UNAUTHENTICATED");
+}
+
+function expiresSoon(): boolean {
+  const expiresAt = localStorage.getItem(EXPIRES_AT_KEY);
+  return !!expiresAt && Date.parse(expiresAt) - Date.now() < REFRESH_MARGIN_MS;
+}
+
+let refreshing: Promise<boolean> | null = null;
+
+// Trades the stored refresh token for a new pair. Concurrent callers share
+// one request, since the server accepts each refresh token only once.
+function refreshTokens(): Promise<boolean> {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem(REFRESH_TOKEN_KEY);
+      if (!refreshToken) return false;
+
+      const mutation = `
+        mutation ($refreshToken: String!) {
+          refreshToken(refreshToken: $refreshToken) { token refreshToken expiresAt }
+        }
+      `;
+      const { json } = await post(mutation, { refreshToken }, null);
+      const payload = json.data?.refreshToken;
+      if (json.errors || !payload?.token) {
+        clearTokens();
+        return false;
+      }
+      storeTokens(payload);
+      return true;
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+// Sends the user back to the login page once the session cannot be renewed
+function sessionEnded(): void {
+  localStorage.removeItem("user");
+  if (window.location.pathname !== "/login") window.location.assign("/login");
+}
+
+export async function graphqlRequest<T, V = Record<string, any>>(query: string, variables?: V): Promise<T> {
+  if (expiresSoon()) await refreshTokens();
+
+  let { res, json } = await post(query, variables, localStorage.getItem(TOKEN_KEY));
+  if (isUnauthenticated(res, json) && localStorage.getItem(TOKEN_KEY)) {
+    if (await refreshTokens()) {
+      ({ res, json } = await post(query, variables, localStorage.getItem(TOKEN_KEY)));
+    } else {
+      sessionEnded();
+    }
+  }
 
-  const json = await res.json();
   if (json.errors) throw new Error(json.errors.map((err: any) => err.message).join(", "));
   return json.data as T;
 }
@@ -1,22 +1,47 @@
 import { graphqlRequest } from "./graphqlRequest";
-import type { Group } from "../GQL/models/group";
+import type { Group, GroupFilter, GroupPage } from "../GQL/models/group";
+import type { PaginationInput } from "../GQL/models/user";
 
 export async function getGroup(cn: string): Promise<Group> {
   const query = `
     query ($cn: String!) {
       group(cn: $cn) {
-        cn gidNumber members dn
+        cn gidNumber description members memberGroups effectiveMembers dn
       }
     }
   `;
   return graphqlRequest<{ group: Group }, { cn: string }>(query, { cn }).then(res => res.group);
 }
 
+export async function listGroups(
+  filter?: GroupFilter,
+  pagination?: PaginationInput
+): Promise<GroupPage> {
+  const query = `
+    query ($filter: GroupFilterInput, $pagination: PaginationInput) {
+      groups(filter: $filter, pagination: $pagination) {
+        items {
+          cn gidNumber description members memberGroups dn
+        }
+        total
+        totalEstimated
+        page
+        limit
+        hasNextPage
+      }
+    }
+  `;
+  return graphqlRequest<
+    { groups: GroupPage },
+    { filter?: GroupFilter; pagination?: PaginationInput }
+  >(query, { filter, pagination }).then(res => res.groups);
+}
+
 export async function createGroup(cn: string, description?: string): Promise<Group> {
   const mutation = `
     mutation ($cn: String!, $description: String) {
       createGroup(cn: $cn, description: $description) {
-        cn gidNumber members dn
+        cn gidNumber description members memberGroups dn
       }
     }
   `;
@@ -31,3 +56,61 @@ export async function addUserToGroup(uid: string, groupCn: string): Promise<bool
   `;
   return graphqlRequest<{ addUserToGroup: boolean }, { uid: string; groupCn: string }>(mutation, { uid, groupCn }).then(res => res.addUserToGroup);
 }
+
+export async function removeUserFromGroup(uid: string, groupCn: string): Promise<boolean> {
+  const mutation = `
+    mutation ($uid: String!, $groupCn: String!) {
+      removeUserFromGroup(uid: $uid, groupCn: $groupCn)
+    }
+  `;
+  return graphqlRequest<{ removeUserFromGroup: boolean }, { uid: string; groupCn: string }>(mutation, { uid, groupCn }).then(res => res.removeUserFromGroup);
+}
+
+export async function addGroupToGroup(groupCn: string, parentCn: string): Promise<boolean> {
+  const mutation = `
+    mutation ($groupCn: String!, $parentCn: String!) {
+      addGroupToGroup(groupCn: $groupCn, parentCn: $parentCn)
+    }
+  `;
+  return graphqlRequest<{ addGroupToGroup: boolean }, { groupCn: string; parentCn: string }>(mutation, { groupCn, parentCn }).then(res => res.addGroupToGroup);
+}
+
+export async function removeGroupFromGroup(groupCn: string, parentCn: string): Promise<boolean> {
+  const mutation = `
+    mutation ($groupCn: String!, $parentCn: String!) {
+      removeGroupFromGroup(groupCn: $groupCn, parentCn: $parentCn)
+    }
+  `;
+  return graphqlRequest<{ removeGroupFromGroup: boolean }, { groupCn: string; parentCn: string }>(mutation, { groupCn, parentCn }).then(res => res.removeGroupFromGroup);
+}
+
+export async function setGroupMembers(cn: string, members: string[]): Promise<Group> {
+  const mutation = `
+    mutation ($cn: String!, $members: [String!]!) {
+      setGroupMembers(cn: $cn, members: $members) {
+        cn gidNumber description members memberGroups dn
+      }
+    }
+  `;
+  return graphqlRequest<{ setGroupMembers: Group }, { cn: string; members: string[] }>(mutation, { cn, members }).then(res => res.setGroupMembers);
+}
+
+export async function updateGroup(cn: string, description: string): Promise<Group> {
+  const mutation = `
+    mutation ($cn: String!, $description: String) {
+      updateGroup(cn: $cn, description: $description) {
+        cn gidNumber description members memberGroups dn
+      }
+    }
+  `;
+  return graphqlRequest<{ updateGroup: Group }, { cn: string; description: string }>(mutation, { cn, description }).then(res => res.updateGroup);
+}
+
+export async function deleteGroup(cn: string): Promise<boolean> {
+  const mutation = `
+    mutation ($cn: String!) {
+      deleteGroup(cn: $cn)
+    }
+  `;
+  return graphqlRequest<{ deleteGroup: boolean }, { cn: string }>(mutation, { cn }).then(res => res.deleteGroup);
+}
@@ -1,5 +1,6 @@
-import { graphqlRequest } from "./graphqlRequest";
-import type { User, CreateUserInput, UpdateUserInput, UserPage, UserFilter, PaginationInput } from "../GQL/models/user";
+import { graphqlRequest, clearTokens } from "./graphqlRequest";
+import type { User, CreateUserInput, UpdateUserInput, UserPage, UserFilter, PaginationInput, UserImportReport } from "../GQL/models/user";
+import type { DeletionSummary } from "../GQL/models/deletionSummary";
 import type { LoginMutation, LoginMutationVariables, MeQuery, RegisterMutation, RegisterMutationVariables } from "../GQL/apis/apis";
 
 
@@ -9,6 +10,8 @@ export const login = async (variables: LoginMutationVariables): Promise<LoginMut
     mutation ($uid: String!, $password: String!) {
       login(uid: $uid, password: $password) {
         token
+        refreshToken
+        expiresAt
         user {
           uid
           cn
@@ -21,6 +24,7 @@ export const login = async (variables: LoginMutationVariables): Promise<LoginMut
           homeDirectory
           repositories
           dn
+          status
         }
       }
     }
@@ -28,15 +32,22 @@ export const login = async (variables: LoginMutationVariables): Promise<LoginMut
   return graphqlRequest<LoginMutation, LoginMutationVariables>(mutation, variables);
 };
 
-export const logout = (navigate: (path: string) => void): void => {
-  localStorage.removeItem("token");
-  localStorage.removeItem("user");
-  navigate("/login");
+// Ends the session on the server before forgetting the tokens locally
+export const logout = async (navigate: (path: string) => void): Promise<void> => {
+  try {
+    await graphqlRequest<{ logout: boolean }>(`mutation { logout }`);
+  } catch {
+    // The session may already be gone; the local tokens are cleared regardless
+  } finally {
+    clearTokens();
+    localStorage.removeItem("user");
+    navigate("/login");
+  }
 };
 
 export const register = async (variables: RegisterMutationVariables): Promise<RegisterMutation> => {
   const mutation = `
-    mutation ($username: String!, $password: String!, $email: String!, $firstName: String!, $lastName: String!, $userType: String!) {
+    mutation ($username: String!, $password: String!, $email: String!, $firstName: String!, $lastName: String!, $userType: String!, $department: String) {
       register(
         username: $username
         password: $password
@@ -44,16 +55,17 @@ export const register = async (variables: RegisterMutationVariables): Promise<Re
         firstName: $firstName
         lastName: $lastName
         userType: $userType
+        department: $department
       ) {
-        token
-        user {
-          id
-          username
-          email
-          firstName
-          lastName
-          userType
-        }
+        id
+        username
+        email
+        firstName
+        lastName
+        userType
+        department
+        status
+        createdAt
       }
     }
   `;
@@ -64,7 +76,7 @@ export async function getUser(uid: string): Promise<User> {
   const query = `
     query ($uid: String!) {
       user(uid: $uid) {
-        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
       }
     }
   `;
@@ -86,6 +98,7 @@ export const getMe = async (): Promise<MeQuery> => {
         homeDirectory
         repositories
         dn
+        status
       }
     }
   `;
@@ -101,9 +114,10 @@ export async function listUsers(
       users(filter: $filter, pagination: $pagination) {
         items {
           uid cn sn givenName mail department
-          uidNumber gidNumber homeDirectory repositories dn
+          uidNumber gidNumber homeDirectory repositories dn status deletedAt
         }
         total
+        totalEstimated
         page
         limit
         hasNextPage
@@ -121,29 +135,101 @@ export async function createUser(input: CreateUserInput): Promise<User> {
   const mutation = `
     mutation ($input: CreateUserInput!) {
       createUser(input: $input) {
-        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
       }
     }
   `;
   return graphqlRequest<{ createUser: User }, { input: CreateUserInput }>(mutation, { input }).then(res => res.createUser);
 }
 
+// Creates users from CSV (uid, givenName, sn, cn, mail, department, groups, repositories)
+export async function importUsers(csv: string, dryRun = false): Promise<UserImportReport> {
+  const mutation = `
+    mutation ($csv: String!, $dryRun: Boolean) {
+      importUsers(csv: $csv, dryRun: $dryRun) {
+        dryRun created valid skipped failed
+        rows { line uid status reason initialPassword }
+      }
+    }
+  `;
+  return graphqlRequest<{ importUsers: UserImportReport }, { csv: string; dryRun: boolean }>(mutation, { csv, dryRun }).then(res => res.importUsers);
+}
+
 export async function updateUser(input: UpdateUserInput): Promise<User> {
   const mutation = `
     mutation ($input: UpdateUserInput!) {
       updateUser(input: $input) {
-        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
       }
     }
   `;
   return graphqlRequest<{ updateUser: User }, { input: UpdateUserInput }>(mutation, { input }).then(res => res.updateUser);
 }
 
-export async function deleteUser(uid: string): Promise<boolean> {
+// Soft deletes the user unless permanent is set
+export async function deleteUser(uid: string, permanent = false): Promise<DeletionSummary> {
+  const mutation = `
+    mutation ($uid: String!, $permanent: Boolean) {
+      deleteUser(uid: $uid, permanent: $permanent) {
+        deleted
+        modified { dn change }
+      }
+    }
+  `;
+  return graphqlRequest<{ deleteUser: DeletionSummary }, { uid: string; permanent: boolean }>(mutation, { uid, permanent }).then(res => res.deleteUser);
+}
+
+export async function disableUser(uid: string): Promise<User> {
   const mutation = `
     mutation ($uid: String!) {
-      deleteUser(uid: $uid)
+      disableUser(uid: $uid) {
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
+      }
+    }
+  `;
+  return graphqlRequest<{ disableUser: User }, { uid: string }>(mutation, { uid }).then(res => res.disableUser);
+}
+
+export async function enableUser(uid: string): Promise<User> {
+  const mutation = `
+    mutation ($uid: String!) {
+      enableUser(uid: $uid) {
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
+      }
+    }
+  `;
+  return graphqlRequest<{ enableUser: User }, { uid: string }>(mutation, { uid }).then(res => res.enableUser);
+}
+
+export async function restoreUser(uid: string): Promise<User> {
+  const mutation = `
+    mutation ($uid: String!) {
+      restoreUser(uid: $uid) {
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
+      }
+    }
+  `;
+  return graphqlRequest<{ restoreUser: User }, { uid: string }>(mutation, { uid }).then(res => res.restoreUser);
+}
+
+export async function renameUser(oldUid: string, newUid: string): Promise<User> {
+  const mutation = `
+    mutation ($oldUid: String!, $newUid: String!) {
+      renameUser(oldUid: $oldUid, newUid: $newUid) {
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
+      }
+    }
+  `;
+  return graphqlRequest<{ renameUser: User }, { oldUid: string; newUid: string }>(mutation, { oldUid, newUid }).then(res => res.renameUser);
+}
+
+export async function moveUser(uid: string, department: string): Promise<User> {
+  const mutation = `
+    mutation ($uid: String!, $department: String!) {
+      moveUser(uid: $uid, department: $department) {
+        uid cn sn givenName mail department uidNumber gidNumber homeDirectory repositories dn status deletedAt
+      }
     }
   `;
-  return graphqlRequest<{ deleteUser: boolean }, { uid: string }>(mutation, { uid }).then(res => res.deleteUser);
+  return graphqlRequest<{ moveUser: User }, { uid: string; department: string }>(mutation, { uid, department }).then(res => res.moveUser);
 }